@@ -1,47 +1,200 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"image"
 	"image/png"
+	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 	"studyguide.parallel/pkg/blur"
+	"studyguide.parallel/pkg/common"
 	"studyguide.parallel/pkg/stats"
 )
 
 func main() {
 	var (
-		inputPath  = flag.String("input", "/input", "Input directory path")
-		outputPath = flag.String("output", "/data/a/output", "Output directory path")
-		kernelSize = flag.Int("kernel", 15, "Gaussian kernel size")
+		inputPath     = flag.String("input", "/input", "Input directory path")
+		outputPath    = flag.String("output", "/data/a/output", "Output directory path")
+		kernelSize    = flag.Int("kernel", 15, "Gaussian kernel size")
+		radius        = flag.Int("radius", 0, "Blur radius R, an alternative to -kernel: sets the kernel size to 2R+1 (0 = use -kernel)")
+		dither        = flag.Bool("dither", false, "Ordered-dither the 8-bit output to reduce banding in smooth gradients")
+		pngComp       = flag.String("png-compression", "default", "PNG compression level: default, none, speed or best")
+		noOutput      = flag.Bool("no-output", false, "Decode and blur but skip encoding/writing outputs (pure compute benchmark)")
+		summaryOnly   = flag.Bool("summary-only", false, "Write only aggregate numbers to the stats file, without per-file listings")
+		validate      = flag.Bool("validate", false, "Preflight: check every input decodes (header only) and report, without processing")
+		strict        = flag.Bool("strict", false, "With -validate, exit non-zero if any input is invalid")
+		bilateral     = flag.Bool("bilateral", false, "Use an edge-preserving bilateral blur instead of a plain Gaussian")
+		sigmaColor    = flag.Float64("sigma-color", 30, "Colour-similarity sigma (0-255 units) for -bilateral; smaller keeps more edges")
+		manifest      = flag.Bool("manifest", false, "Write outputs.json in the output directory mapping each input to its output, size, kernel and time")
+		softness      = flag.Float64("softness", -1, "Blur softness 0-100, sigma = radius*softness/200 (negative keeps the default sigma of kernel/3)")
+		float32Acc    = flag.Bool("float32", false, "Accumulate the Gaussian in float32 (faster; output within 1 level of float64)")
+		failOnEmpty   = flag.Bool("fail-on-empty", false, "Exit with status 3 instead of 0 when the input directory has no images")
+		lumaOnly      = flag.Bool("luma-only", false, "Fast approximate blur: blur luma only and keep the original chroma (colour edges stay sharp)")
+		encodeWorkers = flag.Int("encode-workers", 0, "Encode and write outputs on this many background goroutines while the next image blurs (0 encodes inline)")
+		pipelineSpec  = flag.String("pipeline", "", "Comma list of ops run instead of the plain blur, e.g. scale:0.5,blur:15,unsharp:0.5 (ops: scale:F, blur:K, unsharp:AMOUNT[:K])")
+		contactSheet  = flag.String("contact-sheet", "", "After the run, write a PNG grid of thumbnails of every output to this path")
+		maskPath      = flag.String("mask", "", "Grayscale mask image the size of the inputs: 255 blurs fully, 0 keeps the original, values between blend")
+		vignette      = flag.Float64("vignette", 0, "Blur only toward the edges, keeping the centre sharp: the fraction (0-1] of the centre-to-corner distance the blur fades in over (0 = off)")
+		resizeFilter  = flag.String("resize-filter", "bilinear", "Interpolation for -pipeline scale steps and -contact-sheet thumbnails: nearest, bilinear, catmull-rom or lanczos")
+		quantize      = flag.Bool("quantize", false, "Reduce PNG outputs to a 256-colour median-cut palette: much smaller files, at some banding")
+		approx        = flag.Bool("approx", false, "Approximate the Gaussian with three box blurs: cost independent of kernel size, within a few levels of exact")
+		deadline      = flag.Duration("deadline", 0, "Stop starting new images once the run has taken this long (0 = no limit) and list the rest in -resume-file")
+		resumeFile    = flag.String("resume-file", "", "Where -deadline lists unprocessed inputs, one per line (default unprocessed.txt in the output directory)")
+		inputList     = flag.String("input-list", "", "Process the inputs listed in this file, one per line (e.g. a -resume-file), instead of the PNGs in -input")
+		verifyOutput  = flag.Bool("verify-output", false, "Re-open and fully decode each written output, checking its size, before counting the image as done")
+		verifyRetries = flag.Int("verify-retries", 1, "With -verify-output, rewrite an output that fails the check up to this many times before marking the image failed")
+		imageTimeout  = flag.Duration("timeout-per-image", 0, "Abandon an image whose blur runs longer than this and mark it failed, so one pathological image cannot stall the batch (0 = no limit)")
+		keepMTime     = flag.Bool("preserve-mtime", false, "Give each output file its input's modification time, for archives")
+		profileName   = flag.String("profile", "", "Blur preset instead of -kernel: light, medium, heavy or extreme (extreme blurs twice)")
+		checkKernel   = flag.Bool("validate-kernel-sum", true, "Self-check at startup that the kernel's weights are finite and sum to 1, failing fast on a degenerate size/sigma")
+		outputFormat  = flag.String("output-format", "png", "Output format: png, or dzi for a Deep Zoom tile pyramid (a .dzi descriptor plus a _files directory of tiles) for web zoom viewers")
+		inputSort     = flag.String("input-sort", "", "Process inputs in this order: name, size or mtime, with :desc to reverse (default: as listed)")
+		compareFmts   = flag.Bool("compare-formats", false, "Diagnostic: blur the first input, report its encoded size and encode time as PNG at each -png-compression level and JPEG at several qualities, and exit without writing outputs")
+		kernelEnergy  = flag.Float64("kernel-energy", 0, "Resize the kernel to the smallest odd size capturing this fraction (e.g. 0.99) of the Gaussian's energy, keeping the sigma from -kernel/-softness")
+		minKernel     = flag.Int("min-kernel", 1, "Smallest kernel size a derived size (-kernel-energy) may take; an even value is rounded up to odd")
+		maxKernel     = flag.Int("max-kernel", blur.MaxEnergyKernelSize, "Largest kernel size a derived size (-kernel-energy) may take; an even value is rounded down to odd")
 	)
 	flag.Parse()
+	profile, err := common.ApplyProfileFlag(kernelSize, *profileName)
+	if err != nil {
+		log.Fatalf("Invalid -profile: %v", err)
+	}
+	if err := common.ApplyRadiusFlag(kernelSize, *radius); err != nil {
+		log.Fatalf("Invalid -radius: %v", err)
+	}
+
+	pngLevel, err := common.ParsePNGCompression(*pngComp)
+	if err != nil {
+		log.Fatalf("Invalid -png-compression: %v", err)
+	}
+	outputExt := "_blurred.png"
+	switch *outputFormat {
+	case "png":
+	case "dzi":
+		outputExt = "_blurred.dzi"
+		if *verifyOutput || *contactSheet != "" {
+			log.Fatalf("-output-format dzi writes a tile pyramid, which -verify-output and -contact-sheet cannot read back")
+		}
+	default:
+		log.Fatalf("Invalid -output-format %q: use png or dzi", *outputFormat)
+	}
+
+	// Fix the spatial sigma before -kernel-energy may resize the window around it
+	sigma := 0.0
+	if *softness >= 0 {
+		sigma = blur.SoftnessToSigma(*softness, *kernelSize)
+	}
+	if *kernelEnergy != 0 {
+		if *kernelEnergy < 0 || *kernelEnergy >= 1 {
+			log.Fatalf("Invalid -kernel-energy %g: must be between 0 and 1", *kernelEnergy)
+		}
+		if sigma == 0 {
+			sigma = float64(*kernelSize) / 3.0
+		}
+		if *minKernel < 1 || *maxKernel < *minKernel {
+			log.Fatalf("Invalid -min-kernel %d / -max-kernel %d: need 1 <= min <= max", *minKernel, *maxKernel)
+		}
+		*kernelSize = blur.ClampKernelSize(blur.KernelSizeForEnergy(sigma, *kernelEnergy), *minKernel, *maxKernel)
+	}
+	if *checkKernel {
+		if err := blur.CheckKernel(*kernelSize, sigma); err != nil {
+			log.Fatalf("Kernel self-check failed, refusing to blur with it: %v", err)
+		}
+	}
 
 	startTime := time.Now()
 	log.Printf("=== Starting Sequential Image Processing ===")
 	log.Printf("Start time: %s", startTime.Format("2006-01-02 15:04:05"))
 	log.Printf("Kernel size: %d", *kernelSize)
+	if profile.Name != "" {
+		log.Printf("Profile: %s", profile)
+	}
 	log.Printf("Input path: %s", *inputPath)
 	log.Printf("Output path: %s", *outputPath)
+	log.Printf("Dither: %t", *dither)
+	if *bilateral {
+		log.Printf("Bilateral: on (sigma-color %.1f)", *sigmaColor)
+	}
+	if *float32Acc {
+		log.Printf("Accumulation: float32")
+	}
+	if *lumaOnly {
+		log.Printf("Luma-only blur: on (approximate, chroma unblurred)")
+	}
+	if *approx {
+		log.Printf("Approximate Gaussian: on (three box blurs)")
+	}
+	if *softness >= 0 {
+		log.Printf("Softness: %.0f%% (sigma %.2f)", math.Min(*softness, 100), sigma)
+	}
+	if *kernelEnergy != 0 {
+		log.Printf("Kernel energy: %.2f%% captured by size %d (sigma %.2f)",
+			100*blur.KernelEnergy(*kernelSize, sigma), *kernelSize, sigma)
+	}
+	log.Printf("PNG compression: %s", *pngComp)
+	if *quantize {
+		log.Printf("PNG palette: %d colours (median cut)", common.PaletteSize)
+	}
+	if *noOutput {
+		log.Printf("Output suppressed (-no-output): timings exclude encode and write")
+	} else if *encodeWorkers > 0 {
+		log.Printf("Encode workers: %d (per-image times exclude encode)", *encodeWorkers)
+	}
+	if *verifyOutput && !*noOutput {
+		log.Printf("Output verification: on (%d retries)", *verifyRetries)
+	}
+	if *imageTimeout > 0 {
+		log.Printf("Per-image blur timeout: %s", *imageTimeout)
+	}
+	if *outputFormat == "dzi" && !*noOutput {
+		log.Printf("Output format: Deep Zoom pyramid (%dpx tiles, %dpx overlap, written inline)", common.DZITileSize, common.DZIOverlap)
+	}
 
 	// Create output directory
-	if err := os.MkdirAll(*outputPath, 0755); err != nil {
-		log.Fatalf("Failed to create output directory: %v", err)
+	if !*noOutput && !*validate {
+		if err := os.MkdirAll(*outputPath, 0755); err != nil {
+			log.Fatalf("Failed to create output directory: %v", err)
+		}
 	}
 
-	// Find all PNG files in input directory
-	files, err := filepath.Glob(filepath.Join(*inputPath, "*.png"))
+	// Find all PNG files in input directory, or take them from -input-list
+	var files []string
+	if *inputList != "" {
+		if files, err = common.ReadInputList(*inputList); err == nil {
+			err = common.SortInputs(files, *inputSort)
+		}
+	} else {
+		files, err = common.FindInputs(*inputPath, *inputSort)
+	}
 	if err != nil {
 		log.Fatalf("Failed to find input files: %v", err)
 	}
 
 	if len(files) == 0 {
-		log.Fatalf("No PNG files found in %s", *inputPath)
+		if *inputList != "" {
+			log.Printf("No inputs listed in %s; nothing to process", *inputList)
+		} else {
+			log.Printf("No PNG files found in %s; nothing to process", *inputPath)
+		}
+		if *failOnEmpty {
+			os.Exit(common.ExitNoInputs)
+		}
+		return
+	}
+
+	if *validate {
+		invalid := common.WriteValidationReport(os.Stdout, common.ValidateInputs(files))
+		if invalid > 0 && *strict {
+			os.Exit(1)
+		}
+		return
 	}
 
 	// Create input and output paths for Run_a
@@ -54,24 +207,216 @@ func main() {
 		// Generate output filename
 		filename := filepath.Base(file)
 		name := strings.TrimSuffix(filename, filepath.Ext(filename))
-		outputFile := filepath.Join(*outputPath, name+"_blurred.png")
+		outputFile := filepath.Join(*outputPath, name+outputExt)
 		outputPaths = append(outputPaths, outputFile)
 	}
 
 	log.Printf("Found %d images to process", len(inputPaths))
 
 	// Process images sequentially
-	result := processSequential(inputPaths, outputPaths, *kernelSize)
+	blurOpts := blur.Options{Dither: *dither, Float32: *float32Acc, LumaOnly: *lumaOnly, Approx: *approx}
+	if *bilateral {
+		blurOpts.SigmaColor = *sigmaColor
+	}
+	blurOpts.Sigma = sigma
+	if blurOpts.ResizeFilter, err = blur.ParseResizeFilter(*resizeFilter); err != nil {
+		log.Fatalf("Invalid -resize-filter: %v", err)
+	}
+	opts := processOptions{
+		Blur:          blurOpts,
+		PNGLevel:      pngLevel,
+		NoOutput:      *noOutput,
+		EncodeWorkers: *encodeWorkers,
+		Quantize:      *quantize,
+		VerifyOutput:  *verifyOutput,
+		VerifyRetries: *verifyRetries,
+		ImageTimeout:  *imageTimeout,
+		DZI:           *outputFormat == "dzi",
+	}
+	if *deadline > 0 {
+		opts.Deadline = startTime.Add(*deadline)
+		log.Printf("Deadline: %s (no new image starts after %s)", *deadline, opts.Deadline.Format("15:04:05"))
+	}
+	if *pipelineSpec != "" {
+		if opts.Pipeline, err = blur.ParsePipeline(*pipelineSpec, blurOpts); err != nil {
+			log.Fatalf("Invalid -pipeline: %v", err)
+		}
+		log.Printf("Pipeline: %s (replaces the -kernel blur)", opts.Pipeline)
+	} else {
+		opts.Pipeline = profile.Pipeline(*kernelSize, blurOpts)
+	}
+	if *maskPath != "" {
+		if opts.Mask, err = common.LoadMask(*maskPath, common.DefaultDownloadTimeout); err != nil {
+			log.Fatalf("Invalid -mask: %v", err)
+		}
+		log.Printf("Mask: %s (%dx%d)", *maskPath, opts.Mask.Bounds().Dx(), opts.Mask.Bounds().Dy())
+		if opts.Pipeline.Resizes() {
+			log.Fatalf("-mask blends the blur over the original image, so it can't follow a -pipeline that scales it")
+		}
+	}
+	if *vignette != 0 {
+		if *vignette < 0 || *vignette > 1 {
+			log.Fatalf("Invalid -vignette %g: must be between 0 and 1", *vignette)
+		}
+		if opts.Mask != nil {
+			log.Fatalf("-vignette and -mask both choose where to blur; use one")
+		}
+		if opts.Pipeline.Resizes() {
+			log.Fatalf("-vignette blends the blur over the original image, so it can't follow a -pipeline that scales it")
+		}
+		opts.Vignette = *vignette
+		log.Printf("Vignette: blur fades in over the outer %.0f%% of the centre-to-corner distance", 100**vignette)
+	}
+	if *compareFmts {
+		if err := compareFormats(inputPaths[0], *kernelSize, opts); err != nil {
+			log.Fatalf("Format comparison failed: %v", err)
+		}
+		return
+	}
+	heap := stats.StartHeapSampler(stats.DefaultHeapSampleInterval)
+	result, remaining, err := processSequential(inputPaths, outputPaths, *kernelSize, opts)
+	result.PeakHeapBytes = heap.Stop()
+
+	if *keepMTime {
+		preserveModTimes(result, *noOutput)
+	}
 
 	// Write performance results
 	results := []stats.PerformanceData{result}
-	stats.WritePerformanceResults(results)
+	stats.WritePerformanceResultsWithOptions(results, "abc_", stats.WriteOptions{SummaryOnly: *summaryOnly})
+	if err != nil {
+		log.Fatalf("Stopped after %d completed images, whose stats were written: %v", result.ImagesProcessed, err)
+	}
+
+	if *manifest {
+		if *noOutput {
+			log.Printf("Skipping %s: outputs were suppressed by -no-output", stats.ManifestFile)
+		} else if path, err := stats.WriteManifest(*outputPath, result); err != nil {
+			log.Printf("Failed to write output manifest: %v", err)
+		} else {
+			log.Printf("Output manifest written to %s", path)
+		}
+	}
+
+	if *contactSheet != "" {
+		if *noOutput {
+			log.Printf("Skipping contact sheet: outputs were suppressed by -no-output")
+		} else if err := common.WriteContactSheet(result.OutputPaths, *contactSheet, blurOpts.ResizeFilter, pngLevel); err != nil {
+			log.Printf("Failed to write contact sheet: %v", err)
+		} else {
+			log.Printf("Contact sheet of %d outputs written to %s", len(result.OutputPaths), *contactSheet)
+		}
+	}
+
+	if *deadline > 0 {
+		path := *resumeFile
+		if path == "" {
+			path = filepath.Join(*outputPath, common.ResumeFile)
+		}
+		writeResumeFile(path, remaining)
+	}
 
 	log.Printf("=== Processing Complete ===")
 	log.Printf("Total execution time: %.2fs", time.Since(startTime).Seconds())
 }
 
-func processSequential(inputPaths []string, outputPaths []string, kernelSize int) stats.PerformanceData {
+// preserveModTimes gives every written output its input's modification time, for -preserve-mtime
+func preserveModTimes(result stats.PerformanceData, noOutput bool) {
+	if noOutput {
+		log.Printf("Skipping -preserve-mtime: outputs were suppressed by -no-output")
+		return
+	}
+	if err := common.CopyModTimes(result.OutputPaths, result.InputPaths); err != nil {
+		log.Printf("Failed to preserve modification times: %v", err)
+	}
+}
+
+// writeResumeFile records the inputs a -deadline run did not reach, for the next run's
+// -input-list. A run that finished everything removes a stale list instead, so the
+// next scheduled run does not repeat work.
+func writeResumeFile(path string, remaining []string) {
+	if len(remaining) == 0 {
+		if err := os.Remove(path); err == nil {
+			log.Printf("All inputs processed; removed stale resume file %s", path)
+		}
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Printf("Failed to create resume file directory: %v", err)
+		return
+	}
+	if err := common.WriteInputList(path, remaining); err != nil {
+		log.Printf("Failed to write resume file: %v", err)
+		return
+	}
+	log.Printf("Deadline reached: %d unprocessed inputs listed in %s (rerun with -input-list %s)", len(remaining), path, path)
+}
+
+// processOptions carries the per-run settings shared by every image
+type processOptions struct {
+	Blur          blur.Options
+	PNGLevel      png.CompressionLevel
+	NoOutput      bool // skip encode and write, for benchmarking the blur alone
+	EncodeWorkers int  // > 0 overlaps encoding with the next blur on a bounded pool
+
+	// Pipeline, when set, replaces the single blur with an ordered list of transforms
+	Pipeline blur.Pipeline
+
+	// Mask, when set, limits the blur to where it is nonzero (see blur.BlendMasked)
+	Mask *image.Gray
+
+	// Vignette > 0 blends each image through a blur.VignetteMask of this strength
+	Vignette float64
+
+	// Deadline, when set, is checked between images: none starts after it
+	Deadline time.Time
+
+	// Quantize writes paletted PNGs (see common.Quantize)
+	Quantize bool
+
+	// VerifyOutput decodes every written output again and rewrites it up to
+	// VerifyRetries times if that fails; an output that never passes fails its image
+	VerifyOutput  bool
+	VerifyRetries int
+
+	// DZI writes each output as a Deep Zoom tile pyramid (see common.WriteDZI) instead
+	// of a PNG, always inline
+	DZI bool
+
+	// ImageTimeout, when set, abandons an image whose blur takes longer (see blurWithTimeout)
+	ImageTimeout time.Duration
+
+	// wrapOutput, when set, wraps the writer of every PNG output; tests use it to
+	// simulate a failing disk
+	wrapOutput func(io.Writer) io.Writer
+}
+
+// verifier returns the check for an output of img, or nil when outputs are not verified
+func (o processOptions) verifier(img image.Image) func(path string) error {
+	if !o.VerifyOutput {
+		return nil
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	return func(path string) error { return common.VerifyOutput(path, width, height) }
+}
+
+// encodePNG writes an output image with the run's compression, quantizing it first
+// when asked
+func (o processOptions) encodePNG(w io.Writer, img image.Image) error {
+	if o.Quantize {
+		img = common.Quantize(img, common.PaletteSize)
+	}
+	if o.wrapOutput != nil {
+		w = o.wrapOutput(w)
+	}
+	return common.EncodePNG(w, img, o.PNGLevel)
+}
+
+// processSequential blurs the images in order. It also returns the inputs left
+// unstarted when opts.Deadline passed. An error it cannot continue past stops the
+// batch; it is returned with the stats of the images completed before it, so the
+// caller can record them before exiting.
+func processSequential(inputPaths []string, outputPaths []string, kernelSize int, opts processOptions) (stats.PerformanceData, []string, error) {
 	fmt.Println("=== Starting Sequential Multi-Image Gaussian Blur ===")
 	startTime := time.Now()
 	
@@ -80,67 +425,298 @@ func processSequential(inputPaths []string, outputPaths []string, kernelSize int
 	}
 
 	totalBlurTime := 0.0
+	var timings []stats.ImageTiming
+
+	// At most two blurred images wait per encoder, bounding the extra memory held
+	var encoder *common.EncodePool
+	if opts.EncodeWorkers > 0 && !opts.NoOutput && !opts.DZI {
+		encoder = common.NewEncodePool(opts.EncodeWorkers, 2*opts.EncodeWorkers)
+	}
 	
+	var remaining []string
+	var invalid []common.EncodeFailure
+	var failure error
 	for i, inputPath := range inputPaths {
-		imageTime, err := runSequentialSingle(inputPath, outputPaths[i], kernelSize)
+		if encoder != nil && encoder.DiskFull() != nil {
+			// A background write ran out of space; blurring the rest would be wasted
+			log.Printf("Output disk is full; stopping batch before image %d of %d", i+1, len(inputPaths))
+			inputPaths, outputPaths = inputPaths[:i], outputPaths[:i]
+			break
+		}
+		if !opts.Deadline.IsZero() && time.Now().After(opts.Deadline) {
+			log.Printf("Deadline passed after %d of %d images; stopping", i, len(inputPaths))
+			remaining = append(remaining, inputPaths[i:]...)
+			inputPaths, outputPaths = inputPaths[:i], outputPaths[:i]
+			break
+		}
+		timing, err := runSequentialSingle(inputPath, outputPaths[i], kernelSize, opts, encoder)
 		if err != nil {
-			log.Fatalf("Error processing image %d: %v", i+1, err)
+			if common.IsDiskFull(err) {
+				// Every later write would fail too; keep what finished and flush stats for it
+				log.Printf("Output disk is full while writing image %d; stopping batch after %d completed images", i+1, i)
+				os.Remove(outputPaths[i])
+				inputPaths, outputPaths = inputPaths[:i], outputPaths[:i]
+				break
+			}
+			if errors.Is(err, common.ErrInvalidOutput) || errors.Is(err, errImageTimeout) {
+				// Keep timings parallel to the paths; dropFailedEncodes removes the image
+				if errors.Is(err, errImageTimeout) {
+					fmt.Printf(" abandoned after %s\n", opts.ImageTimeout)
+				} else {
+					fmt.Println(" output failed verification")
+				}
+				invalid = append(invalid, common.EncodeFailure{Path: outputPaths[i], Err: err})
+				timings = append(timings, stats.ImageTiming{})
+				continue
+			}
+			failure = fmt.Errorf("error processing image %d: %w", i+1, err)
+			inputPaths, outputPaths = inputPaths[:i], outputPaths[:i]
+			break
 		}
-		totalBlurTime += imageTime
+		totalBlurTime += timing.Seconds
+		timings = append(timings, timing)
+	}
+
+	if encoder != nil {
+		invalid = append(invalid, encoder.Wait()...)
+	}
+	var writeErr error
+	inputPaths, outputPaths, timings, writeErr = dropFailedEncodes(invalid, inputPaths, outputPaths, timings)
+	if failure == nil {
+		failure = writeErr
 	}
 
 	totalTime := time.Since(startTime).Seconds()
+	averageTime := 0.0
+	if len(inputPaths) > 0 {
+		averageTime = totalTime / float64(len(inputPaths))
+	}
 	fmt.Printf("\n=== Sequential Multi-Image Blur Complete ===\n")
 	fmt.Printf("Images processed: %d\n", len(inputPaths))
 	fmt.Printf("Total blur time: %.2fs\n", totalBlurTime)
 	fmt.Printf("Total execution time: %.2fs\n", totalTime)
-	fmt.Printf("Average time per image: %.2fs\n", totalTime/float64(len(inputPaths)))
+	fmt.Printf("Average time per image: %.2fs\n", averageTime)
 	
+	if opts.NoOutput {
+		outputPaths = nil
+	}
+
 	return stats.PerformanceData{
-		AlgorithmName:   "Sequential",
-		ImagesProcessed: len(inputPaths),
-		KernelSize:      kernelSize,
-		TotalTime:       totalTime,
-		AverageTime:     totalTime / float64(len(inputPaths)),
-		InputPaths:      inputPaths,
-		OutputPaths:     outputPaths,
+		AlgorithmName:     "Sequential",
+		ImagesProcessed:   len(inputPaths),
+		KernelSize:        kernelSize,
+		TotalTime:         totalTime,
+		AverageTime:       averageTime,
+		InputPaths:        inputPaths,
+		OutputPaths:       outputPaths,
+		TotalBlurTime:     &totalBlurTime,
+		OutputsSuppressed: opts.NoOutput,
+		ImageTimings:      timings,
+	}, remaining, failure
+}
+
+// dropFailedEncodes removes images whose output could not be written from the batch
+// results. A full disk, an output failing -verify-output or a blur exceeding
+// -timeout-per-image only loses those images; any other background write error is
+// returned to stop the run, as it does when encoding inline.
+func dropFailedEncodes(failed []common.EncodeFailure, inputPaths, outputPaths []string, timings []stats.ImageTiming) ([]string, []string, []stats.ImageTiming, error) {
+	if len(failed) == 0 {
+		return inputPaths, outputPaths, timings, nil
+	}
+
+	failedPaths := make(map[string]bool)
+	diskFull := 0
+	var writeErr error
+	for _, f := range failed {
+		switch {
+		case common.IsDiskFull(f.Err):
+			diskFull++
+		case errors.Is(f.Err, common.ErrInvalidOutput), errors.Is(f.Err, errImageTimeout):
+			log.Printf("Image failed: %v", f.Err)
+		default:
+			if writeErr == nil {
+				writeErr = fmt.Errorf("error writing %s: %w", f.Path, f.Err)
+			}
+		}
+		failedPaths[f.Path] = true
+	}
+	if diskFull > 0 {
+		log.Printf("Output disk is full; %d images could not be written", diskFull)
+	}
+
+	var keptInputs, keptOutputs []string
+	var keptTimings []stats.ImageTiming
+	for i, outputPath := range outputPaths {
+		if failedPaths[outputPath] {
+			continue
+		}
+		keptInputs = append(keptInputs, inputPaths[i])
+		keptOutputs = append(keptOutputs, outputPath)
+		keptTimings = append(keptTimings, timings[i])
+	}
+	return keptInputs, keptOutputs, keptTimings, writeErr
+}
+
+// compareFormats blurs inputPath as the batch would and prints its size in each output
+// format and setting, for -compare-formats
+func compareFormats(inputPath string, kernelSize int, opts processOptions) error {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+	img, _, err := image.Decode(inputFile)
+	if err != nil {
+		return err
+	}
+
+	var blurred *image.RGBA
+	if opts.Pipeline != nil {
+		blurred = opts.Pipeline.Apply(img)
+	} else {
+		blurred = blur.ApplyBlurToImageWithOptions(img, kernelSize, opts.Blur)
+	}
+	rows, err := common.CompareFormats(blurred)
+	if err != nil {
+		return err
 	}
+
+	fmt.Printf("=== Output formats for %s blurred (%dx%d) ===\n", filepath.Base(inputPath), blurred.Bounds().Dx(), blurred.Bounds().Dy())
+	for _, line := range common.FormatSizeReport(rows, blurred.Bounds()) {
+		fmt.Println(line)
+	}
+	fmt.Println("WebP: not available, Go has no WebP encoder")
+	return nil
 }
 
-func runSequentialSingle(inputPath, outputPath string, kernelSize int) (float64, error) {
+// runSequentialSingle blurs one image and writes it, or hands it to encoder when non-nil
+func runSequentialSingle(inputPath, outputPath string, kernelSize int, opts processOptions, encoder *common.EncodePool) (stats.ImageTiming, error) {
 	startTime := time.Now()
 	
 	// Open input image
 	inputFile, err := os.Open(inputPath)
 	if err != nil {
-		return 0, err
+		return stats.ImageTiming{}, err
 	}
 	defer inputFile.Close()
 
 	img, _, err := image.Decode(inputFile)
 	if err != nil {
-		return 0, err
+		return stats.ImageTiming{}, err
 	}
+	decodeTime := time.Since(startTime).Seconds()
 
 	fmt.Printf("  Processing %s (%dx%d)...", filepath.Base(inputPath), img.Bounds().Dx(), img.Bounds().Dy())
 
-	// Apply blur
-	blurredImg := blur.ApplyBlurToImage(img, kernelSize)
-
-	// Save output
-	outputFile, err := os.Create(outputPath)
+	// Apply blur, or the -pipeline transforms in its place
+	blurStart := time.Now()
+	blurredImg, err := blurWithTimeout(opts.ImageTimeout, func() *image.RGBA {
+		if opts.Pipeline != nil {
+			return opts.Pipeline.Apply(img)
+		}
+		return blur.ApplyBlurToImageWithOptions(img, kernelSize, opts.Blur)
+	})
 	if err != nil {
-		return 0, err
+		return stats.ImageTiming{}, fmt.Errorf("%s: %w", filepath.Base(inputPath), err)
+	}
+	mask := opts.Mask
+	if opts.Vignette > 0 {
+		mask = blur.VignetteMask(img.Bounds(), opts.Vignette)
+	}
+	if mask != nil {
+		if blurredImg, err = blur.BlendMasked(blur.ToRGBA(img), blurredImg, mask); err != nil {
+			return stats.ImageTiming{}, fmt.Errorf("%s: %w", filepath.Base(inputPath), err)
+		}
+	}
+	timing := stats.ImageTiming{
+		Width:         img.Bounds().Dx(),
+		Height:        img.Bounds().Dy(),
+		DecodeSeconds: decodeTime,
+		BlurSeconds:   time.Since(blurStart).Seconds(),
+	}
+
+	if opts.NoOutput {
+		timing.Seconds = time.Since(startTime).Seconds()
+		fmt.Printf(" %.2fs (%s, output suppressed)\n", timing.Seconds, stageTimes(timing))
+		return timing, nil
+	}
+
+	if opts.DZI {
+		if err := common.WriteDZI(outputPath, blurredImg, opts.PNGLevel); err != nil {
+			return stats.ImageTiming{}, err
+		}
+		timing.Seconds = time.Since(startTime).Seconds()
+		fmt.Printf(" %.2fs (%s, %d zoom levels)\n", timing.Seconds, stageTimes(timing), common.DZILevels(timing.Width, timing.Height))
+		return timing, nil
+	}
+
+	if encoder != nil {
+		encoder.SubmitVerified(outputPath, func(w io.Writer) error {
+			return opts.encodePNG(w, blurredImg)
+		}, opts.verifier(blurredImg), opts.VerifyRetries)
+		timing.Seconds = time.Since(startTime).Seconds()
+		fmt.Printf(" %.2fs (%s, encode queued)\n", timing.Seconds, stageTimes(timing))
+		return timing, nil
 	}
-	defer outputFile.Close()
 
-	err = png.Encode(outputFile, blurredImg)
+	// Save output, checking it decodes back when -verify-output is set
+	err = common.WriteVerified(outputPath, func(w io.Writer) error {
+		return opts.encodePNG(w, blurredImg)
+	}, opts.verifier(blurredImg), opts.VerifyRetries)
 	if err != nil {
-		return 0, err
+		return stats.ImageTiming{}, err
 	}
 
-	elapsed := time.Since(startTime).Seconds()
-	fmt.Printf(" %.2fs\n", elapsed)
+	timing.Seconds = time.Since(startTime).Seconds()
+	fmt.Printf(" %.2fs (%s)\n", timing.Seconds, stageTimes(timing))
 	
-	return elapsed, nil
+	return timing, nil
+}
+
+// errImageTimeout marks an image whose blur overran -timeout-per-image
+var errImageTimeout = errors.New("blur exceeded the per-image timeout")
+
+// maxAbandonedBlurs bounds how many timed-out blurs may still be running in the
+// background. A blur cannot be interrupted, so each abandoned one keeps its input and
+// output images alive until it returns; past this many, the next timeout waits instead.
+const maxAbandonedBlurs = 1
+
+var abandonedBlurs = make(chan struct{}, maxAbandonedBlurs)
+
+// blurWithTimeout runs blurFn, giving up with errImageTimeout once timeout passes
+// (0 runs it inline). The abandoned blur finishes in the background and its result is
+// dropped.
+func blurWithTimeout(timeout time.Duration, blurFn func() *image.RGBA) (*image.RGBA, error) {
+	if timeout <= 0 {
+		return blurFn(), nil
+	}
+
+	done := make(chan *image.RGBA, 1) // buffered so an abandoned blur can still exit
+	go func() { done <- blurFn() }()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case img := <-done:
+		return img, nil
+	case <-timer.C:
+	}
+
+	select {
+	case abandonedBlurs <- struct{}{}:
+		go func() {
+			<-done
+			<-abandonedBlurs
+		}()
+	default:
+		log.Printf("An earlier abandoned blur is still running; waiting for this one to finish so they do not pile up")
+		<-done
+	}
+	return nil, errImageTimeout
+}
+
+// stageTimes formats the decode and blur split of an image's time, to show whether a
+// slow batch is disk-bound or compute-bound
+func stageTimes(timing stats.ImageTiming) string {
+	return fmt.Sprintf("decode %.2fs / blur %.2fs", timing.DecodeSeconds, timing.BlurSeconds)
 }
\ No newline at end of file
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"studyguide.parallel/pkg/common"
+	"studyguide.parallel/pkg/stats"
+)
+
+// writeInputs writes n random size x size PNGs to a temporary directory and returns
+// their paths with output paths in another temporary directory
+func writeInputs(t *testing.T, n, size int) (inputs, outputs []string) {
+	t.Helper()
+	inDir, outDir := t.TempDir(), t.TempDir()
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		img := image.NewRGBA(image.Rect(0, 0, size, size))
+		rng.Read(img.Pix)
+		path := filepath.Join(inDir, fmt.Sprintf("img%d.png", i))
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(file, img); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		inputs = append(inputs, path)
+		outputs = append(outputs, filepath.Join(outDir, fmt.Sprintf("img%d_blurred.png", i)))
+	}
+	return inputs, outputs
+}
+
+// fullDisk fails every output write once the first ok outputs have been written
+type fullDisk struct {
+	ok      int32
+	outputs atomic.Int32 // outputs whose encode started
+}
+
+func (d *fullDisk) wrap(w io.Writer) io.Writer {
+	if d.outputs.Add(1) > d.ok {
+		return failingWriter{}
+	}
+	return w
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: "output", Err: syscall.ENOSPC}
+}
+
+func TestProcessSequentialStopsOnDiskFull(t *testing.T) {
+	inputs, outputs := writeInputs(t, 4, 16)
+	disk := &fullDisk{ok: 2}
+	result, remaining, err := processSequential(inputs, outputs, 3, processOptions{wrapOutput: disk.wrap})
+	if err != nil {
+		t.Fatalf("a full disk should stop the batch cleanly, got %v", err)
+	}
+	if result.ImagesProcessed != 2 || len(result.OutputPaths) != 2 || len(result.ImageTimings) != 2 {
+		t.Errorf("stats cover %d images (%d outputs, %d timings), want the 2 written before the disk filled",
+			result.ImagesProcessed, len(result.OutputPaths), len(result.ImageTimings))
+	}
+	if got := disk.outputs.Load(); got != 3 {
+		t.Errorf("%d outputs attempted, want the batch to stop at the first failing one (3)", got)
+	}
+	if _, err := os.Stat(outputs[2]); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the partial output of the failed image was left behind")
+	}
+	if len(remaining) != 0 {
+		t.Errorf("remaining = %v; only -deadline lists unstarted inputs", remaining)
+	}
+}
+
+func TestProcessSequentialEncodeWorkersStopOnDiskFull(t *testing.T) {
+	// The first write fails in the background while the far larger second image blurs,
+	// even with the blur holding every core. The loop must stop before it reaches the
+	// third input, which does not exist and would otherwise fail the run.
+	inputs, outputs := writeInputs(t, 1, 16)
+	large, largeOut := writeInputs(t, 1, 512)
+	inputs, outputs = append(inputs, large...), append(outputs, largeOut...)
+	for i := 0; i < 4; i++ {
+		inputs = append(inputs, filepath.Join(t.TempDir(), "never-read.png"))
+		outputs = append(outputs, filepath.Join(t.TempDir(), "never-written.png"))
+	}
+	disk := &fullDisk{ok: 0}
+	result, _, err := processSequential(inputs, outputs, 15, processOptions{EncodeWorkers: 1, wrapOutput: disk.wrap})
+	if err != nil {
+		t.Fatalf("the batch went on past the full disk: %v", err)
+	}
+	if result.ImagesProcessed != 0 || len(result.InputPaths) != 0 {
+		t.Errorf("stats cover %d images, want none: no output was written", result.ImagesProcessed)
+	}
+	if got := disk.outputs.Load(); got != 1 {
+		t.Errorf("%d outputs attempted, want only the first: the second is skipped once the disk is full", got)
+	}
+	for _, path := range outputs {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s exists after its write failed or was skipped", path)
+		}
+	}
+}
+
+func TestProcessSequentialEncodeOverlapsBlur(t *testing.T) {
+	inputs, outputs := writeInputs(t, 3, 48)
+	want := make([][]byte, len(outputs))
+	if _, _, err := processSequential(inputs, outputs, 7, processOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for i, path := range outputs {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[i] = data
+		os.Remove(path)
+	}
+
+	// The first image's encode holds until the second image's starts, which it can
+	// only do once the second image has been blurred while the first was encoding
+	secondStarted := make(chan struct{})
+	var overlapped atomic.Bool
+	wrap := func(w io.Writer) io.Writer {
+		switch filepath.Base(w.(*os.File).Name()) {
+		case filepath.Base(outputs[0]):
+			select {
+			case <-secondStarted:
+				overlapped.Store(true)
+			case <-time.After(5 * time.Second):
+			}
+		case filepath.Base(outputs[1]):
+			close(secondStarted)
+		}
+		return w
+	}
+	result, _, err := processSequential(inputs, outputs, 7, processOptions{EncodeWorkers: 2, wrapOutput: wrap})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !overlapped.Load() {
+		t.Error("the second image was not blurred while the first was encoding")
+	}
+	if result.ImagesProcessed != len(inputs) {
+		t.Errorf("%d images processed, want %d", result.ImagesProcessed, len(inputs))
+	}
+	for i, path := range outputs {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, want[i]) {
+			t.Errorf("%s differs from the inline encode", filepath.Base(path))
+		}
+	}
+}
+
+func TestProcessSequentialNoOutput(t *testing.T) {
+	inputs, outputs := writeInputs(t, 3, 32)
+	result, _, err := processSequential(inputs, outputs, 5, processOptions{NoOutput: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written, _ := os.ReadDir(filepath.Dir(outputs[0])); len(written) != 0 {
+		t.Errorf("-no-output wrote %d files to the output directory", len(written))
+	}
+	if !result.OutputsSuppressed || result.ImagesProcessed != len(inputs) || len(result.ImageTimings) != len(inputs) {
+		t.Fatalf("stats: suppressed %v, %d images, %d timings; want %d of each with outputs suppressed",
+			result.OutputsSuppressed, result.ImagesProcessed, len(result.ImageTimings), len(inputs))
+	}
+	for i, timing := range result.ImageTimings {
+		if timing.Width != 32 || timing.BlurSeconds <= 0 || timing.Seconds < timing.BlurSeconds {
+			t.Errorf("image %d timing %+v, want its size, blur time and total", i, timing)
+		}
+	}
+	if result.TotalTime <= 0 || result.TotalBlurTime == nil || *result.TotalBlurTime <= 0 {
+		t.Errorf("run timing: total %.3fs, blur %v", result.TotalTime, result.TotalBlurTime)
+	}
+}
+
+func TestProcessSequentialKeepsStatsOnLateFailure(t *testing.T) {
+	// The last input does not exist, so the batch fails on it after two successes
+	inputs, outputs := writeInputs(t, 2, 16)
+	inputs = append(inputs, filepath.Join(t.TempDir(), "missing.png"))
+	outputs = append(outputs, filepath.Join(t.TempDir(), "missing_blurred.png"))
+	result, _, err := processSequential(inputs, outputs, 3, processOptions{})
+	if err == nil {
+		t.Fatal("a missing input did not fail the batch")
+	}
+	if result.ImagesProcessed != 2 || !reflect.DeepEqual(result.InputPaths, inputs[:2]) || !reflect.DeepEqual(result.OutputPaths, outputs[:2]) {
+		t.Errorf("stats cover %d images (%v), want the 2 completed before the failure", result.ImagesProcessed, result.InputPaths)
+	}
+	if len(result.ImageTimings) != 2 || result.TotalBlurTime == nil {
+		t.Errorf("stats have %d image timings and blur time %v, want both recorded", len(result.ImageTimings), result.TotalBlurTime)
+	}
+}
+
+func TestProcessSequentialStopsAtDeadline(t *testing.T) {
+	// The large first image outlasts the deadline, so the two after it never start
+	inputs, outputs := writeInputs(t, 1, 512)
+	small, smallOut := writeInputs(t, 2, 16)
+	inputs, outputs = append(inputs, small...), append(outputs, smallOut...)
+	opts := processOptions{Deadline: time.Now().Add(20 * time.Millisecond)}
+	result, remaining, err := processSequential(inputs, outputs, 15, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ImagesProcessed != 1 || !reflect.DeepEqual(remaining, small) {
+		t.Fatalf("processed %d images with %v remaining, want 1 and %v", result.ImagesProcessed, remaining, small)
+	}
+
+	// The resume file lists them for -input-list, and a later complete run removes it
+	path := filepath.Join(t.TempDir(), "resume", "unprocessed.txt")
+	writeResumeFile(path, remaining)
+	listed, err := common.ReadInputList(path)
+	if err != nil || !reflect.DeepEqual(listed, small) {
+		t.Errorf("resume file lists %v (%v), want %v", listed, err, small)
+	}
+	writeResumeFile(path, nil)
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Error("a run that finished every input left the stale resume file")
+	}
+}
+
+func TestBlurWithTimeout(t *testing.T) {
+	want := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	if img, err := blurWithTimeout(0, func() *image.RGBA { return want }); img != want || err != nil {
+		t.Errorf("without a timeout got %p, %v; want the blur's own result", img, err)
+	}
+	if img, err := blurWithTimeout(time.Second, func() *image.RGBA { return want }); img != want || err != nil {
+		t.Errorf("a blur inside the timeout got %p, %v; want its result", img, err)
+	}
+
+	// Only one abandoned blur may run at a time, and an earlier test's may still be
+	// running, so the slow blur must end on its own
+	start := time.Now()
+	img, err := blurWithTimeout(10*time.Millisecond, func() *image.RGBA {
+		time.Sleep(200 * time.Millisecond)
+		return want
+	})
+	if img != nil || !errors.Is(err, errImageTimeout) {
+		t.Errorf("a stuck blur got %p, %v; want errImageTimeout", img, err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("waited %s for a blur with a 10ms timeout", waited)
+	}
+}
+
+func TestProcessSequentialSkipsTimedOutImage(t *testing.T) {
+	// The large middle image overruns its timeout; the batch goes on to the last one
+	inputs, outputs := writeInputs(t, 1, 16)
+	large, largeOut := writeInputs(t, 1, 512)
+	last, lastOut := writeInputs(t, 1, 16)
+	inputs, outputs = append(append(inputs, large...), last...), append(append(outputs, largeOut...), lastOut...)
+	result, _, err := processSequential(inputs, outputs, 15, processOptions{ImageTimeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{inputs[0], inputs[2]}
+	if result.ImagesProcessed != 2 || !reflect.DeepEqual(result.InputPaths, want) || len(result.ImageTimings) != 2 {
+		t.Errorf("stats cover %v (%d timings), want %v", result.InputPaths, len(result.ImageTimings), want)
+	}
+	if _, err := os.Stat(outputs[1]); !errors.Is(err, os.ErrNotExist) {
+		t.Error("the abandoned image was written")
+	}
+}
+
+func TestDropFailedEncodes(t *testing.T) {
+	inputs := []string{"a.png", "b.png", "c.png", "d.png"}
+	outputs := []string{"a_out.png", "b_out.png", "c_out.png", "d_out.png"}
+	timings := []stats.ImageTiming{{Seconds: 1}, {Seconds: 2}, {Seconds: 3}, {Seconds: 4}}
+	failed := []common.EncodeFailure{
+		{Path: "b_out.png", Err: fmt.Errorf("image 2: %w", errImageTimeout)},
+		{Path: "d_out.png", Err: fmt.Errorf("%w: d_out.png does not decode", common.ErrInvalidOutput)},
+	}
+	keptIn, keptOut, keptTimings, err := dropFailedEncodes(failed, inputs, outputs, timings)
+	if err != nil {
+		t.Errorf("a timeout and a failed verification returned %v; they only lose their own images", err)
+	}
+	if !reflect.DeepEqual(keptIn, []string{"a.png", "c.png"}) || !reflect.DeepEqual(keptOut, []string{"a_out.png", "c_out.png"}) ||
+		!reflect.DeepEqual(keptTimings, []stats.ImageTiming{{Seconds: 1}, {Seconds: 3}}) {
+		t.Errorf("kept %v / %v / %v, want images a and c", keptIn, keptOut, keptTimings)
+	}
+
+	// Any other write error also stops the run
+	failed = append(failed, common.EncodeFailure{Path: "a_out.png", Err: errors.New("permission denied")})
+	keptIn, _, _, err = dropFailedEncodes(failed, inputs, outputs, timings)
+	if err == nil || !reflect.DeepEqual(keptIn, []string{"c.png"}) {
+		t.Errorf("with a failed write: kept %v, error %v; want only c and the write error", keptIn, err)
+	}
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"studyguide.parallel/a/sequential"
+	"studyguide.parallel/pkg/common"
 	"studyguide.parallel/pkg/stats"
 )
 
@@ -25,11 +28,15 @@ func main() {
 		"output/img5_blurred.png",
 	}
 
+	common.HandleInterrupts()
 	fmt.Println("Running Sequential Implementation:")
-	result := Run_a(inputPaths, outputPaths, kernelSize)
+	result, err := sequential.Run_a(inputPaths, outputPaths, kernelSize)
 	
-	// Write results
+	// Write results, including those of the images finished before a failure
 	results := []stats.PerformanceData{result}
 	stats.WritePerformanceResults(results)
 	fmt.Println("Results written to logs/")
+	if err != nil {
+		log.Fatalf("Stopped after %d images: %v", result.ImagesProcessed, err)
+	}
 }
\ No newline at end of file
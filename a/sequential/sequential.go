@@ -1,4 +1,4 @@
-package main
+package sequential
 
 import (
 	"fmt"
@@ -8,6 +8,7 @@ import (
 	"os"
 	"time"
 	"studyguide.parallel/pkg/blur"
+	"studyguide.parallel/pkg/common"
 	"studyguide.parallel/pkg/stats"
 )
 
@@ -39,9 +40,11 @@ func RunSequentialSingle(inputPath, outputPath string, kernelSize int) (float64,
 		return 0, fmt.Errorf("failed to create output file: %v", err)
 	}
 	defer outFile.Close()
+	common.WritingOutput(outputPath)
+	defer common.WritingOutput("")
 
 	// Encode and save
-	err = png.Encode(outFile, blurred) // saves the manipulated image object to a file
+	err = common.EncodePNG(outFile, blurred, png.DefaultCompression) // saves the manipulated image object to a file
 	if err != nil {
 		return 0, fmt.Errorf("failed to encode image: %v", err)
 	}
@@ -51,8 +54,12 @@ func RunSequentialSingle(inputPath, outputPath string, kernelSize int) (float64,
 	return duration, nil
 }
 
-// RunSequentialMultiple executes sequential blur for multiple images
-func Run_a(inputPaths []string, outputPaths []string, kernelSize int) stats.PerformanceData {
+// runSingle blurs one image of a Run_a batch; tests wrap it to act between images
+var runSingle = RunSequentialSingle
+
+// RunSequentialMultiple executes sequential blur for multiple images. If an image fails,
+// the batch stops and the error is returned with the stats of the images before it.
+func Run_a(inputPaths []string, outputPaths []string, kernelSize int) (stats.PerformanceData, error) {
 	fmt.Println("=== Starting Sequential Multi-Image Gaussian Blur ===")
 	startTime := time.Now()
 	
@@ -61,31 +68,48 @@ func Run_a(inputPaths []string, outputPaths []string, kernelSize int) stats.Perf
 	}
 
 	totalBlurTime := 0.0
+	var failure error
+	interrupted := false
 	
 	for i, inputPath := range inputPaths {
-		imageTime, err := RunSequentialSingle(inputPath, outputPaths[i], kernelSize)
+		// After an interrupt, report only the images already written (see common.HandleInterrupts)
+		if common.Interrupted() {
+			log.Printf("Interrupted: stopping after %d of %d images", i, len(inputPaths))
+			inputPaths, outputPaths = inputPaths[:i], outputPaths[:i]
+			interrupted = true
+			break
+		}
+		imageTime, err := runSingle(inputPath, outputPaths[i], kernelSize)
 		if err != nil {
-			log.Fatalf("Error processing image %d: %v", i+1, err)
+			// Report the images already written so the caller can record them before exiting
+			failure = fmt.Errorf("error processing image %d: %w", i+1, err)
+			inputPaths, outputPaths = inputPaths[:i], outputPaths[:i]
+			break
 		}
 		totalBlurTime += imageTime
 	}
 
 	totalTime := time.Since(startTime).Seconds()
+	averageTime := 0.0
+	if len(inputPaths) > 0 {
+		averageTime = totalTime / float64(len(inputPaths))
+	}
 	fmt.Printf("\n=== Sequential Multi-Image Blur Complete ===\n")
 	fmt.Printf("Images processed: %d\n", len(inputPaths))
 	fmt.Printf("Total blur time: %.2fs\n", totalBlurTime)
 	fmt.Printf("Total execution time: %.2fs\n", totalTime)
-	fmt.Printf("Average time per image: %.2fs\n", totalTime/float64(len(inputPaths)))
+	fmt.Printf("Average time per image: %.2fs\n", averageTime)
 	
 	return stats.PerformanceData{
 		AlgorithmName:   "Sequential",
 		ImagesProcessed: len(inputPaths),
 		KernelSize:      kernelSize,
 		TotalTime:       totalTime,
-		AverageTime:     totalTime / float64(len(inputPaths)),
+		AverageTime:     averageTime,
 		InputPaths:      inputPaths,
 		OutputPaths:     outputPaths,
 		Timestamp:       startTime,
 		TotalBlurTime:   &totalBlurTime,
-	}
+		Interrupted:     interrupted,
+	}, failure
 }
\ No newline at end of file
@@ -0,0 +1,70 @@
+package sequential
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"studyguide.parallel/pkg/common"
+)
+
+func TestInterruptStopsBetweenImages(t *testing.T) {
+	inDir, outDir := t.TempDir(), t.TempDir()
+	var inputs, outputs []string
+	for i := 0; i < 3; i++ {
+		img := image.NewRGBA(image.Rect(0, 0, 24, 16))
+		for p := range img.Pix {
+			img.Pix[p] = uint8(p * (i + 3))
+		}
+		in := filepath.Join(inDir, fmt.Sprintf("img%d.png", i+1))
+		f, err := os.Create(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(f, img); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		inputs = append(inputs, in)
+		outputs = append(outputs, filepath.Join(outDir, filepath.Base(in)))
+	}
+
+	// Ctrl-C arrives while the first image is being blurred
+	calls := 0
+	runSingle = func(inputPath, outputPath string, kernelSize int) (float64, error) {
+		calls++
+		if calls == 1 {
+			common.RequestInterrupt()
+		}
+		return RunSequentialSingle(inputPath, outputPath, kernelSize)
+	}
+	t.Cleanup(func() {
+		runSingle = RunSequentialSingle
+		common.ClearInterrupt()
+	})
+
+	result, err := Run_a(inputs, outputs, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("blurred %d images after the interrupt, want only the one in hand", calls)
+	}
+	if !result.Interrupted || result.ImagesProcessed != 1 ||
+		!reflect.DeepEqual(result.InputPaths, inputs[:1]) || !reflect.DeepEqual(result.OutputPaths, outputs[:1]) {
+		t.Errorf("stats after the interrupt: interrupted %v, %d images %v -> %v; want 1 image, %s",
+			result.Interrupted, result.ImagesProcessed, result.InputPaths, result.OutputPaths, inputs[0])
+	}
+	if _, err := os.Stat(outputs[0]); err != nil {
+		t.Errorf("the image in hand was not finished: %v", err)
+	}
+	for _, out := range outputs[1:] {
+		if _, err := os.Stat(out); !os.IsNotExist(err) {
+			t.Errorf("%s written after the interrupt", out)
+		}
+	}
+}
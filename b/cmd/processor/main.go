@@ -13,16 +13,35 @@ import (
 	"sync"
 	"time"
 	"studyguide.parallel/pkg/blur"
+	"studyguide.parallel/pkg/common"
 	"studyguide.parallel/pkg/stats"
 )
 
 func main() {
 	var (
-		inputPath  = flag.String("input", "/input", "Input directory path")
-		outputPath = flag.String("output", "/data/b/output", "Output directory path")
-		kernelSize = flag.Int("kernel", 15, "Gaussian kernel size")
+		inputPath   = flag.String("input", "/input", "Input directory path")
+		outputPath  = flag.String("output", "/data/b/output", "Output directory path")
+		kernelSize  = flag.Int("kernel", 15, "Gaussian kernel size")
+		radius      = flag.Int("radius", 0, "Blur radius R, an alternative to -kernel: sets the kernel size to 2R+1 (0 = use -kernel)")
+		pngComp     = flag.String("png-compression", "default", "PNG compression level: default, none, speed or best")
+		noOutput    = flag.Bool("no-output", false, "Decode and blur but skip encoding/writing outputs (pure compute benchmark)")
+		summaryOnly = flag.Bool("summary-only", false, "Write only aggregate numbers to the stats file, without per-file listings")
+		validate    = flag.Bool("validate", false, "Preflight: check every input decodes (header only) and report, without processing")
+		strict      = flag.Bool("strict", false, "With -validate, exit non-zero if any input is invalid")
+		manifest    = flag.Bool("manifest", false, "Write outputs.json in the output directory mapping each input to its output, size, kernel and time")
+		keepMTime   = flag.Bool("preserve-mtime", false, "Give each output file its input's modification time, for archives")
+		inputSort   = flag.String("input-sort", "", "Process inputs in this order: name, size or mtime, with :desc to reverse (default: as listed)")
+		failOnEmpty = flag.Bool("fail-on-empty", false, "Exit with status 3 instead of 0 when the input directory has no images")
 	)
 	flag.Parse()
+	if err := common.ApplyRadiusFlag(kernelSize, *radius); err != nil {
+		log.Fatalf("Invalid -radius: %v", err)
+	}
+
+	pngLevel, err := common.ParsePNGCompression(*pngComp)
+	if err != nil {
+		log.Fatalf("Invalid -png-compression: %v", err)
+	}
 
 	startTime := time.Now()
 	log.Printf("=== Starting Tile Parallel Image Processing ===")
@@ -30,20 +49,38 @@ func main() {
 	log.Printf("Kernel size: %d", *kernelSize)
 	log.Printf("Input path: %s", *inputPath)
 	log.Printf("Output path: %s", *outputPath)
+	log.Printf("PNG compression: %s", *pngComp)
+	if *noOutput {
+		log.Printf("Output suppressed (-no-output): timings exclude encode and write")
+	}
 
 	// Create output directory
-	if err := os.MkdirAll(*outputPath, 0755); err != nil {
-		log.Fatalf("Failed to create output directory: %v", err)
+	if !*noOutput && !*validate {
+		if err := os.MkdirAll(*outputPath, 0755); err != nil {
+			log.Fatalf("Failed to create output directory: %v", err)
+		}
 	}
 
 	// Find all PNG files in input directory
-	files, err := filepath.Glob(filepath.Join(*inputPath, "*.png"))
+	files, err := common.FindInputs(*inputPath, *inputSort)
 	if err != nil {
 		log.Fatalf("Failed to find input files: %v", err)
 	}
 
 	if len(files) == 0 {
-		log.Fatalf("No PNG files found in %s", *inputPath)
+		log.Printf("No PNG files found in %s; nothing to process", *inputPath)
+		if *failOnEmpty {
+			os.Exit(common.ExitNoInputs)
+		}
+		return
+	}
+
+	if *validate {
+		invalid := common.WriteValidationReport(os.Stdout, common.ValidateInputs(files))
+		if invalid > 0 && *strict {
+			os.Exit(1)
+		}
+		return
 	}
 
 	// Create input and output paths for Run_b
@@ -63,16 +100,50 @@ func main() {
 	log.Printf("Found %d images to process", len(inputPaths))
 
 	// Process images with tile parallelism
-	result := processTileParallel(inputPaths, outputPaths, *kernelSize)
+	opts := processOptions{
+		PNGLevel: pngLevel,
+		NoOutput: *noOutput,
+	}
+	heap := stats.StartHeapSampler(stats.DefaultHeapSampleInterval)
+	result, err := processTileParallel(inputPaths, outputPaths, *kernelSize, opts)
+	result.PeakHeapBytes = heap.Stop()
+
+	if *keepMTime {
+		preserveModTimes(result, *noOutput)
+	}
 
 	// Write performance results
 	results := []stats.PerformanceData{result}
-	stats.WritePerformanceResults(results)
+	stats.WritePerformanceResultsWithOptions(results, "abc_", stats.WriteOptions{SummaryOnly: *summaryOnly})
+	if err != nil {
+		log.Fatalf("Stopped after %d completed images, whose stats were written: %v", result.ImagesProcessed, err)
+	}
+
+	if *manifest {
+		if *noOutput {
+			log.Printf("Skipping %s: outputs were suppressed by -no-output", stats.ManifestFile)
+		} else if path, err := stats.WriteManifest(*outputPath, result); err != nil {
+			log.Printf("Failed to write output manifest: %v", err)
+		} else {
+			log.Printf("Output manifest written to %s", path)
+		}
+	}
 
 	log.Printf("=== Processing Complete ===")
 	log.Printf("Total execution time: %.2fs", time.Since(startTime).Seconds())
 }
 
+// preserveModTimes gives every written output its input's modification time, for -preserve-mtime
+func preserveModTimes(result stats.PerformanceData, noOutput bool) {
+	if noOutput {
+		log.Printf("Skipping -preserve-mtime: outputs were suppressed by -no-output")
+		return
+	}
+	if err := common.CopyModTimes(result.OutputPaths, result.InputPaths); err != nil {
+		log.Printf("Failed to preserve modification times: %v", err)
+	}
+}
+
 const (
 	TILE_SIZE    = 256
 	NUM_WORKERS  = 10
@@ -104,7 +175,16 @@ type Command struct {
 	Tile *Tile
 }
 
-func processTileParallel(inputPaths []string, outputPaths []string, kernelSize int) stats.PerformanceData {
+// processOptions carries the per-run settings shared by every image
+type processOptions struct {
+	PNGLevel png.CompressionLevel
+	NoOutput bool // skip encode and write, for benchmarking the blur alone
+}
+
+// processTileParallel blurs the batch one image at a time. An error it cannot continue
+// past stops the batch; it is returned with the stats of the images completed before
+// it, so the caller can record them before exiting.
+func processTileParallel(inputPaths []string, outputPaths []string, kernelSize int, opts processOptions) (stats.PerformanceData, error) {
 	fmt.Println("=== Starting Parallel Multi-Image Gaussian Blur ===")
 	startTime := time.Now()
 	
@@ -113,57 +193,100 @@ func processTileParallel(inputPaths []string, outputPaths []string, kernelSize i
 	}
 
 	totalBlurTime := 0.0
+	var timings []stats.ImageTiming
+	var failure error
 	
 	for i, inputPath := range inputPaths {
-		imageTime, err := runTileParallelSingle(inputPath, outputPaths[i], kernelSize)
+		timing, err := runTileParallelSingle(inputPath, outputPaths[i], kernelSize, opts)
 		if err != nil {
-			log.Fatalf("Error processing image %d: %v", i+1, err)
+			if common.IsDiskFull(err) {
+				// Every later write would fail too; keep what finished and flush stats for it
+				log.Printf("Output disk is full while writing image %d; stopping batch after %d completed images", i+1, i)
+				os.Remove(outputPaths[i])
+				inputPaths, outputPaths = inputPaths[:i], outputPaths[:i]
+				break
+			}
+			failure = fmt.Errorf("error processing image %d: %w", i+1, err)
+			inputPaths, outputPaths = inputPaths[:i], outputPaths[:i]
+			break
 		}
-		totalBlurTime += imageTime
+		totalBlurTime += timing.Seconds
+		timings = append(timings, timing)
 	}
 
 	totalTime := time.Since(startTime).Seconds()
+	averageTime := 0.0
+	if len(inputPaths) > 0 {
+		averageTime = totalTime / float64(len(inputPaths))
+	}
 	fmt.Printf("\n=== Parallel Multi-Image Blur Complete ===\n")
 	fmt.Printf("Images processed: %d\n", len(inputPaths))
 	fmt.Printf("Total blur time: %.2fs\n", totalBlurTime)
 	fmt.Printf("Total execution time: %.2fs\n", totalTime)
-	fmt.Printf("Average time per image: %.2fs\n", totalTime/float64(len(inputPaths)))
+	fmt.Printf("Average time per image: %.2fs\n", averageTime)
 	
-	return stats.PerformanceData{
-		AlgorithmName:   "Parallel",
-		ImagesProcessed: len(inputPaths),
-		KernelSize:      kernelSize,
-		TotalTime:       totalTime,
-		AverageTime:     totalTime / float64(len(inputPaths)),
-		InputPaths:      inputPaths,
-		OutputPaths:     outputPaths,
+	if opts.NoOutput {
+		outputPaths = nil
 	}
+
+	return stats.PerformanceData{
+		AlgorithmName:     "Parallel",
+		ImagesProcessed:   len(inputPaths),
+		KernelSize:        kernelSize,
+		TotalTime:         totalTime,
+		AverageTime:       averageTime,
+		InputPaths:        inputPaths,
+		OutputPaths:       outputPaths,
+		TotalBlurTime:     &totalBlurTime,
+		OutputsSuppressed: opts.NoOutput,
+		ImageTimings:      timings,
+	}, failure
 }
 
-func runTileParallelSingle(inputPath, outputPath string, kernelSize int) (float64, error) {
+func runTileParallelSingle(inputPath, outputPath string, kernelSize int, opts processOptions) (stats.ImageTiming, error) {
 	startTime := time.Now()
 	
 	// Load image
 	img, err := loadImage(inputPath)
 	if err != nil {
-		return 0, err
+		return stats.ImageTiming{}, err
 	}
+	decodeTime := time.Since(startTime).Seconds()
 
 	fmt.Printf("  Processing %s (%dx%d)...", filepath.Base(inputPath), img.Bounds().Dx(), img.Bounds().Dy())
 
 	// Process with tile parallelism
+	blurStart := time.Now()
 	result := processImageWithTiles(img, kernelSize)
+	timing := stats.ImageTiming{
+		Width:         img.Bounds().Dx(),
+		Height:        img.Bounds().Dy(),
+		DecodeSeconds: decodeTime,
+		BlurSeconds:   time.Since(blurStart).Seconds(),
+	}
+
+	if opts.NoOutput {
+		timing.Seconds = time.Since(startTime).Seconds()
+		fmt.Printf(" %.2fs (%s, output suppressed)\n", timing.Seconds, stageTimes(timing))
+		return timing, nil
+	}
 
 	// Save result
-	err = saveImage(result, outputPath)
+	err = saveImage(result, outputPath, opts.PNGLevel)
 	if err != nil {
-		return 0, err
+		return stats.ImageTiming{}, err
 	}
 
-	elapsed := time.Since(startTime).Seconds()
-	fmt.Printf(" %.2fs\n", elapsed)
+	timing.Seconds = time.Since(startTime).Seconds()
+	fmt.Printf(" %.2fs (%s)\n", timing.Seconds, stageTimes(timing))
 	
-	return elapsed, nil
+	return timing, nil
+}
+
+// stageTimes formats the decode and blur split of an image's time, to show whether a
+// slow batch is disk-bound or compute-bound
+func stageTimes(timing stats.ImageTiming) string {
+	return fmt.Sprintf("decode %.2fs / blur %.2fs", timing.DecodeSeconds, timing.BlurSeconds)
 }
 
 func loadImage(imagePath string) (*image.RGBA, error) {
@@ -179,25 +302,19 @@ func loadImage(imagePath string) (*image.RGBA, error) {
 	}
 
 	// Convert to RGBA
-	bounds := img.Bounds()
-	rgba := image.NewRGBA(bounds)
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			rgba.Set(x, y, img.At(x, y))
-		}
-	}
+	rgba := blur.ToRGBA(img)
 	
 	return rgba, nil
 }
 
-func saveImage(img *image.RGBA, outputPath string) error {
+func saveImage(img *image.RGBA, outputPath string, pngLevel png.CompressionLevel) error {
 	outputFile, err := os.Create(outputPath)
 	if err != nil {
 		return err
 	}
 	defer outputFile.Close()
 
-	return png.Encode(outputFile, img)
+	return common.EncodePNG(outputFile, img, pngLevel)
 }
 
 func processImageWithTiles(img *image.RGBA, kernelSize int) *image.RGBA {
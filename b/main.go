@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"studyguide.parallel/b/tileparallel"
+	"studyguide.parallel/pkg/common"
 	"studyguide.parallel/pkg/stats"
 )
 
@@ -25,11 +28,15 @@ func main() {
 		"output/img5_blurred.png",
 	}
 
+	common.HandleInterrupts()
 	fmt.Println("Running Tile Parallel Implementation:")
-	result := Run_b(inputPaths, outputPaths, kernelSize)
+	result, err := tileparallel.Run_b(inputPaths, outputPaths, kernelSize)
 	
-	// Write results
+	// Write results, including those of the images finished before a failure
 	results := []stats.PerformanceData{result}
 	stats.WritePerformanceResults(results)
 	fmt.Println("Results written to logs/")
+	if err != nil {
+		log.Fatalf("Stopped after %d images: %v", result.ImagesProcessed, err)
+	}
 }
\ No newline at end of file
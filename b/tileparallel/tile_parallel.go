@@ -1,4 +1,4 @@
-package main
+package tileparallel
 
 import (
 	"fmt"
@@ -10,6 +10,7 @@ import (
 	"sync"
 	"time"
 	"studyguide.parallel/pkg/blur"
+	"studyguide.parallel/pkg/common"
 	"studyguide.parallel/pkg/stats"
 )
 
@@ -61,13 +62,8 @@ func imageReader(imagePath string) (*image.RGBA, error) {
 	}
 	
 	// Convert to RGBA
-	bounds := img.Bounds()
-	rgba := image.NewRGBA(bounds)
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			rgba.Set(x, y, img.At(x, y))
-		}
-	}
+	rgba := blur.ToRGBA(img)
+	bounds := rgba.Bounds()
 	
 	fmt.Printf("ImageReader: Loaded image %dx%d in %.2fms\n", 
 		bounds.Dx(), bounds.Dy(), 
@@ -132,35 +128,30 @@ func coordinator(img *image.RGBA, tileQueue chan<- Command, kernelSize int) {
 func extractTileWithPadding(img *image.RGBA, tileX, tileY, tileWidth, tileHeight, padding int) *Tile {
 	bounds := img.Bounds()
 	
-	// Calculate padded dimensions
-	startX := tileX - padding
-	startY := tileY - padding
-	endX := tileX + tileWidth + padding
-	endY := tileY + tileHeight + padding
-	
-	// Clamp to image bounds
-	if startX < bounds.Min.X {
-		startX = bounds.Min.X
-	}
-	if startY < bounds.Min.Y {
-		startY = bounds.Min.Y
-	}
-	if endX > bounds.Max.X {
-		endX = bounds.Max.X
-	}
-	if endY > bounds.Max.Y {
-		endY = bounds.Max.Y
-	}
-	
-	// Create tile data
-	paddedWidth := endX - startX
-	paddedHeight := endY - startY
+	// Always build the full padded region, replicating edge pixels past the
+	// image bounds, so the padding ExtractCenter strips is the same on every tile
+	paddedWidth := tileWidth + 2*padding
+	paddedHeight := tileHeight + 2*padding
 	data := make([][]color.RGBA, paddedHeight)
 	
 	for y := 0; y < paddedHeight; y++ {
 		data[y] = make([]color.RGBA, paddedWidth)
+		srcY := tileY + y - padding
+		if srcY < bounds.Min.Y {
+			srcY = bounds.Min.Y
+		}
+		if srcY >= bounds.Max.Y {
+			srcY = bounds.Max.Y - 1
+		}
 		for x := 0; x < paddedWidth; x++ {
-			data[y][x] = img.RGBAAt(startX+x, startY+y)
+			srcX := tileX + x - padding
+			if srcX < bounds.Min.X {
+				srcX = bounds.Min.X
+			}
+			if srcX >= bounds.Max.X {
+				srcX = bounds.Max.X - 1
+			}
+			data[y][x] = img.RGBAAt(srcX, srcY)
 		}
 	}
 	
@@ -243,7 +234,7 @@ func assembler(resultQueue <-chan *ProcessedTile, outputPath string, imgWidth, i
 	}
 	defer outFile.Close()
 	
-	err = png.Encode(outFile, output)
+	err = common.EncodePNG(outFile, output, png.DefaultCompression)
 	if err != nil {
 		log.Fatalf("Assembler: Failed to encode image: %v", err)
 	}
@@ -333,16 +324,22 @@ func assemblerQuiet(resultQueue <-chan *ProcessedTile, outputPath string, imgWid
 		return
 	}
 	defer outFile.Close()
+	common.WritingOutput(outputPath)
+	defer common.WritingOutput("")
 	
-	err = png.Encode(outFile, output)
+	err = common.EncodePNG(outFile, output, png.DefaultCompression)
 	if err != nil {
 		log.Printf("Failed to encode image: %v", err)
 		return
 	}
 }
 
-// RunParallelMultiple executes parallel blur for multiple images
-func Run_b(inputPaths []string, outputPaths []string, kernelSize int) stats.PerformanceData {
+// runSingle blurs one image of a Run_b batch; tests wrap it to act between images
+var runSingle = RunParallelSingle
+
+// RunParallelMultiple executes parallel blur for multiple images. If an image fails,
+// the batch stops and the error is returned with the stats of the images before it.
+func Run_b(inputPaths []string, outputPaths []string, kernelSize int) (stats.PerformanceData, error) {
 	fmt.Println("=== Starting Parallel Multi-Image Gaussian Blur ===")
 	startTime := time.Now()
 	
@@ -351,21 +348,37 @@ func Run_b(inputPaths []string, outputPaths []string, kernelSize int) stats.Perf
 	}
 
 	totalBlurTime := 0.0
+	var failure error
+	interrupted := false
 	
 	for i, inputPath := range inputPaths {
-		imageTime, err := RunParallelSingle(inputPath, outputPaths[i], kernelSize)
+		// After an interrupt, report only the images already written (see common.HandleInterrupts)
+		if common.Interrupted() {
+			log.Printf("Interrupted: stopping after %d of %d images", i, len(inputPaths))
+			inputPaths, outputPaths = inputPaths[:i], outputPaths[:i]
+			interrupted = true
+			break
+		}
+		imageTime, err := runSingle(inputPath, outputPaths[i], kernelSize)
 		if err != nil {
-			log.Fatalf("Error processing image %d: %v", i+1, err)
+			// Report the images already written so the caller can record them before exiting
+			failure = fmt.Errorf("error processing image %d: %w", i+1, err)
+			inputPaths, outputPaths = inputPaths[:i], outputPaths[:i]
+			break
 		}
 		totalBlurTime += imageTime
 	}
 
 	totalTime := time.Since(startTime).Seconds()
+	averageTime := 0.0
+	if len(inputPaths) > 0 {
+		averageTime = totalTime / float64(len(inputPaths))
+	}
 	fmt.Printf("\n=== Parallel Multi-Image Blur Complete ===\n")
 	fmt.Printf("Images processed: %d\n", len(inputPaths))
 	fmt.Printf("Total blur time: %.2fs\n", totalBlurTime)
 	fmt.Printf("Total execution time: %.2fs\n", totalTime)
-	fmt.Printf("Average time per image: %.2fs\n", totalTime/float64(len(inputPaths)))
+	fmt.Printf("Average time per image: %.2fs\n", averageTime)
 	
 	workers := NUM_WORKERS
 	tileSize := TILE_SIZE
@@ -375,12 +388,13 @@ func Run_b(inputPaths []string, outputPaths []string, kernelSize int) stats.Perf
 		ImagesProcessed: len(inputPaths),
 		KernelSize:      kernelSize,
 		TotalTime:       totalTime,
-		AverageTime:     totalTime / float64(len(inputPaths)),
+		AverageTime:     averageTime,
 		InputPaths:      inputPaths,
 		OutputPaths:     outputPaths,
 		Timestamp:       startTime,
 		TotalBlurTime:   &totalBlurTime,
+		Interrupted:     interrupted,
 		Workers:         &workers,
 		TileSize:        &tileSize,
-	}
+	}, failure
 }
\ No newline at end of file
@@ -9,19 +9,43 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+	"studyguide.parallel/c/pipelined"
 	"studyguide.parallel/pkg/blur"
+	"studyguide.parallel/pkg/common"
 	"studyguide.parallel/pkg/stats"
 )
 
 func main() {
 	var (
-		inputPath  = flag.String("input", "/input", "Input directory path")
-		outputPath = flag.String("output", "/data/c/output", "Output directory path") 
-		kernelSize = flag.Int("kernel", 15, "Gaussian kernel size")
+		inputPath   = flag.String("input", "/input", "Input directory path")
+		outputPath  = flag.String("output", "/data/c/output", "Output directory path") 
+		kernelSize  = flag.Int("kernel", 15, "Gaussian kernel size")
+		radius      = flag.Int("radius", 0, "Blur radius R, an alternative to -kernel: sets the kernel size to 2R+1 (0 = use -kernel)")
+		memoryMB    = flag.Int("memory-budget-mb", 0, "Limit concurrent image decodes to this estimated RAM budget in MB (0 = unlimited)")
+		pngComp     = flag.String("png-compression", "default", "PNG compression level: default, none, speed or best")
+		noOutput    = flag.Bool("no-output", false, "Decode and blur but skip encoding/writing outputs (pure compute benchmark)")
+		summaryOnly = flag.Bool("summary-only", false, "Write only aggregate numbers to the stats file, without per-file listings")
+		validate    = flag.Bool("validate", false, "Preflight: check every input decodes (header only) and report, without processing")
+		strict      = flag.Bool("strict", false, "With -validate, exit non-zero if any input is invalid")
+		manifest    = flag.Bool("manifest", false, "Write outputs.json in the output directory mapping each input to its output, size, kernel and time")
+		keepMTime   = flag.Bool("preserve-mtime", false, "Give each output file its input's modification time, for archives")
+		inputSort   = flag.String("input-sort", "", "Process inputs in this order: name, size or mtime, with :desc to reverse (default: as listed)")
+		failOnEmpty = flag.Bool("fail-on-empty", false, "Exit with status 3 instead of 0 when the input directory has no images")
+		failFast    = flag.Bool("fail-fast", false, "Abort the whole run on the first input that fails to open or decode (default: log it and process the rest)")
 	)
 	flag.Parse()
+	if err := common.ApplyRadiusFlag(kernelSize, *radius); err != nil {
+		log.Fatalf("Invalid -radius: %v", err)
+	}
+
+	pngLevel, err := common.ParsePNGCompression(*pngComp)
+	if err != nil {
+		log.Fatalf("Invalid -png-compression: %v", err)
+	}
 
 	startTime := time.Now()
 	log.Printf("=== Starting Pipelined Image Processing ===")
@@ -29,35 +53,92 @@ func main() {
 	log.Printf("Kernel size: %d", *kernelSize)
 	log.Printf("Input path: %s", *inputPath)
 	log.Printf("Output path: %s", *outputPath)
+	log.Printf("PNG compression: %s", *pngComp)
+	if *memoryMB > 0 {
+		log.Printf("Memory budget: %d MB", *memoryMB)
+	}
+	if *noOutput {
+		log.Printf("Output suppressed (-no-output): timings exclude encode and write")
+	}
 
 	// Create output directory
-	if err := os.MkdirAll(*outputPath, 0755); err != nil {
-		log.Fatalf("Failed to create output directory: %v", err)
+	if !*noOutput && !*validate {
+		if err := os.MkdirAll(*outputPath, 0755); err != nil {
+			log.Fatalf("Failed to create output directory: %v", err)
+		}
 	}
 
 	// Find all PNG files in input directory
-	files, err := filepath.Glob(filepath.Join(*inputPath, "*.png"))
+	files, err := common.FindInputs(*inputPath, *inputSort)
 	if err != nil {
 		log.Fatalf("Failed to find input files: %v", err)
 	}
 
 	if len(files) == 0 {
-		log.Fatalf("No PNG files found in %s", *inputPath)
+		log.Printf("No PNG files found in %s; nothing to process", *inputPath)
+		if *failOnEmpty {
+			os.Exit(common.ExitNoInputs)
+		}
+		return
+	}
+
+	if *validate {
+		invalid := common.WriteValidationReport(os.Stdout, common.ValidateInputs(files))
+		if invalid > 0 && *strict {
+			os.Exit(1)
+		}
+		return
 	}
 
 	log.Printf("Found %d images to process", len(files))
 
 	// Process images with pipeline parallelism
-	result := processPipelined(files, *kernelSize)
+	opts := processOptions{
+		MemoryBudget: int64(*memoryMB) * 1024 * 1024,
+		PNGLevel:     pngLevel,
+		NoOutput:     *noOutput,
+		FailFast:     *failFast,
+	}
+	heap := stats.StartHeapSampler(stats.DefaultHeapSampleInterval)
+	result, err := processPipelined(files, *outputPath, *kernelSize, opts)
+	if err != nil {
+		log.Fatalf("Aborting (-fail-fast): %v", err)
+	}
+	result.PeakHeapBytes = heap.Stop()
+
+	if *keepMTime {
+		preserveModTimes(result, *noOutput)
+	}
 
 	// Write performance results
 	results := []stats.PerformanceData{result}
-	stats.WritePerformanceResults(results)
+	stats.WritePerformanceResultsWithOptions(results, "abc_", stats.WriteOptions{SummaryOnly: *summaryOnly})
+
+	if *manifest {
+		if *noOutput {
+			log.Printf("Skipping %s: outputs were suppressed by -no-output", stats.ManifestFile)
+		} else if path, err := stats.WriteManifest(*outputPath, result); err != nil {
+			log.Printf("Failed to write output manifest: %v", err)
+		} else {
+			log.Printf("Output manifest written to %s", path)
+		}
+	}
 
 	log.Printf("=== Processing Complete ===")
 	log.Printf("Total execution time: %.2fs", time.Since(startTime).Seconds())
 }
 
+// preserveModTimes gives every written output its input's modification time, for -preserve-mtime
+func preserveModTimes(result stats.PerformanceData, noOutput bool) {
+	if noOutput {
+		log.Printf("Skipping -preserve-mtime: outputs were suppressed by -no-output")
+		return
+	}
+	if err := common.CopyModTimes(result.OutputPaths, result.InputPaths); err != nil {
+		log.Printf("Failed to preserve modification times: %v", err)
+	}
+}
+
 const (
 	TILE_SIZE    = 256
 	NUM_WORKERS  = 10
@@ -91,46 +172,68 @@ type ImageCommand struct {
 	ImageTile *ImageTile
 }
 
-// ImageInfo holds metadata about an image being processed
-type ImageInfo struct {
-	ID           int
-	InputPath    string
-	OutputPath   string
-	Width        int
-	Height       int
-	ExpectedTiles int
-	LoadTime     time.Time
-	StartTime    time.Time
+// processOptions carries the per-run settings shared by every stage of the pipeline
+type processOptions struct {
+	MemoryBudget int64 // bytes of estimated decoded images allowed in memory at once (0 = unlimited)
+	PNGLevel     png.CompressionLevel
+	NoOutput     bool // skip encode and write, for benchmarking the blur alone
+	FailFast     bool // abort on the first input that fails to load instead of skipping it
+}
+
+// outputLog records which images reached disk and how long each took from load to write.
+// Once a write fails because the disk is full, every later write would fail too, so it
+// tells the remaining assemblers to stop.
+type outputLog struct {
+	mu       sync.Mutex
+	written  map[int]bool
+	seconds  map[int]float64
+	diskFull bool
+}
+
+func newOutputLog() *outputLog {
+	return &outputLog{written: make(map[int]bool), seconds: make(map[int]float64)}
+}
+
+func (l *outputLog) record(imageID int, seconds float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.written[imageID] = true
+	l.seconds[imageID] = seconds
+}
+
+func (l *outputLog) markDiskFull() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.diskFull = true
 }
 
-// ImageData combines image info with RGBA data to eliminate matching issues
-type ImageData struct {
-	Info *ImageInfo
-	RGBA *image.RGBA
+func (l *outputLog) isDiskFull() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.diskFull
 }
 
-func processPipelined(inputPaths []string, kernelSize int) stats.PerformanceData {
+// processPipelined runs the pipeline over inputPaths. Each image is tiled as soon as it
+// loads and holds its share of opts.MemoryBudget until its assembler is done with it.
+// It only returns an error when opts.FailFast stopped the run on a load failure; images
+// already being blurred by then are still written.
+func processPipelined(inputPaths []string, outputDir string, kernelSize int, opts processOptions) (stats.PerformanceData, error) {
 	fmt.Println("=== Starting Pipelined Multi-Image Gaussian Blur ===")
 	startTime := time.Now()
 	
 	// Create channels
-	imageDataChannel := make(chan *ImageData, len(inputPaths))
+	imageDataChannel := make(chan *pipelined.ImageData, len(inputPaths))
 	tileQueue := make(chan ImageCommand, QUEUE_SIZE*2)
 	resultQueue := make(chan *ProcessedImageTile, QUEUE_SIZE*2)
 	
 	// Start pipeline reader
-	go pipelineReader(inputPaths, imageDataChannel)
+	errs := &pipelined.LoadErrors{FailFast: opts.FailFast}
+	gate := pipelined.NewMemoryGate(opts.MemoryBudget)
+	go pipelined.PipelineReader(inputPaths, outputPathsIn(outputDir, inputPaths), imageDataChannel, gate, errs)
 	
-	// Collect image data and infos
-	var imageDataList []*ImageData
-	var imageInfos []*ImageInfo
-	for imgData := range imageDataChannel {
-		imageDataList = append(imageDataList, imgData) 
-		imageInfos = append(imageInfos, imgData.Info) 
-	}
-	
-	// Start coordinator with collected data
-	go pipelineCoordinator(imageDataList, tileQueue, kernelSize)
+	// Start coordinator, which tiles each image as the reader delivers it
+	assemblers := newAssemblerSet(opts, newOutputLog(), gate)
+	go pipelineCoordinator(imageDataChannel, tileQueue, kernelSize, assemblers, errs)
 	
 	// Start workers
 	var workerWG sync.WaitGroup
@@ -142,7 +245,7 @@ func processPipelined(inputPaths []string, kernelSize int) stats.PerformanceData
 	// Start assembler manager
 	var assemblerWG sync.WaitGroup
 	assemblerWG.Add(1)
-	go pipelineAssemblerManager(resultQueue, imageInfos, &assemblerWG)
+	go pipelineAssemblerManager(resultQueue, assemblers, &assemblerWG)
 	
 	// Wait for workers to finish
 	workerWG.Wait()
@@ -150,18 +253,53 @@ func processPipelined(inputPaths []string, kernelSize int) stats.PerformanceData
 	
 	// Wait for assemblers to finish
 	assemblerWG.Wait()
+	if err := errs.Err(); err != nil {
+		return stats.PerformanceData{}, err
+	}
+	outputs := assemblers.outputs
+	
+	// Images load concurrently; restore input order so stats and the manifest pair up
+	imageInfos := assemblers.infos
+	sort.Slice(imageInfos, func(i, j int) bool { return imageInfos[i].ID < imageInfos[j].ID })
+	
+	// Only images that loaded count as processed, and after a full disk only those written
+	var completed []string
+	for _, info := range imageInfos {
+		if outputs.isDiskFull() && !outputs.written[info.ID] {
+			continue
+		}
+		completed = append(completed, info.InputPath)
+	}
+	if outputs.isDiskFull() {
+		log.Printf("Output disk is full; batch stopped with %d of %d images written", len(completed), len(inputPaths))
+	}
+	inputPaths = completed
 	
 	totalTime := time.Since(startTime).Seconds()
+	averageTime := 0.0
+	if len(inputPaths) > 0 {
+		averageTime = totalTime / float64(len(inputPaths))
+	}
 	fmt.Printf("\n=== Pipelined Multi-Image Blur Complete ===\n")
 	fmt.Printf("Images processed: %d\n", len(inputPaths))
 	fmt.Printf("Total execution time: %.2fs\n", totalTime)
-	fmt.Printf("Average time per image: %.2fs\n", totalTime/float64(len(inputPaths)))
+	fmt.Printf("Average time per image: %.2fs\n", averageTime)
 	
-	// Generate output paths
+	// Generate output paths and per-image timings (none were written when output is suppressed)
 	var outputPaths []string
-	for i := range inputPaths {
-		outputName := fmt.Sprintf("/data/c/output/img%d_blurred.png", i+1)
-		outputPaths = append(outputPaths, outputName)
+	var timings []stats.ImageTiming
+	if !opts.NoOutput {
+		for _, info := range imageInfos {
+			if outputs.isDiskFull() && !outputs.written[info.ID] {
+				continue
+			}
+			outputPaths = append(outputPaths, info.OutputPath)
+			timings = append(timings, stats.ImageTiming{
+				Width:   info.Width,
+				Height:  info.Height,
+				Seconds: outputs.seconds[info.ID],
+			})
+		}
 	}
 	
 	workers := NUM_WORKERS
@@ -173,105 +311,48 @@ func processPipelined(inputPaths []string, kernelSize int) stats.PerformanceData
 		ImagesProcessed: len(inputPaths),
 		KernelSize:      kernelSize,
 		TotalTime:       totalTime,
-		AverageTime:     totalTime / float64(len(inputPaths)),
+		AverageTime:     averageTime,
 		InputPaths:      inputPaths,
 		OutputPaths:     outputPaths,
 		Timestamp:       startTime,
 		Workers:         &workers,
 		TileSize:        &tileSize,
 		QueueSize:       &queueSize,
-	}
+
+		OutputsSuppressed: opts.NoOutput,
+		ImageTimings:      timings,
+	}, nil
 }
 
-func pipelineReader(imagePaths []string, imageDataChannel chan<- *ImageData) {
-	fmt.Println("PipelineReader: Starting...")
-	
-	var wg sync.WaitGroup
-	
-	for i, path := range imagePaths {
-		wg.Add(1)
-		go func(imageID int, imagePath string) {
-			defer wg.Done()
-			
-			startTime := time.Now()
-			
-			// Load image
-			file, err := os.Open(imagePath)
-			if err != nil {
-				log.Printf("PipelineReader: Failed to open image %d: %v", imageID, err)
-				return
-			}
-			defer file.Close()
-			
-			img, _, err := image.Decode(file)
-			if err != nil {
-				log.Printf("PipelineReader: Failed to decode image %d: %v", imageID, err)
-				return
-			}
-			
-			// Convert to RGBA
-			bounds := img.Bounds()
-			rgba := image.NewRGBA(bounds)
-			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-				for x := bounds.Min.X; x < bounds.Max.X; x++ {
-					rgba.Set(x, y, img.At(x, y))
-				}
-			}
-			
-			// Calculate expected tiles
-			imgWidth := bounds.Dx()
-			imgHeight := bounds.Dy()
-			tilesX := (imgWidth + TILE_SIZE - 1) / TILE_SIZE
-			tilesY := (imgHeight + TILE_SIZE - 1) / TILE_SIZE
-			expectedTiles := tilesX * tilesY
-			
-			// Create output path
-			outputPath := fmt.Sprintf("/data/c/output/img%d_blurred.png", imageID+1)
-			
-			// Create image info
-			imageInfo := &ImageInfo{
-				ID:           imageID,
-				InputPath:    imagePath,
-				OutputPath:   outputPath,
-				Width:        imgWidth,
-				Height:       imgHeight,
-				ExpectedTiles: expectedTiles,
-				LoadTime:     time.Now(),
-				StartTime:    startTime,
-			}
-			
-			// Send combined data
-			imageDataChannel <- &ImageData{
-				Info: imageInfo,
-				RGBA: rgba,
-			}
-			
-			fmt.Printf("PipelineReader: Loaded image %d (%dx%d) in %.2fms\n", 
-				imageID+1, imgWidth, imgHeight,
-				float64(time.Since(startTime).Microseconds())/1000.0)
-				
-		}(i, path)
+// outputPathsIn names each input's output in outputDir: the input's base name with
+// "_blurred.png"
+func outputPathsIn(outputDir string, inputPaths []string) []string {
+	outputPaths := make([]string, len(inputPaths))
+	for i, inputPath := range inputPaths {
+		name := strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))
+		outputPaths[i] = filepath.Join(outputDir, name+"_blurred.png")
 	}
-	
-	// Wait for all images to load, then close channels
-	go func() {
-		wg.Wait()
-		close(imageDataChannel)
-		fmt.Println("PipelineReader: All images loaded")
-	}()
+	return outputPaths
 }
 
-func pipelineCoordinator(imageDataList []*ImageData, tileQueue chan<- ImageCommand, kernelSize int) {
+// pipelineCoordinator tiles each image as it arrives from the reader, starting its
+// assembler first so no tile reaches the manager before the image is known
+func pipelineCoordinator(imageDataChannel <-chan *pipelined.ImageData, tileQueue chan<- ImageCommand, kernelSize int, assemblers *assemblerSet, errs *pipelined.LoadErrors) {
 	fmt.Println("PipelineCoordinator: Starting...")
 	
-	totalImages := len(imageDataList)
-	fmt.Printf("PipelineCoordinator: Processing %d images\n", totalImages)
-	
 	padding := kernelSize / 2
+	totalImages := 0
 	totalTiles := 0
 	
-	// Process all images to create tiles
-	for _, imgData := range imageDataList {
+	// Process images to create tiles as they load
+	for imgData := range imageDataChannel {
+		if errs.Aborted() {
+			assemblers.gate.Release(imgData.Reserved)
+			continue
+		}
+		assemblers.start(imgData)
+		totalImages++
+		
 		imageID := imgData.Info.ID
 		img := imgData.RGBA
 		
@@ -315,35 +396,30 @@ func pipelineCoordinator(imageDataList []*ImageData, tileQueue chan<- ImageComma
 func extractImageTileWithPadding(img *image.RGBA, imageID, tileID, tileX, tileY, tileWidth, tileHeight, padding int) *ImageTile {
 	bounds := img.Bounds()
 	
-	// Calculate padded dimensions
-	startX := tileX - padding
-	startY := tileY - padding
-	endX := tileX + tileWidth + padding
-	endY := tileY + tileHeight + padding
-	
-	// Clamp to image bounds
-	if startX < bounds.Min.X {
-		startX = bounds.Min.X
-	}
-	if startY < bounds.Min.Y {
-		startY = bounds.Min.Y
-	}
-	if endX > bounds.Max.X {
-		endX = bounds.Max.X
-	}
-	if endY > bounds.Max.Y {
-		endY = bounds.Max.Y
-	}
-	
-	// Create tile data
-	paddedWidth := endX - startX
-	paddedHeight := endY - startY
+	// Always build the full padded region, replicating edge pixels past the
+	// image bounds, so the padding ExtractCenter strips is the same on every tile
+	paddedWidth := tileWidth + 2*padding
+	paddedHeight := tileHeight + 2*padding
 	data := make([][]color.RGBA, paddedHeight)
 	
 	for y := 0; y < paddedHeight; y++ {
 		data[y] = make([]color.RGBA, paddedWidth)
+		srcY := tileY + y - padding
+		if srcY < bounds.Min.Y {
+			srcY = bounds.Min.Y
+		}
+		if srcY >= bounds.Max.Y {
+			srcY = bounds.Max.Y - 1
+		}
 		for x := 0; x < paddedWidth; x++ {
-			data[y][x] = img.RGBAAt(startX+x, startY+y)
+			srcX := tileX + x - padding
+			if srcX < bounds.Min.X {
+				srcX = bounds.Min.X
+			}
+			if srcX >= bounds.Max.X {
+				srcX = bounds.Max.X - 1
+			}
+			data[y][x] = img.RGBAAt(srcX, srcY)
 		}
 	}
 	
@@ -397,44 +473,97 @@ func pipelineWorker(id int, tileQueue <-chan ImageCommand, resultQueue chan<- *P
 	}
 }
 
-func pipelineAssemblerManager(resultQueue <-chan *ProcessedImageTile, imageInfos []*ImageInfo, assemblerWG *sync.WaitGroup) {
+// assemblerSet tracks the assembler of every image the coordinator has started. Each
+// assembler's channel is closed once all of the image's tiles are routed to it, so the
+// image is written, and its memory reservation released, without waiting for the batch.
+type assemblerSet struct {
+	opts    processOptions
+	outputs *outputLog
+	gate    *pipelined.MemoryGate
+	
+	mu        sync.Mutex
+	channels  map[int]chan *ProcessedImageTile
+	remaining map[int]int  // tiles of each image not yet routed
+	infos     []*pipelined.ImageInfo // every image started, in load order
+	wg        sync.WaitGroup
+}
+
+func newAssemblerSet(opts processOptions, outputs *outputLog, gate *pipelined.MemoryGate) *assemblerSet {
+	return &assemblerSet{
+		opts:     opts,
+		outputs:  outputs,
+		gate:     gate,
+		channels:  make(map[int]chan *ProcessedImageTile),
+		remaining: make(map[int]int),
+	}
+}
+
+// start launches the assembler for an image before any of its tiles are queued
+func (s *assemblerSet) start(imgData *pipelined.ImageData) {
+	info, reserved := imgData.Info, imgData.Reserved
+	tileChan := make(chan *ProcessedImageTile, 100)
+	
+	s.mu.Lock()
+	s.channels[info.ID] = tileChan
+	s.remaining[info.ID] = info.ExpectedTiles
+	s.infos = append(s.infos, info)
+	s.mu.Unlock()
+	
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer s.gate.Release(reserved)
+		pipelineAssembler(info, tileChan, s.opts, s.outputs)
+	}()
+}
+
+// route hands a tile to its image's assembler, closing the assembler's channel after
+// the image's last tile
+func (s *assemblerSet) route(tile *ProcessedImageTile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tileChan, exists := s.channels[tile.ImageID]
+	if !exists {
+		return
+	}
+	tileChan <- tile
+	s.remaining[tile.ImageID]--
+	if s.remaining[tile.ImageID] == 0 {
+		close(tileChan)
+		delete(s.channels, tile.ImageID)
+		delete(s.remaining, tile.ImageID)
+	}
+}
+
+// closeAll closes the channels of any images still missing tiles and waits for every
+// assembler to finish
+func (s *assemblerSet) closeAll() {
+	s.mu.Lock()
+	for id, tileChan := range s.channels {
+		close(tileChan)
+		delete(s.channels, id)
+		delete(s.remaining, id)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func pipelineAssemblerManager(resultQueue <-chan *ProcessedImageTile, assemblers *assemblerSet, assemblerWG *sync.WaitGroup) {
 	defer assemblerWG.Done()
 	
 	fmt.Println("PipelineAssemblerManager: Starting...")
 	
-	// Create assemblers for each image
-	assemblerChannels := make(map[int]chan *ProcessedImageTile)
-	var internalAssemblerWG sync.WaitGroup
-	
-	for _, info := range imageInfos {
-		assemblerChan := make(chan *ProcessedImageTile, 100)
-		assemblerChannels[info.ID] = assemblerChan
-		
-		internalAssemblerWG.Add(1)
-		go func(imageInfo *ImageInfo, tileChan <-chan *ProcessedImageTile) {
-			defer internalAssemblerWG.Done()
-			pipelineAssembler(imageInfo, tileChan)
-		}(info, assemblerChan)
-	}
-	
 	// Route tiles to appropriate assemblers
 	for tile := range resultQueue {
-		if assemblerChan, exists := assemblerChannels[tile.ImageID]; exists {
-			assemblerChan <- tile
-		}
-	}
-	
-	// Close all assembler channels
-	for _, ch := range assemblerChannels {
-		close(ch)
+		assemblers.route(tile)
 	}
 	
 	// Wait for all assemblers to finish
-	internalAssemblerWG.Wait()
+	assemblers.closeAll()
 	fmt.Println("PipelineAssemblerManager: All assemblers finished")
 }
 
-func pipelineAssembler(imageInfo *ImageInfo, tileChannel <-chan *ProcessedImageTile) {
+func pipelineAssembler(imageInfo *pipelined.ImageInfo, tileChannel <-chan *ProcessedImageTile, opts processOptions, outputs *outputLog) {
 	fmt.Printf("PipelineAssembler: Starting for image %d\n", imageInfo.ID+1)
 	startTime := time.Now()
 	
@@ -454,21 +583,39 @@ func pipelineAssembler(imageInfo *ImageInfo, tileChannel <-chan *ProcessedImageT
 		tilesReceived++
 	}
 	
+	if opts.NoOutput {
+		fmt.Printf("PipelineAssembler: Image %d complete - %d tiles in %.2fs (output suppressed)\n",
+			imageInfo.ID+1, tilesReceived, time.Since(startTime).Seconds())
+		return
+	}
+	
+	if outputs.isDiskFull() {
+		fmt.Printf("PipelineAssembler: Skipping image %d, output disk is full\n", imageInfo.ID+1)
+		return
+	}
+	
 	// Save output
 	outFile, err := os.Create(imageInfo.OutputPath)
 	if err != nil {
+		if common.IsDiskFull(err) {
+			outputs.markDiskFull()
+		}
 		log.Printf("PipelineAssembler: Failed to create output file for image %d: %v", imageInfo.ID+1, err)
 		return
 	}
 	defer outFile.Close()
 	
-	err = png.Encode(outFile, output)
+	err = common.EncodePNG(outFile, output, opts.PNGLevel)
 	if err != nil {
+		if common.IsDiskFull(err) {
+			outputs.markDiskFull()
+			os.Remove(imageInfo.OutputPath)
+		}
 		log.Printf("PipelineAssembler: Failed to encode image %d: %v", imageInfo.ID+1, err)
 		return
 	}
-	
 	totalTime := time.Since(imageInfo.StartTime).Seconds()
+	outputs.record(imageInfo.ID, totalTime)
 	assemblerTime := time.Since(startTime).Seconds()
 	
 	fmt.Printf("PipelineAssembler: Image %d complete - %d tiles in %.2fs (total: %.2fs)\n", 
@@ -2,13 +2,14 @@ package main
 
 import (
 	"fmt"
+	"studyguide.parallel/c/pipelined"
 	"studyguide.parallel/pkg/stats"
 )
 
 func main() {
 	kernelSize := 21
 	
-	// Define input paths for 5 images
+	// Define input and output paths for 5 images
 	inputPaths := []string{
 		"../input/img1.png",
 		"../input/img2.png", 
@@ -16,9 +17,17 @@ func main() {
 		"../input/img4.png",
 		"../input/img5.png",
 	}
+	
+	outputPaths := []string{
+		"output/img1_blurred.png",
+		"output/img2_blurred.png",
+		"output/img3_blurred.png", 
+		"output/img4_blurred.png",
+		"output/img5_blurred.png",
+	}
 
 	fmt.Println("Running Pipelined Implementation:")
-	result := Run_c(inputPaths, kernelSize)
+	result := pipelined.Run_c(inputPaths, outputPaths, kernelSize)
 	
 	// Write results
 	results := []stats.PerformanceData{result}
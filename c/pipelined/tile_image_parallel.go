@@ -1,15 +1,17 @@
-package main
+package pipelined
 
 import (
 	"fmt"
 	"image"
 	"image/color"
 	"image/png"
+	"io"
 	"log"
 	"os"
 	"sync"
 	"time"
 	"studyguide.parallel/pkg/blur"
+	"studyguide.parallel/pkg/common"
 	"studyguide.parallel/pkg/stats"
 )
 
@@ -62,12 +64,16 @@ type ImageInfo struct {
 
 // ImageData combines image info with RGBA data to eliminate matching issues
 type ImageData struct {
-	Info *ImageInfo
-	RGBA *image.RGBA
+	Info     *ImageInfo
+	RGBA     *image.RGBA
+	Reserved int64 // bytes held in the reader's memory gate until the image is written
 }
 
-// PipelineReader loads multiple images concurrently
-func pipelineReader(imagePaths []string, imageDataChannel chan<- *ImageData) {
+// PipelineReader loads the images concurrently, each waiting for room in gate (nil for
+// no limit) first. An image's reservation travels with it as ImageData.Reserved and the
+// consumer releases it once the image is no longer in memory. The channel is closed
+// after the last image.
+func PipelineReader(imagePaths, outputPaths []string, imageDataChannel chan<- *ImageData, gate *MemoryGate, errs *LoadErrors) {
 	fmt.Println("PipelineReader: Starting...")
 	
 	var wg sync.WaitGroup
@@ -76,6 +82,9 @@ func pipelineReader(imagePaths []string, imageDataChannel chan<- *ImageData) {
 		wg.Add(1)
 		go func(imageID int, imagePath string) {
 			defer wg.Done()
+			if errs.Aborted() {
+				return
+			}
 			
 			startTime := time.Now()
 			
@@ -83,24 +92,36 @@ func pipelineReader(imagePaths []string, imageDataChannel chan<- *ImageData) {
 			file, err := os.Open(imagePath)
 			if err != nil {
 				log.Printf("PipelineReader: Failed to open image %d: %v", imageID, err)
+				errs.Record(fmt.Errorf("open %s: %w", imagePath, err))
 				return
 			}
 			defer file.Close()
 			
-			img, _, err := image.Decode(file)
+			// Wait for room in the memory budget before decoding
+			estimate, err := EstimateDecodeBytes(file)
+			if err != nil {
+				log.Printf("PipelineReader: Failed to read header of image %d: %v", imageID, err)
+				errs.Record(fmt.Errorf("read header of %s: %w", imagePath, err))
+				return
+			}
+			gate.Acquire(estimate)
+			if errs.Aborted() {
+				gate.Release(estimate)
+				return
+			}
+			
+			img, _, err := decodeImage(file)
 			if err != nil {
+				// Record before releasing, so a load the release admits sees the abort
 				log.Printf("PipelineReader: Failed to decode image %d: %v", imageID, err)
+				errs.Record(fmt.Errorf("decode %s: %w", imagePath, err))
+				gate.Release(estimate)
 				return
 			}
 			
 			// Convert to RGBA
-			bounds := img.Bounds()
-			rgba := image.NewRGBA(bounds)
-			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-				for x := bounds.Min.X; x < bounds.Max.X; x++ {
-					rgba.Set(x, y, img.At(x, y))
-				}
-			}
+			rgba := blur.ToRGBA(img)
+			bounds := rgba.Bounds()
 			
 			// Calculate expected tiles
 			imgWidth := bounds.Dx()
@@ -109,14 +130,11 @@ func pipelineReader(imagePaths []string, imageDataChannel chan<- *ImageData) {
 			tilesY := (imgHeight + TILE_SIZE - 1) / TILE_SIZE
 			expectedTiles := tilesX * tilesY
 			
-			// Create output path
-			outputPath := fmt.Sprintf("/data/c/output/img%d_blurred.png", imageID+1)
-			
 			// Create image info
 			imageInfo := &ImageInfo{
 				ID:           imageID,
 				InputPath:    imagePath,
-				OutputPath:   outputPath,
+				OutputPath:   outputPaths[imageID],
 				Width:        imgWidth,
 				Height:       imgHeight,
 				ExpectedTiles: expectedTiles,
@@ -126,8 +144,9 @@ func pipelineReader(imagePaths []string, imageDataChannel chan<- *ImageData) {
 			
 			// Send combined data - no matching needed!
 			imageDataChannel <- &ImageData{
-				Info: imageInfo,
-				RGBA: rgba,
+				Info:     imageInfo,
+				RGBA:     rgba,
+				Reserved: estimate,
 			}
 			
 			fmt.Printf("PipelineReader: Loaded image %d (%dx%d) in %.2fms\n", 
@@ -145,6 +164,101 @@ func pipelineReader(imagePaths []string, imageDataChannel chan<- *ImageData) {
 	}()
 }
 
+// decodeImage is image.Decode, swapped out by tests that watch the reader's decodes
+var decodeImage = image.Decode
+
+// LoadErrors collects a reader's open and decode failures. With FailFast set the
+// first failure also stops every load that has not started decoding yet.
+type LoadErrors struct {
+	FailFast bool
+	mu       sync.Mutex
+	first    error
+}
+
+// Record keeps the first failure
+func (l *LoadErrors) Record(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.first == nil {
+		l.first = err
+	}
+}
+
+// Aborted reports whether fail-fast has been triggered
+func (l *LoadErrors) Aborted() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.FailFast && l.first != nil
+}
+
+// Err returns the first failure when fail-fast is on, nil otherwise
+func (l *LoadErrors) Err() error {
+	if !l.Aborted() {
+		return nil
+	}
+	return l.first
+}
+
+// MemoryGate admits images only while their estimated footprint fits a budget. A caller
+// holds each reservation until the image is no longer in memory, normally once its
+// output is written, so the budget bounds the decoded images resident at once.
+type MemoryGate struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	budget int64
+	inUse  int64
+}
+
+// NewMemoryGate returns a gate for the given budget in bytes, or nil for no limit
+func NewMemoryGate(budget int64) *MemoryGate {
+	if budget <= 0 {
+		return nil
+	}
+	g := &MemoryGate{budget: budget}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// Acquire blocks until n more bytes fit under the budget. An image larger than the whole
+// budget is still admitted once nothing else is in flight so it can't deadlock the reader.
+func (g *MemoryGate) Acquire(n int64) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	for g.inUse > 0 && g.inUse+n > g.budget {
+		g.cond.Wait()
+	}
+	g.inUse += n
+	g.mu.Unlock()
+}
+
+// Release returns n bytes to the budget and wakes waiting decodes
+func (g *MemoryGate) Release(n int64) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	g.inUse -= n
+	g.mu.Unlock()
+	g.cond.Broadcast()
+}
+
+// EstimateDecodeBytes estimates the RAM needed to hold an image through the pipeline,
+// using only the header so nothing is decoded yet. The file is rewound for decoding.
+func EstimateDecodeBytes(file *os.File) (int64, error) {
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	// Two image-sized buffers at 4 bytes per pixel: the decoded source (or its RGBA
+	// copy) while tiles are cut, and the assembled output
+	return int64(config.Width) * int64(config.Height) * 4 * 2, nil
+}
+
 // PipelineCoordinator manages tile creation for multiple images
 func pipelineCoordinator(imageDataList []*ImageData, tileQueue chan<- ImageCommand, kernelSize int) {
 	fmt.Println("PipelineCoordinator: Starting...")
@@ -201,35 +315,30 @@ func pipelineCoordinator(imageDataList []*ImageData, tileQueue chan<- ImageComma
 func extractImageTileWithPadding(img *image.RGBA, imageID, tileID, tileX, tileY, tileWidth, tileHeight, padding int) *ImageTile {
 	bounds := img.Bounds()
 	
-	// Calculate padded dimensions
-	startX := tileX - padding
-	startY := tileY - padding
-	endX := tileX + tileWidth + padding
-	endY := tileY + tileHeight + padding
-	
-	// Clamp to image bounds
-	if startX < bounds.Min.X {
-		startX = bounds.Min.X
-	}
-	if startY < bounds.Min.Y {
-		startY = bounds.Min.Y
-	}
-	if endX > bounds.Max.X {
-		endX = bounds.Max.X
-	}
-	if endY > bounds.Max.Y {
-		endY = bounds.Max.Y
-	}
-	
-	// Create tile data
-	paddedWidth := endX - startX
-	paddedHeight := endY - startY
+	// Always build the full padded region, replicating edge pixels past the
+	// image bounds, so the padding ExtractCenter strips is the same on every tile
+	paddedWidth := tileWidth + 2*padding
+	paddedHeight := tileHeight + 2*padding
 	data := make([][]color.RGBA, paddedHeight)
 	
 	for y := 0; y < paddedHeight; y++ {
 		data[y] = make([]color.RGBA, paddedWidth)
+		srcY := tileY + y - padding
+		if srcY < bounds.Min.Y {
+			srcY = bounds.Min.Y
+		}
+		if srcY >= bounds.Max.Y {
+			srcY = bounds.Max.Y - 1
+		}
 		for x := 0; x < paddedWidth; x++ {
-			data[y][x] = img.RGBAAt(startX+x, startY+y)
+			srcX := tileX + x - padding
+			if srcX < bounds.Min.X {
+				srcX = bounds.Min.X
+			}
+			if srcX >= bounds.Max.X {
+				srcX = bounds.Max.X - 1
+			}
+			data[y][x] = img.RGBAAt(srcX, srcY)
 		}
 	}
 	
@@ -351,7 +460,7 @@ func pipelineAssembler(imageInfo *ImageInfo, tileChannel <-chan *ProcessedImageT
 	}
 	defer outFile.Close()
 	
-	err = png.Encode(outFile, output)
+	err = common.EncodePNG(outFile, output, png.DefaultCompression)
 	if err != nil {
 		log.Printf("PipelineAssembler: Failed to encode image %d: %v", imageInfo.ID+1, err)
 		return
@@ -364,8 +473,23 @@ func pipelineAssembler(imageInfo *ImageInfo, tileChannel <-chan *ProcessedImageT
 		imageInfo.ID+1, tilesReceived, assemblerTime, totalTime)
 }
 
-// RunPipelined executes the pipelined blur pipeline
-func Run_c(inputPaths []string, kernelSize int) stats.PerformanceData {
+// Options tunes a pipelined run
+type Options struct {
+	// FailFast aborts the run on the first input that fails to open or decode instead
+	// of logging it and carrying on with the images that did load
+	FailFast bool
+}
+
+// Run_c blurs every input with the pipelined implementation. Inputs that fail to load
+// are logged and skipped; use RunWithOptions with FailFast to stop on them instead.
+func Run_c(inputPaths []string, outputPaths []string, kernelSize int) stats.PerformanceData {
+	result, _ := RunWithOptions(inputPaths, outputPaths, kernelSize, Options{})
+	return result
+}
+
+// RunWithOptions is Run_c with options. The error is only set when FailFast stopped
+// the run, in which case nothing was blurred or written.
+func RunWithOptions(inputPaths []string, outputPaths []string, kernelSize int, opts Options) (stats.PerformanceData, error) {
 	fmt.Println("=== Starting Pipelined Multi-Image Gaussian Blur ===")
 	startTime := time.Now()
 	
@@ -375,7 +499,10 @@ func Run_c(inputPaths []string, kernelSize int) stats.PerformanceData {
 	resultQueue := make(chan *ProcessedImageTile, QUEUE_SIZE*2)
 	
 	// Start pipeline reader
-	go pipelineReader(inputPaths, imageDataChannel) // Concurrent loading of images
+	errs := &LoadErrors{FailFast: opts.FailFast}
+	// Concurrent loading of images; the whole batch is collected before tiling, so there
+	// is no memory gate
+	go PipelineReader(inputPaths, outputPaths, imageDataChannel, nil, errs)
 	
 	// Collect image data and infos for coordinator and assembler manager
 	var imageDataList []*ImageData // Used by coordinator
@@ -384,6 +511,9 @@ func Run_c(inputPaths []string, kernelSize int) stats.PerformanceData {
 		imageDataList = append(imageDataList, imgData) 
 		imageInfos = append(imageInfos, imgData.Info) 
 	}
+	if err := errs.Err(); err != nil {
+		return stats.PerformanceData{}, fmt.Errorf("pipelined run aborted: %w", err)
+	}
 	
 	// Start coordinator with collected data
 	go pipelineCoordinator(imageDataList, tileQueue, kernelSize)
@@ -413,13 +543,6 @@ func Run_c(inputPaths []string, kernelSize int) stats.PerformanceData {
 	fmt.Printf("Total execution time: %.2fs\n", totalTime)
 	fmt.Printf("Average time per image: %.2fs\n", totalTime/float64(len(inputPaths)))
 	
-	// Generate output paths for return data
-	var outputPaths []string
-	for i := range inputPaths {
-		outputName := fmt.Sprintf("/data/c/output/img%d_blurred.png", i+1)
-		outputPaths = append(outputPaths, outputName)
-	}
-	
 	workers := NUM_WORKERS
 	tileSize := TILE_SIZE
 	queueSize := QUEUE_SIZE
@@ -436,5 +559,5 @@ func Run_c(inputPaths []string, kernelSize int) stats.PerformanceData {
 		Workers:         &workers,
 		TileSize:        &tileSize,
 		QueueSize:       &queueSize,
-	}
+	}, nil
 }
\ No newline at end of file
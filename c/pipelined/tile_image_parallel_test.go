@@ -0,0 +1,153 @@
+package pipelined
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeImages writes n size x size PNGs to a temporary directory
+func writeImages(t *testing.T, n, size int) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var paths []string
+	for i := 0; i < n; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("img%d.png", i))
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(file, image.NewRGBA(image.Rect(0, 0, size, size))); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// maxConcurrentDecodes runs the reader over paths and returns the most decodes that
+// were in progress at once. Each image's reservation is released as it is received.
+func maxConcurrentDecodes(t *testing.T, paths []string, gate *MemoryGate) int {
+	t.Helper()
+	var mu sync.Mutex
+	running, peak := 0, 0
+	decodeImage = func(r io.Reader) (image.Image, string, error) {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond) // long enough for unchecked decodes to overlap
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+		return image.Decode(r)
+	}
+	defer func() { decodeImage = image.Decode }()
+
+	images := make(chan *ImageData, len(paths))
+	errs := &LoadErrors{}
+	PipelineReader(paths, make([]string, len(paths)), images, gate, errs)
+	loaded := 0
+	for imgData := range images {
+		gate.Release(imgData.Reserved)
+		loaded++
+	}
+	if loaded != len(paths) {
+		t.Fatalf("loaded %d of %d images", loaded, len(paths))
+	}
+	return peak
+}
+
+func TestPipelineReaderMemoryBudget(t *testing.T) {
+	// Each 64x64 image is estimated at 32 KiB, so a 40 KiB budget admits one at a time
+	paths := writeImages(t, 4, 64)
+	if peak := maxConcurrentDecodes(t, paths, NewMemoryGate(40*1024)); peak != 1 {
+		t.Errorf("%d decodes ran at once under a budget that fits one image", peak)
+	}
+	if peak := maxConcurrentDecodes(t, paths, nil); peak < 2 {
+		t.Errorf("without a budget only %d decode ran at once; the hook sees no overlap", peak)
+	}
+}
+
+// truncate cuts the file at path to its first n bytes, keeping a PNG's header
+// readable while its pixel data fails to decode
+func truncate(t *testing.T, path string, n int64) {
+	t.Helper()
+	if err := os.Truncate(path, n); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFailFastStopsLaterLoads(t *testing.T) {
+	paths := writeImages(t, 5, 64)
+	if err := os.WriteFile(paths[1], []byte("not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var decodes atomic.Int32
+	decodeImage = func(r io.Reader) (image.Image, string, error) {
+		decodes.Add(1)
+		return image.Decode(r)
+	}
+	defer func() { decodeImage = image.Decode }()
+
+	// Hold the whole budget so the good images queue in the gate while the bad one
+	// fails on its header, then let them through
+	const budget = 40 * 1024
+	gate := NewMemoryGate(budget)
+	gate.Acquire(budget)
+	errs := &LoadErrors{FailFast: true}
+	images := make(chan *ImageData, len(paths))
+	PipelineReader(paths, make([]string, len(paths)), images, gate, errs)
+	for deadline := time.Now().Add(5 * time.Second); errs.Err() == nil; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the bad image never failed")
+		}
+	}
+	gate.Release(budget)
+
+	loaded := 0
+	for range images {
+		loaded++
+	}
+	if err := errs.Err(); !strings.Contains(err.Error(), paths[1]) {
+		t.Errorf("reader error %v, want the failure of %s", err, paths[1])
+	}
+	if decodes.Load() != 0 || loaded != 0 {
+		t.Errorf("%d images decoded and %d loaded after the failure, want none", decodes.Load(), loaded)
+	}
+}
+
+func TestRunWithFailFastWritesNothing(t *testing.T) {
+	paths := writeImages(t, 4, 64)
+	truncate(t, paths[2], 40)
+	outDir := t.TempDir()
+	var outputs []string
+	for _, path := range paths {
+		outputs = append(outputs, filepath.Join(outDir, filepath.Base(path)))
+	}
+
+	result, err := RunWithOptions(paths, outputs, 5, Options{FailFast: true})
+	if err == nil || !strings.Contains(err.Error(), paths[2]) {
+		t.Fatalf("RunWithOptions = %v, want the run aborted on %s", err, paths[2])
+	}
+	if result.ImagesProcessed != 0 {
+		t.Errorf("aborted run reports %d images processed", result.ImagesProcessed)
+	}
+	if written, _ := os.ReadDir(outDir); len(written) != 0 {
+		t.Errorf("aborted run wrote %d outputs", len(written))
+	}
+}
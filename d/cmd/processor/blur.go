@@ -10,6 +10,26 @@ func generateGaussianKernel(size int) [][]float64 {
 	return blur.GenerateGaussianKernel(size)
 }
 
-func applyBlurToImage(img image.Image, kernelSize int) *image.RGBA {
-	return blur.ApplyBlurToImage(img, kernelSize)
+func applyBlurToImage(img image.Image, kernelSize int, opts blur.Options) *image.RGBA {
+	return blur.ApplyBlurToImageWithOptions(img, kernelSize, opts)
+}
+
+// transformImage runs the -pipeline on a still image, or the plain blur without one,
+// then blends the result back over the original through the -mask or -vignette mask
+// if there is one
+func transformImage(img image.Image, kernelSize int, opts processOptions) (*image.RGBA, error) {
+	var blurred *image.RGBA
+	if opts.Pipeline != nil {
+		blurred = opts.Pipeline.Apply(img)
+	} else {
+		blurred = applyBlurToImage(img, kernelSize, opts.Blur)
+	}
+	mask := opts.Mask
+	if opts.Vignette > 0 {
+		mask = blur.VignetteMask(img.Bounds(), opts.Vignette)
+	}
+	if mask == nil {
+		return blurred, nil
+	}
+	return blur.BlendMasked(blur.ToRGBA(img), blurred, mask)
 }
\ No newline at end of file
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"studyguide.parallel/pkg/common"
+	"studyguide.parallel/pkg/stats"
+)
+
+// isGIF reports whether the path looks like a GIF that should go through the frame-by-frame path
+func isGIF(path string) bool {
+	return strings.ToLower(filepath.Ext(common.InputName(path))) == ".gif"
+}
+
+// processGIFWithDetailedTiming blurs every frame of an (optionally animated) GIF.
+// Frames are composited onto a full-size canvas honouring each frame's disposal method,
+// the composited canvas is blurred, and the result is re-quantized to the GIF's global
+// palette (or Plan9 when there is none). Delays and the loop count are preserved.
+// The timing's blur time sums the frames that were blurred.
+func processGIFWithDetailedTiming(inputPath, outputDir string, kernelSize int, opts processOptions) (timing stats.ImageTiming, outputPath string, err error) {
+	log.Printf("Processing GIF: %s", inputPath)
+
+	var blurTime float64
+	decodeStart := time.Now()
+	file, err := common.OpenInput(inputPath, opts.DownloadTimeout)
+	if err != nil {
+		return stats.ImageTiming{}, "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	src, err := gif.DecodeAll(file)
+	if err != nil {
+		return stats.ImageTiming{}, "", fmt.Errorf("failed to decode gif: %w", err)
+	}
+	decodeTime := time.Since(decodeStart).Seconds()
+
+	width, height := src.Config.Width, src.Config.Height
+	if width == 0 || height == 0 {
+		// Some encoders leave the logical screen empty; fall back to the first frame
+		width, height = src.Image[0].Bounds().Dx(), src.Image[0].Bounds().Dy()
+	}
+	bounds := image.Rect(0, 0, width, height)
+
+	pal, ok := src.Config.ColorModel.(color.Palette)
+	if !ok || len(pal) == 0 {
+		pal = palette.Plan9
+	}
+
+	out := &gif.GIF{
+		Image:     make([]*image.Paletted, 0, len(src.Image)),
+		Delay:     src.Delay,
+		LoopCount: src.LoopCount,
+		Config:    image.Config{ColorModel: pal, Width: width, Height: height},
+	}
+
+	// Hash and output of the last frame that was actually blurred, for -dedup-frames
+	var (
+		lastHash     []float64
+		lastPaletted *image.Paletted
+		reused       int
+	)
+
+	canvas := image.NewRGBA(bounds)
+	for i, frame := range src.Image {
+		// Keep a copy of the canvas for frames that restore to the previous state
+		var previous *image.RGBA
+		disposal := byte(gif.DisposalNone)
+		if i < len(src.Disposal) {
+			disposal = src.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		// Static scenes produce runs of (near) identical frames; reuse the last blurred
+		// frame for them. Comparing against the last blurred frame rather than the
+		// immediately preceding one stops slow drift from accumulating unnoticed.
+		var hash []float64
+		if opts.DedupFrames {
+			hash = frameHash(canvas)
+		}
+		if lastPaletted != nil && hash != nil && frameDistance(hash, lastHash) <= opts.DedupThreshold {
+			out.Image = append(out.Image, lastPaletted)
+			reused++
+		} else {
+			blurStart := time.Now()
+			blurred := applyBlurToImage(canvas, kernelSize, opts.Blur)
+			blurTime += time.Since(blurStart).Seconds()
+
+			paletted := image.NewPaletted(bounds, pal)
+			draw.FloydSteinberg.Draw(paletted, bounds, blurred, image.Point{})
+			out.Image = append(out.Image, paletted)
+			lastHash, lastPaletted = hash, paletted
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	timing = stats.ImageTiming{Width: width, Height: height, DecodeSeconds: decodeTime, BlurSeconds: blurTime}
+
+	// Every output frame is a full composited canvas, so no disposal is needed
+	out.Disposal = make([]byte, len(out.Image))
+
+	if reused > 0 {
+		log.Printf("Reused the previous blur for %d of %d frames", reused, len(out.Image))
+	}
+
+	if opts.NoOutput {
+		log.Printf("Blurred GIF %s (%d frames, decode %.3fs / blur %.3fs, output suppressed)", inputPath, len(out.Image), decodeTime, blurTime)
+		timing.Seconds = time.Since(decodeStart).Seconds()
+		return timing, "", nil
+	}
+
+	baseName := common.InputName(inputPath)
+	nameWithoutExt := strings.TrimSuffix(baseName, filepath.Ext(baseName))
+	outputPath = filepath.Join(outputDir, nameWithoutExt+"_blurred.gif")
+
+	outFile, err := os.Create(outputPath)
+	if err != nil {
+		return stats.ImageTiming{}, "", fmt.Errorf("failed to create output file: %w", err)
+	}
+	var w io.Writer = outFile
+	if opts.wrapOutput != nil {
+		w = opts.wrapOutput(w)
+	}
+	err = gif.EncodeAll(w, out)
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(outputPath) // don't leave a truncated GIF behind
+		return stats.ImageTiming{}, "", fmt.Errorf("failed to encode gif: %w", err)
+	}
+
+	timing.Seconds = time.Since(decodeStart).Seconds()
+	log.Printf("Saved blurred GIF (%d frames) to: %s (decode %.3fs / blur %.3fs)", len(out.Image), outputPath, decodeTime, blurTime)
+	return timing, outputPath, nil
+}
+
+// frameHashGrid is the side of the block grid a frame is reduced to before comparison
+const frameHashGrid = 16
+
+// frameHash reduces a frame to a frameHashGrid x frameHashGrid grid of block-average
+// luma values, cheap to compare and tolerant of tiny encoder noise
+func frameHash(img *image.RGBA) []float64 {
+	bounds := img.Bounds()
+	sums := make([]float64, frameHashGrid*frameHashGrid)
+	counts := make([]int, len(sums))
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		by := (y - bounds.Min.Y) * frameHashGrid / bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			bx := (x - bounds.Min.X) * frameHashGrid / bounds.Dx()
+			c := img.RGBAAt(x, y)
+			sums[by*frameHashGrid+bx] += 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+			counts[by*frameHashGrid+bx]++
+		}
+	}
+
+	for i := range sums {
+		if counts[i] > 0 {
+			sums[i] /= float64(counts[i])
+		}
+	}
+	return sums
+}
+
+// frameDistance is the largest block-average luma difference between two frame hashes
+func frameDistance(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return math.Inf(1)
+	}
+	worst := 0.0
+	for i := range a {
+		worst = math.Max(worst, math.Abs(a[i]-b[i]))
+	}
+	return worst
+}
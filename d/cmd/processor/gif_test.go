@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"studyguide.parallel/pkg/common"
+)
+
+// writeTwoFrameGIF writes a 32x24 animation whose frames are black and white halves,
+// swapped in the second frame, with a 256-level grey palette so blurred edges survive
+// re-quantization
+func writeTwoFrameGIF(t *testing.T, path string) {
+	t.Helper()
+	grey := make(color.Palette, 256)
+	for i := range grey {
+		grey[i] = color.Gray{Y: uint8(i)}
+	}
+	anim := &gif.GIF{Delay: []int{7, 33}, LoopCount: 2, Config: image.Config{ColorModel: grey, Width: 32, Height: 24}}
+	for frame := 0; frame < 2; frame++ {
+		anim.Image = append(anim.Image, halvesFrame(grey, frame == 1))
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := gif.EncodeAll(file, anim); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProcessGIFBlursEveryFrame(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "anim.gif")
+	writeTwoFrameGIF(t, input)
+
+	_, output, err := processGIFWithDetailedTiming(input, dir, 9, processOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	out, err := gif.DecodeAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out.Image) != 2 {
+		t.Fatalf("%d frames, want 2", len(out.Image))
+	}
+	if !reflect.DeepEqual(out.Delay, []int{7, 33}) || out.LoopCount != 2 {
+		t.Errorf("delays %v loop count %d, want [7 33] and 2", out.Delay, out.LoopCount)
+	}
+	grey := func(frame *image.Paletted, x, y int) uint8 {
+		return color.GrayModel.Convert(frame.At(x, y)).(color.Gray).Y
+	}
+	for i, frame := range out.Image {
+		if frame.Bounds() != image.Rect(0, 0, 32, 24) {
+			t.Errorf("frame %d is %v, want 32x24", i, frame.Bounds())
+			continue
+		}
+		// The hard edge at x=16 is softened on both sides, while the far columns keep
+		// their colour
+		left, right := grey(frame, 15, 12), grey(frame, 16, 12)
+		if left < 30 || left > 225 || right < 30 || right > 225 {
+			t.Errorf("frame %d: edge pixels %d and %d, want both blurred towards grey", i, left, right)
+		}
+		far := map[bool]uint8{false: 0, true: 255}[i == 1]
+		if got := grey(frame, 0, 12); got != far {
+			t.Errorf("frame %d: left column %d, want %d", i, got, far)
+		}
+	}
+	if grey(out.Image[0], 14, 12) >= grey(out.Image[1], 14, 12) {
+		t.Error("frame 2 blurred like frame 1; its halves are swapped")
+	}
+}
+
+// halvesFrame is a 32x24 grey frame, black on the left half and white on the right,
+// or the other way round when swapped
+func halvesFrame(grey color.Palette, swapped bool) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, 32, 24), grey)
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			if (x < 16) == swapped {
+				img.SetColorIndex(x, y, 255)
+			}
+		}
+	}
+	return img
+}
+
+// decodeGIF reads every frame of the GIF at path
+func decodeGIF(t *testing.T, path string) *gif.GIF {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	anim, err := gif.DecodeAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return anim
+}
+
+func TestProcessGIFDedupFrames(t *testing.T) {
+	grey := make(color.Palette, 256)
+	for i := range grey {
+		grey[i] = color.Gray{Y: uint8(i)}
+	}
+	// Two still scenes: frames 1 and 4 repeat the frame before them, and frame 2 differs
+	// from frame 0 by one grey level in one pixel, well under the threshold
+	frames := []*image.Paletted{
+		halvesFrame(grey, false), halvesFrame(grey, false), halvesFrame(grey, false),
+		halvesFrame(grey, true), halvesFrame(grey, true),
+	}
+	frames[2].SetColorIndex(20, 10, 254)
+	anim := &gif.GIF{Image: frames, Delay: make([]int, len(frames)), Config: image.Config{ColorModel: grey, Width: 32, Height: 24}}
+	dir := t.TempDir()
+	input := filepath.Join(dir, "still.gif")
+	file, err := os.Create(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.EncodeAll(file, anim); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	plainDir, dedupDir := t.TempDir(), t.TempDir()
+	_, plainPath, err := processGIFWithDetailedTiming(input, plainDir, 9, processOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, dedupPath, err := processGIFWithDetailedTiming(input, dedupDir, 9, processOptions{DedupFrames: true, DedupThreshold: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), "Reused the previous blur for 3 of 5 frames") {
+		t.Errorf("log does not report 3 of 5 frames reused:\n%s", logs.String())
+	}
+
+	plain, dedup := decodeGIF(t, plainPath), decodeGIF(t, dedupPath)
+	if len(dedup.Image) != len(frames) {
+		t.Fatalf("dedup wrote %d frames, want all %d", len(dedup.Image), len(frames))
+	}
+	// Every output frame is the blur of the last frame actually blurred: 0 for frames
+	// 0-2, 3 for frames 3-4. Exact repeats match the frame-by-frame blur as well.
+	for i, source := range []int{0, 0, 0, 3, 3} {
+		if !bytes.Equal(dedup.Image[i].Pix, plain.Image[source].Pix) {
+			t.Errorf("dedup frame %d differs from the blur of frame %d", i, source)
+		}
+	}
+	if bytes.Equal(dedup.Image[3].Pix, dedup.Image[0].Pix) {
+		t.Error("the scene change at frame 3 reused the earlier blur")
+	}
+}
+
+// fullDiskWriter lets the first n bytes through, then fails every write with ENOSPC
+type fullDiskWriter struct {
+	w io.Writer
+	n int
+}
+
+func (d *fullDiskWriter) Write(p []byte) (int, error) {
+	if len(p) > d.n {
+		written, _ := d.w.Write(p[:d.n])
+		d.n = 0
+		return written, &os.PathError{Op: "write", Path: "output", Err: syscall.ENOSPC}
+	}
+	d.n -= len(p)
+	return d.w.Write(p)
+}
+
+func TestProcessGIFRemovesPartialOutput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "anim.gif")
+	writeTwoFrameGIF(t, input)
+	outDir := t.TempDir()
+
+	opts := processOptions{wrapOutput: func(w io.Writer) io.Writer { return &fullDiskWriter{w: w, n: 100} }}
+	_, output, err := processGIFWithDetailedTiming(input, outDir, 9, opts)
+	if err == nil || !common.IsDiskFull(err) {
+		t.Fatalf("writing to a full disk returned %q, %v; want the disk-full error", output, err)
+	}
+	if _, statErr := os.Stat(filepath.Join(outDir, "anim_blurred.gif")); !errors.Is(statErr, os.ErrNotExist) {
+		t.Errorf("a truncated output was left behind (stat: %v)", statErr)
+	}
+}
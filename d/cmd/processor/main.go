@@ -1,52 +1,245 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"image"
-	"image/jpeg"
 	"image/png"
+	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
+	"studyguide.parallel/pkg/blur"
+	"studyguide.parallel/pkg/common"
 	"studyguide.parallel/pkg/stats"
 )
 
 func main() {
 	var (
-		inputPath  = flag.String("input", "/input", "Input directory path")
-		outputPath = flag.String("output", "/d/output", "Output directory path")
-		kernelSize = flag.Int("kernel", 15, "Gaussian kernel size")
-		inputFile  = flag.String("file", "", "Specific input file to process (optional)")
+		inputPath     = flag.String("input", "/input", "Input directory path")
+		outputPath    = flag.String("output", "/d/output", "Output directory path")
+		kernelSize    = flag.Int("kernel", 15, "Gaussian kernel size")
+		radius        = flag.Int("radius", 0, "Blur radius R, an alternative to -kernel: sets the kernel size to 2R+1 (0 = use -kernel)")
+		inputFile     = flag.String("file", "", "Specific input file to process (optional)")
+		dither        = flag.Bool("dither", false, "Ordered-dither the 8-bit output to reduce banding in smooth gradients")
+		pngComp       = flag.String("png-compression", "default", "PNG compression level: default, none, speed or best")
+		jpegQual      = flag.Int("jpeg-quality", common.DefaultJPEGQuality, "Quality (1-100) of the JPEG outputs written for JPEG inputs")
+		noOutput      = flag.Bool("no-output", false, "Decode and blur but skip encoding/writing outputs (pure compute benchmark)")
+		summaryOnly   = flag.Bool("summary-only", false, "Write only aggregate numbers to the stats file, without per-file listings")
+		manifest      = flag.Bool("manifest", false, "Write outputs.json in the output directory mapping each input to its output, size, kernel and decode/blur times")
+		dedupFrames   = flag.Bool("dedup-frames", false, "Reuse the previous blurred frame when an animation frame is unchanged")
+		dedupThresh   = flag.Float64("dedup-threshold", 0.5, "Max block-average luma difference (0-255) for frames to count as identical")
+		validate      = flag.Bool("validate", false, "Preflight: check every input decodes (header only) and report, without processing")
+		strict        = flag.Bool("strict", false, "With -validate, exit non-zero if any input is invalid")
+		bilateral     = flag.Bool("bilateral", false, "Use an edge-preserving bilateral blur instead of a plain Gaussian")
+		sigmaColor    = flag.Float64("sigma-color", 30, "Colour-similarity sigma (0-255 units) for -bilateral; smaller keeps more edges")
+		softness      = flag.Float64("softness", -1, "Blur softness 0-100, sigma = radius*softness/200 (negative keeps the default sigma of kernel/3)")
+		float32Acc    = flag.Bool("float32", false, "Accumulate the Gaussian in float32 (faster; output within 1 level of float64)")
+		dlTimeout     = flag.Duration("download-timeout", common.DefaultDownloadTimeout, "Timeout for fetching an http(s) URL given as -file")
+		lumaOnly      = flag.Bool("luma-only", false, "Fast approximate blur: blur luma only and keep the original chroma (colour edges stay sharp)")
+		encodeWorkers = flag.Int("encode-workers", 0, "Encode and write outputs on this many background goroutines while the next image blurs (0 encodes inline)")
+		pipelineSpec  = flag.String("pipeline", "", "Comma list of ops run instead of the plain blur on still images, e.g. scale:0.5,blur:15,unsharp:0.5 (ops: scale:F, blur:K, unsharp:AMOUNT[:K])")
+		contactSheet  = flag.String("contact-sheet", "", "After the run, write a PNG grid of thumbnails of every output to this path")
+		maskPath      = flag.String("mask", "", "Grayscale mask image the size of the inputs (still images only): 255 blurs fully, 0 keeps the original, values between blend")
+		vignette      = flag.Float64("vignette", 0, "Blur only toward the edges, keeping the centre sharp: the fraction (0-1] of the centre-to-corner distance the blur fades in over (0 = off)")
+		statsAppend   = flag.String("stats-append", "", "Append a row for every run, single-image ones included, to this cumulative CSV file")
+		resizeFilter  = flag.String("resize-filter", "bilinear", "Interpolation for -pipeline scale steps and -contact-sheet thumbnails: nearest, bilinear, catmull-rom or lanczos")
+		quantize      = flag.Bool("quantize", false, "Reduce PNG outputs to a 256-colour median-cut palette: much smaller files, at some banding")
+		approx        = flag.Bool("approx", false, "Approximate the Gaussian with three box blurs: cost independent of kernel size, within a few levels of exact")
+		verifyOutput  = flag.Bool("verify-output", false, "Re-open and fully decode each written PNG/JPEG output, checking its size, before counting the image as done")
+		verifyRetries = flag.Int("verify-retries", 1, "With -verify-output, rewrite an output that fails the check up to this many times before marking the image failed")
+		keepMTime     = flag.Bool("preserve-mtime", false, "Give each output file its input's modification time, for archives")
+		profileName   = flag.String("profile", "", "Blur preset instead of -kernel: light, medium, heavy or extreme (extreme blurs twice on still images)")
+		checkKernel   = flag.Bool("validate-kernel-sum", true, "Self-check at startup that the kernel's weights are finite and sum to 1, failing fast on a degenerate size/sigma")
+		inputSort     = flag.String("input-sort", "", "Process inputs in this order: name, size or mtime, with :desc to reverse (default: as listed)")
+		kernelEnergy  = flag.Float64("kernel-energy", 0, "Resize the kernel to the smallest odd size capturing this fraction (e.g. 0.99) of the Gaussian's energy, keeping the sigma from -kernel/-softness")
+		minKernel     = flag.Int("min-kernel", 1, "Smallest kernel size a derived size (-kernel-energy) may take; an even value is rounded up to odd")
+		maxKernel     = flag.Int("max-kernel", blur.MaxEnergyKernelSize, "Largest kernel size a derived size (-kernel-energy) may take; an even value is rounded down to odd")
 	)
 	flag.Parse()
+	profile, err := common.ApplyProfileFlag(kernelSize, *profileName)
+	if err != nil {
+		log.Fatalf("Invalid -profile: %v", err)
+	}
+	if err := common.ApplyRadiusFlag(kernelSize, *radius); err != nil {
+		log.Fatalf("Invalid -radius: %v", err)
+	}
+
+	pngLevel, err := common.ParsePNGCompression(*pngComp)
+	if err != nil {
+		log.Fatalf("Invalid -png-compression: %v", err)
+	}
+	if *jpegQual < 1 || *jpegQual > 100 {
+		log.Fatalf("Invalid -jpeg-quality %d: must be between 1 and 100", *jpegQual)
+	}
+
+	// Fix the spatial sigma before -kernel-energy may resize the window around it
+	sigma := 0.0
+	if *softness >= 0 {
+		sigma = blur.SoftnessToSigma(*softness, *kernelSize)
+	}
+	if *kernelEnergy != 0 {
+		if *kernelEnergy < 0 || *kernelEnergy >= 1 {
+			log.Fatalf("Invalid -kernel-energy %g: must be between 0 and 1", *kernelEnergy)
+		}
+		if sigma == 0 {
+			sigma = float64(*kernelSize) / 3.0
+		}
+		if *minKernel < 1 || *maxKernel < *minKernel {
+			log.Fatalf("Invalid -min-kernel %d / -max-kernel %d: need 1 <= min <= max", *minKernel, *maxKernel)
+		}
+		*kernelSize = blur.ClampKernelSize(blur.KernelSizeForEnergy(sigma, *kernelEnergy), *minKernel, *maxKernel)
+	}
+	if *checkKernel {
+		if err := blur.CheckKernel(*kernelSize, sigma); err != nil {
+			log.Fatalf("Kernel self-check failed, refusing to blur with it: %v", err)
+		}
+	}
 
 	startTime := time.Now()
 	log.Printf("=== Starting Distributed Sequential Image Processing ===")
 	log.Printf("Start time: %s", startTime.Format("2006-01-02 15:04:05"))
 	log.Printf("Kernel size: %d", *kernelSize)
+	if profile.Name != "" {
+		log.Printf("Profile: %s", profile)
+	}
 	log.Printf("Input path: %s", *inputPath)
 	log.Printf("Output path: %s", *outputPath)
+	log.Printf("Dither: %t", *dither)
+	if *bilateral {
+		log.Printf("Bilateral: on (sigma-color %.1f)", *sigmaColor)
+	}
+	if *float32Acc {
+		log.Printf("Accumulation: float32")
+	}
+	if *lumaOnly {
+		log.Printf("Luma-only blur: on (approximate, chroma unblurred)")
+	}
+	if *approx {
+		log.Printf("Approximate Gaussian: on (three box blurs)")
+	}
+	if *softness >= 0 {
+		log.Printf("Softness: %.0f%% (sigma %.2f)", math.Min(*softness, 100), sigma)
+	}
+	if *kernelEnergy != 0 {
+		log.Printf("Kernel energy: %.2f%% captured by size %d (sigma %.2f)",
+			100*blur.KernelEnergy(*kernelSize, sigma), *kernelSize, sigma)
+	}
+	log.Printf("PNG compression: %s", *pngComp)
+	log.Printf("JPEG quality: %d", *jpegQual)
+	if *quantize {
+		log.Printf("PNG palette: %d colours (median cut)", common.PaletteSize)
+	}
+	if *dedupFrames {
+		log.Printf("Frame dedup: on (threshold %.2f)", *dedupThresh)
+	}
+	if *noOutput {
+		log.Printf("Output suppressed (-no-output): timings exclude encode and write")
+	} else if *encodeWorkers > 0 {
+		log.Printf("Encode workers: %d", *encodeWorkers)
+	}
+	if *verifyOutput && !*noOutput {
+		log.Printf("Output verification: on (%d retries, GIFs unchecked)", *verifyRetries)
+	}
+
+	if *validate {
+		var inputPaths []string
+		if *inputFile != "" {
+			inputPaths = []string{resolveInputFile(*inputPath, *inputFile)}
+		} else if inputPaths, err = findInputFiles(*inputPath); err != nil {
+			log.Fatalf("Failed to read input directory: %v", err)
+		}
+		invalid := common.WriteValidationReport(os.Stdout, common.ValidateInputs(inputPaths))
+		if invalid > 0 && *strict {
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Ensure output directory exists
-	if err := os.MkdirAll(*outputPath, 0755); err != nil {
-		log.Fatalf("Failed to create output directory: %v", err)
+	if !*noOutput {
+		if err := os.MkdirAll(*outputPath, 0755); err != nil {
+			log.Fatalf("Failed to create output directory: %v", err)
+		}
 	}
 
+	blurOpts := blur.Options{Dither: *dither, Float32: *float32Acc, LumaOnly: *lumaOnly, Approx: *approx}
+	if *bilateral {
+		blurOpts.SigmaColor = *sigmaColor
+	}
+	blurOpts.Sigma = sigma
+	opts := processOptions{
+		Blur:        blurOpts,
+		PNGLevel:    pngLevel,
+		JPEGQuality: *jpegQual,
+		NoOutput:    *noOutput,
+
+		DedupFrames:    *dedupFrames,
+		DedupThreshold: *dedupThresh,
+
+		DownloadTimeout: *dlTimeout,
+
+		EncodeWorkers: *encodeWorkers,
+		Quantize:      *quantize,
+		VerifyOutput:  *verifyOutput,
+		VerifyRetries: *verifyRetries,
+		InputSort:     *inputSort,
+	}
+	if blurOpts.ResizeFilter, err = blur.ParseResizeFilter(*resizeFilter); err != nil {
+		log.Fatalf("Invalid -resize-filter: %v", err)
+	}
+	if *pipelineSpec != "" {
+		if opts.Pipeline, err = blur.ParsePipeline(*pipelineSpec, blurOpts); err != nil {
+			log.Fatalf("Invalid -pipeline: %v", err)
+		}
+		log.Printf("Pipeline: %s (replaces the -kernel blur; GIFs keep the plain blur)", opts.Pipeline)
+	} else {
+		opts.Pipeline = profile.Pipeline(*kernelSize, blurOpts)
+	}
+	if *maskPath != "" {
+		if opts.Mask, err = common.LoadMask(*maskPath, *dlTimeout); err != nil {
+			log.Fatalf("Invalid -mask: %v", err)
+		}
+		log.Printf("Mask: %s (%dx%d)", *maskPath, opts.Mask.Bounds().Dx(), opts.Mask.Bounds().Dy())
+		if opts.Pipeline.Resizes() {
+			log.Fatalf("-mask blends the blur over the original image, so it can't follow a -pipeline that scales it")
+		}
+	}
+	if *vignette != 0 {
+		if *vignette < 0 || *vignette > 1 {
+			log.Fatalf("Invalid -vignette %g: must be between 0 and 1", *vignette)
+		}
+		if opts.Mask != nil {
+			log.Fatalf("-vignette and -mask both choose where to blur; use one")
+		}
+		if opts.Pipeline.Resizes() {
+			log.Fatalf("-vignette blends the blur over the original image, so it can't follow a -pipeline that scales it")
+		}
+		opts.Vignette = *vignette
+		log.Printf("Vignette: blur fades in over the outer %.0f%% of the centre-to-corner distance", 100**vignette)
+	}
 	var result stats.PerformanceData
+	heap := stats.StartHeapSampler(stats.DefaultHeapSampleInterval)
 	
 	// Process specific file or all files in directory
 	if *inputFile != "" {
-		inputPaths := []string{filepath.Join(*inputPath, *inputFile)}
+		inputPaths := []string{resolveInputFile(*inputPath, *inputFile)}
 		outputPaths := []string{} // Will be filled by processFile
-		result = processFileWithTiming(inputPaths[0], *outputPath, *kernelSize, startTime)
+		result = processFileWithTiming(inputPaths[0], *outputPath, *kernelSize, opts, startTime)
 		outputPaths = append(outputPaths, result.OutputPaths...)
 		result.InputPaths = inputPaths
 		result.OutputPaths = outputPaths
 	} else {
-		result = processDirectoryWithTiming(*inputPath, *outputPath, *kernelSize, startTime)
+		result = processDirectoryWithTiming(*inputPath, *outputPath, *kernelSize, opts, startTime)
+	}
+	result.PeakHeapBytes = heap.Stop()
+
+	if *keepMTime {
+		preserveModTimes(result, *noOutput)
 	}
 
 	// Output performance results
@@ -55,48 +248,225 @@ func main() {
 	log.Printf("Total time: %.2fs", totalTime)
 	log.Printf("Images processed: %d", result.ImagesProcessed)
 	log.Printf("Average time per image: %.2fs", result.AverageTime)
+	log.Printf("Peak heap: %.1f MB", float64(result.PeakHeapBytes)/(1024*1024))
 	
 	// Write stats file if processing multiple images
 	if result.ImagesProcessed > 1 {
 		results := []stats.PerformanceData{result}
-		stats.WritePerformanceResultsWithPrefix(results, "d_")
+		stats.WritePerformanceResultsWithOptions(results, "d_", stats.WriteOptions{SummaryOnly: *summaryOnly})
 		log.Println("Performance results written to logs/d_*.txt")
 	}
+
+	if *manifest {
+		if *noOutput {
+			log.Printf("Skipping %s: outputs were suppressed by -no-output", stats.ManifestFile)
+		} else if path, err := stats.WriteManifest(*outputPath, result); err != nil {
+			log.Printf("Failed to write output manifest: %v", err)
+		} else {
+			log.Printf("Output manifest written to %s", path)
+		}
+	}
+
+	// Single-image runs (one call per video frame, say) only leave a record this way
+	if *statsAppend != "" {
+		if err := stats.AppendCSV(*statsAppend, result); err != nil {
+			log.Printf("Failed to append stats to %s: %v", *statsAppend, err)
+		} else {
+			log.Printf("Stats appended to %s", *statsAppend)
+		}
+	}
+
+	if *contactSheet != "" {
+		if *noOutput {
+			log.Printf("Skipping contact sheet: outputs were suppressed by -no-output")
+		} else if err := common.WriteContactSheet(result.OutputPaths, *contactSheet, blurOpts.ResizeFilter, pngLevel); err != nil {
+			log.Printf("Failed to write contact sheet: %v", err)
+		} else {
+			log.Printf("Contact sheet of %d outputs written to %s", len(result.OutputPaths), *contactSheet)
+		}
+	}
 }
 
-func processDirectoryWithTiming(inputDir, outputDir string, kernelSize int, overallStartTime time.Time) stats.PerformanceData {
+// preserveModTimes gives every written output its input's modification time, for -preserve-mtime
+func preserveModTimes(result stats.PerformanceData, noOutput bool) {
+	if noOutput {
+		log.Printf("Skipping -preserve-mtime: outputs were suppressed by -no-output")
+		return
+	}
+	if err := common.CopyModTimes(result.OutputPaths, result.InputPaths); err != nil {
+		log.Printf("Failed to preserve modification times: %v", err)
+	}
+}
+
+// processOptions carries the per-run settings shared by every file this processor handles
+type processOptions struct {
+	Blur        blur.Options
+	PNGLevel    png.CompressionLevel
+	JPEGQuality int  // for outputs of JPEG inputs (0 = common.DefaultJPEGQuality)
+	NoOutput    bool // skip encode and write, for benchmarking the blur alone
+
+	// Animated inputs: reuse the previous frame's blur when frames match within DedupThreshold
+	DedupFrames    bool
+	DedupThreshold float64
+
+	// InputSort orders the inputs of a directory run (see common.SortInputs)
+	InputSort string
+
+	// DownloadTimeout bounds fetching an input given as an http(s) URL
+	DownloadTimeout time.Duration
+
+	// EncodeWorkers > 0 overlaps encoding with the next blur in directory runs;
+	// encoder is the pool those runs create for it. GIFs are always written inline.
+	EncodeWorkers int
+	encoder       *common.EncodePool
+
+	// Pipeline, when set, replaces the blur of still images with an ordered list of
+	// transforms. GIF frames keep the plain blur, as a scale would break their layout.
+	Pipeline blur.Pipeline
+
+	// Mask, when set, limits the blur of still images to where it is nonzero
+	Mask *image.Gray
+
+	// Vignette > 0 blends still images through a blur.VignetteMask of this strength
+	Vignette float64
+
+	// Quantize writes PNG outputs with a palette (see common.Quantize)
+	Quantize bool
+
+	// VerifyOutput decodes every written still image again and rewrites it up to
+	// VerifyRetries times if that fails; an output that never passes fails its image
+	VerifyOutput  bool
+	VerifyRetries int
+
+	// wrapOutput, when set, wraps the writer of every output; tests use it to
+	// simulate a failing disk
+	wrapOutput func(io.Writer) io.Writer
+}
+
+// verifier returns the check for an output of img, or nil when outputs are not verified
+func (o processOptions) verifier(img image.Image) func(path string) error {
+	if !o.VerifyOutput {
+		return nil
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	return func(path string) error { return common.VerifyOutput(path, width, height) }
+}
+
+// encodeOutput writes an output image in its input's format (see common.EncodeOutput)
+// with the run's PNG compression or JPEG quality, quantizing PNGs first when asked
+func (o processOptions) encodeOutput(w io.Writer, img image.Image, format string) error {
+	if o.Quantize && format != "jpeg" {
+		img = common.Quantize(img, common.PaletteSize)
+	}
+	if o.wrapOutput != nil {
+		w = o.wrapOutput(w)
+	}
+	return common.EncodeOutput(w, img, format, o.PNGLevel, o.JPEGQuality)
+}
+
+// resolveInputFile joins a -file name onto the input directory, unless it is a URL
+func resolveInputFile(inputDir, name string) string {
+	if common.IsURL(name) {
+		return name
+	}
+	return filepath.Join(inputDir, name)
+}
+
+// isImageFile reports whether a file name has an extension this processor can handle
+func isImageFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".gif"
+}
+
+// findInputFiles lists the processable images directly inside inputDir
+func findInputFiles(inputDir string) ([]string, error) {
 	files, err := os.ReadDir(inputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, file := range files {
+		if !file.IsDir() && isImageFile(file.Name()) {
+			paths = append(paths, filepath.Join(inputDir, file.Name()))
+		}
+	}
+	return paths, nil
+}
+
+func processDirectoryWithTiming(inputDir, outputDir string, kernelSize int, opts processOptions, overallStartTime time.Time) stats.PerformanceData {
+	files, err := findInputFiles(inputDir)
 	if err != nil {
 		log.Fatalf("Failed to read input directory: %v", err)
 	}
+	if err := common.SortInputs(files, opts.InputSort); err != nil {
+		log.Fatalf("Invalid -input-sort: %v", err)
+	}
 
 	var inputPaths []string
 	var outputPaths []string
+	var timings []stats.ImageTiming
 	var totalBlurTime float64
 	processedCount := 0
 
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
+	// At most two blurred images wait per encoder, bounding the extra memory held
+	if opts.EncodeWorkers > 0 && !opts.NoOutput {
+		opts.encoder = common.NewEncodePool(opts.EncodeWorkers, 2*opts.EncodeWorkers)
+	}
 
-		ext := strings.ToLower(filepath.Ext(file.Name()))
-		if ext == ".jpg" || ext == ".jpeg" || ext == ".png" {
-			inputPath := filepath.Join(inputDir, file.Name())
-			
-			blurTime, outputPath, err := processFileWithDetailedTiming(inputPath, outputDir, kernelSize)
-			if err != nil {
-				log.Printf("Failed to process %s: %v", file.Name(), err)
-			} else {
-				inputPaths = append(inputPaths, inputPath)
+	for _, inputPath := range files {
+		name := filepath.Base(inputPath)
+		if opts.encoder != nil && opts.encoder.DiskFull() != nil {
+			// A background write ran out of space; blurring the rest would be wasted
+			log.Printf("Output disk is full; stopping batch before %s after %d images", name, processedCount)
+			break
+		}
+		timing, outputPath, err := processFileWithDetailedTiming(inputPath, outputDir, kernelSize, opts)
+		if common.IsDiskFull(err) {
+			// Every later write would fail too; stop and report the images that finished
+			log.Printf("Output disk is full while writing %s; stopping batch after %d completed images", name, processedCount)
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to process %s: %v", name, err)
+		} else {
+			inputPaths = append(inputPaths, inputPath)
+			if outputPath != "" {
 				outputPaths = append(outputPaths, outputPath)
-				totalBlurTime += blurTime
-				processedCount++
 			}
+			timings = append(timings, timing)
+			totalBlurTime += timing.BlurSeconds
+			processedCount++
+		}
+	}
+
+	if opts.encoder != nil {
+		failed := make(map[string]bool)
+		for _, f := range opts.encoder.Wait() {
+			log.Printf("Failed to write %s: %v", f.Path, f.Err)
+			failed[f.Path] = true
+		}
+		if len(failed) > 0 {
+			// Background encodes only run when outputs are on, so the two lists are parallel
+			var keptInputs, keptOutputs []string
+			var keptTimings []stats.ImageTiming
+			for i, outputPath := range outputPaths {
+				if !failed[outputPath] {
+					keptInputs = append(keptInputs, inputPaths[i])
+					keptOutputs = append(keptOutputs, outputPath)
+					keptTimings = append(keptTimings, timings[i])
+				}
+			}
+			inputPaths, outputPaths, timings = keptInputs, keptOutputs, keptTimings
+			processedCount = len(keptInputs)
 		}
 	}
 
 	totalTime := time.Since(overallStartTime).Seconds()
+	averageTime := 0.0
+	if processedCount > 0 {
+		averageTime = totalTime / float64(processedCount)
+	}
 	log.Printf("Processed %d images", processedCount)
 
 	return stats.PerformanceData{
@@ -104,15 +474,18 @@ func processDirectoryWithTiming(inputDir, outputDir string, kernelSize int, over
 		ImagesProcessed: processedCount,
 		KernelSize:      kernelSize,
 		TotalTime:       totalTime,
-		AverageTime:     totalTime / float64(processedCount),
+		AverageTime:     averageTime,
 		InputPaths:      inputPaths,
 		OutputPaths:     outputPaths,
 		Timestamp:       overallStartTime,
 		TotalBlurTime:   &totalBlurTime,
+		ImageTimings:    timings,
+
+		OutputsSuppressed: opts.NoOutput,
 	}
 }
 
-func processDirectory(inputDir, outputDir string, kernelSize int) {
+func processDirectory(inputDir, outputDir string, kernelSize int, opts processOptions) {
 	files, err := os.ReadDir(inputDir)
 	if err != nil {
 		log.Fatalf("Failed to read input directory: %v", err)
@@ -124,10 +497,13 @@ func processDirectory(inputDir, outputDir string, kernelSize int) {
 			continue
 		}
 
-		ext := strings.ToLower(filepath.Ext(file.Name()))
-		if ext == ".jpg" || ext == ".jpeg" || ext == ".png" {
+		if isImageFile(file.Name()) {
 			inputPath := filepath.Join(inputDir, file.Name())
-			if err := processFile(inputPath, outputDir, kernelSize); err != nil {
+			if err := processFile(inputPath, outputDir, kernelSize, opts); err != nil {
+				if common.IsDiskFull(err) {
+					log.Printf("Output disk is full while writing %s; stopping batch after %d completed images", file.Name(), processedCount)
+					break
+				}
 				log.Printf("Failed to process %s: %v", file.Name(), err)
 			} else {
 				processedCount++
@@ -138,11 +514,16 @@ func processDirectory(inputDir, outputDir string, kernelSize int) {
 	log.Printf("Processed %d images", processedCount)
 }
 
-func processFile(inputPath, outputDir string, kernelSize int) error {
+func processFile(inputPath, outputDir string, kernelSize int, opts processOptions) error {
+	if isGIF(inputPath) {
+		_, _, err := processGIFWithDetailedTiming(inputPath, outputDir, kernelSize, opts)
+		return err
+	}
+
 	log.Printf("Processing: %s", inputPath)
 
 	// Open and decode image
-	file, err := os.Open(inputPath)
+	file, err := common.OpenInput(inputPath, opts.DownloadTimeout)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
 	}
@@ -154,31 +535,35 @@ func processFile(inputPath, outputDir string, kernelSize int) error {
 	}
 
 	// Apply Gaussian blur
-	blurred := applyBlurToImage(img, kernelSize)
+	blurred, err := transformImage(img, kernelSize, opts)
+	if err != nil {
+		return fmt.Errorf("failed to blur %s: %w", inputPath, err)
+	}
+	if opts.NoOutput {
+		log.Printf("Blurred %s (output suppressed)", inputPath)
+		return nil
+	}
 
 	// Generate output filename
-	baseName := filepath.Base(inputPath)
+	baseName := common.InputName(inputPath)
 	nameWithoutExt := strings.TrimSuffix(baseName, filepath.Ext(baseName))
 	outputFileName := fmt.Sprintf("%s_blurred.%s", nameWithoutExt, format)
 	outputPath := filepath.Join(outputDir, outputFileName)
 
-	// Save blurred image
-	outFile, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
-	}
-	defer outFile.Close()
-
-	switch format {
-	case "jpeg":
-		err = jpeg.Encode(outFile, blurred, &jpeg.Options{Quality: 95})
-	case "png":
-		err = png.Encode(outFile, blurred)
-	default:
+	if format != "jpeg" && format != "png" {
 		return fmt.Errorf("unsupported format: %s", format)
 	}
+	encode := func(w io.Writer) error { return opts.encodeOutput(w, blurred, format) }
 
+	// Save blurred image, checking it decodes back when -verify-output is set
+	err = common.WriteVerified(outputPath, encode, opts.verifier(blurred), opts.VerifyRetries)
+	if errors.Is(err, common.ErrInvalidOutput) {
+		return err
+	}
 	if err != nil {
+		if common.IsDiskFull(err) {
+			os.Remove(outputPath) // don't leave a truncated image behind
+		}
 		return fmt.Errorf("failed to encode image: %w", err)
 	}
 
@@ -186,66 +571,98 @@ func processFile(inputPath, outputDir string, kernelSize int) error {
 	return nil
 }
 
-// processFileWithDetailedTiming wraps processFile with detailed timing
-func processFileWithDetailedTiming(inputPath, outputDir string, kernelSize int) (blurTime float64, outputPath string, err error) {
+// processFileWithDetailedTiming wraps processFile with detailed timing: the image's
+// size, its decode and blur times, and its total time including the write (or, with
+// an encoder, the hand-off to it)
+func processFileWithDetailedTiming(inputPath, outputDir string, kernelSize int, opts processOptions) (timing stats.ImageTiming, outputPath string, err error) {
+	if isGIF(inputPath) {
+		return processGIFWithDetailedTiming(inputPath, outputDir, kernelSize, opts)
+	}
+
 	log.Printf("Processing: %s", inputPath)
 
-	// Open and decode image
-	file, err := os.Open(inputPath)
+	// Open and decode image, timed apart from the blur to show when a batch is I/O-bound
+	decodeStart := time.Now()
+	file, err := common.OpenInput(inputPath, opts.DownloadTimeout)
 	if err != nil {
-		return 0, "", fmt.Errorf("failed to open file: %w", err)
+		return stats.ImageTiming{}, "", fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
 	img, format, err := image.Decode(file)
 	if err != nil {
-		return 0, "", fmt.Errorf("failed to decode image: %w", err)
+		return stats.ImageTiming{}, "", fmt.Errorf("failed to decode image: %w", err)
 	}
+	decodeTime := time.Since(decodeStart).Seconds()
 
 	// Time the blur operation
 	blurStart := time.Now()
-	blurred := applyBlurToImage(img, kernelSize)
-	blurTime = time.Since(blurStart).Seconds()
+	blurred, err := transformImage(img, kernelSize, opts)
+	if err != nil {
+		return stats.ImageTiming{}, "", fmt.Errorf("failed to blur image: %w", err)
+	}
+	timing = stats.ImageTiming{
+		Width:         img.Bounds().Dx(),
+		Height:        img.Bounds().Dy(),
+		DecodeSeconds: decodeTime,
+		BlurSeconds:   time.Since(blurStart).Seconds(),
+	}
+	if opts.NoOutput {
+		timing.Seconds = time.Since(decodeStart).Seconds()
+		log.Printf("Blurred %s (decode %.3fs / blur %.3fs, output suppressed)", inputPath, decodeTime, timing.BlurSeconds)
+		return timing, "", nil
+	}
 
 	// Generate output filename
-	baseName := filepath.Base(inputPath)
+	baseName := common.InputName(inputPath)
 	nameWithoutExt := strings.TrimSuffix(baseName, filepath.Ext(baseName))
 	outputFileName := fmt.Sprintf("%s_blurred.%s", nameWithoutExt, format)
 	outputPath = filepath.Join(outputDir, outputFileName)
 
-	// Save blurred image
-	outFile, err := os.Create(outputPath)
-	if err != nil {
-		return 0, "", fmt.Errorf("failed to create output file: %w", err)
+	if format != "jpeg" && format != "png" {
+		return stats.ImageTiming{}, "", fmt.Errorf("unsupported format: %s", format)
 	}
-	defer outFile.Close()
+	encode := func(w io.Writer) error { return opts.encodeOutput(w, blurred, format) }
 
-	switch format {
-	case "jpeg":
-		err = jpeg.Encode(outFile, blurred, &jpeg.Options{Quality: 95})
-	case "png":
-		err = png.Encode(outFile, blurred)
-	default:
-		return 0, "", fmt.Errorf("unsupported format: %s", format)
+	if opts.encoder != nil {
+		opts.encoder.SubmitVerified(outputPath, encode, opts.verifier(blurred), opts.VerifyRetries)
+		timing.Seconds = time.Since(decodeStart).Seconds()
+		log.Printf("Queued blurred image for encoding: %s (decode %.3fs / blur %.3fs)", outputPath, decodeTime, timing.BlurSeconds)
+		return timing, outputPath, nil
 	}
 
+	// Save blurred image, checking it decodes back when -verify-output is set
+	err = common.WriteVerified(outputPath, encode, opts.verifier(blurred), opts.VerifyRetries)
+	if errors.Is(err, common.ErrInvalidOutput) {
+		return stats.ImageTiming{}, "", err
+	}
 	if err != nil {
-		return 0, "", fmt.Errorf("failed to encode image: %w", err)
+		if common.IsDiskFull(err) {
+			os.Remove(outputPath) // don't leave a truncated image behind
+		}
+		return stats.ImageTiming{}, "", fmt.Errorf("failed to encode image: %w", err)
 	}
 
-	log.Printf("Saved blurred image to: %s (blur time: %.3fs)", outputPath, blurTime)
-	return blurTime, outputPath, nil
+	timing.Seconds = time.Since(decodeStart).Seconds()
+	log.Printf("Saved blurred image to: %s (decode %.3fs / blur %.3fs)", outputPath, decodeTime, timing.BlurSeconds)
+	return timing, outputPath, nil
 }
 
 // processFileWithTiming wraps single file processing with timing for stats
-func processFileWithTiming(inputPath, outputDir string, kernelSize int, startTime time.Time) stats.PerformanceData {
-	blurTime, outputPath, err := processFileWithDetailedTiming(inputPath, outputDir, kernelSize)
+func processFileWithTiming(inputPath, outputDir string, kernelSize int, opts processOptions, startTime time.Time) stats.PerformanceData {
+	timing, outputPath, err := processFileWithDetailedTiming(inputPath, outputDir, kernelSize, opts)
 	if err != nil {
 		log.Fatalf("Failed to process file: %v", err)
 	}
+	blurTime := timing.BlurSeconds
 
 	totalTime := time.Since(startTime).Seconds()
 
+	var outputPaths []string
+	if outputPath != "" {
+		outputPaths = []string{outputPath}
+	}
+
 	return stats.PerformanceData{
 		AlgorithmName:   "Distributed Sequential",
 		ImagesProcessed: 1,
@@ -253,8 +670,11 @@ func processFileWithTiming(inputPath, outputDir string, kernelSize int, startTim
 		TotalTime:       totalTime,
 		AverageTime:     totalTime,
 		InputPaths:      []string{inputPath},
-		OutputPaths:     []string{outputPath},
+		OutputPaths:     outputPaths,
 		Timestamp:       startTime,
 		TotalBlurTime:   &blurTime,
+		ImageTimings:    []stats.ImageTiming{timing},
+
+		OutputsSuppressed: opts.NoOutput,
 	}
 }
\ No newline at end of file
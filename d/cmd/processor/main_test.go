@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"studyguide.parallel/pkg/stats"
+)
+
+// writePNG writes a random width x height PNG to path
+func writePNG(t *testing.T, path string, width, height int) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManifestRecordsDecodeAndBlurTimes(t *testing.T) {
+	inDir, outDir := t.TempDir(), t.TempDir()
+	writePNG(t, filepath.Join(inDir, "a.png"), 24, 16)
+	writePNG(t, filepath.Join(inDir, "b.png"), 16, 24)
+	writeTwoFrameGIF(t, filepath.Join(inDir, "c.gif"))
+
+	result := processDirectoryWithTiming(inDir, outDir, 5, processOptions{}, time.Now())
+	if result.ImagesProcessed != 3 || len(result.ImageTimings) != 3 {
+		t.Fatalf("processed %d images with %d timings, want 3 of each", result.ImagesProcessed, len(result.ImageTimings))
+	}
+	path, err := stats.WriteManifest(outDir, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest struct {
+		Outputs []map[string]any `json:"outputs"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][2]float64{"a.png": {24, 16}, "b.png": {16, 24}, "c.gif": {32, 24}}
+	for _, entry := range manifest.Outputs {
+		name := filepath.Base(entry["input"].(string))
+		decode, _ := entry["decode_seconds"].(float64)
+		blurred, _ := entry["blur_seconds"].(float64)
+		seconds, _ := entry["seconds"].(float64)
+		if decode <= 0 || blurred <= 0 || seconds < decode+blurred {
+			t.Errorf("%s: decode %gs, blur %gs, total %gs; want both stages timed within the total", name, decode, blurred, seconds)
+		}
+		if size := want[name]; entry["width"] != size[0] || entry["height"] != size[1] {
+			t.Errorf("%s: recorded %vx%v, want %gx%g", name, entry["width"], entry["height"], size[0], size[1])
+		}
+	}
+	if len(manifest.Outputs) != 3 {
+		t.Errorf("manifest lists %d outputs, want 3", len(manifest.Outputs))
+	}
+}
+
+func TestProcessFileUsesJPEGQuality(t *testing.T) {
+	inDir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	rand.New(rand.NewSource(2)).Read(img.Pix)
+	var src bytes.Buffer
+	if err := jpeg.Encode(&src, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(inDir, "photo.jpg")
+	if err := os.WriteFile(input, src.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := map[int]int64{}
+	for _, quality := range []int{10, 0, 95} {
+		outDir := t.TempDir()
+		_, output, err := processFileWithDetailedTiming(input, outDir, 3, processOptions{JPEGQuality: quality})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Base(output) != "photo_blurred.jpeg" {
+			t.Errorf("quality %d wrote %s, want photo_blurred.jpeg", quality, filepath.Base(output))
+		}
+		info, err := os.Stat(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[quality] = info.Size()
+	}
+	if sizes[10] >= sizes[95] {
+		t.Errorf("quality 10 output is %d bytes, quality 95 %d; want the lower quality smaller", sizes[10], sizes[95])
+	}
+	if sizes[0] != sizes[95] {
+		t.Errorf("quality 0 output is %d bytes, want the default quality's %d", sizes[0], sizes[95])
+	}
+}
@@ -13,7 +13,9 @@ import (
 
 	"go-blur/pkg/common"
 	"go-blur/pkg/queue"
+	sharedcommon "studyguide.parallel/pkg/common"
 	"studyguide.parallel/pkg/stats"
+	"studyguide.parallel/pkg/verify"
 )
 
 type ImageAssembler struct {
@@ -24,19 +26,77 @@ type ImageAssembler struct {
 	outputImage   *image.RGBA
 }
 
+// checkThrottle lets a periodic check run at most once per interval
+type checkThrottle struct {
+	every time.Duration
+	now   func() time.Time
+	last  time.Time // when the check last ran; zero before the first
+}
+
+// due reports whether the check may run now, and if so counts it as run
+func (c *checkThrottle) due() bool {
+	now := c.now()
+	if !c.last.IsZero() && now.Sub(c.last) < c.every {
+		return false
+	}
+	c.last = now
+	return true
+}
+
+// addTile records a tile of the image and returns how many tiles have arrived. With
+// ordered the tile is only buffered, to be placed by placeOrdered, and a redelivered
+// tile replaces its earlier copy instead of counting twice.
+func (a *ImageAssembler) addTile(tile *common.ProcessedImageTile, ordered bool) int {
+	if !ordered {
+		sharedcommon.PlaceTile(a.outputImage, tile)
+		a.tilesReceived++
+		return a.tilesReceived
+	}
+	a.tiles[tile.TileID] = tile
+	a.tilesReceived = len(a.tiles)
+	return a.tilesReceived
+}
+
+// placeOrdered places the tiles buffered by addTile in tile-ID order
+func (a *ImageAssembler) placeOrdered() {
+	tiles := make([]*common.ProcessedImageTile, 0, len(a.tiles))
+	for _, t := range a.tiles {
+		tiles = append(tiles, t)
+	}
+	sharedcommon.PlaceOrdered(a.outputImage, tiles)
+}
+
 func main() {
 	var (
 		redisAddr   = flag.String("redis", "redis:6379", "Redis server address")
-		timeout     = flag.Duration("timeout", 30*time.Second, "Result poll timeout")
+		timeout     = flag.Duration("timeout", 30*time.Second, "Result poll timeout: how long each wait for a result blocks")
+		checkEvery  = flag.Duration("completion-check-interval", 30*time.Second, "When idle, check every image's progress in Redis at most this often (0 checks after every poll timeout)")
 		maxImages   = flag.Int("max-images", 100, "Maximum number of images to track")
+		pngComp     = flag.String("png-compression", "default", "PNG compression level: default, none, speed or best")
+		jpegQual    = flag.Int("jpeg-quality", sharedcommon.DefaultJPEGQuality, "Quality (1-100) of the JPEG outputs written for JPEG inputs")
+		summaryOnly = flag.Bool("summary-only", false, "Write only aggregate numbers to the stats file, without per-file listings")
+		ordered     = flag.Bool("ordered", false, "Buffer every tile of an image and place them in tile-ID order before saving (deterministic output for testing)")
+		seamless    = flag.Bool("assert-seamless", false, "After saving each image, check its tile boundaries for seams and log an error if one exceeds -seam-threshold")
+		seamThresh  = flag.Float64("seam-threshold", verify.DefaultSeamThreshold, "Seam magnitude (excess 8-bit luma step) that fails -assert-seamless")
+		seamFatal   = flag.Bool("seam-fatal", false, "With -assert-seamless, exit non-zero on the first seamed image instead of only logging it")
 	)
+	redisTLS := sharedcommon.RedisTLSFlags()
 	flag.Parse()
 
+	pngLevel, err := sharedcommon.ParsePNGCompression(*pngComp)
+	if err != nil {
+		log.Fatalf("Invalid -png-compression: %v", err)
+	}
+
 	log.Printf("Assembler starting...")
 	log.Printf("Redis address: %s", *redisAddr)
 
 	// Connect to Redis
-	redisQueue, err := queue.NewRedisQueue(*redisAddr)
+	tlsConfig, err := redisTLS.Config()
+	if err != nil {
+		log.Fatalf("Invalid Redis TLS flags: %v", err)
+	}
+	redisQueue, err := queue.NewRedisQueue(*redisAddr, tlsConfig)
 	if err != nil {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
@@ -46,6 +106,13 @@ func main() {
 	assemblers := make(map[int]*ImageAssembler)
 	completedImages := 0
 
+	// Workers that returned at least one tile, reported as the run's worker count
+	activeWorkers := make(map[string]bool)
+
+	// A short -timeout must not turn the idle progress check into a Redis round-trip
+	// per image several times a second
+	idleCheck := &checkThrottle{every: *checkEvery, now: time.Now}
+
 	// Main assembler loop
 	for {
 		// Pop result from queue
@@ -56,6 +123,10 @@ func main() {
 		}
 
 		if result == nil {
+			if !idleCheck.due() {
+				continue
+			}
+
 			// Check if all known images are complete
 			allComplete := true
 			for imageID, assembler := range assemblers {
@@ -72,6 +143,12 @@ func main() {
 		}
 
 		tile := result.ProcessedTile
+		if err := sharedcommon.ValidateTileData(tile); err != nil {
+			// Placing it would leave unwritten pixels in the image
+			log.Printf("Rejecting result from worker %s: %v", result.WorkerID, err)
+			continue
+		}
+		activeWorkers[result.WorkerID] = true
 		
 		// Get or create assembler for this image
 		assembler, exists := assemblers[tile.ImageID]
@@ -93,19 +170,10 @@ func main() {
 			log.Printf("Created assembler for image %d (%s)", tile.ImageID+1, imageInfo.InputPath)
 		}
 
-		// Add tile to assembler
+		// Place tile in output image; with -ordered the tiles are only placed once all
+		// have arrived. Otherwise no tile is kept, so an image costs one RGBA buffer.
 		assembler.mutex.Lock()
-		assembler.tiles[tile.TileID] = tile
-		assembler.tilesReceived++
-
-		// Place tile in output image
-		for y := 0; y < tile.Height && y < len(tile.Data); y++ {
-			for x := 0; x < tile.Width && x < len(tile.Data[y]); x++ {
-				assembler.outputImage.SetRGBA(tile.X+x, tile.Y+y, tile.Data[y][x])
-			}
-		}
-
-		tilesReceived := assembler.tilesReceived
+		tilesReceived := assembler.addTile(tile, *ordered)
 		expectedTiles := assembler.imageInfo.ExpectedTiles
 		assembler.mutex.Unlock()
 
@@ -115,9 +183,13 @@ func main() {
 		// Check if image is complete
 		if tilesReceived >= expectedTiles {
 			log.Printf("Image %d complete! Saving...", tile.ImageID+1)
-			
+
+			if *ordered {
+				assembler.placeOrdered()
+			}
+
 			// Save the assembled image
-			if err := saveImage(assembler.outputImage, assembler.imageInfo.OutputPath); err != nil {
+			if err := saveImage(assembler.outputImage, assembler.imageInfo, pngLevel, *jpegQual); err != nil {
 				log.Printf("Failed to save image %d: %v", tile.ImageID+1, err)
 			} else {
 				processingTime := time.Since(assembler.imageInfo.StartTime)
@@ -125,6 +197,10 @@ func main() {
 					tile.ImageID+1, assembler.imageInfo.OutputPath, processingTime.Seconds())
 				completedImages++
 
+				if *seamless {
+					assertSeamless(assembler.outputImage, assembler.imageInfo, *seamThresh, *seamFatal)
+				}
+
 				// Update image end time in Redis
 				endTime := time.Now()
 				if err := redisQueue.UpdateImageEndTime(tile.ImageID, endTime); err != nil {
@@ -140,7 +216,7 @@ func main() {
 				log.Printf("All images processed. Total: %d", completedImages)
 				
 				// Generate and output final performance stats
-				if err := outputFinalStats(redisQueue); err != nil {
+				if err := outputFinalStats(redisQueue, len(activeWorkers), stats.WriteOptions{SummaryOnly: *summaryOnly}); err != nil {
 					log.Printf("Failed to output final stats: %v", err)
 				}
 				
@@ -152,7 +228,9 @@ func main() {
 	log.Printf("Assembler shutting down. Completed %d images.", completedImages)
 }
 
-func outputFinalStats(redisQueue *queue.RedisQueue) error {
+// outputFinalStats writes the run's stats. activeWorkers is the number of distinct
+// workers that returned tiles; when none were seen the coordinator's -workers is used.
+func outputFinalStats(redisQueue *queue.RedisQueue, activeWorkers int, writeOpts stats.WriteOptions) error {
 	// Get timing data from Redis
 	timingData, err := redisQueue.GetTiming()
 	if err != nil {
@@ -178,19 +256,11 @@ func outputFinalStats(redisQueue *queue.RedisQueue) error {
 		log.Printf("Failed to update final timing data: %v", err)
 	}
 
-	// Calculate total time
-	totalTime := finalEndTime.Sub(timingData.StartTime).Seconds()
-	
-	// Create performance data for stats package
-	performanceData := stats.PerformanceData{
-		AlgorithmName:   "Distributed Queue",
-		ImagesProcessed: timingData.TotalImages,
-		KernelSize:      timingData.KernelSize,
-		TotalTime:       totalTime,
-		AverageTime:     totalTime / float64(timingData.TotalImages),
-		InputPaths:      timingData.InputPaths,
-		OutputPaths:     timingData.OutputPaths,
-		Timestamp:       timingData.StartTime,
+	performanceData := distributedStats(timingData, finalEndTime, activeWorkers)
+	totalTime := performanceData.TotalTime
+	workers := 0
+	if performanceData.Workers != nil {
+		workers = *performanceData.Workers
 	}
 
 	// Output performance summary
@@ -201,6 +271,11 @@ func outputFinalStats(redisQueue *queue.RedisQueue) error {
 	log.Printf("Images processed: %d", timingData.TotalImages)
 	log.Printf("Average time per image: %.2fs", performanceData.AverageTime)
 	log.Printf("Kernel size: %d", timingData.KernelSize)
+	if timingData.TileSize > 0 {
+		log.Printf("Workers: %d, tile size: %d", workers, timingData.TileSize)
+	} else {
+		log.Printf("Workers: %d, tile size: sized per image (-auto-tile)", workers)
+	}
 
 	// Individual image times
 	for imageID, startTime := range timingData.ImageStartTimes {
@@ -212,30 +287,83 @@ func outputFinalStats(redisQueue *queue.RedisQueue) error {
 
 	// Write performance results file
 	results := []stats.PerformanceData{performanceData}
-	stats.WritePerformanceResultsWithPrefix(results, "e_")
+	stats.WritePerformanceResultsWithOptions(results, "e_", writeOpts)
 	log.Printf("Performance results written to logs/e_*.txt")
 
 	return nil
 }
 
-func saveImage(img *image.RGBA, outputPath string) error {
+// distributedStats builds the run's stats from the coordinator's timing data, ending at
+// finalEndTime. activeWorkers is the number of distinct workers that returned tiles;
+// when none were seen the coordinator's -workers is used.
+func distributedStats(timingData *common.TimingData, finalEndTime time.Time, activeWorkers int) stats.PerformanceData {
+	totalTime := finalEndTime.Sub(timingData.StartTime).Seconds()
+	performanceData := stats.PerformanceData{
+		AlgorithmName:   "Distributed Queue",
+		ImagesProcessed: timingData.TotalImages,
+		KernelSize:      timingData.KernelSize,
+		TotalTime:       totalTime,
+		AverageTime:     totalTime / float64(timingData.TotalImages),
+		InputPaths:      timingData.InputPaths,
+		OutputPaths:     timingData.OutputPaths,
+		Timestamp:       timingData.StartTime,
+	}
+
+	workers := activeWorkers
+	if workers == 0 {
+		workers = timingData.Workers
+	}
+	if workers > 0 {
+		performanceData.Workers = &workers
+	}
+	if timingData.TileSize > 0 {
+		tileSize := timingData.TileSize
+		performanceData.TileSize = &tileSize
+	}
+	return performanceData
+}
+
+// assertSeamless logs an error for an assembled image with a seam at a tile boundary,
+// exiting when fatal is set
+func assertSeamless(img *image.RGBA, info *common.ImageInfo, threshold float64, fatal bool) {
+	err := sharedcommon.CheckSeamless(img, info, threshold)
+	if err == nil {
+		log.Printf("Image %d: no tile seams above %.2f", info.ID+1, threshold)
+		return
+	}
+	if fatal {
+		log.Fatalf("Image %d failed -assert-seamless: %v", info.ID+1, err)
+	}
+	log.Printf("ERROR: image %d failed -assert-seamless: %v", info.ID+1, err)
+}
+
+// saveImage writes img to info.OutputPath in the input's format (see
+// sharedcommon.EncodeOutput), with the input's timestamp under -preserve-mtime
+func saveImage(img *image.RGBA, info *common.ImageInfo, pngLevel png.CompressionLevel, jpegQuality int) error {
 	// Ensure output directory exists
-	outputDir := filepath.Dir(outputPath)
+	outputDir := filepath.Dir(info.OutputPath)
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
 	// Create output file
-	file, err := os.Create(outputPath)
+	file, err := os.Create(info.OutputPath)
 	if err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
 	}
-	defer file.Close()
 
-	// Encode and save as PNG
-	if err := png.Encode(file, img); err != nil {
+	// Encode and save as PNG, or JPEG for JPEG inputs
+	if err := sharedcommon.EncodeOutput(file, img, info.Format, pngLevel, jpegQuality); err != nil {
+		file.Close()
 		return fmt.Errorf("failed to encode image: %w", err)
 	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
 
+	// Set after closing, so the final write cannot bump the time again
+	if err := sharedcommon.ApplySourceModTime(info, info.OutputPath); err != nil {
+		return fmt.Errorf("failed to preserve modification time: %w", err)
+	}
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"go-blur/pkg/common"
+	sharedcommon "studyguide.parallel/pkg/common"
+)
+
+func TestDistributedStatsWorkersAndTileSize(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	timing := &common.TimingData{
+		StartTime:   start,
+		KernelSize:  15,
+		TotalImages: 4,
+		Workers:     6,
+		TileSize:    common.TILE_SIZE,
+	}
+	tests := []struct {
+		name          string
+		activeWorkers int
+		want          int
+	}{
+		{"workers that returned tiles", 3, 3},
+		{"none seen, the coordinator's -workers", 0, 6},
+	}
+	for _, tc := range tests {
+		data := distributedStats(timing, start.Add(8*time.Second), tc.activeWorkers)
+		if data.Workers == nil || *data.Workers != tc.want {
+			t.Errorf("%s: workers %v, want %d", tc.name, data.Workers, tc.want)
+		}
+		if data.TileSize == nil || *data.TileSize != common.TILE_SIZE {
+			t.Errorf("%s: tile size %v, want %d", tc.name, data.TileSize, common.TILE_SIZE)
+		}
+		if data.TotalTime != 8 || data.AverageTime != 2 || data.ImagesProcessed != 4 || data.KernelSize != 15 {
+			t.Errorf("%s: %d images in %.1fs (%.1fs each), kernel %d; want 4 in 8s (2s each), kernel 15",
+				tc.name, data.ImagesProcessed, data.TotalTime, data.AverageTime, data.KernelSize)
+		}
+	}
+
+	// Timing data from a coordinator that predates the fields leaves both unset
+	data := distributedStats(&common.TimingData{StartTime: start, TotalImages: 1}, start.Add(time.Second), 0)
+	if data.Workers != nil || data.TileSize != nil {
+		t.Errorf("without a worker count or tile size got workers %v, tile size %v; want neither", data.Workers, data.TileSize)
+	}
+}
+
+// assembleTiles runs tiles through an ImageAssembler for a width x height image as the
+// main loop does, returning the last tile count addTile reported and the image
+func assembleTiles(width, height int, tiles []*common.ProcessedImageTile, ordered bool) (int, *image.RGBA) {
+	a := &ImageAssembler{
+		tiles:       make(map[int]*common.ProcessedImageTile),
+		outputImage: image.NewRGBA(image.Rect(0, 0, width, height)),
+	}
+	received := 0
+	for _, tile := range tiles {
+		received = a.addTile(tile, ordered)
+	}
+	if ordered {
+		a.placeOrdered()
+	}
+	return received, a.outputImage
+}
+
+func TestOrderedAssembly(t *testing.T) {
+	const width, height, size = 9, 6, 4
+	var tiles []*common.ProcessedImageTile
+	for y0 := 0; y0 < height; y0 += size {
+		for x0 := 0; x0 < width; x0 += size {
+			tile := &common.ProcessedImageTile{TileID: len(tiles), X: x0, Y: y0, Width: min(size, width-x0), Height: min(size, height-y0)}
+			for y := 0; y < tile.Height; y++ {
+				row := make([]color.RGBA, tile.Width)
+				for x := range row {
+					row[x] = color.RGBA{uint8((x0 + x) * 25), uint8((y0 + y) * 40), uint8(tile.TileID), 255}
+				}
+				tile.Data = append(tile.Data, row)
+			}
+			tiles = append(tiles, tile)
+		}
+	}
+	want := sharedcommon.AssembleOrdered(width, height, tiles)
+
+	shuffled := append([]*common.ProcessedImageTile(nil), tiles...)
+	rand.New(rand.NewSource(1)).Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	for _, ordered := range []bool{false, true} {
+		received, got := assembleTiles(width, height, shuffled, ordered)
+		if received != len(tiles) || !bytes.Equal(got.Pix, want.Pix) {
+			t.Errorf("ordered=%v: shuffled tiles counted %d and assembled differently from tile-ID order", ordered, received)
+		}
+	}
+
+	// A redelivered tile is counted once, so it cannot complete the image early
+	received, got := assembleTiles(width, height, append(shuffled[1:], shuffled[2]), true)
+	if received != len(tiles)-1 {
+		t.Errorf("one tile missing and one repeated counted %d tiles, want %d", received, len(tiles)-1)
+	}
+	missing := shuffled[0]
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c, w := got.RGBAAt(x, y), want.RGBAAt(x, y)
+			if x >= missing.X && x < missing.X+missing.Width && y >= missing.Y && y < missing.Y+missing.Height {
+				w = color.RGBA{}
+			}
+			if c != w {
+				t.Fatalf("without tile %d, (%d,%d) is %v, want %v", missing.TileID, x, y, c, w)
+			}
+		}
+	}
+}
+
+func TestCheckThrottle(t *testing.T) {
+	clock := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	check := &checkThrottle{every: 30 * time.Second, now: func() time.Time { return clock }}
+
+	// Polls time out every 5s; the check runs on the first and then every 30s
+	var ran []int
+	for poll := 0; poll <= 13; poll++ {
+		if check.due() {
+			ran = append(ran, poll*5)
+		}
+		clock = clock.Add(5 * time.Second)
+	}
+	if want := []int{0, 30, 60}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("checks ran at %vs, want %vs", ran, want)
+	}
+
+	// An interval of 0 checks after every poll
+	check = &checkThrottle{now: func() time.Time { return clock }}
+	for poll := 0; poll < 3; poll++ {
+		if !check.due() {
+			t.Fatalf("poll %d skipped the check with no interval", poll)
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"flag"
 	"fmt"
 	"image"
-	"image/color"
 	_ "image/jpeg"
 	_ "image/png"
 	"log"
@@ -15,26 +14,55 @@ import (
 
 	"go-blur/pkg/common"
 	"go-blur/pkg/queue"
+	"studyguide.parallel/pkg/blur"
+	sharedcommon "studyguide.parallel/pkg/common"
 )
 
 func main() {
 	var (
-		inputPath   = flag.String("input", "/input", "Input directory path")
-		outputPath  = flag.String("output", "/e/output", "Output directory path")
-		kernelSize  = flag.Int("kernel", 15, "Gaussian kernel size")
-		redisAddr   = flag.String("redis", "redis:6379", "Redis server address")
-		numWorkers  = flag.Int("workers", 4, "Number of workers to wait for")
+		inputPath  = flag.String("input", "/input", "Input directory path")
+		outputPath = flag.String("output", "/e/output", "Output directory path")
+		kernelSize = flag.Int("kernel", 15, "Gaussian kernel size")
+		redisAddr  = flag.String("redis", "redis:6379", "Redis server address")
+		numWorkers = flag.Int("workers", 4, "Number of done signals to send when no worker has registered a heartbeat")
+		batchSize  = flag.Int("enqueue-batch", 64, "Number of tile jobs sent to Redis per round-trip")
+		dither     = flag.Bool("dither", false, "Ordered-dither tile output (matches the sequential -dither output exactly)")
+		edgeName   = flag.String("edge-mode", "clamp", "How border tiles are padded past the image edge: clamp, reflect or wrap")
+		autoTile   = flag.Bool("auto-tile", false, "Size tiles per image to aim for about 4 tiles per live worker (or per -workers if none has registered) instead of a fixed 256px")
+		overlapRep = flag.Bool("tile-overlap-report", false, "Preflight: report each image's tile padding and its overhead for the kernel it is blurred with (-kernel or -kernel-manifest)")
+		keepMTime  = flag.Bool("preserve-mtime", false, "Have the assembler give each output its input's modification time")
+		inputSort  = flag.String("input-sort", "", "Enqueue images in this order: name, size or mtime, with :desc to reverse (default: directory order)")
+		kernelList = flag.String("kernel-manifest", "", "File of \"name kernel\" lines giving listed images their own kernel size (others use -kernel)")
+		maxConsec  = flag.Int("max-consecutive-tiles", 0, "Interleave images on the queue, at most this many tiles of one image in a row (0 enqueues image by image; holds every decoded image in memory)")
 	)
+	redisTLS := sharedcommon.RedisTLSFlags()
 	flag.Parse()
 
+	edgeMode, err := blur.ParseEdgeMode(*edgeName)
+	if err != nil {
+		log.Fatalf("Invalid -edge-mode: %v", err)
+	}
+
 	log.Printf("Coordinator starting...")
 	log.Printf("Input path: %s", *inputPath)
 	log.Printf("Output path: %s", *outputPath)
 	log.Printf("Kernel size: %d", *kernelSize)
+	log.Printf("Edge mode: %s", edgeMode)
 	log.Printf("Redis address: %s", *redisAddr)
+	var kernels sharedcommon.ImageKernels
+	if *kernelList != "" {
+		if kernels, err = sharedcommon.ReadImageKernels(*kernelList); err != nil {
+			log.Fatalf("Invalid -kernel-manifest: %v", err)
+		}
+		log.Printf("Kernel manifest: %d images with their own kernel size", len(kernels))
+	}
 
 	// Connect to Redis
-	redisQueue, err := queue.NewRedisQueue(*redisAddr)
+	tlsConfig, err := redisTLS.Config()
+	if err != nil {
+		log.Fatalf("Invalid Redis TLS flags: %v", err)
+	}
+	redisQueue, err := queue.NewRedisQueue(*redisAddr, tlsConfig)
 	if err != nil {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
@@ -45,6 +73,9 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to get image paths: %v", err)
 	}
+	if err := sharedcommon.SortInputs(imagePaths, *inputSort); err != nil {
+		log.Fatalf("Invalid -input-sort: %v", err)
+	}
 
 	if len(imagePaths) == 0 {
 		log.Println("No images found to process")
@@ -53,6 +84,15 @@ func main() {
 
 	log.Printf("Found %d images to process", len(imagePaths))
 
+	targetTiles := 0
+	if *autoTile {
+		targetTiles = autoTileTarget(redisQueue, *numWorkers)
+		log.Printf("Auto tile size: targeting %d tiles per image", targetTiles)
+	}
+	if *overlapRep {
+		reportTileOverlap(imagePaths, kernels, *kernelSize, *autoTile)
+	}
+
 	// Initialize timing data
 	startTime := time.Now()
 	
@@ -64,36 +104,62 @@ func main() {
 		OutputPaths:     make([]string, 0, len(imagePaths)),
 		ImageStartTimes: make(map[int]time.Time),
 		ImageEndTimes:   make(map[int]*time.Time),
+		TileSize:        common.TILE_SIZE,
+	}
+	if *autoTile {
+		timingData.TileSize = 0 // each image has its own, in its ImageInfo
 	}
 
 	// Process each image
-	padding := *kernelSize / 2
 	totalTiles := 0
+	batch := make([]*common.JobMessage, 0, *batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := redisQueue.PushJobs(batch); err != nil {
+			log.Printf("Failed to push %d tile jobs: %v", len(batch), err)
+		} else {
+			totalTiles += len(batch)
+		}
+		batch = batch[:0]
+	}
+	enqueue := func(tile *common.ImageTile) {
+		batch = append(batch, &common.JobMessage{Type: "tile", ImageTile: tile})
+		if len(batch) >= *batchSize {
+			flush()
+		}
+	}
+
+	// With -max-consecutive-tiles every image is loaded first and its tiles are
+	// enqueued round-robin afterwards, so a large image cannot starve the rest
+	var sources []func() *common.ImageTile
 
 	for imageID, imagePath := range imagePaths {
 		log.Printf("Processing image %d: %s", imageID+1, imagePath)
 		imageStartTime := time.Now()
 		
 		// Load image
-		img, err := loadImage(imagePath)
+		img, format, err := loadImage(imagePath)
 		if err != nil {
 			log.Printf("Failed to load image %s: %v", imagePath, err)
 			continue
 		}
 
+		imageKernel := kernels.For(imagePath, *kernelSize)
+
 		bounds := img.Bounds()
 		width := bounds.Dx()
 		height := bounds.Dy()
 
-		// Calculate tiles
-		tilesX := (width + common.TILE_SIZE - 1) / common.TILE_SIZE
-		tilesY := (height + common.TILE_SIZE - 1) / common.TILE_SIZE
-		expectedTiles := tilesX * tilesY
+		// Calculate tiles; AutoTileSize gives TILE_SIZE without -auto-tile
+		tileSize := sharedcommon.AutoTileSize(width, height, targetTiles)
+		expectedTiles := sharedcommon.TileCount(width, height, tileSize)
 
 		// Create output path
 		baseName := filepath.Base(imagePath)
 		nameWithoutExt := strings.TrimSuffix(baseName, filepath.Ext(baseName))
-		outputFile := filepath.Join(*outputPath, fmt.Sprintf("%s_blurred.png", nameWithoutExt))
+		outputFile := filepath.Join(*outputPath, nameWithoutExt+"_blurred"+sharedcommon.OutputExt(format))
 
 		// Add to timing data
 		timingData.InputPaths = append(timingData.InputPaths, imagePath)
@@ -108,53 +174,54 @@ func main() {
 			Width:         width,
 			Height:        height,
 			ExpectedTiles: expectedTiles,
+			TileSize:      tileSize,
+			EdgeMode:      edgeMode,
+			Format:        format,
+			KernelSize:    imageKernel,
 			LoadTime:      time.Now(),
 			StartTime:     imageStartTime,
 		}
+		if *keepMTime {
+			if mtime, err := sharedcommon.InputModTime(imagePath); err != nil {
+				log.Printf("Not preserving the modification time of %s: %v", imagePath, err)
+			} else {
+				imageInfo.SourceModTime = &mtime
+			}
+		}
 
 		if err := redisQueue.StoreImageInfo(imageInfo); err != nil {
 			log.Printf("Failed to store image info: %v", err)
 			continue
 		}
 
-		// Create tiles and push to queue
-		tileID := 0
-		for y := bounds.Min.Y; y < bounds.Max.Y; y += common.TILE_SIZE {
-			for x := bounds.Min.X; x < bounds.Max.X; x += common.TILE_SIZE {
-				// Calculate tile dimensions
-				tileWidth := common.TILE_SIZE
-				if x+common.TILE_SIZE > bounds.Max.X {
-					tileWidth = bounds.Max.X - x
-				}
-				tileHeight := common.TILE_SIZE
-				if y+common.TILE_SIZE > bounds.Max.Y {
-					tileHeight = bounds.Max.Y - y
-				}
-
-				// Extract tile with padding
-				tile := extractTileWithPadding(img, imageID, tileID, x, y, tileWidth, tileHeight, padding)
-
-				// Push to queue
-				job := &common.JobMessage{
-					Type:      "tile",
-					ImageTile: tile,
-				}
-
-				if err := redisQueue.PushJob(job); err != nil {
-					log.Printf("Failed to push tile job: %v", err)
-				} else {
-					totalTiles++
-				}
-
-				tileID++
-			}
+		if *maxConsec > 0 {
+			sources = append(sources, sharedcommon.TileSource(img, imageID, tileSize, imageKernel, edgeMode, *dither))
+			log.Printf("Loaded image %d (%d tiles), enqueueing after all images load", imageID+1, expectedTiles)
+			continue
+		}
+
+		// Create tiles and push to queue in batches; workers blur each with the kernel
+		// its padding was cut for
+		next := sharedcommon.TileSource(img, imageID, tileSize, imageKernel, edgeMode, *dither)
+		for tile := next(); tile != nil; tile = next() {
+			enqueue(tile)
 		}
+		flush()
+
+		log.Printf("Created %d tiles of %dpx for image %d", expectedTiles, tileSize, imageID+1)
+	}
 
-		log.Printf("Created %d tiles for image %d", expectedTiles, imageID+1)
+	if len(sources) > 0 {
+		log.Printf("Interleaving %d images, at most %d tiles of one image in a row", len(sources), *maxConsec)
+		sharedcommon.InterleaveTiles(sources, *maxConsec, enqueue)
+		flush()
 	}
 
-	// Send done signals for workers
-	for i := 0; i < *numWorkers; i++ {
+	// Send one done signal per registered worker, so scaling the deployment neither
+	// leaves workers waiting nor piles up stale signals for the next run
+	signals := doneSignals(redisQueue, *numWorkers)
+	timingData.Workers = signals
+	for i := 0; i < signals; i++ {
 		job := &common.JobMessage{
 			Type: "complete",
 		}
@@ -194,73 +261,67 @@ func getImagePaths(inputDir string) ([]string, error) {
 	return paths, nil
 }
 
-func loadImage(path string) (*image.RGBA, error) {
+// loadImage decodes an input and returns it with its format, which the output keeps
+func loadImage(path string) (*image.RGBA, string, error) {
 	file, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer file.Close()
 
-	img, _, err := image.Decode(file)
+	img, format, err := image.Decode(file)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	// Convert to RGBA
-	bounds := img.Bounds()
-	rgba := image.NewRGBA(bounds)
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			rgba.Set(x, y, img.At(x, y))
-		}
-	}
-
-	return rgba, nil
+	return blur.ToRGBA(img), format, nil
 }
 
-func extractTileWithPadding(img *image.RGBA, imageID, tileID, tileX, tileY, tileWidth, tileHeight, padding int) *common.ImageTile {
-	bounds := img.Bounds()
-
-	// Calculate padded dimensions
-	startX := tileX - padding
-	startY := tileY - padding
-	endX := tileX + tileWidth + padding
-	endY := tileY + tileHeight + padding
+// workerCounter counts the workers registered by heartbeat; *queue.RedisQueue is one
+type workerCounter interface {
+	LiveWorkers() (int64, error)
+}
 
-	// Clamp to image bounds
-	if startX < bounds.Min.X {
-		startX = bounds.Min.X
-	}
-	if startY < bounds.Min.Y {
-		startY = bounds.Min.Y
-	}
-	if endX > bounds.Max.X {
-		endX = bounds.Max.X
+// doneSignals returns the number of live workers from their heartbeats, falling back
+// to fallback when none are registered (workers that predate heartbeats)
+func doneSignals(workers workerCounter, fallback int) int {
+	live, err := workers.LiveWorkers()
+	if err != nil {
+		log.Printf("Failed to count workers, sending %d done signals: %v", fallback, err)
+		return fallback
 	}
-	if endY > bounds.Max.Y {
-		endY = bounds.Max.Y
+	if live == 0 {
+		log.Printf("No workers registered, sending %d done signals", fallback)
+		return fallback
 	}
+	log.Printf("Sending done signals to %d registered workers", live)
+	return int(live)
+}
 
-	// Create tile data
-	paddedWidth := endX - startX
-	paddedHeight := endY - startY
-	data := make([][]color.RGBA, paddedHeight)
-
-	for y := 0; y < paddedHeight; y++ {
-		data[y] = make([]color.RGBA, paddedWidth)
-		for x := 0; x < paddedWidth; x++ {
-			data[y][x] = img.RGBAAt(startX+x, startY+y)
-		}
+// autoTileTarget is how many tiles -auto-tile aims to cut each image into:
+// AutoTilesPerWorker for each live worker, or for fallback workers when none has
+// registered a heartbeat yet
+func autoTileTarget(workers workerCounter, fallback int) int {
+	live, err := workers.LiveWorkers()
+	if err != nil || live == 0 {
+		live = int64(fallback)
 	}
+	return sharedcommon.AutoTilesPerWorker * int(live)
+}
 
-	return &common.ImageTile{
-		ImageID: imageID,
-		TileID:  tileID,
-		X:       tileX,
-		Y:       tileY,
-		Width:   tileWidth,
-		Height:  tileHeight,
-		Data:    data,
-		Padding: padding,
+// reportTileOverlap logs the padding each image's tiles carry for its kernel and what
+// it costs. Tiles tell the workers their kernel, so the padding always covers it. With
+// -auto-tile the overhead is for MinAutoTileSize tiles, where padding costs most.
+func reportTileOverlap(imagePaths []string, kernels sharedcommon.ImageKernels, kernelSize int, autoTile bool) {
+	tileSize := common.TILE_SIZE
+	if autoTile {
+		tileSize = sharedcommon.MinAutoTileSize
 	}
-}
\ No newline at end of file
+	for _, path := range imagePaths {
+		kernel := kernels.For(path, kernelSize)
+		for _, line := range sharedcommon.CheckTileOverlap(tileSize, kernel, kernel/2).Report() {
+			log.Printf("%s: %s", filepath.Base(path), line)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"go-blur/pkg/queue"
+)
+
+var _ workerCounter = (*queue.RedisQueue)(nil)
+
+// liveWorkers is a workerCounter with a fixed answer
+type liveWorkers struct {
+	n   int64
+	err error
+}
+
+func (w liveWorkers) LiveWorkers() (int64, error) { return w.n, w.err }
+
+func TestDoneSignals(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers liveWorkers
+		want    int
+	}{
+		{"one per registered worker", liveWorkers{n: 3}, 3},
+		{"more registered than -workers", liveWorkers{n: 9}, 9},
+		{"none registered", liveWorkers{}, 4},
+		{"count failed", liveWorkers{err: errors.New("connection refused")}, 4},
+	}
+	for _, tc := range tests {
+		if got := doneSignals(tc.workers, 4); got != tc.want {
+			t.Errorf("%s: %d done signals, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAutoTileTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers liveWorkers
+		want    int
+	}{
+		{"four per live worker", liveWorkers{n: 3}, 12},
+		{"none registered", liveWorkers{}, 16},
+		{"count failed", liveWorkers{err: errors.New("connection refused")}, 16},
+	}
+	for _, tc := range tests {
+		if got := autoTileTarget(tc.workers, 4); got != tc.want {
+			t.Errorf("%s: targeting %d tiles, want %d", tc.name, got, tc.want)
+		}
+	}
+}
@@ -1,33 +1,34 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"go-blur/pkg/common"
 	"go-blur/pkg/queue"
+	sharedcommon "studyguide.parallel/pkg/common"
 )
 
 func main() {
 	var (
 		redisAddr  = flag.String("redis", "redis:6379", "Redis server address")
 		kernelSize = flag.Int("kernel", 15, "Gaussian kernel size")
-		workerID   = flag.String("id", "", "Worker ID (defaults to hostname)")
+		workerID   = flag.String("id", "", "Worker ID (default: worker-<hostname>-<pid>-<random>); must be unique per worker")
 		timeout    = flag.Duration("timeout", 30*time.Second, "Job poll timeout")
 	)
+	redisTLS := sharedcommon.RedisTLSFlags()
 	flag.Parse()
 
-	// Set worker ID
+	// The ID is the worker's heartbeat registration, and the coordinator sends one
+	// done signal per registration, so workers sharing a host must not share an ID
 	if *workerID == "" {
-		hostname, err := os.Hostname()
-		if err != nil {
-			*workerID = fmt.Sprintf("worker-%d", time.Now().Unix())
-		} else {
-			*workerID = hostname
-		}
+		*workerID = sharedcommon.UniqueConsumerName("worker")
 	}
 
 	log.Printf("Worker %s starting...", *workerID)
@@ -35,87 +36,82 @@ func main() {
 	log.Printf("Kernel size: %d", *kernelSize)
 
 	// Connect to Redis
-	redisQueue, err := queue.NewRedisQueue(*redisAddr)
+	tlsConfig, err := redisTLS.Config()
+	if err != nil {
+		log.Fatalf("Invalid Redis TLS flags: %v", err)
+	}
+	redisQueue, err := queue.NewRedisQueue(*redisAddr, tlsConfig)
 	if err != nil {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
 	defer redisQueue.Close()
 
-	// Generate Gaussian kernel once
-	kernel := common.GenerateGaussianKernel(*kernelSize)
-	log.Printf("Generated Gaussian kernel of size %d", *kernelSize)
-
-	tilesProcessed := 0
-
-	// Main worker loop
-	for {
-		// Pop job from queue
-		job, err := redisQueue.PopJob(*timeout)
-		if err != nil {
-			log.Printf("Error popping job: %v", err)
-			continue
-		}
-
-		if job == nil {
-			log.Printf("No job available, waiting...")
-			continue
-		}
-
-		// Check for completion signal
-		if job.Type == "complete" {
-			log.Printf("Received completion signal. Processed %d tiles total.", tilesProcessed)
-			break
-		}
-
-		if job.Type != "tile" || job.ImageTile == nil {
-			log.Printf("Invalid job type or missing tile data")
-			continue
-		}
-
-		tile := job.ImageTile
-		startTime := time.Now()
+	// Register with the coordinator, which sends one done signal per live worker
+	stopHeartbeat := startHeartbeat(redisQueue, *workerID)
+	defer stopHeartbeat()
+
+	// On SIGTERM (a rollout or scale-down) deregister at once, since the read in hand
+	// can block for -timeout, then stop after the current tile. A second signal kills.
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	go func() {
+		<-ctx.Done()
+		stopSignals()
+		stopHeartbeat()
+	}()
+
+	// Shared worker loop: runs until the coordinator's completion signal
+	processed := 0
+	tilesProcessed := sharedcommon.RunWorker(ctx, redisQueue, *kernelSize, *workerID, *timeout,
+		func(tile *common.ProcessedImageTile) {
+			// Update progress
+			if _, err := redisQueue.IncrementProgress(tile.ImageID); err != nil {
+				log.Printf("Failed to update progress: %v", err)
+			}
+
+			processed++
+			if processed%10 == 0 {
+				log.Printf("Worker %s: Processed %d tiles so far...", *workerID, processed)
+			}
+		})
 
-		// Apply blur to tile
-		blurredData := common.ApplyBlurToTile(tile.Data, kernel)
-
-		// Extract center portion (remove padding)
-		centerData := common.ExtractCenter(blurredData, tile.Padding, tile.Width, tile.Height)
-
-		// Create processed tile
-		processedTile := &common.ProcessedImageTile{
-			ImageID: tile.ImageID,
-			TileID:  tile.TileID,
-			X:       tile.X,
-			Y:       tile.Y,
-			Width:   tile.Width,
-			Height:  tile.Height,
-			Data:    centerData,
-		}
-
-		// Push result back to queue
-		result := &common.ResultMessage{
-			ProcessedTile: processedTile,
-			WorkerID:      *workerID,
-			ProcessTime:   time.Since(startTime).Seconds(),
-		}
-
-		if err := redisQueue.PushResult(result); err != nil {
-			log.Printf("Failed to push result: %v", err)
-			continue
-		}
-
-		// Update progress
-		_, err = redisQueue.IncrementProgress(tile.ImageID)
-		if err != nil {
-			log.Printf("Failed to update progress: %v", err)
-		}
+	log.Printf("Worker %s shutting down. Processed %d tiles total.", *workerID, tilesProcessed)
+}
+
+// startHeartbeat registers workerID now and every queue.HeartbeatInterval until the
+// returned func is called, which also deregisters it. The func is safe to call more
+// than once.
+func startHeartbeat(redisQueue *queue.RedisQueue, workerID string) func() {
+	if err := redisQueue.Heartbeat(workerID); err != nil {
+		log.Printf("Failed to register worker: %v", err)
+	}
 
-		tilesProcessed++
-		
-		if tilesProcessed%10 == 0 {
-			log.Printf("Worker %s: Processed %d tiles so far...", *workerID, tilesProcessed)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(queue.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := redisQueue.Heartbeat(workerID); err != nil {
+					log.Printf("Failed to send heartbeat: %v", err)
+				}
+			}
 		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			<-done
+			if err := redisQueue.Deregister(workerID); err != nil {
+				log.Printf("Failed to deregister worker: %v", err)
+			}
+		})
 	}
-
-	log.Printf("Worker %s shutting down. Processed %d tiles total.", *workerID, tilesProcessed)
-}
\ No newline at end of file
+}
@@ -2,12 +2,14 @@ package queue
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"go-blur/pkg/common"
+	sharedcommon "studyguide.parallel/pkg/common"
 )
 
 const (
@@ -16,6 +18,14 @@ const (
 	ImageInfoKey   = "image:info:%d"
 	ProgressKey    = "image:progress:%d"
 	TimingDataKey  = "timing:data"
+	WorkersKey     = "workers:heartbeat"
+)
+
+const (
+	// HeartbeatInterval is how often a worker refreshes its registration
+	HeartbeatInterval = 5 * time.Second
+	// WorkerTTL is how long a registration counts as live without a heartbeat
+	WorkerTTL = 3 * HeartbeatInterval
 )
 
 type RedisQueue struct {
@@ -23,11 +33,16 @@ type RedisQueue struct {
 	ctx    context.Context
 }
 
-func NewRedisQueue(addr string) (*RedisQueue, error) {
+// RedisQueue feeds the shared worker loop in pkg/common
+var _ sharedcommon.Queue = (*RedisQueue)(nil)
+
+// NewRedisQueue connects to the Redis at addr, over TLS when tlsConfig is non-nil
+func NewRedisQueue(addr string, tlsConfig *tls.Config) (*RedisQueue, error) {
 	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: "",
-		DB:       0,
+		Addr:      addr,
+		Password:  "",
+		DB:        0,
+		TLSConfig: tlsConfig,
 	})
 
 	ctx := context.Background()
@@ -53,6 +68,26 @@ func (q *RedisQueue) PushJob(job *common.JobMessage) error {
 	return q.client.LPush(q.ctx, JobQueueKey, data).Err()
 }
 
+// PushJobs adds a batch of jobs with a single LPUSH, so enqueuing a large image
+// costs one round-trip per batch instead of one per tile. Jobs are popped in
+// slice order, the same as calling PushJob for each.
+func (q *RedisQueue) PushJobs(jobs []*common.JobMessage) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	values := make([]interface{}, 0, len(jobs))
+	for _, job := range jobs {
+		data, err := json.Marshal(job)
+		if err != nil {
+			return fmt.Errorf("failed to marshal job: %w", err)
+		}
+		values = append(values, data)
+	}
+
+	return q.client.LPush(q.ctx, JobQueueKey, values...).Err()
+}
+
 // PopJob retrieves and removes a job from the queue (blocking)
 func (q *RedisQueue) PopJob(timeout time.Duration) (*common.JobMessage, error) {
 	result, err := q.client.BRPop(q.ctx, timeout, JobQueueKey).Result()
@@ -75,6 +110,23 @@ func (q *RedisQueue) PopJob(timeout time.Duration) (*common.JobMessage, error) {
 	return &job, nil
 }
 
+// ReadJob pops a job so RedisQueue satisfies common.Queue. A list pop removes the job
+// outright, so the returned ID is always empty and there is nothing to ack.
+func (q *RedisQueue) ReadJob(consumer string, block time.Duration) (string, *common.JobMessage, error) {
+	job, err := q.PopJob(block)
+	return "", job, err
+}
+
+// AckJob is a no-op: jobs popped from a list cannot be redelivered
+func (q *RedisQueue) AckJob(id string) error {
+	return nil
+}
+
+// AddResult pushes a result so RedisQueue satisfies common.Queue
+func (q *RedisQueue) AddResult(result *common.ResultMessage) (string, error) {
+	return "", q.PushResult(result)
+}
+
 // PushResult adds a processed result to the result queue
 func (q *RedisQueue) PushResult(result *common.ResultMessage) error {
 	data, err := json.Marshal(result)
@@ -202,6 +254,30 @@ func (q *RedisQueue) UpdateImageEndTime(imageID int, endTime time.Time) error {
 	return q.StoreTiming(timing)
 }
 
+// Heartbeat registers workerID as live. Workers are kept in a sorted set scored by
+// their last heartbeat, so a crashed worker drops out once WorkerTTL passes.
+func (q *RedisQueue) Heartbeat(workerID string) error {
+	return q.client.ZAdd(q.ctx, WorkersKey, redis.Z{
+		Score:  float64(time.Now().Unix()),
+		Member: workerID,
+	}).Err()
+}
+
+// Deregister removes workerID from the live workers
+func (q *RedisQueue) Deregister(workerID string) error {
+	return q.client.ZRem(q.ctx, WorkersKey, workerID).Err()
+}
+
+// LiveWorkers counts the workers that have sent a heartbeat within WorkerTTL, pruning
+// the ones that have not
+func (q *RedisQueue) LiveWorkers() (int64, error) {
+	cutoff := time.Now().Add(-WorkerTTL).Unix()
+	if err := q.client.ZRemRangeByScore(q.ctx, WorkersKey, "-inf", fmt.Sprintf("(%d", cutoff)).Err(); err != nil {
+		return 0, fmt.Errorf("failed to prune workers: %w", err)
+	}
+	return q.client.ZCard(q.ctx, WorkersKey).Result()
+}
+
 // Close closes the Redis connection
 func (q *RedisQueue) Close() error {
 	return q.client.Close()
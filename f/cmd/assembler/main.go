@@ -4,34 +4,79 @@ import (
     "flag"
     "fmt"
     "image"
-    "image/png"
     "log"
     "os"
     "path/filepath"
     "time"
 
     "studyguide.parallel/pkg/common"
+    "studyguide.parallel/pkg/verify"
     ftqqueue "go-blur-ftq/pkg/queue"
 )
 
 type ImageAssembler struct {
     info   *common.ImageInfo
     img    *image.RGBA
+    tiles  []*common.ProcessedImageTile // buffered tiles with -ordered
 }
 
 func main() {
     var (
-        redisAddr = flag.String("redis", "redis:6379", "Redis address")
-        timeout   = flag.Duration("timeout", 5*time.Second, "Stream read block timeout")
+        redisAddr  = flag.String("redis", "redis:6379", "Redis address")
+        timeout    = flag.Duration("timeout", 5*time.Second, "Stream read block timeout")
+        pngComp    = flag.String("png-compression", "default", "PNG compression level: default, none, speed or best")
+        jpegQual   = flag.Int("jpeg-quality", common.DefaultJPEGQuality, "Quality (1-100) of the JPEG outputs written for JPEG inputs")
+        group      = flag.String("group", ftqqueue.DefaultResultGroup, "Result consumer group; each group receives every result and takes exactly one assembler")
+        output     = flag.String("output", "file", "Where finished images go: file (each image's output path) or redis (image:<id>:output, for setups without shared disk)")
+        outputTTL  = flag.Duration("output-ttl", ftqqueue.DefaultOutputTTL, "With -output=redis, how long stored images are kept")
+        outputMax  = flag.Int("output-max-bytes", ftqqueue.DefaultMaxOutputBytes, "With -output=redis, largest encoded image stored; bigger ones are logged and skipped")
+        ordered    = flag.Bool("ordered", false, "Buffer every tile of an image and place them in tile-ID order before saving (deterministic output for testing)")
+        seamless   = flag.Bool("assert-seamless", false, "After saving each image, check its tile boundaries for seams and log an error if one exceeds -seam-threshold")
+        seamThresh = flag.Float64("seam-threshold", verify.DefaultSeamThreshold, "Seam magnitude (excess 8-bit luma step) that fails -assert-seamless")
+        seamFatal  = flag.Bool("seam-fatal", false, "With -assert-seamless, exit non-zero on the first seamed image instead of only logging it")
     )
+    redisTLS := common.RedisTLSFlags()
     flag.Parse()
 
-    rs, err := ftqqueue.NewRedisStreams(*redisAddr)
+    pngLevel, err := common.ParsePNGCompression(*pngComp)
+    if err != nil { log.Fatalf("png-compression: %v", err) }
+
+    tlsConfig, err := redisTLS.Config()
+    if err != nil { log.Fatalf("redis tls: %v", err) }
+    rs, err := ftqqueue.NewRedisStreams(*redisAddr, tlsConfig)
     if err != nil { log.Fatalf("redis: %v", err) }
     defer rs.Close()
+    rs.SetResultGroup(*group)
     if err := rs.EnsureGroups(); err != nil { log.Printf("ensure groups: %v", err) }
 
-    log.Printf("Assembler ready - waiting for results on fixed streams...")
+    // Tiles are only held in this process, so a second assembler in the group would
+    // save images missing this one's tiles. A restarted assembler waits out the lock
+    // of the process it replaces.
+    owner := common.UniqueConsumerName("assembler")
+    lockGroup(rs, owner, *group)
+    defer rs.UnlockResultGroup(owner)
+    go func() {
+        ticker := time.NewTicker(ftqqueue.AssemblerLockTTL / 3)
+        defer ticker.Stop()
+        for range ticker.C {
+            held, err := rs.RefreshResultGroup(owner)
+            if err != nil { log.Printf("refresh group lock: %v", err); continue }
+            if !held { log.Fatalf("lost the lock on result group %s to another assembler", *group) }
+        }
+    }()
+
+    var sink common.OutputSink
+    switch *output {
+    case "file":
+        sink = common.FileSink{Level: pngLevel, JPEGQuality: *jpegQual}
+    case "redis":
+        sink = ftqqueue.NewRedisSink(rs, pngLevel, *jpegQual, *outputTTL, *outputMax)
+        log.Printf("Storing outputs in Redis (ttl %s, cap %d bytes)", *outputTTL, *outputMax)
+    default:
+        log.Fatalf("unknown -output %q (use file or redis)", *output)
+    }
+
+    log.Printf("Assembler ready - waiting for results on fixed streams (group %s)...", *group)
 
     assemblers := map[int]*ImageAssembler{}
     consumer := "assembler"
@@ -48,6 +93,11 @@ func main() {
         }
 
         tile := res.ProcessedTile
+        if err := common.ValidateTileData(tile); err != nil {
+            // Placing it would leave unwritten pixels; keep it pending for inspection
+            log.Printf("invalid result from worker %s: %v; left unacked", res.WorkerID, err)
+            continue
+        }
         asm := assemblers[tile.ImageID]
         if asm == nil {
             info, err := rs.GetImageInfo(tile.ImageID)
@@ -68,11 +118,11 @@ func main() {
         // Persist each tile to disk (durable) before ack
         if err := persistTile("ftq", asm.info, tile); err != nil { log.Printf("persist: %v", err); continue }
 
-        // Apply into image buffer
-        for y := 0; y < tile.Height && y < len(tile.Data); y++ {
-            for x := 0; x < tile.Width && x < len(tile.Data[y]); x++ {
-                asm.img.SetRGBA(tile.X+x, tile.Y+y, tile.Data[y][x])
-            }
+        // Apply into image buffer, or hold it until the image is complete with -ordered
+        if *ordered {
+            asm.tiles = append(asm.tiles, tile)
+        } else {
+            common.PlaceTile(asm.img, tile)
         }
 
         // Ack only after durable write and in-memory apply
@@ -81,16 +131,49 @@ func main() {
         // Check completion
         count, _ := rs.GetReceivedCount(tile.ImageID)
         if int(count) >= asm.info.ExpectedTiles {
-            if err := saveImage(asm.img, asm.info.OutputPath); err != nil {
+            if *ordered {
+                common.PlaceOrdered(asm.img, asm.tiles)
+            }
+            if where, err := sink.Save(asm.info, asm.img); err != nil {
                 log.Printf("save image: %v", err)
             } else {
-                log.Printf("Saved image %d to %s", tile.ImageID+1, asm.info.OutputPath)
+                log.Printf("Saved image %d to %s", tile.ImageID+1, where)
+                if *seamless { assertSeamless(asm, *seamThresh, *seamFatal) }
             }
             delete(assemblers, tile.ImageID)
         }
     }
 }
 
+// lockGroup takes the result group for owner, retrying for up to AssemblerLockTTL so
+// an assembler replacing one that just exited can take over. It exits if another
+// assembler still holds the group.
+func lockGroup(rs *ftqqueue.RedisStreams, owner, group string) {
+    deadline := time.Now().Add(ftqqueue.AssemblerLockTTL + time.Second)
+    for {
+        held, err := rs.LockResultGroup(owner)
+        if err != nil { log.Fatalf("lock result group: %v", err) }
+        if held { return }
+        if time.Now().After(deadline) {
+            log.Fatalf("another assembler is reading result group %s; run one assembler per group and give independent deployments their own -group", group)
+        }
+        log.Printf("Result group %s is held by another assembler; waiting for its lock to expire", group)
+        time.Sleep(time.Second)
+    }
+}
+
+// assertSeamless logs an error for an assembled image with a seam at a tile boundary,
+// exiting when fatal is set
+func assertSeamless(asm *ImageAssembler, threshold float64, fatal bool) {
+    err := common.CheckSeamless(asm.img, asm.info, threshold)
+    if err == nil {
+        log.Printf("Image %d: no tile seams above %.2f", asm.info.ID+1, threshold)
+        return
+    }
+    if fatal { log.Fatalf("Image %d failed -assert-seamless: %v", asm.info.ID+1, err) }
+    log.Printf("ERROR: image %d failed -assert-seamless: %v", asm.info.ID+1, err)
+}
+
 func persistTile(runID string, info *common.ImageInfo, tile *common.ProcessedImageTile) error {
     base := filepath.Join("/data/run", runID, fmt.Sprintf("img_%d", info.ID), "tiles")
     if err := os.MkdirAll(base, 0755); err != nil { return err }
@@ -99,11 +182,4 @@ func persistTile(runID string, info *common.ImageInfo, tile *common.ProcessedIma
     return os.WriteFile(f, []byte("ok"), 0644)
 }
 
-func saveImage(img *image.RGBA, outputPath string) error {
-    if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil { return err }
-    f, err := os.Create(outputPath)
-    if err != nil { return err }
-    defer f.Close()
-    return png.Encode(f, img)
-}
 
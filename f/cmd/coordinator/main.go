@@ -2,9 +2,7 @@ package main
 
 import (
     "flag"
-    "fmt"
     "image"
-    "image/color"
     _ "image/jpeg"
     _ "image/png"
     "log"
@@ -13,6 +11,7 @@ import (
     "strings"
     "time"
 
+    "studyguide.parallel/pkg/blur"
     "studyguide.parallel/pkg/common"
     ftqqueue "go-blur-ftq/pkg/queue"
 )
@@ -23,19 +22,74 @@ func main() {
         outputPath = flag.String("output", "/data/e_output", "Output directory path")
         kernelSize = flag.Int("kernel", 15, "Gaussian kernel size")
         redisAddr  = flag.String("redis", "redis:6379", "Redis server address")
+        batchSize  = flag.Int("enqueue-batch", 64, "Number of tile jobs sent to Redis per round-trip")
+        dither     = flag.Bool("dither", false, "Ordered-dither tile output (matches the sequential -dither output exactly)")
+        edgeName   = flag.String("edge-mode", "clamp", "How border tiles are padded past the image edge: clamp, reflect or wrap")
+        autoTile   = flag.Bool("auto-tile", false, "Size tiles per image to aim for about 4 tiles per live worker (or per -workers if none has registered) instead of a fixed 256px")
+        numWorkers = flag.Int("workers", 4, "Worker count -auto-tile sizes tiles for when no worker has sent a heartbeat")
+        overlapRep = flag.Bool("tile-overlap-report", false, "Preflight: report each image's tile padding and its overhead for the kernel it is blurred with (-kernel or -kernel-manifest)")
+        keepMTime  = flag.Bool("preserve-mtime", false, "Have the assembler give each output its input's modification time (file sink only)")
+        inputSort  = flag.String("input-sort", "", "Enqueue images in this order: name, size or mtime, with :desc to reverse (default: directory order)")
+        checkLive  = flag.Bool("check-workers", true, "Warn when no worker has sent a heartbeat recently, before and while enqueueing (jobs would sit unconsumed)")
+        kernelList = flag.String("kernel-manifest", "", "File of \"name kernel\" lines giving listed images their own kernel size (others use -kernel)")
+        affinity   = flag.Int("affinity", 0, "Split jobs over this many streams by image ID so all tiles of an image go to one shard's workers (0 = one shared stream); start workers with the same -affinity and a -shard each")
+        recordPath = flag.String("record", "", "Also write every image info, job and the timing data to this JSONL file, for cmd/replay to re-enqueue")
+        maxConsec  = flag.Int("max-consecutive-tiles", 0, "Interleave images on the stream, at most this many tiles of one image in a row (0 enqueues image by image; holds every decoded image in memory)")
     )
+    redisTLS := common.RedisTLSFlags()
     flag.Parse()
 
+    edgeMode, err := blur.ParseEdgeMode(*edgeName)
+    if err != nil { log.Fatalf("edge mode: %v", err) }
+
     log.Printf("FTQ Coordinator starting...")
+    var kernels common.ImageKernels
+    if *kernelList != "" {
+        if kernels, err = common.ReadImageKernels(*kernelList); err != nil { log.Fatalf("kernel manifest: %v", err) }
+        log.Printf("Kernel manifest: %d images with their own kernel size", len(kernels))
+    }
 
-    rs, err := ftqqueue.NewRedisStreams(*redisAddr)
+    tlsConfig, err := redisTLS.Config()
+    if err != nil { log.Fatalf("redis tls: %v", err) }
+    rs, err := ftqqueue.NewRedisStreams(*redisAddr, tlsConfig)
     if err != nil { log.Fatalf("redis: %v", err) }
     defer rs.Close()
+    if *affinity > 0 {
+        rs.SetJobShards(*affinity, -1)
+        log.Printf("Tile affinity: jobs split over %d streams by image ID", *affinity)
+    }
     if err := rs.EnsureGroups(); err != nil { log.Printf("ensure groups: %v", err) }
 
+    // Re-checked at most once per heartbeat interval while images are enqueued
+    var lastCheck time.Time
+    checkWorkers := func() {
+        if !*checkLive || time.Since(lastCheck) < ftqqueue.HeartbeatInterval { return }
+        lastCheck = time.Now()
+        warnIfNoWorkers(rs)
+    }
+    checkWorkers()
+
     paths, err := getImagePaths(*inputPath)
     if err != nil { log.Fatalf("images: %v", err) }
+    if err := common.SortInputs(paths, *inputSort); err != nil { log.Fatalf("input sort: %v", err) }
     if len(paths) == 0 { log.Printf("no images found"); return }
+    targetTiles := 0
+    if *autoTile {
+        targetTiles = autoTileTarget(rs, *numWorkers)
+        log.Printf("Auto tile size: targeting %d tiles per image", targetTiles)
+    }
+    if *overlapRep { reportTileOverlap(paths, kernels, *kernelSize, *autoTile) }
+
+    // With -record, each entry is logged once Redis has accepted it
+    var recorder *ftqqueue.JobRecorder
+    if *recordPath != "" {
+        if recorder, err = ftqqueue.NewJobRecorder(*recordPath); err != nil { log.Fatalf("record: %v", err) }
+        log.Printf("Recording jobs to %s", *recordPath)
+    }
+    record := func(e ftqqueue.JobLogEntry) {
+        if recorder == nil { return }
+        if err := recorder.Record(e); err != nil { log.Printf("record: %v", err) }
+    }
 
     start := time.Now()
     timing := &common.TimingData{
@@ -46,47 +100,86 @@ func main() {
         OutputPaths:    make([]string, 0, len(paths)),
         ImageStartTimes: map[int]time.Time{},
         ImageEndTimes:   map[int]*time.Time{},
+        TileSize:        common.TILE_SIZE,
     }
-
-    padding := *kernelSize / 2
+    if *autoTile { timing.TileSize = 0 } // each image has its own, in its ImageInfo
+
+    // enqueue tiles in pipelined batches
+    batch := make([]*common.JobMessage, 0, *batchSize)
+    flush := func() {
+        if len(batch) == 0 { return }
+        if _, err := rs.AddJobs(batch); err != nil {
+            log.Printf("add jobs: %v", err)
+        } else {
+            for _, job := range batch { record(ftqqueue.JobLogEntry{Job: job}) }
+        }
+        batch = batch[:0]
+    }
+    enqueue := func(tile *common.ImageTile) {
+        batch = append(batch, &common.JobMessage{Type: "tile", ImageTile: tile})
+        if len(batch) >= *batchSize { flush() }
+    }
+    // with -max-consecutive-tiles, tiles are enqueued round-robin once every image is loaded
+    var sources []func() *common.ImageTile
     for imageID, p := range paths {
-        img, err := loadImage(p)
+        img, format, err := loadImage(p)
         if err != nil { log.Printf("load %s: %v", p, err); continue }
+        k := kernels.For(p, *kernelSize)
         b := img.Bounds()
-        tilesX := (b.Dx() + common.TILE_SIZE - 1) / common.TILE_SIZE
-        tilesY := (b.Dy() + common.TILE_SIZE - 1) / common.TILE_SIZE
-        expected := tilesX * tilesY
+        tileSize := common.AutoTileSize(b.Dx(), b.Dy(), targetTiles) // TILE_SIZE without -auto-tile
+        expected := common.TileCount(b.Dx(), b.Dy(), tileSize)
 
         base := filepath.Base(p)
         name := strings.TrimSuffix(base, filepath.Ext(base))
-        out := filepath.Join(*outputPath, fmt.Sprintf("%s_blurred.png", name))
+        out := filepath.Join(*outputPath, name+"_blurred"+common.OutputExt(format))
 
         timing.InputPaths = append(timing.InputPaths, p)
         timing.OutputPaths = append(timing.OutputPaths, out)
         timing.ImageStartTimes[imageID] = time.Now()
 
-        info := &common.ImageInfo{ID: imageID, InputPath: p, OutputPath: out, Width: b.Dx(), Height: b.Dy(), ExpectedTiles: expected, LoadTime: time.Now(), StartTime: time.Now()}
-        if err := rs.StoreImageInfo(info); err != nil { log.Printf("store image info: %v", err) }
-
-        // enqueue tiles
-        tileID := 0
-        for y := b.Min.Y; y < b.Max.Y; y += common.TILE_SIZE {
-            for x := b.Min.X; x < b.Max.X; x += common.TILE_SIZE {
-                tw := min(common.TILE_SIZE, b.Max.X-x)
-                th := min(common.TILE_SIZE, b.Max.Y-y)
-                tile := extractTileWithPadding(img, imageID, tileID, x, y, tw, th, padding)
-                job := &common.JobMessage{Type: "tile", ImageTile: tile}
-                if _, err := rs.AddJob(job); err != nil { log.Printf("add job: %v", err) }
-                tileID++
-            }
+        info := &common.ImageInfo{ID: imageID, InputPath: p, OutputPath: out, Width: b.Dx(), Height: b.Dy(), ExpectedTiles: expected, TileSize: tileSize, EdgeMode: edgeMode, Format: format, KernelSize: k, LoadTime: time.Now(), StartTime: time.Now()}
+        if *keepMTime {
+            if mtime, err := common.InputModTime(p); err != nil { log.Printf("preserve mtime %s: %v", p, err) } else { info.SourceModTime = &mtime }
+        }
+        if err := rs.StoreImageInfo(info); err != nil { log.Printf("store image info: %v", err) } else { record(ftqqueue.JobLogEntry{ImageInfo: info}) }
+
+        if *maxConsec > 0 {
+            sources = append(sources, common.TileSource(img, imageID, tileSize, k, edgeMode, *dither))
+            log.Printf("Loaded image %d (%d tiles)", imageID+1, expected)
+            continue
         }
-        log.Printf("Enqueued %d tiles for image %d", expected, imageID+1)
+
+        next := common.TileSource(img, imageID, tileSize, k, edgeMode, *dither)
+        for tile := next(); tile != nil; tile = next() { enqueue(tile) }
+        flush()
+        log.Printf("Enqueued %d tiles of %dpx for image %d", expected, tileSize, imageID+1)
+        checkWorkers()
+    }
+    if len(sources) > 0 {
+        common.InterleaveTiles(sources, *maxConsec, enqueue)
+        flush()
+        log.Printf("Enqueued %d images interleaved, at most %d tiles of one image in a row", len(sources), *maxConsec)
     }
 
-    if err := rs.StoreTiming(timing); err != nil { log.Printf("store timing: %v", err) }
+    if err := rs.StoreTiming(timing); err != nil { log.Printf("store timing: %v", err) } else { record(ftqqueue.JobLogEntry{Timing: timing}) }
+    if recorder != nil {
+        if err := recorder.Close(); err != nil { log.Printf("record: %v", err) }
+    }
     log.Printf("Coordinator finished")
 }
 
+// warnIfNoWorkers logs a warning when no worker heartbeat is live, the sign that jobs
+// are being enqueued for workers that have already exited
+func warnIfNoWorkers(rs *ftqqueue.RedisStreams) {
+    live, err := rs.LiveWorkers()
+    if err != nil { log.Printf("count workers: %v", err); return }
+    if live == 0 {
+        log.Printf("WARNING: no live workers (no heartbeat in %s); enqueued jobs will wait in ftq:jobs until a worker starts", ftqqueue.WorkerTTL)
+        return
+    }
+    log.Printf("%d live workers", live)
+}
+
 func getImagePaths(dir string) ([]string, error) {
     entries, err := os.ReadDir(dir)
     if err != nil { return nil, err }
@@ -99,35 +192,42 @@ func getImagePaths(dir string) ([]string, error) {
     return paths, nil
 }
 
-func loadImage(path string) (*image.RGBA, error) {
+// loadImage decodes an input and returns it with its format, which the output keeps
+func loadImage(path string) (*image.RGBA, string, error) {
     f, err := os.Open(path)
-    if err != nil { return nil, err }
+    if err != nil { return nil, "", err }
     defer f.Close()
-    im, _, err := image.Decode(f)
-    if err != nil { return nil, err }
-    b := im.Bounds()
-    rgba := image.NewRGBA(b)
-    for y := b.Min.Y; y < b.Max.Y; y++ {
-        for x := b.Min.X; x < b.Max.X; x++ { rgba.Set(x, y, im.At(x, y)) }
-    }
-    return rgba, nil
+    im, format, err := image.Decode(f)
+    if err != nil { return nil, "", err }
+    return blur.ToRGBA(im), format, nil
+}
+
+// workerCounter counts the workers registered by heartbeat; *ftqqueue.RedisStreams is one
+type workerCounter interface {
+    LiveWorkers() (int64, error)
 }
 
-func extractTileWithPadding(img *image.RGBA, imageID, tileID, tileX, tileY, tileW, tileH, padding int) *common.ImageTile {
-    b := img.Bounds()
-    sx := max(tileX-padding, b.Min.X)
-    sy := max(tileY-padding, b.Min.Y)
-    ex := min(tileX+tileW+padding, b.Max.X)
-    ey := min(tileY+tileH+padding, b.Max.Y)
-    pw := ex - sx
-    ph := ey - sy
-    pdata := make([][]color.RGBA, ph)
-    for y := 0; y < ph; y++ {
-        row := make([]color.RGBA, pw)
-        for x := 0; x < pw; x++ { row[x] = img.RGBAAt(sx+x, sy+y) }
-        pdata[y] = row
+// autoTileTarget is how many tiles -auto-tile aims to cut each image into:
+// AutoTilesPerWorker for each live worker, or for fallback workers when none has
+// registered a heartbeat yet
+func autoTileTarget(workers workerCounter, fallback int) int {
+    live, err := workers.LiveWorkers()
+    if err != nil || live == 0 { live = int64(fallback) }
+    return common.AutoTilesPerWorker * int(live)
+}
+
+// reportTileOverlap logs the padding each image's tiles carry for its kernel and what
+// it costs. Tiles tell the workers their kernel, so the padding always covers it. With
+// -auto-tile the overhead is for MinAutoTileSize tiles, where padding costs most.
+func reportTileOverlap(paths []string, kernels common.ImageKernels, kernelSize int, autoTile bool) {
+    tileSize := common.TILE_SIZE
+    if autoTile { tileSize = common.MinAutoTileSize }
+    for _, p := range paths {
+        k := kernels.For(p, kernelSize)
+        for _, line := range common.CheckTileOverlap(tileSize, k, k/2).Report() {
+            log.Printf("%s: %s", filepath.Base(p), line)
+        }
     }
-    return &common.ImageTile{ImageID: imageID, TileID: tileID, X: tileX, Y: tileY, Width: tileW, Height: tileH, Data: pdata, Padding: padding}
 }
 
 
@@ -0,0 +1,115 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "image"
+    "image/jpeg"
+    "image/png"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "github.com/alicebob/miniredis/v2"
+    ftqqueue "go-blur-ftq/pkg/queue"
+    "studyguide.parallel/pkg/common"
+)
+
+// captureLog returns what fn logs
+func captureLog(fn func()) string {
+    var buf bytes.Buffer
+    prev := log.Writer()
+    log.SetOutput(&buf)
+    defer log.SetOutput(prev)
+    fn()
+    return buf.String()
+}
+
+func TestWarnIfNoWorkers(t *testing.T) {
+    server := miniredis.RunT(t)
+    rs, err := ftqqueue.NewRedisStreams(server.Addr(), nil)
+    if err != nil { t.Fatal(err) }
+    defer rs.Close()
+
+    if out := captureLog(func() { warnIfNoWorkers(rs) }); !strings.Contains(out, "WARNING: no live workers") {
+        t.Errorf("with no worker registered the coordinator logged %q, want the warning", out)
+    }
+    if err := rs.Heartbeat("worker-1"); err != nil { t.Fatal(err) }
+    out := captureLog(func() { warnIfNoWorkers(rs) })
+    if strings.Contains(out, "WARNING") || !strings.Contains(out, "1 live workers") {
+        t.Errorf("with a live worker the coordinator logged %q, want its count and no warning", out)
+    }
+    if err := rs.Deregister("worker-1"); err != nil { t.Fatal(err) }
+    if out := captureLog(func() { warnIfNoWorkers(rs) }); !strings.Contains(out, "WARNING") {
+        t.Errorf("after the worker exited the coordinator logged %q, want the warning again", out)
+    }
+}
+
+func TestLoadImageKeepsFormat(t *testing.T) {
+    dir := t.TempDir()
+    img := image.NewRGBA(image.Rect(0, 0, 8, 6))
+    encoders := map[string]func(*os.File) error{
+        "jpeg": func(f *os.File) error { return jpeg.Encode(f, img, nil) },
+        "png":  func(f *os.File) error { return png.Encode(f, img) },
+    }
+    for format, encode := range encoders {
+        path := filepath.Join(dir, "in."+format)
+        file, err := os.Create(path)
+        if err != nil { t.Fatal(err) }
+        if err := encode(file); err != nil { t.Fatal(err) }
+        file.Close()
+
+        got, gotFormat, err := loadImage(path)
+        if err != nil { t.Fatal(err) }
+        if gotFormat != format || got.Bounds() != img.Bounds() { t.Errorf("%s input loaded as %s %v", format, gotFormat, got.Bounds()) }
+        if ext := common.OutputExt(gotFormat); ext != "."+format { t.Errorf("%s input gets a %s output name", format, ext) }
+    }
+}
+
+func TestReportTileOverlap(t *testing.T) {
+    paths := []string{"/in/small.png", "/in/large.png"}
+    kernels := common.ImageKernels{"large.png": 31}
+    out := captureLog(func() { reportTileOverlap(paths, kernels, 15, false) })
+    if strings.Contains(out, "WARNING") {
+        t.Errorf("logged %q, want no seam warning: tiles carry their own kernel's padding", out)
+    }
+    for _, want := range []string{"small.png: Tile overlap: 256px tiles, 7px padding, kernel 15", "large.png: Tile overlap: 256px tiles, 15px padding, kernel 31"} {
+        if !strings.Contains(out, want) { t.Errorf("logged %q, want a line with %q", out, want) }
+    }
+}
+
+func TestReportTileOverlapAutoTile(t *testing.T) {
+    out := captureLog(func() { reportTileOverlap([]string{"/in/a.png"}, nil, 15, true) })
+    if want := "a.png: Tile overlap: 64px tiles, 7px padding"; !strings.Contains(out, want) {
+        t.Errorf("logged %q, want the smallest auto tile size (%q)", out, want)
+    }
+}
+
+var _ workerCounter = (*ftqqueue.RedisStreams)(nil)
+
+// liveWorkers is a workerCounter with a fixed answer
+type liveWorkers struct {
+    n   int64
+    err error
+}
+
+func (w liveWorkers) LiveWorkers() (int64, error) { return w.n, w.err }
+
+func TestAutoTileTarget(t *testing.T) {
+    tests := []struct {
+        name    string
+        workers liveWorkers
+        want    int
+    }{
+        {"four per live worker", liveWorkers{n: 3}, 12},
+        {"none registered", liveWorkers{}, 8},
+        {"count failed", liveWorkers{err: errors.New("connection refused")}, 8},
+    }
+    for _, tc := range tests {
+        if got := autoTileTarget(tc.workers, 2); got != tc.want {
+            t.Errorf("%s: targeting %d tiles, want %d", tc.name, got, tc.want)
+        }
+    }
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "image"
+    "image/png"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "studyguide.parallel/pkg/common"
+    ftqqueue "go-blur-ftq/pkg/queue"
+)
+
+// reconstruct rebuilds output images from tiles that workers stored with -store-tiles,
+// independently of when (or whether) an assembler consumed the results stream.
+func main() {
+    var (
+        redisAddr    = flag.String("redis", "redis:6379", "Redis address")
+        imageID      = flag.Int("image", -1, "Image ID to reconstruct (-1 = every image in the run's timing data)")
+        outputPath   = flag.String("output", "", "Override output path (single image only; default is the coordinator's path)")
+        allowPartial = flag.Bool("allow-partial", false, "Write images even if some tiles are missing")
+        pngComp      = flag.String("png-compression", "default", "PNG compression level: default, none, speed or best")
+    )
+    redisTLS := common.RedisTLSFlags()
+    flag.Parse()
+
+    pngLevel, err := common.ParsePNGCompression(*pngComp)
+    if err != nil { log.Fatalf("png-compression: %v", err) }
+
+    tlsConfig, err := redisTLS.Config()
+    if err != nil { log.Fatalf("redis tls: %v", err) }
+    rs, err := ftqqueue.NewRedisStreams(*redisAddr, tlsConfig)
+    if err != nil { log.Fatalf("redis: %v", err) }
+    defer rs.Close()
+
+    ids := []int{*imageID}
+    if *imageID < 0 {
+        if *outputPath != "" { log.Fatalf("-output requires -image") }
+        timing, err := rs.GetTiming()
+        if err != nil { log.Fatalf("timing: %v", err) }
+        ids = ids[:0]
+        for i := 0; i < timing.TotalImages; i++ { ids = append(ids, i) }
+    }
+
+    failed := 0
+    for _, id := range ids {
+        if err := reconstructImage(rs, id, *outputPath, *allowPartial, pngLevel); err != nil {
+            log.Printf("image %d: %v", id+1, err)
+            failed++
+        }
+    }
+    if failed > 0 { log.Fatalf("%d of %d images could not be reconstructed", failed, len(ids)) }
+    log.Printf("Reconstructed %d images", len(ids))
+}
+
+func reconstructImage(rs *ftqqueue.RedisStreams, imageID int, outputPath string, allowPartial bool, pngLevel png.CompressionLevel) error {
+    info, err := rs.GetImageInfo(imageID)
+    if err != nil { return fmt.Errorf("image info: %w", err) }
+    tiles, err := rs.GetTiles(imageID)
+    if err != nil { return fmt.Errorf("tiles: %w", err) }
+    if len(tiles) < info.ExpectedTiles && !allowPartial {
+        return fmt.Errorf("only %d/%d tiles stored (use -allow-partial to write anyway)", len(tiles), info.ExpectedTiles)
+    }
+
+    img := image.NewRGBA(image.Rect(0, 0, info.Width, info.Height))
+    for _, tile := range tiles {
+        common.PlaceTile(img, tile)
+    }
+
+    // The coordinator's path keeps the input's format; an override goes by its extension
+    format := info.Format
+    if outputPath == "" {
+        outputPath = info.OutputPath
+    } else if ext := strings.ToLower(filepath.Ext(outputPath)); ext == ".jpg" || ext == ".jpeg" {
+        format = "jpeg"
+    } else {
+        format = "png"
+    }
+    if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil { return err }
+    f, err := os.Create(outputPath)
+    if err != nil { return err }
+    defer f.Close()
+    if err := common.EncodeOutput(f, img, format, pngLevel, common.DefaultJPEGQuality); err != nil { return err }
+
+    log.Printf("Reconstructed image %d from %d/%d tiles to %s", imageID+1, len(tiles), info.ExpectedTiles, outputPath)
+    return nil
+}
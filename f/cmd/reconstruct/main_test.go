@@ -0,0 +1,123 @@
+package main
+
+import (
+    "bytes"
+    "image"
+    _ "image/jpeg"
+    "image/color"
+    "image/png"
+    "math/rand"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/alicebob/miniredis/v2"
+    ftqqueue "go-blur-ftq/pkg/queue"
+    "studyguide.parallel/pkg/common"
+)
+
+// storeImage splits img into tileSize tiles, stores every tile but those in skip, and
+// records the image's info, with a PNG input format, as the coordinator would
+func storeImage(t *testing.T, rs *ftqqueue.RedisStreams, img *image.RGBA, tileSize int, outputPath string, skip map[int]bool) {
+    storeImageFormat(t, rs, img, tileSize, outputPath, "png", skip)
+}
+
+// storeImageFormat is storeImage for an input of the given format
+func storeImageFormat(t *testing.T, rs *ftqqueue.RedisStreams, img *image.RGBA, tileSize int, outputPath, format string, skip map[int]bool) {
+    t.Helper()
+    bounds := img.Bounds()
+    tileID := 0
+    for y := 0; y < bounds.Dy(); y += tileSize {
+        for x := 0; x < bounds.Dx(); x += tileSize {
+            tile := &common.ProcessedImageTile{ImageID: 0, TileID: tileID, X: x, Y: y,
+                Width: min(tileSize, bounds.Dx()-x), Height: min(tileSize, bounds.Dy()-y)}
+            for row := 0; row < tile.Height; row++ {
+                data := make([]color.RGBA, tile.Width)
+                for col := range data { data[col] = img.RGBAAt(x+col, y+row) }
+                tile.Data = append(tile.Data, data)
+            }
+            if !skip[tileID] {
+                if err := rs.StoreTile(tile); err != nil { t.Fatal(err) }
+            }
+            tileID++
+        }
+    }
+    info := &common.ImageInfo{ID: 0, OutputPath: outputPath, Width: bounds.Dx(), Height: bounds.Dy(), ExpectedTiles: tileID, TileSize: tileSize, Format: format}
+    if err := rs.StoreImageInfo(info); err != nil { t.Fatal(err) }
+}
+
+func newTestStreams(t *testing.T) (*ftqqueue.RedisStreams, *miniredis.Miniredis) {
+    t.Helper()
+    server := miniredis.RunT(t)
+    rs, err := ftqqueue.NewRedisStreams(server.Addr(), nil)
+    if err != nil { t.Fatal(err) }
+    t.Cleanup(func() { rs.Close() })
+    return rs, server
+}
+
+func TestReconstructImage(t *testing.T) {
+    rs, server := newTestStreams(t)
+    img := image.NewRGBA(image.Rect(0, 0, 40, 30)) // edge tiles are partial
+    rand.New(rand.NewSource(5)).Read(img.Pix)
+    output := filepath.Join(t.TempDir(), "out", "img0_blurred.png")
+    storeImage(t, rs, img, 16, output, nil)
+    if ttl := server.TTL("image:0:tiles"); ttl <= 0 || ttl > 24*time.Hour { t.Errorf("stored tiles TTL = %s, want up to 24h", ttl) }
+
+    if err := reconstructImage(rs, 0, "", false, png.DefaultCompression); err != nil { t.Fatal(err) }
+    file, err := os.Open(output)
+    if err != nil { t.Fatal(err) }
+    defer file.Close()
+    got, err := png.Decode(file)
+    if err != nil { t.Fatal(err) }
+    rgba, ok := got.(*image.NRGBA)
+    if !ok || rgba.Bounds() != img.Bounds() { t.Fatalf("decoded %T %v, want a 40x30 image", got, got.Bounds()) }
+
+    // Random alpha makes the NRGBA round trip lossy, so compare through RGBA
+    back := image.NewRGBA(img.Bounds())
+    for y := 0; y < 30; y++ {
+        for x := 0; x < 40; x++ { back.Set(x, y, rgba.At(x, y)) }
+    }
+    want := image.NewRGBA(img.Bounds())
+    for y := 0; y < 30; y++ {
+        for x := 0; x < 40; x++ { want.Set(x, y, color.NRGBAModel.Convert(img.At(x, y))) }
+    }
+    if !bytes.Equal(back.Pix, want.Pix) { t.Error("the reconstructed image differs from the tiles that were stored") }
+}
+
+func TestReconstructImageMissingTiles(t *testing.T) {
+    rs, _ := newTestStreams(t)
+    img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+    for i := range img.Pix { img.Pix[i] = 200 }
+    output := filepath.Join(t.TempDir(), "img0_blurred.png")
+    storeImage(t, rs, img, 16, output, map[int]bool{3: true})
+
+    if err := reconstructImage(rs, 0, "", false, png.DefaultCompression); err == nil { t.Fatal("reconstructed an image with a tile missing") }
+    if _, err := os.Stat(output); err == nil { t.Error("an incomplete image was written without -allow-partial") }
+
+    if err := reconstructImage(rs, 0, "", true, png.DefaultCompression); err != nil { t.Fatal(err) }
+    file, err := os.Open(output)
+    if err != nil { t.Fatal(err) }
+    defer file.Close()
+    got, err := png.Decode(file)
+    if err != nil { t.Fatal(err) }
+    if _, _, _, a := got.At(20, 20).RGBA(); a != 0 { t.Error("the missing tile's area is not left empty") }
+    if _, _, _, a := got.At(5, 5).RGBA(); a == 0 { t.Error("a stored tile is missing from the partial image") }
+}
+
+func TestReconstructKeepsFormat(t *testing.T) {
+    img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+    for i := range img.Pix { img.Pix[i] = 180 }
+    for _, format := range []string{"jpeg", "png"} {
+        rs, _ := newTestStreams(t)
+        output := filepath.Join(t.TempDir(), "img0_blurred"+common.OutputExt(format))
+        storeImageFormat(t, rs, img, 16, output, format, nil)
+        if err := reconstructImage(rs, 0, "", false, png.DefaultCompression); err != nil { t.Fatal(err) }
+
+        file, err := os.Open(output)
+        if err != nil { t.Fatal(err) }
+        _, got, err := image.DecodeConfig(file)
+        file.Close()
+        if err != nil || got != format { t.Errorf("a %s input was written as %q (%v)", format, got, err) }
+    }
+}
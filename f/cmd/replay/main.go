@@ -0,0 +1,41 @@
+package main
+
+import (
+    "flag"
+    "log"
+    "time"
+
+    "studyguide.parallel/pkg/common"
+    ftqqueue "go-blur-ftq/pkg/queue"
+)
+
+// replay re-enqueues a run recorded with the coordinator's -record into another Redis,
+// in the recorded order, so workers and an assembler can reproduce the workload
+// offline. The stream and image keys are the recorded run's own, so it refuses a
+// Redis that already has job streams rather than mixing the replay into them.
+func main() {
+    var (
+        redisAddr = flag.String("redis", "localhost:6379", "Redis address to replay into")
+        logPath   = flag.String("log", "jobs.jsonl", "Job log written by the coordinator's -record")
+        batchSize = flag.Int("enqueue-batch", 64, "Number of tile jobs sent to Redis per round-trip")
+        affinity  = flag.Int("affinity", 0, "Split jobs over this many streams by image ID, as the coordinator's -affinity does (0 = one shared stream)")
+    )
+    redisTLS := common.RedisTLSFlags()
+    flag.Parse()
+
+    tlsConfig, err := redisTLS.Config()
+    if err != nil { log.Fatalf("redis tls: %v", err) }
+    rs, err := ftqqueue.NewRedisStreams(*redisAddr, tlsConfig)
+    if err != nil { log.Fatalf("redis: %v", err) }
+    defer rs.Close()
+    if *affinity > 0 { rs.SetJobShards(*affinity, -1) }
+    exists, err := rs.HasJobStreams()
+    if err != nil { log.Fatalf("redis: %v", err) }
+    if exists { log.Fatalf("%s already has job streams; replay into an empty Redis so the run's keys don't collide", *redisAddr) }
+    if err := rs.EnsureGroups(); err != nil { log.Printf("ensure groups: %v", err) }
+
+    start := time.Now()
+    images, jobs, err := rs.ReplayJobLog(*logPath, *batchSize)
+    if err != nil { log.Fatalf("replay after %d jobs: %v", jobs, err) }
+    log.Printf("Replayed %d images and %d jobs from %s in %.2fs", images, jobs, *logPath, time.Since(start).Seconds())
+}
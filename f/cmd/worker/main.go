@@ -1,15 +1,17 @@
 package main
 
 import (
+    "context"
     "flag"
     "fmt"
     "log"
     "os"
+    "os/signal"
+    "syscall"
     "time"
 
     "studyguide.parallel/pkg/common"
     ftqqueue "go-blur-ftq/pkg/queue"
-    "studyguide.parallel/pkg/blur"
 )
 
 func main() {
@@ -18,41 +20,141 @@ func main() {
         kernelSize = flag.Int("kernel", 15, "Gaussian kernel size")
         timeout    = flag.Duration("timeout", 5*time.Second, "Stream read block timeout")
         visTimeout = flag.Duration("visibility", 30*time.Second, "Visibility timeout for retries")
+        claimBatch = flag.Int("claim-batch", 50, "Max stale jobs reclaimed per claim pass")
+        claimEvery = flag.Duration("claim-interval", 10*time.Second, "How often to reclaim stale jobs from dead workers")
+        jobsMaxAge = flag.Duration("jobs-max-age", 0, "Sweep job entries older than this from the stream on each claim pass (0 = never)")
+        batchSize  = flag.Int("batch", 1, "Read this many tiles per round-trip and publish their results and acks together (1 = one tile at a time)")
+        batchConc  = flag.Int("batch-concurrency", 1, "With -batch > 1, blur up to this many tiles of a batch at once")
+        affinity   = flag.Int("affinity", 0, "Number of job streams the coordinator's -affinity split jobs over (0 = one shared stream)")
+        shard      = flag.Int("shard", 0, "With -affinity, the job stream (0 to affinity-1) this worker consumes")
+        storeTiles = flag.Bool("store-tiles", false, "Also store each processed tile in Redis for later cmd/reconstruct")
+        workerID   = flag.String("id", "", "Consumer name in the workers group (default: worker-<hostname>-<pid>-<random>); must be unique per worker")
     )
+    redisTLS := common.RedisTLSFlags()
     flag.Parse()
 
-    hostname, _ := os.Hostname()
-    consumer := fmt.Sprintf("worker-%s", hostname)
+    if *batchSize < 1 || *batchConc < 1 {
+        log.Fatalf("-batch and -batch-concurrency must be at least 1")
+    }
+    if *affinity > 0 && (*shard < 0 || *shard >= *affinity) {
+        log.Fatalf("-shard %d is outside the %d -affinity streams", *shard, *affinity)
+    }
+    if *jobsMaxAge > 0 && *jobsMaxAge <= *visTimeout {
+        log.Fatalf("-jobs-max-age (%s) must exceed -visibility (%s) or in-flight jobs could be swept", *jobsMaxAge, *visTimeout)
+    }
+
+    // Reads and stale-job claims both go through this name, so it must not collide
+    consumer := *workerID
+    if consumer == "" {
+        consumer = common.UniqueConsumerName("worker")
+    }
     
-    rs, err := ftqqueue.NewRedisStreams(*redisAddr)
+    tlsConfig, err := redisTLS.Config()
+    if err != nil { log.Fatalf("redis tls: %v", err) }
+    rs, err := ftqqueue.NewRedisStreams(*redisAddr, tlsConfig)
     if err != nil { log.Fatalf("redis: %v", err) }
     defer rs.Close()
+    if *affinity > 0 {
+        rs.SetJobShards(*affinity, *shard)
+        log.Printf("Tile affinity: consuming job stream %d of %d", *shard, *affinity)
+    }
     if err := rs.EnsureGroups(); err != nil { log.Printf("ensure groups: %v", err) }
 
     log.Printf("Worker %s ready - waiting for jobs on fixed streams...", consumer)
 
-    kernel := blur.GenerateGaussianKernel(*kernelSize)
+    // f sends workers no completion signal, so SIGTERM (a rollout or scale-down) is how
+    // a worker ends: stop after the current tile or batch, then deregister. A second
+    // signal kills.
+    ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stopSignals()
+    go func() {
+        <-ctx.Done()
+        stopSignals()
+        log.Printf("Worker %s stopping after its current work", consumer)
+    }()
 
-    for {
-        // Claim stale jobs periodically
-        if _, err := rs.ClaimStaleJobs(consumer, *visTimeout, 50); err != nil {
-            log.Printf("claim stale: %v", err)
+    // Register with a heartbeat so coordinators can tell whether anyone will consume
+    // what they enqueue
+    if err := rs.Heartbeat(consumer); err != nil { log.Printf("heartbeat: %v", err) }
+    defer func() {
+        if err := rs.Deregister(consumer); err != nil { log.Printf("deregister: %v", err) }
+    }()
+    go func() {
+        ticker := time.NewTicker(ftqqueue.HeartbeatInterval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C:
+            }
+            if err := rs.Heartbeat(consumer); err != nil { log.Printf("heartbeat: %v", err) }
         }
+    }()
+
+    // Claim stale jobs on a fixed interval rather than every read, to keep Redis load flat
+    go runClaims(ctx, rs, consumer, *claimEvery, *visTimeout, *claimBatch, *jobsMaxAge)
 
-        id, job, err := rs.ReadJob(consumer, *timeout)
-        if err != nil { log.Printf("read job: %v", err); continue }
-        if job == nil { continue }
+    var q common.BatchQueue = rs
+    if *storeTiles {
+        log.Printf("Storing processed tiles in Redis for reconstruction")
+        q = tileStoringQueue{rs}
+    }
+
+    if *batchSize > 1 {
+        log.Printf("Processing tiles in batches of %d, %d at a time", *batchSize, *batchConc)
+        common.RunBatchWorker(ctx, q, *kernelSize, consumer, *timeout, *batchSize, *batchConc, nil)
+        return
+    }
+    common.RunWorker(ctx, q, *kernelSize, consumer, *timeout, nil)
+}
 
-        if job.Type != "tile" || job.ImageTile == nil { log.Printf("invalid job"); _ = rs.AckJob(id); continue }
+// staleJobs is the part of the queue the claim loop uses
+type staleJobs interface {
+    ClaimStaleJobs(consumer string, minIdle time.Duration, count int) ([]string, error)
+    SweepOldJobs(maxAge time.Duration, count int) (int64, error)
+}
 
-        tile := job.ImageTile
-        start := time.Now()
-        blurred := blur.ApplyBlurToTile(tile.Data, kernel)
-        center := blur.ExtractCenter(blurred, tile.Padding, tile.Width, tile.Height)
-        processed := &common.ProcessedImageTile{ImageID: tile.ImageID, TileID: tile.TileID, X: tile.X, Y: tile.Y, Width: tile.Width, Height: tile.Height, Data: center}
-        res := &common.ResultMessage{ProcessedTile: processed, WorkerID: consumer, ProcessTime: time.Since(start).Seconds()}
-        if _, err := rs.AddResult(res); err != nil { log.Printf("push result: %v", err); continue }
-        if err := rs.AckJob(id); err != nil { log.Printf("ack job: %v", err) }
+// runClaims claims up to batch jobs idle for at least visibility every interval until
+// ctx is done, for this worker's next reads to process, and sweeps job entries older
+// than maxAge when it is > 0
+func runClaims(ctx context.Context, q staleJobs, consumer string, interval, visibility time.Duration, batch int, maxAge time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+        ids, err := q.ClaimStaleJobs(consumer, visibility, batch)
+        if err != nil { log.Printf("claim stale: %v", err) }
+        if len(ids) > 0 { log.Printf("Reclaimed %d stale jobs for reprocessing", len(ids)) }
+        if maxAge > 0 {
+            swept, err := q.SweepOldJobs(maxAge, batch)
+            if err != nil {
+                log.Printf("sweep old jobs: %v", err)
+            } else if swept > 0 {
+                log.Printf("Swept %d job entries older than %s", swept, maxAge)
+            }
+        }
     }
 }
 
+// tileStoringQueue writes each processed tile to the per-image tile hash before
+// publishing the result, so the job is only acked once the tile is stored
+type tileStoringQueue struct {
+    *ftqqueue.RedisStreams
+}
+
+func (q tileStoringQueue) AddResult(res *common.ResultMessage) (string, error) {
+    if err := q.StoreTile(res.ProcessedTile); err != nil { return "", fmt.Errorf("store tile: %w", err) }
+    return q.RedisStreams.AddResult(res)
+}
+
+func (q tileStoringQueue) AddResultsAndAck(results []*common.ResultMessage, ids []string) error {
+    for _, res := range results {
+        if err := q.StoreTile(res.ProcessedTile); err != nil { return fmt.Errorf("store tile: %w", err) }
+    }
+    return q.RedisStreams.AddResultsAndAck(results, ids)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+    "context"
+    "sync"
+    "testing"
+    "time"
+)
+
+// recordingClaims records when and how the claim loop calls the queue
+type recordingClaims struct {
+    mu     sync.Mutex
+    claims []time.Time
+    counts []int
+    idles  []time.Duration
+    sweeps int
+}
+
+func (r *recordingClaims) ClaimStaleJobs(consumer string, minIdle time.Duration, count int) ([]string, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.claims = append(r.claims, time.Now())
+    r.counts = append(r.counts, count)
+    r.idles = append(r.idles, minIdle)
+    return nil, nil
+}
+
+func (r *recordingClaims) SweepOldJobs(maxAge time.Duration, count int) (int64, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.sweeps++
+    return 0, nil
+}
+
+func TestRunClaimsIntervalAndBatch(t *testing.T) {
+    const interval = 20 * time.Millisecond
+    q := &recordingClaims{}
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    start := time.Now()
+    go func() {
+        runClaims(ctx, q, "worker-1", interval, 30*time.Second, 7, 0)
+        close(done)
+    }()
+    time.Sleep(5*interval + interval/2)
+    cancel()
+    <-done
+
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if len(q.claims) < 3 || len(q.claims) > 6 {
+        t.Fatalf("%d claim passes in %s at a %s interval, want about 5", len(q.claims), 5*interval, interval)
+    }
+    if first := q.claims[0].Sub(start); first < interval-5*time.Millisecond {
+        t.Errorf("first claim after %s, want it to wait one %s interval", first, interval)
+    }
+    for i, count := range q.counts {
+        if count != 7 || q.idles[i] != 30*time.Second {
+            t.Errorf("claim %d asked for %d jobs idle %s, want 7 idle 30s", i, count, q.idles[i])
+        }
+    }
+    if q.sweeps != 0 {
+        t.Errorf("swept %d times with -jobs-max-age 0", q.sweeps)
+    }
+}
+
+func TestRunClaimsSweeps(t *testing.T) {
+    q := &recordingClaims{}
+    ctx, cancel := context.WithTimeout(context.Background(), 35*time.Millisecond)
+    defer cancel()
+    runClaims(ctx, q, "worker-1", 10*time.Millisecond, time.Second, 5, time.Hour)
+
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if q.sweeps == 0 || q.sweeps != len(q.claims) {
+        t.Errorf("%d sweeps for %d claim passes, want one each", q.sweeps, len(q.claims))
+    }
+}
@@ -0,0 +1,100 @@
+package queue
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "time"
+
+    "studyguide.parallel/pkg/common"
+)
+
+// JobLogEntry is one line of a coordinator's -record log. Exactly one field is set:
+// the image info stored before an image's tiles, a job as it was added to the jobs
+// stream, or the run's timing data stored at the end.
+type JobLogEntry struct {
+    ImageInfo *common.ImageInfo  `json:"image_info,omitempty"`
+    Job       *common.JobMessage `json:"job,omitempty"`
+    Timing    *common.TimingData `json:"timing,omitempty"`
+}
+
+// JobRecorder appends JobLogEntry lines (JSONL) to a file, in the order the
+// coordinator wrote them to Redis, so cmd/replay can reproduce the run
+type JobRecorder struct {
+    f   *os.File
+    w   *bufio.Writer
+    enc *json.Encoder
+}
+
+func NewJobRecorder(path string) (*JobRecorder, error) {
+    f, err := os.Create(path)
+    if err != nil { return nil, err }
+    w := bufio.NewWriter(f)
+    return &JobRecorder{f: f, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+func (r *JobRecorder) Record(e JobLogEntry) error { return r.enc.Encode(e) }
+
+// Close flushes buffered entries and closes the file
+func (r *JobRecorder) Close() error {
+    if err := r.w.Flush(); err != nil { r.f.Close(); return err }
+    return r.f.Close()
+}
+
+// ReadJobLog calls fn with each entry of a -record log in order, stopping at the first
+// error. Entries are decoded one at a time, so a log of full-size tiles is never held
+// in memory at once.
+func ReadJobLog(path string, fn func(JobLogEntry) error) error {
+    f, err := os.Open(path)
+    if err != nil { return err }
+    defer f.Close()
+    dec := json.NewDecoder(bufio.NewReader(f))
+    for n := 1; ; n++ {
+        var e JobLogEntry
+        if err := dec.Decode(&e); errors.Is(err, io.EOF) {
+            return nil
+        } else if err != nil {
+            return fmt.Errorf("%s: entry %d: %w", path, n, err)
+        }
+        if err := fn(e); err != nil { return err }
+    }
+}
+
+// ReplayJobLog re-enqueues a -record log in its recorded order, sending tile jobs
+// batchSize at a time. Pending jobs are flushed before any image info or timing entry
+// so the stream order matches the log. Start times are reset to the replay, so the
+// assembler's durations measure it.
+func (r *RedisStreams) ReplayJobLog(path string, batchSize int) (images, jobs int, err error) {
+    batch := make([]*common.JobMessage, 0, batchSize)
+    flush := func() error {
+        if len(batch) == 0 { return nil }
+        if _, err := r.AddJobs(batch); err != nil { return err }
+        jobs += len(batch)
+        batch = batch[:0]
+        return nil
+    }
+
+    start := time.Now()
+    err = ReadJobLog(path, func(e JobLogEntry) error {
+        switch {
+        case e.Job != nil:
+            batch = append(batch, e.Job)
+            if len(batch) >= batchSize { return flush() }
+        case e.ImageInfo != nil:
+            if err := flush(); err != nil { return err }
+            e.ImageInfo.LoadTime, e.ImageInfo.StartTime = time.Now(), time.Now()
+            images++
+            return r.StoreImageInfo(e.ImageInfo)
+        case e.Timing != nil:
+            if err := flush(); err != nil { return err }
+            e.Timing.StartTime = start
+            return r.StoreTiming(e.Timing)
+        }
+        return nil
+    })
+    if err == nil { err = flush() }
+    return images, jobs, err
+}
@@ -0,0 +1,53 @@
+package queue
+
+import (
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+
+    "studyguide.parallel/pkg/common"
+)
+
+func TestRecordThenReplay(t *testing.T) {
+    // Record a two-image run as the coordinator does: each image's info, then its jobs,
+    // then the completion signal and the timing data
+    path := filepath.Join(t.TempDir(), "jobs.jsonl")
+    recorder, err := NewJobRecorder(path)
+    if err != nil { t.Fatal(err) }
+    var jobs []*common.JobMessage
+    for image, tiles := range []int{5, 3} {
+        info := &common.ImageInfo{ID: image, InputPath: "in.png", Width: 4, Height: 4, ExpectedTiles: tiles}
+        if err := recorder.Record(JobLogEntry{ImageInfo: info}); err != nil { t.Fatal(err) }
+        for tile := 0; tile < tiles; tile++ {
+            job := tileJob(image, tile)
+            jobs = append(jobs, job)
+            if err := recorder.Record(JobLogEntry{Job: job}); err != nil { t.Fatal(err) }
+        }
+    }
+    complete := &common.JobMessage{Type: "complete"}
+    jobs = append(jobs, complete)
+    if err := recorder.Record(JobLogEntry{Job: complete}); err != nil { t.Fatal(err) }
+    timing := &common.TimingData{StartTime: time.Unix(1, 0), KernelSize: 9, TotalImages: 2}
+    if err := recorder.Record(JobLogEntry{Timing: timing}); err != nil { t.Fatal(err) }
+    if err := recorder.Close(); err != nil { t.Fatal(err) }
+
+    // A batch of 2 splits image 0's jobs over several round trips
+    rs, _ := newTestStreams(t)
+    images, replayed, err := rs.ReplayJobLog(path, 2)
+    if err != nil { t.Fatal(err) }
+    if images != 2 || replayed != len(jobs) { t.Errorf("replayed %d images and %d jobs, want 2 and %d", images, replayed, len(jobs)) }
+
+    _, got, err := rs.ReadJobs("worker", 100, 10*time.Millisecond)
+    if err != nil { t.Fatal(err) }
+    if !reflect.DeepEqual(got, jobs) {
+        t.Errorf("the replay enqueued %d jobs that differ from the %d recorded, or are out of order", len(got), len(jobs))
+    }
+    for image, tiles := range []int{5, 3} {
+        info, err := rs.GetImageInfo(image)
+        if err != nil || info.ExpectedTiles != tiles { t.Errorf("image %d info after the replay = %+v, %v", image, info, err) }
+    }
+    stored, err := rs.GetTiming()
+    if err != nil || stored.KernelSize != 9 || stored.TotalImages != 2 { t.Errorf("timing after the replay = %+v, %v", stored, err) }
+    if !stored.StartTime.After(time.Unix(1, 0)) { t.Error("the timing start time was not reset to the replay") }
+}
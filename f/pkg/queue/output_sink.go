@@ -0,0 +1,53 @@
+package queue
+
+import (
+    "bytes"
+    "fmt"
+    "image"
+    "image/png"
+    "log"
+    "time"
+
+    "studyguide.parallel/pkg/common"
+)
+
+// Defaults for RedisSink: outputs live long enough for a downstream service to fetch
+// them, and are capped well below Redis's 512MB value limit
+const (
+    DefaultOutputTTL      = time.Hour
+    DefaultMaxOutputBytes = 64 << 20
+)
+
+// RedisSink stores each finished image's encoded bytes (PNG, or JPEG for JPEG inputs)
+// under image:<id>:output with a TTL, for deployments with no filesystem shared
+// between the assembler and its consumers
+type RedisSink struct {
+    rs       *RedisStreams
+    level    png.CompressionLevel
+    quality  int
+    ttl      time.Duration
+    maxBytes int
+}
+
+var _ common.OutputSink = (*RedisSink)(nil)
+
+func NewRedisSink(rs *RedisStreams, level png.CompressionLevel, quality int, ttl time.Duration, maxBytes int) *RedisSink {
+    return &RedisSink{rs: rs, level: level, quality: quality, ttl: ttl, maxBytes: maxBytes}
+}
+
+func (s *RedisSink) Save(info *common.ImageInfo, img image.Image) (string, error) {
+    var buf bytes.Buffer
+    if err := common.EncodeOutput(&buf, img, info.Format, s.level, s.quality); err != nil { return "", err }
+    if s.maxBytes > 0 && buf.Len() > s.maxBytes {
+        log.Printf("Output for image %d is %d bytes, over the %d byte cap; not stored", info.ID+1, buf.Len(), s.maxBytes)
+        return "", fmt.Errorf("encoded output is %d bytes, over the %d byte cap", buf.Len(), s.maxBytes)
+    }
+    key := s.rs.outputKey(info.ID)
+    if err := s.rs.client.Set(s.rs.ctx, key, buf.Bytes(), s.ttl).Err(); err != nil { return "", err }
+    return "redis:" + key, nil
+}
+
+// GetOutput fetches the image bytes a RedisSink stored for an image
+func (r *RedisStreams) GetOutput(imageID int) ([]byte, error) {
+    return r.client.Get(r.ctx, r.outputKey(imageID)).Bytes()
+}
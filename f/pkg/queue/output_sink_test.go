@@ -0,0 +1,56 @@
+package queue
+
+import (
+    "bytes"
+    "image"
+    _ "image/jpeg"
+    "image/png"
+    "math/rand"
+    "testing"
+    "time"
+
+    "studyguide.parallel/pkg/common"
+)
+
+func TestRedisSinkStoresOutput(t *testing.T) {
+    rs, server := newTestStreams(t)
+    img := image.NewRGBA(image.Rect(0, 0, 24, 16))
+    for i := range img.Pix { img.Pix[i] = uint8(i * 5) }
+    for i := 3; i < len(img.Pix); i += 4 { img.Pix[i] = 255 } // opaque, so PNG keeps every channel exactly
+    sink := NewRedisSink(rs, png.DefaultCompression, 90, 10*time.Minute, DefaultMaxOutputBytes)
+
+    where, err := sink.Save(&common.ImageInfo{ID: 2, Format: "png"}, img)
+    if err != nil { t.Fatal(err) }
+    if where != "redis:image:2:output" { t.Errorf("Save reported %q, want the key redis:image:2:output", where) }
+    if ttl := server.TTL("image:2:output"); ttl != 10*time.Minute { t.Errorf("output TTL = %s, want 10m", ttl) }
+
+    data, err := rs.GetOutput(2)
+    if err != nil { t.Fatal(err) }
+    got, err := png.Decode(bytes.NewReader(data))
+    if err != nil { t.Fatalf("the stored output does not decode as PNG: %v", err) }
+    back := image.NewRGBA(got.Bounds())
+    for y := 0; y < 16; y++ {
+        for x := 0; x < 24; x++ { back.Set(x, y, got.At(x, y)) }
+    }
+    if !bytes.Equal(back.Pix, img.Pix) { t.Error("the stored PNG differs from the assembled image") }
+}
+
+func TestRedisSinkSkipsOverCap(t *testing.T) {
+    rs, server := newTestStreams(t)
+    // Noise barely compresses, so its PNG is well over a 1KB cap
+    img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+    rand.New(rand.NewSource(1)).Read(img.Pix)
+    sink := NewRedisSink(rs, png.DefaultCompression, 90, time.Hour, 1024)
+
+    if _, err := sink.Save(&common.ImageInfo{ID: 0, Format: "png"}, img); err == nil { t.Error("an output over the cap was saved without an error") }
+    if server.Exists("image:0:output") { t.Error("an output over the cap was stored") }
+}
+
+func TestRedisSinkKeepsJPEG(t *testing.T) {
+    rs, _ := newTestStreams(t)
+    sink := NewRedisSink(rs, png.DefaultCompression, 90, time.Hour, DefaultMaxOutputBytes)
+    if _, err := sink.Save(&common.ImageInfo{ID: 1, Format: "jpeg"}, image.NewRGBA(image.Rect(0, 0, 16, 8))); err != nil { t.Fatal(err) }
+    data, err := rs.GetOutput(1)
+    if err != nil { t.Fatal(err) }
+    if _, format, err := image.DecodeConfig(bytes.NewReader(data)); err != nil || format != "jpeg" { t.Errorf("a JPEG input's output was stored as %q (%v)", format, err) }
+}
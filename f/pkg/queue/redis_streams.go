@@ -2,45 +2,126 @@ package queue
 
 import (
     "context"
+    "crypto/tls"
     "encoding/json"
+    "errors"
     "fmt"
+    "strconv"
+    "strings"
     "time"
 
     "github.com/redis/go-redis/v9"
     "studyguide.parallel/pkg/common"
 )
 
+// DefaultResultGroup is the consumer group assemblers read results through
+const DefaultResultGroup = "assemblers"
+
+const (
+    // HeartbeatInterval is how often a worker refreshes its registration
+    HeartbeatInterval = 5 * time.Second
+    // WorkerTTL is how long a registration counts as live without a heartbeat
+    WorkerTTL = 3 * HeartbeatInterval
+    // AssemblerLockTTL is how long an assembler's hold on its result group lasts
+    // without a refresh
+    AssemblerLockTTL = 15 * time.Second
+)
+
+// MaxDeliveries is how many times a job is handed to a worker before ClaimStaleJobs
+// moves it to ftq:dlq:jobs instead of claiming it for another try
+const MaxDeliveries = 3
+
 type RedisStreams struct {
-    client *redis.Client
-    ctx    context.Context
+    client      *redis.Client
+    ctx         context.Context
+    resultGroup string
+    shards      int // > 0 splits jobs over ftq:jobs:<n> by image ID (see SetJobShards)
+    shard       int // the shard a worker consumes, or -1 for producers
+
+    claimed common.ClaimedJobs // jobs taken over by ClaimStaleJobs, returned by the next reads
 }
 
-func NewRedisStreams(addr string) (*RedisStreams, error) {
-    client := redis.NewClient(&redis.Options{Addr: addr})
+// RedisStreams feeds the shared worker loops in pkg/common
+var _ common.BatchQueue = (*RedisStreams)(nil)
+
+// NewRedisStreams connects to the Redis at addr, over TLS when tlsConfig is non-nil
+func NewRedisStreams(addr string, tlsConfig *tls.Config) (*RedisStreams, error) {
+    client := redis.NewClient(&redis.Options{Addr: addr, TLSConfig: tlsConfig})
     ctx := context.Background()
     if err := client.Ping(ctx).Err(); err != nil {
         return nil, err
     }
-    rs := &RedisStreams{client: client, ctx: ctx}
+    rs := &RedisStreams{client: client, ctx: ctx, resultGroup: DefaultResultGroup, shard: -1}
     return rs, nil
 }
 
 func (r *RedisStreams) Close() error { return r.client.Close() }
 
-func (r *RedisStreams) jobsStream() string    { return "ftq:jobs" }
+// SetResultGroup switches the consumer group results are read and acked through.
+// Each group receives every result, so independent assembler deployments each need
+// their own, and a group has exactly one assembler (see LockResultGroup). Call it
+// before EnsureGroups.
+func (r *RedisStreams) SetResultGroup(group string) { r.resultGroup = group }
+
+// SetJobShards splits tile jobs over shards streams, ftq:jobs:0 to ftq:jobs:<shards-1>,
+// by image ID, so every tile of an image goes to the workers of one shard and each
+// worker blurs contiguous tiles of the same images. Workers pass the shard they
+// consume; producers pass -1 and add to every shard. Jobs without a tile go to shard
+// 0. Call it before EnsureGroups.
+func (r *RedisStreams) SetJobShards(shards, shard int) { r.shards, r.shard = shards, shard }
+
+func (r *RedisStreams) jobsStream() string {
+    if r.shards > 0 && r.shard >= 0 { return shardStream(r.shard) }
+    return "ftq:jobs"
+}
+func shardStream(shard int) string { return fmt.Sprintf("ftq:jobs:%d", shard) }
+
+// HasJobStreams reports whether ftq:jobs or any of the shard streams set by
+// SetJobShards already exists, for a producer that must start from an empty Redis
+func (r *RedisStreams) HasJobStreams() (bool, error) {
+    streams := []string{"ftq:jobs"}
+    for shard := 0; shard < r.shards; shard++ { streams = append(streams, shardStream(shard)) }
+    n, err := r.client.Exists(r.ctx, streams...).Result()
+    return n > 0, err
+}
+
+// streamFor picks the stream a producer adds job to
+func (r *RedisStreams) streamFor(job *common.JobMessage) string {
+    if r.shards <= 0 { return r.jobsStream() }
+    if job.ImageTile == nil { return shardStream(0) }
+    return shardStream(job.ImageTile.ImageID % r.shards)
+}
+
 func (r *RedisStreams) resultsStream() string { return "ftq:results" }
 func (r *RedisStreams) dlqJobsStream() string { return "ftq:dlq:jobs" }
+func (r *RedisStreams) workersKey() string    { return "ftq:workers" }
+func (r *RedisStreams) groupLockKey() string  { return "ftq:assembler-lock:" + r.resultGroup }
 
 func (r *RedisStreams) imageInfoKey(imageID int) string   { return fmt.Sprintf("image:%d:info", imageID) }
 func (r *RedisStreams) timingKey() string                 { return "timing" }
-func (r *RedisStreams) receivedSetKey(imageID int) string { return fmt.Sprintf("image:%d:received", imageID) }
+func (r *RedisStreams) receivedKey(imageID int) string {
+    // Each result group assembles independently, so it needs its own received bitmap.
+    // The "-bits" name keeps it clear of received sets left by older assemblers.
+    if r.resultGroup != DefaultResultGroup {
+        return fmt.Sprintf("image:%d:received-bits:%s", imageID, r.resultGroup)
+    }
+    return fmt.Sprintf("image:%d:received-bits", imageID)
+}
+func (r *RedisStreams) tilesKey(imageID int) string       { return fmt.Sprintf("image:%d:tiles", imageID) }
+func (r *RedisStreams) outputKey(imageID int) string      { return fmt.Sprintf("image:%d:output", imageID) }
 
 // Init consumer groups (idempotent)
 func (r *RedisStreams) EnsureGroups() error {
     // Create consumer groups with MKSTREAM to create empty streams if needed
     // Using "$" means only new messages will be consumed (not existing ones)
-    _ = r.client.XGroupCreateMkStream(r.ctx, r.jobsStream(), "workers", "$").Err()
-    _ = r.client.XGroupCreateMkStream(r.ctx, r.resultsStream(), "assemblers", "$").Err()
+    if r.shards > 0 && r.shard < 0 {
+        for shard := 0; shard < r.shards; shard++ {
+            _ = r.client.XGroupCreateMkStream(r.ctx, shardStream(shard), "workers", "$").Err()
+        }
+    } else {
+        _ = r.client.XGroupCreateMkStream(r.ctx, r.jobsStream(), "workers", "$").Err()
+    }
+    _ = r.client.XGroupCreateMkStream(r.ctx, r.resultsStream(), r.resultGroup, "$").Err()
     return nil
 }
 
@@ -48,10 +129,25 @@ func (r *RedisStreams) EnsureGroups() error {
 func (r *RedisStreams) AddJob(job *common.JobMessage) (string, error) {
     b, err := json.Marshal(job)
     if err != nil { return "", err }
-    id := r.client.XAdd(r.ctx, &redis.XAddArgs{Stream: r.jobsStream(), Values: map[string]any{"data": b}}).Val()
+    id := r.client.XAdd(r.ctx, &redis.XAddArgs{Stream: r.streamFor(job), Values: map[string]any{"data": b}}).Val()
     return id, nil
 }
 
+// AddJobs enqueues a batch of jobs in one pipelined round-trip and returns their stream IDs in order
+func (r *RedisStreams) AddJobs(jobs []*common.JobMessage) ([]string, error) {
+    pipe := r.client.Pipeline()
+    cmds := make([]*redis.StringCmd, 0, len(jobs))
+    for _, job := range jobs {
+        b, err := json.Marshal(job)
+        if err != nil { return nil, err }
+        cmds = append(cmds, pipe.XAdd(r.ctx, &redis.XAddArgs{Stream: r.streamFor(job), Values: map[string]any{"data": b}}))
+    }
+    if _, err := pipe.Exec(r.ctx); err != nil { return nil, err }
+    ids := make([]string, len(cmds))
+    for i, cmd := range cmds { ids[i] = cmd.Val() }
+    return ids, nil
+}
+
 func (r *RedisStreams) AddResult(res *common.ResultMessage) (string, error) {
     b, err := json.Marshal(res)
     if err != nil { return "", err }
@@ -60,7 +156,11 @@ func (r *RedisStreams) AddResult(res *common.ResultMessage) (string, error) {
 }
 
 // Consumer APIs
+
+// ReadJob returns a job claimed by ClaimStaleJobs if there is one, and otherwise reads
+// the next new job
 func (r *RedisStreams) ReadJob(consumer string, block time.Duration) (string, *common.JobMessage, error) {
+    if ids, jobs := r.claimed.Take(1); len(jobs) > 0 { return ids[0], jobs[0], nil }
     res := r.client.XReadGroup(r.ctx, &redis.XReadGroupArgs{
         Group:    "workers",
         Consumer: consumer,
@@ -80,9 +180,52 @@ func (r *RedisStreams) AckJob(id string) error {
     return r.client.XAck(r.ctx, r.jobsStream(), "workers", id).Err()
 }
 
+// ReadJobs reads up to count jobs in one round-trip. Entries that fail to decode are
+// left pending, to be reclaimed or dead-lettered like any failed job, and reported in
+// the error alongside the jobs that did decode. Jobs claimed by ClaimStaleJobs are
+// returned first, without reading the stream.
+func (r *RedisStreams) ReadJobs(consumer string, count int, block time.Duration) ([]string, []*common.JobMessage, error) {
+    if ids, jobs := r.claimed.Take(count); len(jobs) > 0 { return ids, jobs, nil }
+    res := r.client.XReadGroup(r.ctx, &redis.XReadGroupArgs{
+        Group:    "workers",
+        Consumer: consumer,
+        Streams:  []string{r.jobsStream(), ">"},
+        Count:    int64(count),
+        Block:    block,
+    }).Val()
+    if len(res) == 0 { return nil, nil, nil }
+    ids := make([]string, 0, len(res[0].Messages))
+    jobs := make([]*common.JobMessage, 0, len(res[0].Messages))
+    var errs []error
+    for _, msg := range res[0].Messages {
+        var jm common.JobMessage
+        if err := json.Unmarshal(bytesFromAny(msg.Values["data"]), &jm); err != nil {
+            errs = append(errs, fmt.Errorf("job %s: %w", msg.ID, err))
+            continue
+        }
+        ids = append(ids, msg.ID)
+        jobs = append(jobs, &jm)
+    }
+    return ids, jobs, errors.Join(errs...)
+}
+
+// AddResultsAndAck adds the results and acks the jobs ids in one MULTI/EXEC, so the
+// jobs are acked only if every result was added
+func (r *RedisStreams) AddResultsAndAck(results []*common.ResultMessage, ids []string) error {
+    pipe := r.client.TxPipeline()
+    for _, res := range results {
+        b, err := json.Marshal(res)
+        if err != nil { return err }
+        pipe.XAdd(r.ctx, &redis.XAddArgs{Stream: r.resultsStream(), Values: map[string]any{"data": b}})
+    }
+    if len(ids) > 0 { pipe.XAck(r.ctx, r.jobsStream(), "workers", ids...) }
+    _, err := pipe.Exec(r.ctx)
+    return err
+}
+
 func (r *RedisStreams) ReadResult(consumer string, block time.Duration) (string, *common.ResultMessage, error) {
     res := r.client.XReadGroup(r.ctx, &redis.XReadGroupArgs{
-        Group:    "assemblers",
+        Group:    r.resultGroup,
         Consumer: consumer,
         Streams:  []string{r.resultsStream(), ">"},
         Count:    1,
@@ -97,25 +240,200 @@ func (r *RedisStreams) ReadResult(consumer string, block time.Duration) (string,
 }
 
 func (r *RedisStreams) AckResult(id string) error {
-    return r.client.XAck(r.ctx, r.resultsStream(), "assemblers", id).Err()
+    return r.client.XAck(r.ctx, r.resultsStream(), r.resultGroup, id).Err()
 }
 
-// Pending and claim for retries
+// ClaimStaleJobs takes over up to count jobs that have sat unacked in another
+// consumer's pending list for at least minIdle, such as those of a worker that died
+// mid-tile, and returns the IDs of those it will retry. XCLAIM alone only moves them
+// into this consumer's pending list, where reads of new entries never see them, so the
+// claimed jobs are also kept and handed out by this client's next ReadJob or ReadJobs
+// calls. Claim with the same consumer name the worker reads with. Jobs already
+// delivered MaxDeliveries times are moved to ftq:dlq:jobs and acked instead. An entry
+// that does not decode stays pending and is dead-lettered once it has been claimed
+// often enough.
 func (r *RedisStreams) ClaimStaleJobs(consumer string, minIdle time.Duration, count int) ([]string, error) {
-    pend := r.client.XPendingExt(r.ctx, &redis.XPendingExtArgs{
-        Stream: r.jobsStream(), Group: "workers", Idle: minIdle, Count: int64(count), Start: "-", End: "+",
-    }).Val()
-    ids := make([]string, 0, len(pend))
-    for _, p := range pend {
-        ids = append(ids, p.ID)
+    pending, err := r.client.XPendingExt(r.ctx, &redis.XPendingExtArgs{
+        Stream: r.jobsStream(), Group: "workers", Idle: minIdle, Start: "-", End: "+", Count: int64(count),
+    }).Result()
+    if err != nil || len(pending) == 0 { return nil, err }
+    stale := make([]string, len(pending))
+    deliveries := make(map[string]int64, len(pending))
+    for i, p := range pending { stale[i] = p.ID; deliveries[p.ID] = p.RetryCount }
+
+    msgs, err := r.client.XClaim(r.ctx, &redis.XClaimArgs{
+        Stream: r.jobsStream(), Group: "workers", Consumer: consumer, MinIdle: minIdle, Messages: stale,
+    }).Result()
+    if err != nil { return nil, err }
+    ids := make([]string, 0, len(msgs))
+    var errs []error
+    deadLetters := r.client.TxPipeline()
+    for _, msg := range msgs {
+        if deliveries[msg.ID] >= MaxDeliveries {
+            deadLetters.XAdd(r.ctx, &redis.XAddArgs{Stream: r.dlqJobsStream(), Values: map[string]any{
+                "data": msg.Values["data"], "job_id": msg.ID, "deliveries": deliveries[msg.ID],
+            }})
+            deadLetters.XAck(r.ctx, r.jobsStream(), "workers", msg.ID)
+            continue
+        }
+        var jm common.JobMessage
+        if err := json.Unmarshal(bytesFromAny(msg.Values["data"]), &jm); err != nil {
+            errs = append(errs, fmt.Errorf("job %s: %w", msg.ID, err))
+            continue
+        }
+        r.claimed.Add(msg.ID, &jm)
+        ids = append(ids, msg.ID)
     }
-    if len(ids) == 0 { return ids, nil }
-    claimed := r.client.XClaim(r.ctx, &redis.XClaimArgs{
-        Stream:   r.jobsStream(), Group: "workers", Consumer: consumer, MinIdle: minIdle, Messages: ids,
-    }).Val()
-    out := make([]string, 0, len(claimed))
-    for _, c := range claimed { out = append(out, c.ID) }
-    return out, nil
+    if deadLetters.Len() > 0 {
+        if _, err := deadLetters.Exec(r.ctx); err != nil { errs = append(errs, fmt.Errorf("dead-letter jobs: %w", err)) }
+    }
+    return ids, errors.Join(errs...)
+}
+
+// SweepOldJobs deletes up to count job entries that will never be acked by a live
+// worker, acking them first so they also leave the workers' pending list. Only entries
+// the group has already delivered are considered, so a long backlog of jobs still
+// waiting for a worker is never touched. Of those it removes entries created more than
+// maxAge ago that are no longer pending, and pending ones idle for at least maxAge;
+// keep maxAge well above the visibility timeout so jobs that are merely slow are never swept.
+func (r *RedisStreams) SweepOldJobs(maxAge time.Duration, count int) (int64, error) {
+    cutoff := time.Now().Add(-maxAge).UnixMilli()
+    if cutoff <= 0 { return 0, nil }
+    stream := r.jobsStream()
+
+    groups, err := r.client.XInfoGroups(r.ctx, stream).Result()
+    if err != nil { return 0, err }
+    lastDelivered := ""
+    for _, g := range groups {
+        if g.Name == "workers" { lastDelivered = g.LastDeliveredID }
+    }
+    if lastDelivered == "" || lastDelivered == "0-0" { return 0, nil }
+
+    // Pending entries nobody has touched for maxAge, wherever they are in the stream
+    stale, err := r.client.XPendingExt(r.ctx, &redis.XPendingExtArgs{
+        Stream: stream, Group: "workers", Idle: maxAge, Start: "-", End: "+", Count: int64(count),
+    }).Result()
+    if err != nil { return 0, err }
+    ids := make([]string, 0, count)
+    chosen := make(map[string]bool, count)
+    for _, p := range stale { ids = append(ids, p.ID); chosen[p.ID] = true }
+
+    // Delivered entries older than cutoff, skipping the ones still pending
+    var old []redis.XMessage
+    if len(ids) < count {
+        old, err = r.client.XRangeN(r.ctx, stream, "-", lastDelivered, int64(count-len(ids))).Result()
+        if err != nil { return 0, err }
+    }
+    if len(old) > 0 {
+        entries := make([]string, len(old))
+        for i, m := range old { entries[i] = m.ID }
+        pending, err := r.client.XPendingExt(r.ctx, &redis.XPendingExtArgs{
+            Stream: stream, Group: "workers", Start: entries[0], End: entries[len(entries)-1], Count: int64(len(entries)),
+        }).Result()
+        if err != nil { return 0, err }
+        idle := make(map[string]time.Duration, len(pending))
+        for _, p := range pending { idle[p.ID] = p.Idle }
+        for _, id := range sweepableJobs(entries, lastDelivered, cutoff, idle, maxAge) {
+            if !chosen[id] { ids = append(ids, id) }
+        }
+    }
+    if len(ids) == 0 { return 0, nil }
+    if err := r.client.XAck(r.ctx, stream, "workers", ids...).Err(); err != nil { return 0, err }
+    return r.client.XDel(r.ctx, stream, ids...).Result()
+}
+
+// sweepableJobs picks the entries of ids that SweepOldJobs may delete: ones at or before
+// lastDelivered, created before cutoff (Unix milliseconds), and either not in pending or
+// idle there for at least maxAge
+func sweepableJobs(ids []string, lastDelivered string, cutoff int64, pending map[string]time.Duration, maxAge time.Duration) []string {
+    var out []string
+    for _, id := range ids {
+        if compareStreamIDs(id, lastDelivered) > 0 { continue }
+        ms, _, ok := parseStreamID(id)
+        if !ok || ms >= cutoff { continue }
+        if idle, isPending := pending[id]; isPending && idle < maxAge { continue }
+        out = append(out, id)
+    }
+    return out
+}
+
+// parseStreamID splits a stream ID into its millisecond time and sequence number
+func parseStreamID(id string) (ms, seq int64, ok bool) {
+    msPart, seqPart, found := strings.Cut(id, "-")
+    ms, err := strconv.ParseInt(msPart, 10, 64)
+    if err != nil { return 0, 0, false }
+    if !found { return ms, 0, true }
+    seq, err = strconv.ParseInt(seqPart, 10, 64)
+    if err != nil { return 0, 0, false }
+    return ms, seq, true
+}
+
+// compareStreamIDs orders stream IDs as Redis does, returning -1, 0 or 1. An ID that
+// does not parse sorts last.
+func compareStreamIDs(a, b string) int {
+    aMS, aSeq, aOK := parseStreamID(a)
+    bMS, bSeq, bOK := parseStreamID(b)
+    switch {
+    case !aOK || !bOK:
+        if aOK == bOK { return 0 }
+        if !aOK { return 1 }
+        return -1
+    case aMS != bMS:
+        if aMS < bMS { return -1 }
+        return 1
+    case aSeq != bSeq:
+        if aSeq < bSeq { return -1 }
+        return 1
+    }
+    return 0
+}
+
+// An assembler keeps the tiles it reads in memory, so two assemblers splitting one
+// group would each save images missing the other's tiles. The group lock makes the
+// result group single-assembler: the holder refreshes it, and it expires
+// AssemblerLockTTL after the holder stops.
+var (
+    refreshGroupLock = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`)
+    unlockGroupLock  = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`)
+)
+
+// LockResultGroup takes the result group for owner, returning false if another
+// assembler holds it
+func (r *RedisStreams) LockResultGroup(owner string) (bool, error) {
+    return r.client.SetNX(r.ctx, r.groupLockKey(), owner, AssemblerLockTTL).Result()
+}
+
+// RefreshResultGroup extends owner's hold on the result group, returning false if it
+// has been lost
+func (r *RedisStreams) RefreshResultGroup(owner string) (bool, error) {
+    n, err := refreshGroupLock.Run(r.ctx, r.client, []string{r.groupLockKey()}, owner, AssemblerLockTTL.Milliseconds()).Int()
+    return n == 1, err
+}
+
+// UnlockResultGroup releases owner's hold on the result group
+func (r *RedisStreams) UnlockResultGroup(owner string) error {
+    return unlockGroupLock.Run(r.ctx, r.client, []string{r.groupLockKey()}, owner).Err()
+}
+
+// Worker registration APIs: live workers are a sorted set scored by their last
+// heartbeat, so a crashed worker drops out once WorkerTTL passes
+func (r *RedisStreams) Heartbeat(consumer string) error {
+    return r.client.ZAdd(r.ctx, r.workersKey(), redis.Z{Score: float64(time.Now().Unix()), Member: consumer}).Err()
+}
+
+// Deregister removes consumer from the live workers
+func (r *RedisStreams) Deregister(consumer string) error {
+    return r.client.ZRem(r.ctx, r.workersKey(), consumer).Err()
+}
+
+// LiveWorkers counts the workers that have sent a heartbeat within WorkerTTL, pruning
+// the ones that have not
+func (r *RedisStreams) LiveWorkers() (int64, error) {
+    cutoff := time.Now().Add(-WorkerTTL).Unix()
+    if err := r.client.ZRemRangeByScore(r.ctx, r.workersKey(), "-inf", fmt.Sprintf("(%d", cutoff)).Err(); err != nil {
+        return 0, fmt.Errorf("prune workers: %w", err)
+    }
+    return r.client.ZCard(r.ctx, r.workersKey()).Result()
 }
 
 // Metadata APIs
@@ -149,12 +467,45 @@ func (r *RedisStreams) GetTiming() (*common.TimingData, error) {
     return &t, nil
 }
 
+// Received tiles are a bitmap indexed by tile ID: one bit per tile instead of a set
+// member, so a large image's bookkeeping stays a few hundred bytes. Like stored tiles
+// it expires 24 hours after the last mark.
+// MarkTileReceived returns 1 if the tile is newly marked and 0 for a re-mark, as SADD
+// did.
 func (r *RedisStreams) MarkTileReceived(imageID int, tileID int) (int64, error) {
-    return r.client.SAdd(r.ctx, r.receivedSetKey(imageID), tileID).Result()
+    if tileID < 0 { return 0, fmt.Errorf("tile ID %d is negative", tileID) }
+    key := r.receivedKey(imageID)
+    pipe := r.client.TxPipeline()
+    prev := pipe.SetBit(r.ctx, key, int64(tileID), 1)
+    pipe.Expire(r.ctx, key, 24*time.Hour)
+    if _, err := pipe.Exec(r.ctx); err != nil { return 0, err }
+    return 1 - prev.Val(), nil
 }
 
 func (r *RedisStreams) GetReceivedCount(imageID int) (int64, error) {
-    return r.client.SCard(r.ctx, r.receivedSetKey(imageID)).Result()
+    return r.client.BitCount(r.ctx, r.receivedKey(imageID), nil).Result()
+}
+
+// Stored tile APIs: processed tiles kept in a per-image hash (field = tile ID) so an
+// image can be reconstructed later without a live assembler
+func (r *RedisStreams) StoreTile(tile *common.ProcessedImageTile) error {
+    b, err := json.Marshal(tile)
+    if err != nil { return err }
+    key := r.tilesKey(tile.ImageID)
+    if err := r.client.HSet(r.ctx, key, fmt.Sprint(tile.TileID), b).Err(); err != nil { return err }
+    return r.client.Expire(r.ctx, key, 24*time.Hour).Err()
+}
+
+func (r *RedisStreams) GetTiles(imageID int) ([]*common.ProcessedImageTile, error) {
+    fields, err := r.client.HGetAll(r.ctx, r.tilesKey(imageID)).Result()
+    if err != nil { return nil, err }
+    tiles := make([]*common.ProcessedImageTile, 0, len(fields))
+    for _, data := range fields {
+        var tile common.ProcessedImageTile
+        if err := json.Unmarshal([]byte(data), &tile); err != nil { return nil, err }
+        tiles = append(tiles, &tile)
+    }
+    return tiles, nil
 }
 
 // helper: handle Redis returning either string or []byte
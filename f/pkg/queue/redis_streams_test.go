@@ -0,0 +1,358 @@
+package queue
+
+import (
+    "context"
+    "fmt"
+    "image/color"
+    "reflect"
+    "strconv"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/alicebob/miniredis/v2"
+    "github.com/redis/go-redis/v9"
+    "studyguide.parallel/pkg/common"
+)
+
+// newTestStreams connects a RedisStreams to a fresh in-memory Redis with the groups created
+func newTestStreams(t *testing.T) (*RedisStreams, *miniredis.Miniredis) {
+    t.Helper()
+    server := miniredis.RunT(t)
+    rs, err := NewRedisStreams(server.Addr(), nil)
+    if err != nil { t.Fatal(err) }
+    t.Cleanup(func() { rs.Close() })
+    if err := rs.EnsureGroups(); err != nil { t.Fatal(err) }
+    return rs, server
+}
+
+// tileJob is a job for one 1x1 tile of the image
+func tileJob(imageID, tileID int) *common.JobMessage {
+    return &common.JobMessage{Type: "tile", ImageTile: &common.ImageTile{
+        ImageID: imageID, TileID: tileID, Width: 1, Height: 1, Data: [][]color.RGBA{{{1, 2, 3, 255}}},
+    }}
+}
+
+func TestSweepableJobs(t *testing.T) {
+    now := time.Now().UnixMilli()
+    maxAge := time.Hour
+    cutoff := now - maxAge.Milliseconds()
+    id := func(age time.Duration, seq int) string {
+        return fmt.Sprintf("%d-%d", now-age.Milliseconds(), seq)
+    }
+
+    oldAcked := id(2*time.Hour, 0)
+    oldIdle := id(2*time.Hour, 1)
+    oldBusy := id(2*time.Hour, 2)
+    recent := id(time.Minute, 0)
+    undelivered := id(90*time.Minute, 0)
+    lastDelivered := id(80*time.Minute, 0)
+
+    pending := map[string]time.Duration{
+        oldIdle: 2 * time.Hour,
+        oldBusy: time.Second,
+    }
+    ids := []string{oldAcked, oldIdle, oldBusy, lastDelivered, recent}
+    got := sweepableJobs(ids, lastDelivered, cutoff, pending, maxAge)
+    want := []string{oldAcked, oldIdle, lastDelivered}
+    if !reflect.DeepEqual(got, want) {
+        t.Fatalf("sweepableJobs = %v, want %v", got, want)
+    }
+
+    // An old entry the group has not delivered yet is still waiting for a worker
+    got = sweepableJobs([]string{oldAcked, undelivered}, oldAcked, cutoff, nil, maxAge)
+    if !reflect.DeepEqual(got, []string{oldAcked}) {
+        t.Fatalf("sweepableJobs swept an undelivered job: %v", got)
+    }
+}
+
+func TestCompareStreamIDs(t *testing.T) {
+    cases := []struct {
+        a, b string
+        want int
+    }{
+        {"1-0", "1-0", 0},
+        {"1-0", "1-1", -1},
+        {"2-0", "1-9", 1},
+        {"10-0", "9-0", 1},
+        {"5", "5-0", 0},
+    }
+    for _, c := range cases {
+        if got := compareStreamIDs(c.a, c.b); got != c.want {
+            t.Errorf("compareStreamIDs(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+        }
+    }
+}
+
+func TestClaimedJobsAreReadAgain(t *testing.T) {
+    rs, server := newTestStreams(t)
+    start := time.Now()
+    server.SetTime(start)
+    if _, err := rs.AddJobs([]*common.JobMessage{tileJob(1, 0), tileJob(1, 1)}); err != nil { t.Fatal(err) }
+
+    // A worker reads both jobs and dies without acking them
+    ids, _, err := rs.ReadJobs("dead", 2, 10*time.Millisecond)
+    if err != nil || len(ids) != 2 { t.Fatalf("dead worker read %v, %v", ids, err) }
+
+    // Not idle long enough yet
+    if claimed, err := rs.ClaimStaleJobs("live", 30*time.Second, 10); err != nil || len(claimed) != 0 {
+        t.Fatalf("claimed %v, %v before the visibility timeout", claimed, err)
+    }
+
+    server.SetTime(start.Add(time.Minute))
+    claimed, err := rs.ClaimStaleJobs("live", 30*time.Second, 1)
+    if err != nil { t.Fatal(err) }
+    if !reflect.DeepEqual(claimed, ids[:1]) { t.Fatalf("claimed %v with a batch of 1, want %v", claimed, ids[:1]) }
+
+    // The claimed job comes back from the live worker's next read, not just its pending list
+    id, job, err := rs.ReadJob("live", 10*time.Millisecond)
+    if err != nil { t.Fatal(err) }
+    if id != ids[0] || job == nil || job.ImageTile.TileID != 0 { t.Fatalf("read %q %+v after the claim, want %q tile 0", id, job, ids[0]) }
+    if err := rs.AckJob(id); err != nil { t.Fatal(err) }
+
+    // The rest are claimed in the next pass and read through ReadJobs
+    if claimed, err = rs.ClaimStaleJobs("live", 30*time.Second, 10); err != nil || !reflect.DeepEqual(claimed, ids[1:]) {
+        t.Fatalf("second pass claimed %v, %v, want %v", claimed, err, ids[1:])
+    }
+    got, jobs, err := rs.ReadJobs("live", 5, 10*time.Millisecond)
+    if err != nil || !reflect.DeepEqual(got, ids[1:]) || jobs[0].ImageTile.TileID != 1 {
+        t.Fatalf("ReadJobs = %v, %v after the second claim, want %v", got, err, ids[1:])
+    }
+    if id, job, err := rs.ReadJob("live", 10*time.Millisecond); err != nil || job != nil {
+        t.Fatalf("read %q %+v, %v with nothing left", id, job, err)
+    }
+}
+
+func TestClaimedJobsComeBeforeNewJobs(t *testing.T) {
+    rs, server := newTestStreams(t)
+    start := time.Now()
+    server.SetTime(start)
+    if _, err := rs.AddJobs([]*common.JobMessage{tileJob(1, 0), tileJob(1, 1), tileJob(1, 2)}); err != nil { t.Fatal(err) }
+    stale, _, err := rs.ReadJobs("dead", 3, 10*time.Millisecond)
+    if err != nil || len(stale) != 3 { t.Fatalf("dead worker read %v, %v", stale, err) }
+
+    // A new job arrives after the dead worker's three went stale
+    if _, err := rs.AddJobs([]*common.JobMessage{tileJob(2, 0)}); err != nil { t.Fatal(err) }
+    server.SetTime(start.Add(time.Minute))
+    if claimed, err := rs.ClaimStaleJobs("live", 30*time.Second, 10); err != nil || !reflect.DeepEqual(claimed, stale) {
+        t.Fatalf("claimed %v, %v, want %v", claimed, err, stale)
+    }
+
+    // Batches of two drain the claimed jobs before the stream is read, and never mix the two
+    ids, _, err := rs.ReadJobs("live", 2, 10*time.Millisecond)
+    if err != nil || !reflect.DeepEqual(ids, stale[:2]) { t.Fatalf("first batch %v, %v, want %v", ids, err, stale[:2]) }
+    ids, _, err = rs.ReadJobs("live", 2, 10*time.Millisecond)
+    if err != nil || !reflect.DeepEqual(ids, stale[2:]) { t.Fatalf("second batch %v, %v, want %v", ids, err, stale[2:]) }
+    _, jobs, err := rs.ReadJobs("live", 2, 10*time.Millisecond)
+    if err != nil || len(jobs) != 1 || jobs[0].ImageTile.ImageID != 2 { t.Fatalf("third batch %+v, %v, want the new job", jobs, err) }
+}
+
+func TestPoisonJobIsDeadLetteredAfterMaxDeliveries(t *testing.T) {
+    rs, server := newTestStreams(t)
+    now := time.Now()
+    server.SetTime(now)
+    if _, err := rs.AddJobs([]*common.JobMessage{tileJob(1, 0), tileJob(1, 1)}); err != nil { t.Fatal(err) }
+
+    // A worker reads both jobs and fails them, leaving them unacked
+    ids, _, err := rs.ReadJobs("worker", 2, 10*time.Millisecond)
+    if err != nil || len(ids) != 2 { t.Fatalf("worker read %v, %v", ids, err) }
+
+    // claimAndRead claims the stale jobs and reads each one back from ReadJob
+    claimAndRead := func(want []string) {
+        t.Helper()
+        now = now.Add(time.Minute)
+        server.SetTime(now)
+        claimed, err := rs.ClaimStaleJobs("worker", 30*time.Second, 10)
+        if err != nil || len(claimed) != len(want) || len(want) > 0 && !reflect.DeepEqual(claimed, want) {
+            t.Fatalf("claimed %v, %v; want %v", claimed, err, want)
+        }
+        for _, id := range want {
+            got, job, err := rs.ReadJob("worker", 10*time.Millisecond)
+            if err != nil || got != id || job == nil { t.Fatalf("read %q %+v, %v; want the claimed job %q", got, job, err, id) }
+        }
+        if got, job, err := rs.ReadJob("worker", 10*time.Millisecond); err != nil || job != nil {
+            t.Fatalf("read %q %+v, %v with no job left to hand out", got, job, err)
+        }
+    }
+
+    // The second tile succeeds on its retry; the first fails every delivery
+    claimAndRead(ids)
+    if err := rs.AckJob(ids[1]); err != nil { t.Fatal(err) }
+    for try := 3; try <= MaxDeliveries; try++ { claimAndRead(ids[:1]) }
+    claimAndRead(nil)
+
+    dead, err := server.Stream("ftq:dlq:jobs")
+    if err != nil || len(dead) != 1 { t.Fatalf("dead-letter stream = %v, %v; want the one poison tile", dead, err) }
+    fields := map[string]string{}
+    for i := 0; i+1 < len(dead[0].Values); i += 2 { fields[dead[0].Values[i]] = dead[0].Values[i+1] }
+    if fields["job_id"] != ids[0] || fields["deliveries"] != strconv.Itoa(MaxDeliveries) || fields["data"] == "" {
+        t.Errorf("dead letter %v, want job %s with its data after %d deliveries", fields, ids[0], MaxDeliveries)
+    }
+    if pending, err := rs.client.XPending(rs.ctx, rs.jobsStream(), "workers").Result(); err != nil || pending.Count != 0 {
+        t.Errorf("pending after the dead letter = %+v, %v; want none", pending, err)
+    }
+}
+
+func TestReceivedTilesBitmap(t *testing.T) {
+    rs, server := newTestStreams(t)
+    marks := []struct {
+        tile int
+        want int64
+    }{{0, 1}, {5, 1}, {0, 0}, {130, 1}, {5, 0}, {130, 0}}
+    for _, m := range marks {
+        got, err := rs.MarkTileReceived(3, m.tile)
+        if err != nil { t.Fatal(err) }
+        if got != m.want { t.Errorf("MarkTileReceived(3, %d) = %d, want %d", m.tile, got, m.want) }
+    }
+    if count, err := rs.GetReceivedCount(3); err != nil || count != 3 {
+        t.Errorf("GetReceivedCount = %d, %v after marking tiles 0, 5 and 130, want 3", count, err)
+    }
+    if count, err := rs.GetReceivedCount(4); err != nil || count != 0 {
+        t.Errorf("GetReceivedCount = %d, %v for an image with no marks, want 0", count, err)
+    }
+    if _, err := rs.MarkTileReceived(3, -1); err == nil { t.Error("a negative tile ID was marked") }
+
+    key := "image:3:received-bits"
+    if ttl := server.TTL(key); ttl <= 0 || ttl > 24*time.Hour { t.Errorf("%s TTL = %s, want up to 24h", key, ttl) }
+
+    // Another result group keeps its own bitmap
+    rs.SetResultGroup("audit")
+    if count, err := rs.GetReceivedCount(3); err != nil || count != 0 {
+        t.Errorf("the audit group sees %d, %v received tiles of the default group's image", count, err)
+    }
+    if got, err := rs.MarkTileReceived(3, 0); err != nil || got != 1 {
+        t.Errorf("the audit group's first mark of tile 0 = %d, %v, want 1", got, err)
+    }
+    if !server.Exists("image:3:received-bits:audit") { t.Error("the audit group's bitmap is not under its own key") }
+}
+
+func TestJobShardsKeepImagesTogether(t *testing.T) {
+    const shards = 3
+    producer, server := newTestStreams(t)
+    producer.SetJobShards(shards, -1)
+    if err := producer.EnsureGroups(); err != nil { t.Fatal(err) }
+
+    var jobs []*common.JobMessage
+    for tile := 0; tile < 4; tile++ {
+        for image := 0; image < 7; image++ { jobs = append(jobs, tileJob(image, tile)) }
+    }
+    if _, err := producer.AddJobs(jobs); err != nil { t.Fatal(err) }
+
+    // Each worker consumes only its own shard, so all tiles of an image go to one worker
+    consumedBy := map[int]int{}
+    read := 0
+    for shard := 0; shard < shards; shard++ {
+        worker, err := NewRedisStreams(server.Addr(), nil)
+        if err != nil { t.Fatal(err) }
+        defer worker.Close()
+        worker.SetJobShards(shards, shard)
+        _, got, err := worker.ReadJobs(fmt.Sprintf("worker-%d", shard), 100, 10*time.Millisecond)
+        if err != nil { t.Fatal(err) }
+        for _, job := range got {
+            image := job.ImageTile.ImageID
+            if image%shards != shard { t.Errorf("worker %d read a tile of image %d, which belongs to shard %d", shard, image, image%shards) }
+            if prev, ok := consumedBy[image]; ok && prev != shard { t.Errorf("image %d was split between workers %d and %d", image, prev, shard) }
+            consumedBy[image] = shard
+        }
+        read += len(got)
+    }
+    if read != len(jobs) || len(consumedBy) != 7 { t.Errorf("workers read %d jobs of %d images, want %d of 7", read, len(consumedBy), len(jobs)) }
+    if entries, _ := server.Stream("ftq:jobs"); len(entries) != 0 { t.Errorf("%d sharded jobs also went to the unsharded ftq:jobs stream", len(entries)) }
+}
+
+func TestBatchWorkerPublishesAndAcksBatch(t *testing.T) {
+    rs, server := newTestStreams(t)
+    var jobs []*common.JobMessage
+    for tile := 0; tile < 5; tile++ { jobs = append(jobs, tileJob(1, tile)) }
+    ids, err := rs.AddJobs(jobs)
+    if err != nil { t.Fatal(err) }
+    if _, err := rs.AddJob(&common.JobMessage{Type: "complete"}); err != nil { t.Fatal(err) }
+
+    var seen []int
+    processed := common.RunBatchWorker(context.Background(), rs, 3, "worker", 10*time.Millisecond, 5, 2, func(tile *common.ProcessedImageTile) {
+        seen = append(seen, tile.TileID)
+    })
+    if processed != 5 || len(seen) != 5 { t.Fatalf("processed %d tiles (%d reported), want the batch of 5", processed, len(seen)) }
+
+    results, err := server.Stream("ftq:results")
+    if err != nil || len(results) != 5 { t.Fatalf("%d results on ftq:results (%v), want 5", len(results), err) }
+
+    // Only the "complete" job, which the worker never acks, is left pending
+    pending, err := rs.client.XPendingExt(rs.ctx, &redis.XPendingExtArgs{Stream: "ftq:jobs", Group: "workers", Start: "-", End: "+", Count: 10}).Result()
+    if err != nil { t.Fatal(err) }
+    for _, p := range pending {
+        for _, id := range ids {
+            if p.ID == id { t.Errorf("tile job %s is still pending after its result was added", id) }
+        }
+    }
+    if len(pending) != 1 { t.Errorf("%d jobs pending, want only the completion signal", len(pending)) }
+}
+
+// roundTrips counts the commands and pipelines a client sends, one round trip each
+type roundTrips struct{ n atomic.Int32 }
+
+func (h *roundTrips) DialHook(next redis.DialHook) redis.DialHook { return next }
+func (h *roundTrips) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+    return func(ctx context.Context, cmd redis.Cmder) error { h.n.Add(1); return next(ctx, cmd) }
+}
+func (h *roundTrips) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+    return func(ctx context.Context, cmds []redis.Cmder) error { h.n.Add(1); return next(ctx, cmds) }
+}
+
+func TestAddJobsOneRoundTrip(t *testing.T) {
+    rs, server := newTestStreams(t)
+    trips := &roundTrips{}
+    rs.client.AddHook(trips)
+
+    var jobs []*common.JobMessage
+    for tile := 0; tile < 25; tile++ { jobs = append(jobs, tileJob(1, tile)) }
+    ids, err := rs.AddJobs(jobs)
+    if err != nil { t.Fatal(err) }
+    if n := trips.n.Load(); n != 1 { t.Errorf("AddJobs took %d round trips for %d jobs, want 1", n, len(jobs)) }
+
+    entries, err := server.Stream("ftq:jobs")
+    if err != nil { t.Fatal(err) }
+    if len(ids) != len(jobs) || len(entries) != len(jobs) { t.Fatalf("%d IDs and %d stream entries for %d jobs", len(ids), len(entries), len(jobs)) }
+    for i, entry := range entries {
+        if entry.ID != ids[i] { t.Errorf("ID %d is %s, but the stream's entry %d is %s", i, ids[i], i, entry.ID) }
+    }
+}
+
+func TestResultGroupLock(t *testing.T) {
+    rs, server := newTestStreams(t)
+    lock := func(owner string) bool {
+        t.Helper()
+        ok, err := rs.LockResultGroup(owner)
+        if err != nil { t.Fatal(err) }
+        return ok
+    }
+    refresh := func(owner string) bool {
+        t.Helper()
+        ok, err := rs.RefreshResultGroup(owner)
+        if err != nil { t.Fatal(err) }
+        return ok
+    }
+
+    if !lock("a") { t.Fatal("the first assembler could not take a free group") }
+    if lock("b") { t.Error("a second assembler took a group that is already held") }
+    if refresh("b") { t.Error("an assembler that never held the group refreshed it") }
+    if err := rs.UnlockResultGroup("b"); err != nil { t.Fatal(err) }
+    if !refresh("a") { t.Error("another assembler's unlock released the holder's lock") }
+
+    // Another group has its own lock
+    other, err := NewRedisStreams(server.Addr(), nil)
+    if err != nil { t.Fatal(err) }
+    defer other.Close()
+    other.SetResultGroup("audit")
+    if ok, err := other.LockResultGroup("b"); err != nil || !ok { t.Errorf("locking the audit group = %v, %v; want it free", ok, err) }
+
+    // Once the holder stops refreshing, the lock expires and another takes over
+    server.FastForward(AssemblerLockTTL + time.Second)
+    if !lock("b") { t.Fatal("the lock was not taken over after its TTL") }
+    if refresh("a") { t.Error("the old holder refreshed a lock it lost") }
+    if err := rs.UnlockResultGroup("a"); err != nil { t.Fatal(err) }
+    if !refresh("b") { t.Error("the old holder's unlock released the new holder's lock") }
+
+    if err := rs.UnlockResultGroup("b"); err != nil { t.Fatal(err) }
+    if !lock("c") { t.Error("the group could not be taken after its holder unlocked it") }
+}
@@ -16,6 +16,8 @@ import (
     "go-blur-mt/pkg/coordinator"
     "go-blur-mt/pkg/processor"
     "go-blur-mt/pkg/queue"
+    "studyguide.parallel/pkg/blur"
+    "studyguide.parallel/pkg/common"
 )
 
 func main() {
@@ -26,17 +28,84 @@ func main() {
         kernelSize   = flag.Int("kernel", 15, "Gaussian kernel size")
         numWorkers   = flag.Int("workers", 10, "Number of worker threads")
         mode         = flag.String("mode", "all", "Mode: coordinator, worker, assembler, or all")
+        pngComp      = flag.String("png-compression", "default", "PNG compression level: default, none, speed or best")
+        jpegQual     = flag.Int("jpeg-quality", common.DefaultJPEGQuality, "Quality (1-100) of the JPEG outputs written for JPEG inputs")
+        dither       = flag.Bool("dither", false, "Ordered-dither tile output (matches the sequential -dither output exactly)")
+        edgeName     = flag.String("edge-mode", "clamp", "How border tiles are padded past the image edge: clamp, reflect or wrap")
+        autoTile     = flag.Bool("auto-tile", false, "Size tiles per image to aim for about 4 tiles per worker instead of a fixed 256px")
+        statsEvery   = flag.Duration("stats-interval", 30*time.Second, "How often the assembler saves aggregate stats to logs/ (0 disables)")
+        profileName  = flag.String("profile", "", "Blur preset setting -kernel and -edge-mode: light, medium, heavy or extreme")
+        inputSort    = flag.String("input-sort", "", "Queue images in this order: name, size or mtime, with :desc to reverse (default: by extension, then name)")
+        kernelList   = flag.String("kernel-manifest", "", "File of \"name kernel\" lines giving listed images their own kernel size (others use -kernel)")
+        keepMTime    = flag.Bool("preserve-mtime", false, "Give each output its input's modification time")
+        overlapRep   = flag.Bool("tile-overlap-report", false, "Preflight: report whether the tile padding covers the kernel and warn if tiles will seam")
     )
+    redisTLS := common.RedisTLSFlags()
     flag.Parse()
     
+    profile, err := common.ApplyProfileFlag(kernelSize, *profileName)
+    if err != nil {
+        log.Fatalf("Invalid -profile: %v", err)
+    }
+    if profile.Name != "" {
+        *edgeName = string(profile.EdgeMode)
+        if profile.Passes > 1 {
+            log.Printf("Profile %s: tiles are blurred in a single pass; the %d-pass preset applies to the a and d processors only", profile.Name, profile.Passes)
+        }
+    }
+    
+    pngLevel, err := common.ParsePNGCompression(*pngComp)
+    if err != nil {
+        log.Fatalf("Invalid -png-compression: %v", err)
+    }
+    
+    edgeMode, err := blur.ParseEdgeMode(*edgeName)
+    if err != nil {
+        log.Fatalf("Invalid -edge-mode: %v", err)
+    }
+    
+    var kernels common.ImageKernels
+    if *kernelList != "" {
+        if kernels, err = common.ReadImageKernels(*kernelList); err != nil {
+            log.Fatalf("Invalid -kernel-manifest: %v", err)
+        }
+    }
+    
     hostname, _ := os.Hostname()
     serviceID := fmt.Sprintf("%s-%d", hostname, time.Now().Unix())
     
     log.Printf("Starting multithreaded blur service")
     log.Printf("Mode: %s, Service ID: %s", *mode, serviceID)
     log.Printf("Redis: %s, Workers: %d, Kernel: %d", *redisAddr, *numWorkers, *kernelSize)
+    if profile.Name != "" {
+        log.Printf("Profile: %s", profile)
+    }
+    if len(kernels) > 0 {
+        log.Printf("Kernel manifest: %d images with their own kernel size", len(kernels))
+    }
+    
+    targetTiles := 0
+    if *autoTile {
+        targetTiles = common.AutoTilesPerWorker * *numWorkers
+        log.Printf("Auto tile size: targeting %d tiles per image", targetTiles)
+    }
+    
+    if *overlapRep {
+        // Auto-sized tiles can be as small as MinAutoTileSize, where padding costs most
+        tileSize := common.TILE_SIZE
+        if *autoTile {
+            tileSize = common.MinAutoTileSize
+        }
+        for _, line := range common.CheckTileOverlap(tileSize, *kernelSize, *kernelSize/2).Report() {
+            log.Print(line)
+        }
+    }
     
-    redisClient, err := queue.NewRedisClient(*redisAddr)
+    tlsConfig, err := redisTLS.Config()
+    if err != nil {
+        log.Fatalf("Invalid Redis TLS flags: %v", err)
+    }
+    redisClient, err := queue.NewRedisClient(*redisAddr, tlsConfig)
     if err != nil {
         log.Fatalf("Failed to connect to Redis: %v", err)
     }
@@ -57,7 +126,7 @@ func main() {
     
     switch *mode {
     case "coordinator":
-        runCoordinator(redisClient, *inputDir, *outputDir, *kernelSize)
+        runCoordinator(redisClient, *inputDir, *outputDir, *kernelSize, *dither, targetTiles, edgeMode, *keepMTime, *inputSort, kernels)
         
     case "worker":
         workerPool := processor.NewWorkerPool(redisClient, *numWorkers, *kernelSize, serviceID)
@@ -72,7 +141,7 @@ func main() {
         workerPool.Stop()
         
     case "assembler":
-        imageAssembler := assembler.NewAssembler(redisClient, serviceID)
+        imageAssembler := assembler.NewAssembler(redisClient, serviceID, *kernelSize, pngLevel, *jpegQual, *statsEvery)
         
         wg.Add(1)
         go func() {
@@ -95,7 +164,7 @@ func main() {
         go func() {
             defer wg.Done()
             time.Sleep(2 * time.Second)
-            runCoordinator(redisClient, *inputDir, *outputDir, *kernelSize)
+            runCoordinator(redisClient, *inputDir, *outputDir, *kernelSize, *dither, targetTiles, edgeMode, *keepMTime, *inputSort, kernels)
         }()
         
         workerPool := processor.NewWorkerPool(redisClient, *numWorkers, *kernelSize, serviceID)
@@ -105,7 +174,7 @@ func main() {
             workerPool.Start()
         }()
         
-        imageAssembler := assembler.NewAssembler(redisClient, serviceID)
+        imageAssembler := assembler.NewAssembler(redisClient, serviceID, *kernelSize, pngLevel, *jpegQual, *statsEvery)
         wg.Add(1)
         go func() {
             defer wg.Done()
@@ -125,16 +194,19 @@ func main() {
     log.Println("Service shutdown complete")
 }
 
-func runCoordinator(redisClient *queue.RedisClient, inputDir, outputDir string, kernelSize int) {
+func runCoordinator(redisClient *queue.RedisClient, inputDir, outputDir string, kernelSize int, dither bool, targetTiles int, edgeMode blur.EdgeMode, preserveMTime bool, inputSort string, kernels common.ImageKernels) {
     imagePaths := findImages(inputDir)
     if len(imagePaths) == 0 {
         log.Printf("No images found in %s", inputDir)
         return
     }
+    if err := common.SortInputs(imagePaths, inputSort); err != nil {
+        log.Fatalf("Invalid -input-sort: %v", err)
+    }
     
     log.Printf("Coordinator: Processing %d images", len(imagePaths))
     
-    coord := coordinator.NewCoordinator(redisClient, kernelSize)
+    coord := coordinator.NewCoordinator(redisClient, kernelSize, dither, targetTiles, edgeMode, preserveMTime, kernels)
     
     startTime := time.Now()
     if err := coord.ProcessImages(imagePaths, outputDir); err != nil {
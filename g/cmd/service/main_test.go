@@ -0,0 +1,160 @@
+package main
+
+import (
+    "image"
+    "image/png"
+    "math/rand"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/alicebob/miniredis/v2"
+
+    "go-blur-mt/pkg/assembler"
+    "go-blur-mt/pkg/processor"
+    "go-blur-mt/pkg/queue"
+    "studyguide.parallel/pkg/blur"
+    "studyguide.parallel/pkg/common"
+)
+
+// startDistributed starts a worker pool and an assembler against an in-memory Redis,
+// as -mode all does, and stops them when the test ends
+func startDistributed(t *testing.T, kernelSize int) *queue.RedisClient {
+    t.Helper()
+    server := miniredis.RunT(t)
+    redisClient, err := queue.NewRedisClient(server.Addr(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { redisClient.Close() })
+    if err := redisClient.EnsureGroups(); err != nil {
+        t.Fatal(err)
+    }
+
+    workerPool := processor.NewWorkerPool(redisClient, 2, kernelSize, "test")
+    imageAssembler := assembler.NewAssembler(redisClient, "test", kernelSize, png.BestSpeed, common.DefaultJPEGQuality, 0)
+    stopped := make(chan struct{}, 2)
+    go func() {
+        workerPool.Start()
+        stopped <- struct{}{}
+    }()
+    go func() {
+        imageAssembler.Start()
+        stopped <- struct{}{}
+    }()
+    // Cleanups run last-in first-out, so the pool and assembler stop before Redis closes
+    t.Cleanup(func() {
+        workerPool.Stop()
+        imageAssembler.Stop()
+        <-stopped
+        <-stopped
+    })
+    return redisClient
+}
+
+// randomImage returns an opaque width x height image of random pixels
+func randomImage(width, height int, seed int64) *image.RGBA {
+    rng := rand.New(rand.NewSource(seed))
+    img := image.NewRGBA(image.Rect(0, 0, width, height))
+    for i := range img.Pix {
+        img.Pix[i] = uint8(rng.Intn(256))
+        if i%4 == 3 {
+            img.Pix[i] = 255
+        }
+    }
+    return img
+}
+
+func writePNG(t *testing.T, path string, img image.Image) {
+    t.Helper()
+    file, err := os.Create(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := png.Encode(file, img); err != nil {
+        t.Fatal(err)
+    }
+    if err := file.Close(); err != nil {
+        t.Fatal(err)
+    }
+}
+
+// waitAssembled waits up to 30s for the assembler to complete image imageID
+func waitAssembled(t *testing.T, redisClient *queue.RedisClient, imageID int) {
+    t.Helper()
+    deadline := time.Now().Add(30 * time.Second)
+    for {
+        done, err := redisClient.IsImageCompleted(imageID)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if done {
+            return
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("image %d was not assembled within 30s", imageID)
+        }
+        time.Sleep(50 * time.Millisecond)
+    }
+}
+
+// checkOutput decodes the PNG at path and fails unless it matches want pixel for pixel
+func checkOutput(t *testing.T, path string, want *image.RGBA) {
+    t.Helper()
+    file, err := os.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer file.Close()
+    decoded, err := png.Decode(file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    got := blur.ToRGBA(decoded)
+    if got.Bounds() != want.Bounds() {
+        t.Fatalf("%s is %v, want %v", path, got.Bounds(), want.Bounds())
+    }
+    for y := 0; y < want.Bounds().Dy(); y++ {
+        for x := 0; x < want.Bounds().Dx(); x++ {
+            if g, w := got.RGBAAt(x, y), want.RGBAAt(x, y); g != w {
+                t.Fatalf("%s: pixel (%d, %d) = %v, sequential blur gives %v", path, x, y, g, w)
+            }
+        }
+    }
+}
+
+// TestDistributedEndToEnd runs the coordinator, a worker pool and an assembler against
+// an in-memory Redis, as -mode all does, and checks the assembled output of one image
+// matches the sequential blur pixel for pixel
+func TestDistributedEndToEnd(t *testing.T) {
+    const kernelSize = 7
+    redisClient := startDistributed(t, kernelSize)
+
+    // Larger than one tile in both directions, with partial tiles on the right and bottom
+    src := randomImage(common.TILE_SIZE*2+37, common.TILE_SIZE+19, 1)
+    inputDir, outputDir := t.TempDir(), t.TempDir()
+    writePNG(t, filepath.Join(inputDir, "synthetic.png"), src)
+
+    runCoordinator(redisClient, inputDir, outputDir, kernelSize, false, 0, blur.EdgeClamp, false, "", nil)
+
+    waitAssembled(t, redisClient, 0)
+    checkOutput(t, filepath.Join(outputDir, "img1_blurred.png"), blur.ApplyBlurToImage(src, kernelSize))
+}
+
+// TestDistributedPerImageKernels blurs two images in one run, one with the run's kernel
+// and one with its own from a kernel manifest
+func TestDistributedPerImageKernels(t *testing.T) {
+    redisClient := startDistributed(t, 3)
+    first, second := randomImage(common.TILE_SIZE+21, 40, 2), randomImage(60, common.TILE_SIZE+9, 3)
+    inputDir, outputDir := t.TempDir(), t.TempDir()
+    writePNG(t, filepath.Join(inputDir, "a.png"), first)
+    writePNG(t, filepath.Join(inputDir, "b.png"), second)
+
+    runCoordinator(redisClient, inputDir, outputDir, 3, false, 0, blur.EdgeClamp, false, "name", common.ImageKernels{"b.png": 9})
+
+    waitAssembled(t, redisClient, 0)
+    waitAssembled(t, redisClient, 1)
+    checkOutput(t, filepath.Join(outputDir, "img1_blurred.png"), blur.ApplyBlurToImage(first, 3))
+    checkOutput(t, filepath.Join(outputDir, "img2_blurred.png"), blur.ApplyBlurToImage(second, 9))
+}
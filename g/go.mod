@@ -3,6 +3,7 @@ module go-blur-mt
 go 1.21
 
 require (
+	github.com/alicebob/miniredis/v2 v2.39.0
 	github.com/redis/go-redis/v9 v9.5.1
 	studyguide.parallel/pkg v0.0.0
 )
@@ -12,4 +13,5 @@ replace studyguide.parallel/pkg => ../pkg
 require (
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 )
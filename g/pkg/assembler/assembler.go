@@ -7,20 +7,54 @@ import (
     "image/png"
     "log"
     "os"
+    "sort"
     "sync"
     "time"
 
     "go-blur-mt/pkg/queue"
     "studyguide.parallel/pkg/common"
+    "studyguide.parallel/pkg/stats"
 )
 
+// CompletedAssemblyGrace is how long a saved image's assembly stays in imageMap before
+// checkpointMonitor drops it. Duplicates arriving later are still recognised from the
+// image's completed mark in Redis.
+const CompletedAssemblyGrace = time.Minute
+
 type Assembler struct {
-    redisClient *queue.RedisClient
-    assemblerID string
-    imageMap    map[int]*ImageAssembly
-    mutex       sync.RWMutex
-    ctx         context.Context
-    cancel      context.CancelFunc
+    redisClient   *queue.RedisClient
+    assemblerID   string
+    kernelSize    int
+    pngLevel      png.CompressionLevel
+    jpegQuality   int           // for outputs of JPEG inputs (0 = common.DefaultJPEGQuality)
+    statsInterval time.Duration // how often checkpointMonitor saves stats (0 = never)
+    writeStats    func()        // saves stats for checkpointMonitor; saveStats unless a test counts calls
+    startTime     time.Time
+    imageMap      map[int]*ImageAssembly
+    saved         int // images saved and since dropped from imageMap, for stats
+    mutex         sync.RWMutex
+    slowest       *assemblyRate // lowest tiles/second of the images completed so far
+    slowestMutex  sync.Mutex
+    ctx           context.Context
+    cancel        context.CancelFunc
+}
+
+// assemblyRate is how fast one image's tiles were assembled
+type assemblyRate struct {
+    imageID     int
+    tiles       int
+    seconds     float64
+    tilesPerSec float64
+}
+
+// newAssemblyRate computes the tiles/second for an image whose tiles all arrived
+// between start, when the coordinator began it, and end
+func newAssemblyRate(imageID, tiles int, start, end time.Time) assemblyRate {
+    r := assemblyRate{imageID: imageID, tiles: tiles, seconds: end.Sub(start).Seconds()}
+    if r.seconds > 0 {
+        r.tilesPerSec = float64(tiles) / r.seconds
+    }
+    return r
 }
 
 type ImageAssembly struct {
@@ -29,19 +63,27 @@ type ImageAssembly struct {
     tilesReceived int
     processedTiles map[int]bool  // In-memory duplicate tracking
     completed     bool
+    completedAt   time.Time
     mutex         sync.Mutex
 }
 
-func NewAssembler(redisClient *queue.RedisClient, assemblerID string) *Assembler {
+func NewAssembler(redisClient *queue.RedisClient, assemblerID string, kernelSize int, pngLevel png.CompressionLevel, jpegQuality int, statsInterval time.Duration) *Assembler {
     ctx, cancel := context.WithCancel(context.Background())
     
-    return &Assembler{
-        redisClient: redisClient,
-        assemblerID: assemblerID,
-        imageMap:    make(map[int]*ImageAssembly),
-        ctx:         ctx,
-        cancel:      cancel,
+    a := &Assembler{
+        redisClient:   redisClient,
+        assemblerID:   assemblerID,
+        kernelSize:    kernelSize,
+        pngLevel:      pngLevel,
+        jpegQuality:   jpegQuality,
+        statsInterval: statsInterval,
+        startTime:     time.Now(),
+        imageMap:      make(map[int]*ImageAssembly),
+        ctx:           ctx,
+        cancel:        cancel,
     }
+    a.writeStats = a.saveStats
+    return a
 }
 
 func (a *Assembler) Start() {
@@ -94,11 +136,21 @@ func (a *Assembler) resultProcessor(wg *sync.WaitGroup) {
 }
 
 func (a *Assembler) processTile(tile *common.ProcessedImageTile) error {
+    // A tile whose data does not match its size would leave unwritten pixels; the
+    // error leaves its result unacked
+    if err := common.ValidateTileData(tile); err != nil {
+        return fmt.Errorf("rejected tile: %w", err)
+    }
     
     assembly, err := a.getOrCreateAssembly(tile.ImageID)
     if err != nil {
         return fmt.Errorf("failed to get assembly: %w", err)
     }
+    if assembly == nil {
+        // A late duplicate for an image already saved
+        log.Printf("Tile %d for image %d arrived after the image was saved (idempotent)", tile.TileID, tile.ImageID)
+        return nil
+    }
     
     assembly.mutex.Lock()
     defer assembly.mutex.Unlock()
@@ -116,11 +168,9 @@ func (a *Assembler) processTile(tile *common.ProcessedImageTile) error {
     // Mark tile as processed
     assembly.processedTiles[tile.TileID] = true
     
-    for y := 0; y < tile.Height && y < len(tile.Data); y++ {
-        for x := 0; x < tile.Width && x < len(tile.Data[y]); x++ {
-            assembly.outputImage.Set(tile.X+x, tile.Y+y, tile.Data[y][x])
-        }
-    }
+    // Results arrive on one goroutine, so placement stays under the lock; PlaceTile
+    // copies whole rows into Pix, which keeps this far cheaper than per-pixel Set
+    common.PlaceTile(assembly.outputImage, tile)
     
     assembly.tilesReceived++
     
@@ -129,15 +179,18 @@ func (a *Assembler) processTile(tile *common.ProcessedImageTile) error {
             return fmt.Errorf("failed to save image: %w", err)
         }
         assembly.completed = true
+        assembly.completedAt = time.Now()
+        assembly.outputImage = nil // saved; only late duplicates can still arrive
         
         // Mark image as completed in Redis
         if err := a.redisClient.MarkImageCompleted(tile.ImageID); err != nil {
             log.Printf("Warning: failed to mark image %d as completed in Redis: %v", tile.ImageID, err)
         }
         
-        duration := time.Since(assembly.info.StartTime).Seconds()
-        log.Printf("Image %d assembled: %d tiles in %.2fs", 
-            tile.ImageID, assembly.tilesReceived, duration)
+        rate := newAssemblyRate(tile.ImageID, assembly.tilesReceived, assembly.info.StartTime, time.Now())
+        log.Printf("Image %d assembled: %d tiles in %.2fs (%.1f tiles/s)", 
+            tile.ImageID, rate.tiles, rate.seconds, rate.tilesPerSec)
+        a.recordRate(rate)
     } else if assembly.tilesReceived%10 == 0 {
         log.Printf("Image %d progress: %d/%d tiles", 
             tile.ImageID, assembly.tilesReceived, assembly.info.ExpectedTiles)
@@ -146,19 +199,56 @@ func (a *Assembler) processTile(tile *common.ProcessedImageTile) error {
     return nil
 }
 
+// recordRate keeps rate if it is the slowest image assembled so far. A low rate here
+// while workers are idle points at the assembler rather than the workers.
+func (a *Assembler) recordRate(rate assemblyRate) {
+    a.slowestMutex.Lock()
+    defer a.slowestMutex.Unlock()
+    if a.slowest == nil || rate.tilesPerSec < a.slowest.tilesPerSec {
+        a.slowest = &rate
+    }
+}
+
+// logSlowest logs the slowest-assembling image of the run, if any has completed
+func (a *Assembler) logSlowest() {
+    a.slowestMutex.Lock()
+    defer a.slowestMutex.Unlock()
+    if a.slowest != nil {
+        log.Printf("Slowest assembly: image %d, %d tiles in %.2fs (%.1f tiles/s)",
+            a.slowest.imageID, a.slowest.tiles, a.slowest.seconds, a.slowest.tilesPerSec)
+    }
+}
+
+// getOrCreateAssembly returns the assembly for imageID, creating it on its first tile.
+// It returns nil while Redis marks the image completed: its tile is a late duplicate.
+// A coordinator storing a new image under the ID clears that mark, so a later run
+// reusing IDs starts a fresh assembly instead of having its tiles dropped.
 func (a *Assembler) getOrCreateAssembly(imageID int) (*ImageAssembly, error) {
     a.mutex.RLock()
-    if assembly, exists := a.imageMap[imageID]; exists {
-        a.mutex.RUnlock()
+    assembly, exists := a.imageMap[imageID]
+    a.mutex.RUnlock()
+    if exists && !assembly.isCompleted() {
         return assembly, nil
     }
-    a.mutex.RUnlock()
+    
+    // New to this assembler, or saved already (and maybe dropped by pruneCompleted)
+    done, err := a.redisClient.IsImageCompleted(imageID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to check image status: %w", err)
+    }
+    if done {
+        return nil, nil
+    }
     
     a.mutex.Lock()
     defer a.mutex.Unlock()
     
     if assembly, exists := a.imageMap[imageID]; exists {
-        return assembly, nil
+        if !assembly.isCompleted() {
+            return assembly, nil
+        }
+        // The ID was reused by a new run; the saved image only counts in stats now
+        a.saved++
     }
     
     info, err := a.redisClient.GetImageInfo(imageID)
@@ -166,7 +256,7 @@ func (a *Assembler) getOrCreateAssembly(imageID int) (*ImageAssembly, error) {
         return nil, fmt.Errorf("failed to get image info: %w", err)
     }
     
-    assembly := &ImageAssembly{
+    assembly = &ImageAssembly{
         info:           info,
         outputImage:    image.NewRGBA(image.Rect(0, 0, info.Width, info.Height)),
         tilesReceived:  0,
@@ -187,12 +277,19 @@ func (a *Assembler) saveImage(assembly *ImageAssembly) error {
     if err != nil {
         return fmt.Errorf("failed to create output file: %w", err)
     }
-    defer file.Close()
     
-    if err := png.Encode(file, assembly.outputImage); err != nil {
-        return fmt.Errorf("failed to encode PNG: %w", err)
+    if err := common.EncodeOutput(file, assembly.outputImage, assembly.info.Format, a.pngLevel, a.jpegQuality); err != nil {
+        file.Close()
+        return fmt.Errorf("failed to encode output: %w", err)
+    }
+    if err := file.Close(); err != nil {
+        return fmt.Errorf("failed to write output file: %w", err)
     }
     
+    // Carry the input's timestamp when the coordinator ran with -preserve-mtime
+    if err := common.ApplySourceModTime(assembly.info, assembly.info.OutputPath); err != nil {
+        return fmt.Errorf("failed to preserve modification time: %w", err)
+    }
     return nil
 }
 
@@ -202,27 +299,148 @@ func (a *Assembler) checkpointMonitor(wg *sync.WaitGroup) {
     ticker := time.NewTicker(10 * time.Second)
     defer ticker.Stop()
     
+    // Save aggregate stats periodically so a crash mid-run keeps the timings so far
+    var statsTick <-chan time.Time
+    if a.statsInterval > 0 {
+        statsTicker := time.NewTicker(a.statsInterval)
+        defer statsTicker.Stop()
+        statsTick = statsTicker.C
+    }
+    
+    // Tiles received per incomplete image at the previous tick, to spot stalls
+    lastProgress := make(map[int]int)
+    
     for {
         select {
         case <-a.ctx.Done():
+            if a.statsInterval > 0 {
+                a.writeStats()
+            }
+            a.logSlowest()
             return
+        case <-statsTick:
+            a.writeStats()
         case <-ticker.C:
             a.mutex.RLock()
             activeImages := len(a.imageMap)
             var incompleteCount int
+            stalled := make(map[int]map[int]bool)
             for _, assembly := range a.imageMap {
+                assembly.mutex.Lock()
                 if !assembly.completed {
                     incompleteCount++
                     log.Printf("Image %d progress: %d/%d tiles received",
                         assembly.info.ID, assembly.tilesReceived, assembly.info.ExpectedTiles)
+                    
+                    // No new tiles since the last check: snapshot what arrived to name the gaps
+                    if last, seen := lastProgress[assembly.info.ID]; seen && last == assembly.tilesReceived {
+                        received := make(map[int]bool, len(assembly.processedTiles))
+                        for id := range assembly.processedTiles {
+                            received[id] = true
+                        }
+                        stalled[assembly.info.ID] = received
+                    }
+                    lastProgress[assembly.info.ID] = assembly.tilesReceived
                 }
+                assembly.mutex.Unlock()
             }
             a.mutex.RUnlock()
             
+            for imageID, received := range stalled {
+                a.reportMissingTiles(imageID, received)
+            }
+            a.pruneCompleted(CompletedAssemblyGrace)
+            
             if activeImages > 0 {
                 log.Printf("Assembler status: %d active images, %d incomplete", 
                     activeImages, incompleteCount)
             }
         }
     }
-}
\ No newline at end of file
+}
+
+// pruneCompleted drops assemblies saved more than grace ago from imageMap, counting
+// them for stats, so a long-lived assembler's memory does not grow with every image
+// it has assembled. Later duplicates are recognised from the image's completed mark
+// in Redis instead.
+func (a *Assembler) pruneCompleted(grace time.Duration) {
+    a.mutex.Lock()
+    defer a.mutex.Unlock()
+    for imageID, assembly := range a.imageMap {
+        assembly.mutex.Lock()
+        if assembly.completed && time.Since(assembly.completedAt) > grace {
+            delete(a.imageMap, imageID)
+            a.saved++
+        }
+        assembly.mutex.Unlock()
+    }
+}
+
+// isCompleted reports whether the image has been saved
+func (asm *ImageAssembly) isCompleted() bool {
+    asm.mutex.Lock()
+    defer asm.mutex.Unlock()
+    return asm.completed
+}
+
+// reportMissingTiles logs the IDs of the tiles a stalled image is still waiting for,
+// using the expected tile-ID set the coordinator stored in Redis
+func (a *Assembler) reportMissingTiles(imageID int, received map[int]bool) {
+    expected, err := a.redisClient.GetExpectedTiles(imageID)
+    if err != nil {
+        log.Printf("Image %d stalled; failed to read expected tile IDs: %v", imageID, err)
+        return
+    }
+    if len(expected) == 0 {
+        log.Printf("Image %d stalled with %d tiles received; no expected tile IDs recorded yet", imageID, len(received))
+        return
+    }
+    
+    missing := missingTiles(expected, received)
+    log.Printf("Image %d stalled at %d/%d tiles, missing tile IDs: %v",
+        imageID, len(expected)-len(missing), len(expected), missing)
+}
+
+// missingTiles returns the expected tile IDs absent from received, in ascending order
+func missingTiles(expected []int, received map[int]bool) []int {
+    var missing []int
+    for _, id := range expected {
+        if !received[id] {
+            missing = append(missing, id)
+        }
+    }
+    sort.Ints(missing)
+    return missing
+}
+
+// saveStats writes the aggregate stats for the images completed so far. The file is
+// named after the assembler's start time, so every save overwrites the previous one.
+// Only the totals are written: a long-lived assembler drops saved images (see
+// pruneCompleted), so it no longer has every image's paths to list.
+func (a *Assembler) saveStats() {
+    a.mutex.RLock()
+    completed := a.saved
+    for _, assembly := range a.imageMap {
+        if assembly.isCompleted() {
+            completed++
+        }
+    }
+    a.mutex.RUnlock()
+    
+    if completed == 0 {
+        return
+    }
+    
+    totalTime := time.Since(a.startTime).Seconds()
+    results := []stats.PerformanceData{{
+        AlgorithmName:   "Multithreaded Microservice",
+        ImagesProcessed: completed,
+        KernelSize:      a.kernelSize,
+        TotalTime:       totalTime,
+        AverageTime:     totalTime / float64(completed),
+        Timestamp:       a.startTime,
+    }}
+    stats.WritePerformanceResultsWithOptions(results, "g_", stats.WriteOptions{SummaryOnly: true})
+    log.Printf("Assembler stats saved: %d images completed in %.2fs", completed, totalTime)
+}
+
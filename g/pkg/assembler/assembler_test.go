@@ -0,0 +1,111 @@
+package assembler
+
+import (
+    "reflect"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestMissingTiles(t *testing.T) {
+    tests := []struct {
+        name     string
+        expected []int
+        received map[int]bool
+        want     []int
+    }{
+        {"none received", []int{2, 0, 1}, nil, []int{0, 1, 2}},
+        {"all received", []int{0, 1, 2}, map[int]bool{0: true, 1: true, 2: true}, nil},
+        {"gaps", []int{5, 3, 1, 4, 2}, map[int]bool{1: true, 4: true}, []int{2, 3, 5}},
+        {"unexpected extras ignored", []int{0, 1}, map[int]bool{1: true, 7: true}, []int{0}},
+        {"nothing expected", nil, map[int]bool{0: true}, nil},
+    }
+    for _, tc := range tests {
+        if got := missingTiles(tc.expected, tc.received); !reflect.DeepEqual(got, tc.want) {
+            t.Errorf("%s: missingTiles = %v, want %v", tc.name, got, tc.want)
+        }
+    }
+}
+
+func TestNewAssemblyRate(t *testing.T) {
+    start := time.Unix(1000, 0)
+    r := newAssemblyRate(7, 40, start, start.Add(4*time.Second))
+    if r.imageID != 7 || r.tiles != 40 || r.seconds != 4 || r.tilesPerSec != 10 {
+        t.Errorf("newAssemblyRate = %+v, want 40 tiles in 4s at 10 tiles/s", r)
+    }
+
+    // A zero or negative span (clock skew between hosts) leaves the rate at zero
+    // rather than dividing by it
+    for _, end := range []time.Time{start, start.Add(-time.Second)} {
+        if r := newAssemblyRate(7, 40, start, end); r.tilesPerSec != 0 {
+            t.Errorf("span %v: tilesPerSec = %v, want 0", end.Sub(start), r.tilesPerSec)
+        }
+    }
+}
+
+func TestPruneCompleted(t *testing.T) {
+    a := NewAssembler(nil, "test", 3, 0, 90, 0)
+    now := time.Now()
+    a.imageMap[1] = &ImageAssembly{completed: true, completedAt: now.Add(-2 * time.Minute)}
+    a.imageMap[2] = &ImageAssembly{completed: true, completedAt: now.Add(-10 * time.Second)}
+    a.imageMap[3] = &ImageAssembly{processedTiles: map[int]bool{0: true}} // still assembling
+
+    // Only the image saved longer ago than the grace period is dropped; the recent one
+    // stays to absorb late duplicates
+    a.pruneCompleted(time.Minute)
+    if _, ok := a.imageMap[1]; ok {
+        t.Error("image 1, saved before the grace period, is still in imageMap")
+    }
+    for _, id := range []int{2, 3} {
+        if _, ok := a.imageMap[id]; !ok {
+            t.Errorf("image %d was dropped from imageMap", id)
+        }
+    }
+    if a.saved != 1 {
+        t.Errorf("saved = %d, want the dropped image counted once", a.saved)
+    }
+}
+
+func TestPeriodicStats(t *testing.T) {
+    a := NewAssembler(nil, "test", 3, 0, 90, 5*time.Millisecond)
+    var saves atomic.Int32
+    a.writeStats = func() { saves.Add(1) }
+
+    var wg sync.WaitGroup
+    wg.Add(1)
+    go a.checkpointMonitor(&wg)
+
+    // Several intervals must each produce a report, not just the first
+    deadline := time.Now().Add(5 * time.Second)
+    for saves.Load() < 3 && time.Now().Before(deadline) {
+        time.Sleep(time.Millisecond)
+    }
+    periodic := saves.Load()
+    a.Stop()
+    wg.Wait()
+
+    if periodic < 3 {
+        t.Fatalf("%d periodic stats reports in 5s at a 5ms interval, want at least 3", periodic)
+    }
+    if final := saves.Load(); final <= periodic {
+        t.Errorf("no stats report on shutdown: %d reports before Stop, %d after", periodic, final)
+    }
+}
+
+func TestNoPeriodicStatsWhenDisabled(t *testing.T) {
+    a := NewAssembler(nil, "test", 3, 0, 90, 0)
+    var saves atomic.Int32
+    a.writeStats = func() { saves.Add(1) }
+
+    var wg sync.WaitGroup
+    wg.Add(1)
+    go a.checkpointMonitor(&wg)
+    time.Sleep(20 * time.Millisecond)
+    a.Stop()
+    wg.Wait()
+
+    if n := saves.Load(); n != 0 {
+        t.Errorf("%d stats reports with a zero interval, want none", n)
+    }
+}
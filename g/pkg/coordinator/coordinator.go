@@ -3,26 +3,46 @@ package coordinator
 import (
     "fmt"
     "image"
-    "image/color"
+    "io"
     "log"
     "os"
+    "path/filepath"
+    "runtime/debug"
+    "strings"
     "sync"
     "time"
 
     "go-blur-mt/pkg/queue"
+    "studyguide.parallel/pkg/blur"
     "studyguide.parallel/pkg/common"
 )
 
+// EnqueueBatchSize is how many tile jobs are sent to Redis per pipelined round-trip
+const EnqueueBatchSize = 64
 
 type Coordinator struct {
-    redisClient *queue.RedisClient
-    kernelSize  int
+    redisClient   *queue.RedisClient
+    kernelSize    int
+    dither        bool
+    targetTiles   int // with > 0, size tiles per image to aim for this many (see common.AutoTileSize)
+    edgeMode      blur.EdgeMode
+    preserveMTime bool                // record each input's mtime for the assembler to give its output
+    kernels       common.ImageKernels // per-image kernel sizes overriding kernelSize (nil for none)
+
+    // decode reads an input image; image.Decode unless a test injects a failing one
+    decode func(io.Reader) (image.Image, string, error)
 }
 
-func NewCoordinator(redisClient *queue.RedisClient, kernelSize int) *Coordinator {
+func NewCoordinator(redisClient *queue.RedisClient, kernelSize int, dither bool, targetTiles int, edgeMode blur.EdgeMode, preserveMTime bool, kernels common.ImageKernels) *Coordinator {
     return &Coordinator{
-        redisClient: redisClient,
-        kernelSize:  kernelSize,
+        redisClient:   redisClient,
+        kernelSize:    kernelSize,
+        dither:        dither,
+        targetTiles:   targetTiles,
+        edgeMode:      edgeMode,
+        preserveMTime: preserveMTime,
+        kernels:       kernels,
+        decode:        image.Decode,
     }
 }
 
@@ -30,18 +50,25 @@ func (c *Coordinator) ProcessImage(imageID int, inputPath, outputPath string) er
     log.Printf("Coordinator: Processing image %d from %s", imageID, inputPath)
     startTime := time.Now()
     
-    img, err := c.loadImage(inputPath)
+    img, format, err := c.loadImage(inputPath)
     if err != nil {
         return fmt.Errorf("failed to load image: %w", err)
     }
+    // The output keeps the input's format, so swap the extension ProcessImages chose
+    outputPath = strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + common.OutputExt(format)
+    
+    kernelSize := c.kernels.For(inputPath, c.kernelSize)
     
     bounds := img.Bounds()
     width := bounds.Dx()
     height := bounds.Dy()
     
-    tilesX := (width + common.TILE_SIZE - 1) / common.TILE_SIZE
-    tilesY := (height + common.TILE_SIZE - 1) / common.TILE_SIZE
-    expectedTiles := tilesX * tilesY
+    tileSize := common.TILE_SIZE
+    if c.targetTiles > 0 {
+        tileSize = common.AutoTileSize(width, height, c.targetTiles)
+    }
+    
+    expectedTiles := common.TileCount(width, height, tileSize)
     
     imageInfo := &common.ImageInfo{
         ID:            imageID,
@@ -50,16 +77,28 @@ func (c *Coordinator) ProcessImage(imageID int, inputPath, outputPath string) er
         Width:         width,
         Height:        height,
         ExpectedTiles: expectedTiles,
+        TileSize:      tileSize,
+        EdgeMode:      c.edgeMode,
+        Format:        format,
+        KernelSize:    kernelSize,
         StartTime:     startTime,
     }
+    if c.preserveMTime {
+        // A missing mtime only costs the output its timestamp, not the image
+        if mtime, err := common.InputModTime(inputPath); err != nil {
+            log.Printf("Coordinator: Not preserving the modification time of %s: %v", inputPath, err)
+        } else {
+            imageInfo.SourceModTime = &mtime
+        }
+    }
     
     if err := c.redisClient.StoreImageInfo(imageInfo); err != nil {
         return fmt.Errorf("failed to store image info: %w", err)
     }
     
-    log.Printf("Coordinator: Image %d (%dx%d) will generate %d tiles", imageID, width, height, expectedTiles)
+    log.Printf("Coordinator: Image %d (%dx%d) will generate %d tiles of %dpx (kernel %d)", imageID, width, height, expectedTiles, tileSize, kernelSize)
     
-    if err := c.partitionAndQueue(imageID, img); err != nil {
+    if err := c.partitionAndQueue(imageID, img, tileSize, kernelSize); err != nil {
         return fmt.Errorf("failed to partition image: %w", err)
     }
     
@@ -79,7 +118,7 @@ func (c *Coordinator) ProcessImages(imagePaths []string, outputDir string) error
             defer wg.Done()
             
             outputPath := fmt.Sprintf("%s/img%d_blurred.png", outputDir, id+1)
-            if err := c.ProcessImage(id, path, outputPath); err != nil {
+            if err := c.processImageSafely(id, path, outputPath); err != nil {
                 errors <- fmt.Errorf("image %d: %w", id, err)
             }
         }(i, inputPath)
@@ -90,6 +129,7 @@ func (c *Coordinator) ProcessImages(imagePaths []string, outputDir string) error
     
     var allErrors []error
     for err := range errors {
+        log.Printf("Coordinator: Failed %v", err)
         allErrors = append(allErrors, err)
     }
     
@@ -100,93 +140,76 @@ func (c *Coordinator) ProcessImages(imagePaths []string, outputDir string) error
     return nil
 }
 
-func (c *Coordinator) loadImage(path string) (*image.RGBA, error) {
+// processImageSafely runs ProcessImage, turning a panic (a decoder bug on a malformed
+// file, say) into that image's error so the other images still get queued
+func (c *Coordinator) processImageSafely(imageID int, inputPath, outputPath string) (err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            log.Printf("Coordinator: Recovered from panic on image %d (%s): %v\n%s", imageID, inputPath, r, debug.Stack())
+            err = fmt.Errorf("panic processing %s: %v", inputPath, r)
+        }
+    }()
+    return c.ProcessImage(imageID, inputPath, outputPath)
+}
+
+// loadImage decodes an input and returns it with its format, which the output keeps
+func (c *Coordinator) loadImage(path string) (*image.RGBA, string, error) {
     file, err := os.Open(path)
     if err != nil {
-        return nil, err
+        return nil, "", err
     }
     defer file.Close()
     
-    img, _, err := image.Decode(file)
+    img, format, err := c.decode(file)
     if err != nil {
-        return nil, err
+        return nil, "", err
     }
     
-    bounds := img.Bounds()
-    rgba := image.NewRGBA(bounds)
-    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-        for x := bounds.Min.X; x < bounds.Max.X; x++ {
-            rgba.Set(x, y, img.At(x, y))
-        }
-    }
-    
-    return rgba, nil
+    return blur.ToRGBA(img), format, nil
 }
 
-func (c *Coordinator) partitionAndQueue(imageID int, img *image.RGBA) error {
-    bounds := img.Bounds()
-    padding := c.kernelSize / 2
-    tileID := 0
-    
-    for y := bounds.Min.Y; y < bounds.Max.Y; y += common.TILE_SIZE {
-        for x := bounds.Min.X; x < bounds.Max.X; x += common.TILE_SIZE {
-            tileWidth := common.TILE_SIZE
-            if x+common.TILE_SIZE > bounds.Max.X {
-                tileWidth = bounds.Max.X - x
-            }
-            
-            tileHeight := common.TILE_SIZE
-            if y+common.TILE_SIZE > bounds.Max.Y {
-                tileHeight = bounds.Max.Y - y
-            }
-            
-            tile := c.extractTileWithPadding(img, imageID, tileID, x, y, tileWidth, tileHeight, padding)
-            
-            job := &common.JobMessage{
-                Type:      "tile",
-                ImageTile: tile,
-            }
-            
-            if _, err := c.redisClient.AddJob(job); err != nil {
-                return fmt.Errorf("failed to queue tile %d: %w", tileID, err)
+func (c *Coordinator) partitionAndQueue(imageID int, img *image.RGBA, tileSize, kernelSize int) error {
+    var tileIDs []int
+    
+    // Tiles are sent in pipelined batches to avoid one Redis round-trip per tile
+    batch := make([]*common.JobMessage, 0, EnqueueBatchSize)
+    flush := func() error {
+        if len(batch) == 0 {
+            return nil
+        }
+        if _, err := c.redisClient.AddJobs(batch); err != nil {
+            first := batch[0].ImageTile.TileID
+            return fmt.Errorf("failed to queue tiles %d-%d: %w", first, first+len(batch)-1, err)
+        }
+        batch = batch[:0]
+        return nil
+    }
+    
+    // Workers blur each tile with the kernel its padding was cut for
+    next := common.TileSource(img, imageID, tileSize, kernelSize, c.edgeMode, c.dither)
+    for tile := next(); tile != nil; tile = next() {
+        batch = append(batch, &common.JobMessage{
+            Type:      "tile",
+            ImageTile: tile,
+        })
+        tileIDs = append(tileIDs, tile.TileID)
+        
+        if len(batch) >= EnqueueBatchSize {
+            if err := flush(); err != nil {
+                return err
             }
-            
-            tileID++
         }
     }
     
-    return nil
-}
-
-func (c *Coordinator) extractTileWithPadding(img *image.RGBA, imageID, tileID, tileX, tileY, tileWidth, tileHeight, padding int) *common.ImageTile {
-    bounds := img.Bounds()
-    
-    startX := max(bounds.Min.X, tileX-padding)
-    startY := max(bounds.Min.Y, tileY-padding)
-    endX := min(bounds.Max.X, tileX+tileWidth+padding)
-    endY := min(bounds.Max.Y, tileY+tileHeight+padding)
-    
-    paddedWidth := endX - startX
-    paddedHeight := endY - startY
-    
-    data := make([][]color.RGBA, paddedHeight)
-    for y := 0; y < paddedHeight; y++ {
-        data[y] = make([]color.RGBA, paddedWidth)
-        for x := 0; x < paddedWidth; x++ {
-            data[y][x] = img.RGBAAt(startX+x, startY+y)
-        }
+    if err := flush(); err != nil {
+        return err
     }
     
-    return &common.ImageTile{
-        ImageID: imageID,
-        TileID:  tileID,
-        X:       tileX,
-        Y:       tileY,
-        Width:   tileWidth,
-        Height:  tileHeight,
-        Data:    data,
-        Padding: padding,
+    // Record the full expected set so a stalled assembly can report which tiles are missing
+    if err := c.redisClient.StoreExpectedTiles(imageID, tileIDs); err != nil {
+        return fmt.Errorf("failed to store expected tile IDs: %w", err)
     }
+    return nil
 }
 
 func max(a, b int) int {
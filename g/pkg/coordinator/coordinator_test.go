@@ -0,0 +1,94 @@
+package coordinator
+
+import (
+    "bytes"
+    "image"
+    "image/png"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "github.com/alicebob/miniredis/v2"
+    "go-blur-mt/pkg/queue"
+    "studyguide.parallel/pkg/blur"
+    "studyguide.parallel/pkg/common"
+)
+
+// writePNG saves a blank width x height PNG at path
+func writePNG(t *testing.T, path string, width, height int) {
+    t.Helper()
+    f, err := os.Create(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    if err := png.Encode(f, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestPanickingImageFailsAlone(t *testing.T) {
+    server := miniredis.RunT(t)
+    client, err := queue.NewRedisClient(server.Addr(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { client.Close() })
+    if err := client.EnsureGroups(); err != nil {
+        t.Fatal(err)
+    }
+
+    dir := t.TempDir()
+    var paths []string
+    for _, name := range []string{"a.png", "bad.png", "c.png"} {
+        path := filepath.Join(dir, name)
+        writePNG(t, path, 40, 30)
+        paths = append(paths, path)
+    }
+
+    c := NewCoordinator(client, 5, false, 0, blur.EdgeClamp, false, nil)
+    c.decode = func(r io.Reader) (image.Image, string, error) {
+        if f, ok := r.(*os.File); ok && filepath.Base(f.Name()) == "bad.png" {
+            panic("decoder bug")
+        }
+        return image.Decode(r)
+    }
+
+    var logs bytes.Buffer
+    log.SetOutput(&logs)
+    t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+    err = c.ProcessImages(paths, t.TempDir())
+    if err == nil || !strings.Contains(err.Error(), "failed to process 1 images") {
+        t.Fatalf("ProcessImages = %v, want the one panicking image reported as failed", err)
+    }
+    out := logs.String()
+    if !strings.Contains(out, "Recovered from panic on image 1") || !strings.Contains(out, "decoder bug") {
+        t.Errorf("log does not report the recovered panic:\n%s", out)
+    }
+    if !strings.Contains(out, "Failed image 1: panic processing "+paths[1]) {
+        t.Errorf("log does not mark image 1 failed:\n%s", out)
+    }
+
+    // The other images are still stored and fully queued
+    for _, id := range []int{0, 2} {
+        info, err := client.GetImageInfo(id)
+        if err != nil {
+            t.Fatalf("image %d has no info after the panic: %v", id, err)
+        }
+        tiles, err := client.GetExpectedTiles(id)
+        if err != nil || len(tiles) != info.ExpectedTiles {
+            t.Errorf("image %d queued %d tiles, %v; want %d", id, len(tiles), err, info.ExpectedTiles)
+        }
+    }
+    if _, err := client.GetImageInfo(1); err == nil {
+        t.Error("the panicking image stored its info")
+    }
+    jobs, err := server.Stream("mt:jobs")
+    if err != nil || len(jobs) != 2*common.TileCount(40, 30, common.TILE_SIZE) {
+        t.Errorf("mt:jobs holds %d jobs, %v; want the tiles of the two good images", len(jobs), err)
+    }
+}
@@ -10,21 +10,61 @@ import (
 
     "go-blur-mt/pkg/queue"
     "studyguide.parallel/pkg/common"
-    "studyguide.parallel/pkg/blur"
 )
 
 
+// DrainTimeout is how long Stop waits for workers to finish and ack the tile they are
+// on before giving up and leaving it for redelivery
+const DrainTimeout = 30 * time.Second
+
+// StaleJobTimeout is how long a job may sit unacked before the retry monitor claims it
+// for the pool's workers to process again
+const StaleJobTimeout = 30 * time.Second
+
 type WorkerPool struct {
-    redisClient   *queue.RedisClient
+    redisClient   jobQueue
     numWorkers    int
     kernelSize    int
-    kernel        [][]float64
     workerID      string
     tilesProcessed atomic.Int64
+    inFlight      atomic.Int64
+    started       atomic.Bool
+    retryEvery    time.Duration // how often the retry monitor claims stale jobs
+    staleAfter    time.Duration // idle time after which a job is claimed
+    done          chan struct{}
     ctx           context.Context
     cancel        context.CancelFunc
 }
 
+// jobQueue is the part of queue.RedisClient the pool uses
+type jobQueue interface {
+    common.Queue
+    ClaimStaleJobs(consumer string, minIdle time.Duration, count int) ([]string, error)
+}
+
+var _ jobQueue = (*queue.RedisClient)(nil)
+
+// drainQueue wraps the pool's client for one worker and counts that worker's tile as
+// in flight from the moment a job is read until the worker asks for the next one
+type drainQueue struct {
+    common.Queue
+    pool *WorkerPool
+    busy bool
+}
+
+func (q *drainQueue) ReadJob(consumer string, block time.Duration) (string, *common.JobMessage, error) {
+    if q.busy {
+        q.busy = false
+        q.pool.inFlight.Add(-1)
+    }
+    id, job, err := q.Queue.ReadJob(consumer, block)
+    if err == nil && job != nil && job.Type == "tile" {
+        q.busy = true
+        q.pool.inFlight.Add(1)
+    }
+    return id, job, err
+}
+
 func NewWorkerPool(redisClient *queue.RedisClient, numWorkers, kernelSize int, workerID string) *WorkerPool {
     ctx, cancel := context.WithCancel(context.Background())
     
@@ -32,14 +72,17 @@ func NewWorkerPool(redisClient *queue.RedisClient, numWorkers, kernelSize int, w
         redisClient: redisClient,
         numWorkers:  numWorkers,
         kernelSize:  kernelSize,
-        kernel:      blur.GenerateGaussianKernel(kernelSize),
         workerID:    workerID,
+        retryEvery:  StaleJobTimeout,
+        staleAfter:  StaleJobTimeout,
+        done:        make(chan struct{}),
         ctx:         ctx,
         cancel:      cancel,
     }
 }
 
 func (wp *WorkerPool) Start() {
+    wp.started.Store(true)
     var wg sync.WaitGroup
     
     for i := 0; i < wp.numWorkers; i++ {
@@ -52,11 +95,29 @@ func (wp *WorkerPool) Start() {
     
     log.Printf("WorkerPool: Started %d workers", wp.numWorkers)
     wg.Wait()
+    close(wp.done)
 }
 
+// Stop cancels the pool and lets each worker finish and ack its current tile, waiting
+// up to DrainTimeout. Tiles still in flight after that are abandoned unacked: they are
+// only processed again once a running pool's retry monitor claims them, StaleJobTimeout
+// after they were read, so with no other pool running they wait for the next one to
+// start. A pool that was never started has nothing to drain.
 func (wp *WorkerPool) Stop() {
-    log.Println("WorkerPool: Shutting down...")
+    if !wp.started.Load() {
+        wp.cancel()
+        return
+    }
+    log.Printf("WorkerPool: Shutting down, draining %d in-flight tiles...", wp.inFlight.Load())
     wp.cancel()
+
+    select {
+    case <-wp.done:
+        log.Printf("WorkerPool: Drained; processed %d tiles total", wp.tilesProcessed.Load())
+    case <-time.After(DrainTimeout):
+        log.Printf("WorkerPool: Drain timed out after %s; abandoned %d in-flight tiles, processed %d tiles total",
+            DrainTimeout, wp.inFlight.Load(), wp.tilesProcessed.Load())
+    }
 }
 
 func (wp *WorkerPool) worker(id int, wg *sync.WaitGroup) {
@@ -65,80 +126,31 @@ func (wp *WorkerPool) worker(id int, wg *sync.WaitGroup) {
     consumer := fmt.Sprintf("%s-worker-%d", wp.workerID, id)
     log.Printf("Worker %d started as consumer %s", id, consumer)
     
-    for {
-        select {
-        case <-wp.ctx.Done():
-            log.Printf("Worker %d shutting down", id)
-            return
-        default:
-            msgID, job, err := wp.redisClient.ReadJob(consumer, 5*time.Second)
-            if err != nil {
-                if err.Error() != "redis: nil" {
-                    log.Printf("Worker %d read error: %v", id, err)
-                }
-                continue
-            }
-            
-            if job == nil {
-                continue
-            }
-            
-            if job.Type != "tile" || job.ImageTile == nil {
-                log.Printf("Worker %d: invalid job type", id)
-                _ = wp.redisClient.AckJob(msgID)
-                continue
-            }
-            
-            if err := wp.processTile(job.ImageTile, msgID); err != nil {
-                log.Printf("Worker %d failed to process tile: %v", id, err)
-                // Don't ACK the message - let it be reclaimed after visibility timeout
-            } else {
-                _ = wp.redisClient.AckJob(msgID)
-                wp.tilesProcessed.Add(1)
-                
-                if count := wp.tilesProcessed.Load(); count%100 == 0 {
-                    log.Printf("WorkerPool: Processed %d tiles total", count)
-                }
-            }
+    // Failed tiles are left unacked for the retry monitor to claim and hand back out,
+    // until the queue dead-letters them after queue.MaxDeliveries tries
+    q := &drainQueue{Queue: wp.redisClient, pool: wp}
+    common.RunWorker(wp.ctx, q, wp.kernelSize, consumer, 5*time.Second, func(*common.ProcessedImageTile) {
+        // Acked, so no longer in flight even if the worker now stops
+        q.busy = false
+        wp.inFlight.Add(-1)
+        if count := wp.tilesProcessed.Add(1); count%100 == 0 {
+            log.Printf("WorkerPool: Processed %d tiles total", count)
         }
-    }
-}
-
-func (wp *WorkerPool) processTile(tile *common.ImageTile, msgID string) error {
-    startTime := time.Now()
-    
-    blurred := blur.ApplyBlurToTile(tile.Data, wp.kernel)
+    })
     
-    center := blur.ExtractCenter(blurred, tile.Padding, tile.Width, tile.Height)
-    
-    processed := &common.ProcessedImageTile{
-        ImageID: tile.ImageID,
-        TileID:  tile.TileID,
-        X:       tile.X,
-        Y:       tile.Y,
-        Width:   tile.Width,
-        Height:  tile.Height,
-        Data:    center,
+    if q.busy {
+        wp.inFlight.Add(-1)
     }
-    
-    result := &common.ResultMessage{
-        ProcessedTile: processed,
-        WorkerID:      wp.workerID,
-        ProcessTime:   time.Since(startTime).Seconds(),
-    }
-    
-    if _, err := wp.redisClient.AddResult(result); err != nil {
-        return fmt.Errorf("failed to add result: %w", err)
-    }
-    
-    return nil
+    log.Printf("Worker %d shutting down", id)
 }
 
 
+// retryMonitor claims jobs left unacked for staleAfter, whether their blur failed or
+// their worker died, and the queue hands them to this pool's workers' next reads
 func (wp *WorkerPool) retryMonitor(wg *sync.WaitGroup) {
     defer wg.Done()
     
-    ticker := time.NewTicker(30 * time.Second)
+    ticker := time.NewTicker(wp.retryEvery)
     defer ticker.Stop()
     
     consumer := fmt.Sprintf("%s-retry-monitor", wp.workerID)
@@ -148,10 +160,10 @@ func (wp *WorkerPool) retryMonitor(wg *sync.WaitGroup) {
         case <-wp.ctx.Done():
             return
         case <-ticker.C:
-            claimedIDs, err := wp.redisClient.ClaimStaleJobs(consumer, 30*time.Second, 50)
+            // Jobs that did claim are queued for retry even if others failed to
+            claimedIDs, err := wp.redisClient.ClaimStaleJobs(consumer, wp.staleAfter, 50)
             if err != nil {
                 log.Printf("Failed to claim stale jobs: %v", err)
-                continue
             }
             
             if len(claimedIDs) > 0 {
@@ -0,0 +1,176 @@
+package processor
+
+import (
+    "image/color"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/alicebob/miniredis/v2"
+    "go-blur-mt/pkg/queue"
+    "studyguide.parallel/pkg/common"
+)
+
+// slowQueue hands out one tile and holds its result until release is closed, so a
+// test can stop the pool while the tile is in flight
+type slowQueue struct {
+    publishing chan struct{} // closed once the tile's result is being added
+    release    chan struct{}
+
+    mu    sync.Mutex
+    read  bool
+    acked []string
+}
+
+func (q *slowQueue) ReadJob(consumer string, block time.Duration) (string, *common.JobMessage, error) {
+    q.mu.Lock()
+    first := !q.read
+    q.read = true
+    q.mu.Unlock()
+    if !first {
+        time.Sleep(block)
+        return "", nil, nil
+    }
+    data := make([][]color.RGBA, 3)
+    for y := range data {
+        data[y] = make([]color.RGBA, 3)
+    }
+    tile := &common.ImageTile{Width: 1, Height: 1, Data: data, Padding: 1, KernelSize: 3}
+    return "1-0", &common.JobMessage{Type: "tile", ImageTile: tile}, nil
+}
+
+func (q *slowQueue) AddResult(*common.ResultMessage) (string, error) {
+    close(q.publishing)
+    <-q.release
+    return "2-0", nil
+}
+
+func (q *slowQueue) AckJob(id string) error {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    q.acked = append(q.acked, id)
+    return nil
+}
+
+func (q *slowQueue) ClaimStaleJobs(string, time.Duration, int) ([]string, error) {
+    return nil, nil
+}
+
+func TestStopDrainsInFlightTile(t *testing.T) {
+    q := &slowQueue{publishing: make(chan struct{}), release: make(chan struct{})}
+    wp := NewWorkerPool(nil, 1, 3, "test")
+    wp.redisClient = q
+    go wp.Start()
+    <-q.publishing
+
+    stopped := make(chan struct{})
+    go func() {
+        wp.Stop()
+        close(stopped)
+    }()
+    select {
+    case <-stopped:
+        t.Fatal("Stop returned while a tile was still being published")
+    case <-time.After(50 * time.Millisecond):
+    }
+    close(q.release)
+    select {
+    case <-stopped:
+    case <-time.After(5 * time.Second):
+        t.Fatal("Stop did not return once the in-flight tile finished")
+    }
+
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if len(q.acked) != 1 || q.acked[0] != "1-0" {
+        t.Errorf("acked %v, want the in-flight tile 1-0", q.acked)
+    }
+    if got := wp.tilesProcessed.Load(); got != 1 {
+        t.Errorf("tilesProcessed = %d, want 1", got)
+    }
+    if got := wp.inFlight.Load(); got != 0 {
+        t.Errorf("inFlight = %d after the drain, want 0", got)
+    }
+}
+
+func TestStopWithoutStart(t *testing.T) {
+    wp := NewWorkerPool(nil, 1, 3, "test")
+    stopped := make(chan struct{})
+    go func() {
+        wp.Stop()
+        close(stopped)
+    }()
+    select {
+    case <-stopped:
+    case <-time.After(time.Second):
+        t.Fatal("Stop waited for workers that were never started")
+    }
+}
+
+// countingQueue records how often each job is read, and shortens the workers' blocking
+// reads so jobs the retry monitor claims are picked up without waiting out the block
+type countingQueue struct {
+    *queue.RedisClient
+
+    mu    sync.Mutex
+    reads map[string]int
+}
+
+func (q *countingQueue) ReadJob(consumer string, block time.Duration) (string, *common.JobMessage, error) {
+    id, job, err := q.RedisClient.ReadJob(consumer, 10*time.Millisecond)
+    if job != nil {
+        q.mu.Lock()
+        q.reads[id]++
+        q.mu.Unlock()
+    }
+    return id, job, err
+}
+
+func TestPanickedTileIsRetriedThenDeadLettered(t *testing.T) {
+    server := miniredis.RunT(t)
+    client, err := queue.NewRedisClient(server.Addr(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer client.Close()
+    if err := client.EnsureGroups(); err != nil {
+        t.Fatal(err)
+    }
+
+    // A negative height in the header panics cutting out the centre every time
+    data := [][]color.RGBA{make([]color.RGBA, 3), make([]color.RGBA, 3), make([]color.RGBA, 3)}
+    id, err := client.AddJob(&common.JobMessage{Type: "tile", ImageTile: &common.ImageTile{Width: 1, Height: -1, Data: data, Padding: 1, KernelSize: 3}})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    q := &countingQueue{RedisClient: client, reads: map[string]int{}}
+    wp := NewWorkerPool(client, 1, 3, "test")
+    wp.redisClient = q
+    wp.retryEvery = 10 * time.Millisecond
+    wp.staleAfter = 20 * time.Millisecond
+    go wp.Start()
+    defer wp.Stop()
+
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        if dead, _ := server.Stream("mt:dlq:jobs"); len(dead) > 0 {
+            break
+        }
+        if time.Now().After(deadline) {
+            q.mu.Lock()
+            defer q.mu.Unlock()
+            t.Fatalf("the panicking tile was not dead-lettered; it was read %d times", q.reads[id])
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if q.reads[id] != queue.MaxDeliveries {
+        t.Errorf("the panicking tile was read %d times, want it retried up to %d", q.reads[id], queue.MaxDeliveries)
+    }
+    if got := wp.tilesProcessed.Load(); got != 0 {
+        t.Errorf("tilesProcessed = %d for a tile that never blurred", got)
+    }
+}
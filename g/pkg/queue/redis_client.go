@@ -2,7 +2,9 @@ package queue
 
 import (
     "context"
+    "crypto/tls"
     "encoding/json"
+    "errors"
     "fmt"
     "time"
 
@@ -10,14 +12,25 @@ import (
     "studyguide.parallel/pkg/common"
 )
 
+// MaxDeliveries is how many times a job is handed to a worker before ClaimStaleJobs
+// moves it to mt:dlq:jobs instead of claiming it for another try
+const MaxDeliveries = 3
+
 type RedisClient struct {
     client *redis.Client
     ctx    context.Context
+
+    claimed common.ClaimedJobs // jobs taken over by ClaimStaleJobs, returned by the next reads
 }
 
-func NewRedisClient(addr string) (*RedisClient, error) {
+// RedisClient feeds the shared worker loop in pkg/common
+var _ common.Queue = (*RedisClient)(nil)
+
+// NewRedisClient connects to the Redis at addr, over TLS when tlsConfig is non-nil
+func NewRedisClient(addr string, tlsConfig *tls.Config) (*RedisClient, error) {
     client := redis.NewClient(&redis.Options{
         Addr:         addr,
+        TLSConfig:    tlsConfig,
         MaxRetries:   3,
         DialTimeout:  5 * time.Second,
         ReadTimeout:  3 * time.Second,
@@ -43,6 +56,10 @@ func (r *RedisClient) jobsStream() string {
     return "mt:jobs"
 }
 
+func (r *RedisClient) dlqJobsStream() string {
+    return "mt:dlq:jobs"
+}
+
 func (r *RedisClient) resultsStream() string {
     return "mt:results"
 }
@@ -55,6 +72,10 @@ func (r *RedisClient) imageStatusKey(imageID int) string {
     return fmt.Sprintf("mt:image:%d:status", imageID)
 }
 
+func (r *RedisClient) imageTilesKey(imageID int) string {
+    return fmt.Sprintf("mt:image:%d:tiles", imageID)
+}
+
 
 func (r *RedisClient) EnsureGroups() error {
     _ = r.client.XGroupCreateMkStream(r.ctx, r.jobsStream(), "workers", "$").Err()
@@ -76,6 +97,33 @@ func (r *RedisClient) AddJob(job *common.JobMessage) (string, error) {
     return result.Val(), result.Err()
 }
 
+// AddJobs enqueues a batch of jobs in one pipelined round-trip and returns their stream IDs in order
+func (r *RedisClient) AddJobs(jobs []*common.JobMessage) ([]string, error) {
+    pipe := r.client.Pipeline()
+    cmds := make([]*redis.StringCmd, 0, len(jobs))
+    
+    for _, job := range jobs {
+        b, err := json.Marshal(job)
+        if err != nil {
+            return nil, err
+        }
+        cmds = append(cmds, pipe.XAdd(r.ctx, &redis.XAddArgs{
+            Stream: r.jobsStream(),
+            Values: map[string]interface{}{"data": b},
+        }))
+    }
+    
+    if _, err := pipe.Exec(r.ctx); err != nil {
+        return nil, err
+    }
+    
+    ids := make([]string, len(cmds))
+    for i, cmd := range cmds {
+        ids[i] = cmd.Val()
+    }
+    return ids, nil
+}
+
 func (r *RedisClient) AddResult(res *common.ResultMessage) (string, error) {
     b, err := json.Marshal(res)
     if err != nil {
@@ -90,7 +138,12 @@ func (r *RedisClient) AddResult(res *common.ResultMessage) (string, error) {
     return result.Val(), result.Err()
 }
 
+// ReadJob returns a job claimed by ClaimStaleJobs if there is one, and otherwise reads
+// the next new job
 func (r *RedisClient) ReadJob(consumer string, block time.Duration) (string, *common.JobMessage, error) {
+    if ids, jobs := r.claimed.Take(1); len(jobs) > 0 {
+        return ids[0], jobs[0], nil
+    }
     result, err := r.client.XReadGroup(r.ctx, &redis.XReadGroupArgs{
         Group:    "workers",
         Consumer: consumer,
@@ -99,6 +152,10 @@ func (r *RedisClient) ReadJob(consumer string, block time.Duration) (string, *co
         Block:    block,
     }).Result()
     
+    // A block timeout is not an error for callers polling the stream
+    if err == redis.Nil {
+        return "", nil, nil
+    }
     if err != nil || len(result) == 0 || len(result[0].Messages) == 0 {
         return "", nil, err
     }
@@ -146,12 +203,18 @@ func (r *RedisClient) AckResult(id string) error {
     return r.client.XAck(r.ctx, r.resultsStream(), "assemblers", id).Err()
 }
 
+// StoreImageInfo stores an image's info and clears any completed mark left under its
+// ID, so a run that reuses image IDs is not taken for late duplicates of the last one
 func (r *RedisClient) StoreImageInfo(info *common.ImageInfo) error {
     b, err := json.Marshal(info)
     if err != nil {
         return err
     }
-    return r.client.Set(r.ctx, r.imageInfoKey(info.ID), b, 24*time.Hour).Err()
+    pipe := r.client.TxPipeline()
+    pipe.Set(r.ctx, r.imageInfoKey(info.ID), b, 24*time.Hour)
+    pipe.Del(r.ctx, r.imageStatusKey(info.ID))
+    _, err = pipe.Exec(r.ctx)
+    return err
 }
 
 func (r *RedisClient) GetImageInfo(imageID int) (*common.ImageInfo, error) {
@@ -168,6 +231,42 @@ func (r *RedisClient) GetImageInfo(imageID int) (*common.ImageInfo, error) {
     return &info, nil
 }
 
+// StoreExpectedTiles records every tile ID the coordinator emitted for an image, so the
+// assembler can name the tiles that are missing rather than just count them
+func (r *RedisClient) StoreExpectedTiles(imageID int, tileIDs []int) error {
+    if len(tileIDs) == 0 {
+        return nil
+    }
+    members := make([]interface{}, len(tileIDs))
+    for i, id := range tileIDs {
+        members[i] = id
+    }
+    
+    pipe := r.client.TxPipeline()
+    pipe.SAdd(r.ctx, r.imageTilesKey(imageID), members...)
+    pipe.Expire(r.ctx, r.imageTilesKey(imageID), 24*time.Hour)
+    _, err := pipe.Exec(r.ctx)
+    return err
+}
+
+// GetExpectedTiles returns the tile IDs stored by StoreExpectedTiles, or nil if none were
+func (r *RedisClient) GetExpectedTiles(imageID int) ([]int, error) {
+    members, err := r.client.SMembers(r.ctx, r.imageTilesKey(imageID)).Result()
+    if err != nil {
+        return nil, err
+    }
+    
+    tileIDs := make([]int, 0, len(members))
+    for _, m := range members {
+        var id int
+        if _, err := fmt.Sscanf(m, "%d", &id); err != nil {
+            return nil, fmt.Errorf("bad tile ID %q: %w", m, err)
+        }
+        tileIDs = append(tileIDs, id)
+    }
+    return tileIDs, nil
+}
+
 func (r *RedisClient) MarkImageCompleted(imageID int) error {
     return r.client.Set(r.ctx, r.imageStatusKey(imageID), "completed", 0).Err()
 }
@@ -184,6 +283,13 @@ func (r *RedisClient) IsImageCompleted(imageID int) (bool, error) {
 }
 
 
+// ClaimStaleJobs takes over up to count jobs that have sat unacked for at least
+// minIdle, such as tiles whose blur failed or whose worker died, and returns the IDs of
+// those it will retry. XCLAIM alone only moves them into consumer's pending list, where
+// reads of new entries never see them, so the claimed jobs are also kept and handed out
+// by this client's next ReadJob calls. Jobs already delivered MaxDeliveries times are
+// moved to mt:dlq:jobs and acked instead. An entry that does not decode stays pending
+// and is dead-lettered once it has been claimed often enough.
 func (r *RedisClient) ClaimStaleJobs(consumer string, minIdle time.Duration, count int) ([]string, error) {
     pending, err := r.client.XPendingExt(r.ctx, &redis.XPendingExtArgs{
         Stream:  r.jobsStream(),
@@ -199,8 +305,10 @@ func (r *RedisClient) ClaimStaleJobs(consumer string, minIdle time.Duration, cou
     }
     
     ids := make([]string, 0, len(pending))
+    deliveries := make(map[string]int64, len(pending))
     for _, p := range pending {
         ids = append(ids, p.ID)
+        deliveries[p.ID] = p.RetryCount
     }
     
     claimed, err := r.client.XClaim(r.ctx, &redis.XClaimArgs{
@@ -215,12 +323,33 @@ func (r *RedisClient) ClaimStaleJobs(consumer string, minIdle time.Duration, cou
         return nil, err
     }
     
+    var errs []error
+    deadLetters := r.client.TxPipeline()
     claimedIDs := make([]string, 0, len(claimed))
     for _, c := range claimed {
+        if deliveries[c.ID] >= MaxDeliveries {
+            deadLetters.XAdd(r.ctx, &redis.XAddArgs{
+                Stream: r.dlqJobsStream(),
+                Values: map[string]interface{}{"data": c.Values["data"], "job_id": c.ID, "deliveries": deliveries[c.ID]},
+            })
+            deadLetters.XAck(r.ctx, r.jobsStream(), "workers", c.ID)
+            continue
+        }
+        var job common.JobMessage
+        if err := json.Unmarshal(r.bytesFromInterface(c.Values["data"]), &job); err != nil {
+            errs = append(errs, fmt.Errorf("job %s: %w", c.ID, err))
+            continue
+        }
+        r.claimed.Add(c.ID, &job)
         claimedIDs = append(claimedIDs, c.ID)
     }
     
-    return claimedIDs, nil
+    if deadLetters.Len() > 0 {
+        if _, err := deadLetters.Exec(r.ctx); err != nil {
+            errs = append(errs, fmt.Errorf("dead-letter jobs: %w", err))
+        }
+    }
+    return claimedIDs, errors.Join(errs...)
 }
 
 func (r *RedisClient) bytesFromInterface(v interface{}) []byte {
@@ -0,0 +1,233 @@
+package queue
+
+import (
+    "context"
+    "image/color"
+    "reflect"
+    "strconv"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/alicebob/miniredis/v2"
+    "github.com/redis/go-redis/v9"
+    "studyguide.parallel/pkg/common"
+)
+
+// newTestClient connects a RedisClient to a fresh in-memory Redis with the groups created
+func newTestClient(t *testing.T) (*RedisClient, *miniredis.Miniredis) {
+    t.Helper()
+    server := miniredis.RunT(t)
+    client, err := NewRedisClient(server.Addr(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { client.Close() })
+    if err := client.EnsureGroups(); err != nil {
+        t.Fatal(err)
+    }
+    return client, server
+}
+
+// roundTrips counts the commands and pipelines a client sends, one round trip each
+type roundTrips struct {
+    n atomic.Int32
+}
+
+func (h *roundTrips) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *roundTrips) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+    return func(ctx context.Context, cmd redis.Cmder) error {
+        h.n.Add(1)
+        return next(ctx, cmd)
+    }
+}
+
+func (h *roundTrips) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+    return func(ctx context.Context, cmds []redis.Cmder) error {
+        h.n.Add(1)
+        return next(ctx, cmds)
+    }
+}
+
+// tileJob is a job for one 1x1 tile of the image
+func tileJob(imageID, tileID int) *common.JobMessage {
+    return &common.JobMessage{Type: "tile", ImageTile: &common.ImageTile{
+        ImageID: imageID, TileID: tileID, Width: 1, Height: 1, Data: [][]color.RGBA{{{1, 2, 3, 255}}},
+    }}
+}
+
+func TestAddJobsOneRoundTrip(t *testing.T) {
+    client, server := newTestClient(t)
+    trips := &roundTrips{}
+    client.client.AddHook(trips)
+
+    var jobs []*common.JobMessage
+    for tile := 0; tile < 25; tile++ {
+        jobs = append(jobs, tileJob(1, tile))
+    }
+    ids, err := client.AddJobs(jobs)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n := trips.n.Load(); n != 1 {
+        t.Errorf("AddJobs took %d round trips for %d jobs, want 1", n, len(jobs))
+    }
+
+    entries, err := server.Stream("mt:jobs")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(ids) != len(jobs) || len(entries) != len(jobs) {
+        t.Fatalf("%d IDs and %d stream entries for %d jobs", len(ids), len(entries), len(jobs))
+    }
+    for i, entry := range entries {
+        if entry.ID != ids[i] {
+            t.Errorf("ID %d is %s, but the stream's entry %d is %s", i, ids[i], i, entry.ID)
+        }
+    }
+
+    // The jobs come back in order, intact
+    for tile := 0; tile < 3; tile++ {
+        id, job, err := client.ReadJob("worker", 10*time.Millisecond)
+        if err != nil || id != ids[tile] || job.ImageTile.TileID != tile {
+            t.Fatalf("read %s %+v, %v, want %s tile %d", id, job, err, ids[tile], tile)
+        }
+    }
+}
+
+func TestClaimedJobsAreReadAgain(t *testing.T) {
+    client, server := newTestClient(t)
+    now := time.Now()
+    server.SetTime(now)
+    if _, err := client.AddJobs([]*common.JobMessage{tileJob(1, 0), tileJob(1, 1)}); err != nil {
+        t.Fatal(err)
+    }
+
+    // A worker reads both jobs and fails them, leaving them unacked
+    var ids []string
+    for i := 0; i < 2; i++ {
+        id, _, err := client.ReadJob("worker", 10*time.Millisecond)
+        if err != nil {
+            t.Fatal(err)
+        }
+        ids = append(ids, id)
+    }
+    if claimed, err := client.ClaimStaleJobs("monitor", 30*time.Second, 10); err != nil || len(claimed) != 0 {
+        t.Fatalf("claimed %v, %v before the jobs went stale", claimed, err)
+    }
+
+    // The claimed jobs come back from the next reads, not just the monitor's pending list
+    server.SetTime(now.Add(time.Minute))
+    claimed, err := client.ClaimStaleJobs("monitor", 30*time.Second, 10)
+    if err != nil || !reflect.DeepEqual(claimed, ids) {
+        t.Fatalf("claimed %v, %v; want %v", claimed, err, ids)
+    }
+    for _, id := range ids {
+        got, job, err := client.ReadJob("worker", 10*time.Millisecond)
+        if err != nil || got != id || job == nil || job.ImageTile == nil {
+            t.Fatalf("read %q %+v, %v; want the claimed job %q", got, job, err, id)
+        }
+        if err := client.AckJob(got); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if got, job, err := client.ReadJob("worker", 10*time.Millisecond); err != nil || job != nil {
+        t.Fatalf("read %q %+v, %v with nothing left", got, job, err)
+    }
+}
+
+func TestClaimedJobComesBeforeNewJobs(t *testing.T) {
+    client, server := newTestClient(t)
+    now := time.Now()
+    server.SetTime(now)
+    if _, err := client.AddJobs([]*common.JobMessage{tileJob(1, 0)}); err != nil {
+        t.Fatal(err)
+    }
+    stale, _, err := client.ReadJob("worker", 10*time.Millisecond)
+    if err != nil || stale == "" {
+        t.Fatalf("worker read %q, %v", stale, err)
+    }
+
+    // A new job arrives after the first went stale; the claimed one is still read first
+    if _, err := client.AddJobs([]*common.JobMessage{tileJob(2, 0)}); err != nil {
+        t.Fatal(err)
+    }
+    server.SetTime(now.Add(time.Minute))
+    if claimed, err := client.ClaimStaleJobs("monitor", 30*time.Second, 10); err != nil || !reflect.DeepEqual(claimed, []string{stale}) {
+        t.Fatalf("claimed %v, %v; want [%s]", claimed, err, stale)
+    }
+    for _, want := range []int{1, 2} {
+        _, job, err := client.ReadJob("worker", 10*time.Millisecond)
+        if err != nil || job == nil || job.ImageTile.ImageID != want {
+            t.Fatalf("read %+v, %v; want image %d's job", job, err, want)
+        }
+    }
+}
+
+func TestClaimStaleJobsRetriesThenDeadLetters(t *testing.T) {
+    client, server := newTestClient(t)
+    now := time.Now()
+    server.SetTime(now)
+    if _, err := client.AddJobs([]*common.JobMessage{tileJob(1, 0), tileJob(1, 1)}); err != nil {
+        t.Fatal(err)
+    }
+
+    // A worker reads both jobs and fails them, leaving them unacked
+    var ids []string
+    for i := 0; i < 2; i++ {
+        id, _, err := client.ReadJob("worker", 10*time.Millisecond)
+        if err != nil {
+            t.Fatal(err)
+        }
+        ids = append(ids, id)
+    }
+    if claimed, err := client.ClaimStaleJobs("monitor", 30*time.Second, 10); err != nil || len(claimed) != 0 {
+        t.Fatalf("claimed %v, %v before the jobs went stale", claimed, err)
+    }
+
+    // claimAndRead claims the stale jobs and reads each one back from ReadJob
+    claimAndRead := func(want []string) {
+        t.Helper()
+        now = now.Add(time.Minute)
+        server.SetTime(now)
+        claimed, err := client.ClaimStaleJobs("monitor", 30*time.Second, 10)
+        if err != nil || len(claimed) != len(want) || len(want) > 0 && !reflect.DeepEqual(claimed, want) {
+            t.Fatalf("claimed %v, %v; want %v", claimed, err, want)
+        }
+        for _, id := range want {
+            got, job, err := client.ReadJob("worker", 10*time.Millisecond)
+            if err != nil || got != id || job == nil || job.ImageTile == nil {
+                t.Fatalf("read %q %+v, %v; want the claimed job %q", got, job, err, id)
+            }
+        }
+        if got, job, err := client.ReadJob("worker", 10*time.Millisecond); err != nil || job != nil {
+            t.Fatalf("read %q %+v, %v with no job left to hand out", got, job, err)
+        }
+    }
+
+    // The second job succeeds on its retry; the first fails every delivery
+    claimAndRead(ids)
+    if err := client.AckJob(ids[1]); err != nil {
+        t.Fatal(err)
+    }
+    for try := 3; try <= MaxDeliveries; try++ {
+        claimAndRead(ids[:1])
+    }
+    claimAndRead(nil)
+
+    dead, err := server.Stream("mt:dlq:jobs")
+    if err != nil || len(dead) != 1 {
+        t.Fatalf("dead-letter stream = %v, %v; want the one failed job", dead, err)
+    }
+    fields := map[string]string{}
+    for i := 0; i+1 < len(dead[0].Values); i += 2 {
+        fields[dead[0].Values[i]] = dead[0].Values[i+1]
+    }
+    if fields["job_id"] != ids[0] || fields["deliveries"] != strconv.Itoa(MaxDeliveries) || fields["data"] == "" {
+        t.Errorf("dead letter %v, want job %s with its data after %d deliveries", fields, ids[0], MaxDeliveries)
+    }
+    if pending, err := client.client.XPending(client.ctx, client.jobsStream(), "workers").Result(); err != nil || pending.Count != 0 {
+        t.Errorf("pending after the dead letter = %+v, %v; want none", pending, err)
+    }
+}
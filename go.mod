@@ -0,0 +1,17 @@
+module studyguide.parallel
+
+go 1.21
+
+require (
+	studyguide.parallel/a v0.0.0
+	studyguide.parallel/b v0.0.0
+	studyguide.parallel/c v0.0.0
+	studyguide.parallel/pkg v0.0.0
+)
+
+replace (
+	studyguide.parallel/a => ./a
+	studyguide.parallel/b => ./b
+	studyguide.parallel/c => ./c
+	studyguide.parallel/pkg => ./pkg
+)
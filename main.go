@@ -0,0 +1,193 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	_ "image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"studyguide.parallel/a/sequential"
+	"studyguide.parallel/b/tileparallel"
+	"studyguide.parallel/c/pipelined"
+	"studyguide.parallel/pkg/common"
+	"studyguide.parallel/pkg/stats"
+	"studyguide.parallel/pkg/verify"
+)
+
+// algorithm is one of the in-process implementations run side by side
+type algorithm struct {
+	name string // output directory name
+	flag string // -algo value that selects it
+	run  func(inputPaths, outputPaths []string, kernelSize int) (stats.PerformanceData, error)
+}
+
+var algorithms = []algorithm{
+	{"sequential", "sequential", sequential.Run_a},
+	{"tile_parallel", "parallel", tileparallel.Run_b},
+	{"pipelined", "pipelined", func(inputPaths, outputPaths []string, kernelSize int) (stats.PerformanceData, error) {
+		return pipelined.Run_c(inputPaths, outputPaths, kernelSize), nil
+	}},
+}
+
+// selectAlgorithms returns the algorithms an -algo value names: one of them, or all
+func selectAlgorithms(name string) ([]algorithm, error) {
+	if name == "all" {
+		return algorithms, nil
+	}
+	for _, algo := range algorithms {
+		if name == algo.flag || name == algo.name {
+			return []algorithm{algo}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown algorithm %q (use sequential, parallel, pipelined or all)", name)
+}
+
+func main() {
+	var (
+		kernelSize = flag.Int("kernel", 21, "Gaussian kernel size")
+		radius     = flag.Int("radius", 0, "Blur radius R, an alternative to -kernel: sets the kernel size to 2R+1 (0 = use -kernel)")
+		outputDir  = flag.String("output", "output", "Directory the per-algorithm output directories are created in")
+		compareAll = flag.Bool("compare-all", false, "Compare every pair of algorithm outputs and fail if any diverge beyond -tolerance")
+		tolerance  = flag.Int("tolerance", 1, "Largest per-channel difference (8-bit levels) allowed between algorithms with -compare-all")
+		algoName   = flag.String("algo", "all", "Algorithm to run: sequential, parallel, pipelined or all")
+		htmlReport = flag.String("html-report", "", "Also write an HTML page charting each algorithm's total time and speedup to this path")
+	)
+	flag.Parse()
+	if err := common.ApplyRadiusFlag(kernelSize, *radius); err != nil {
+		log.Fatalf("Invalid -radius: %v", err)
+	}
+	selected, err := selectAlgorithms(*algoName)
+	if err != nil {
+		log.Fatalf("Invalid -algo: %v", err)
+	}
+	if *compareAll && len(selected) < 2 {
+		log.Fatalf("-compare-all needs -algo all: there is nothing to compare %s against", *algoName)
+	}
+
+	// Define input paths for 5 images
+	inputPaths := []string{
+		"input/img1.png",
+		"input/img2.png",
+		"input/img3.png",
+		"input/img4.png",
+		"input/img5.png",
+	}
+
+	// Ctrl-C lets the running algorithm finish its current image and still writes stats
+	common.HandleInterrupts()
+
+	// Every algorithm writes the same file names into its own directory
+	outputPaths := make(map[string][]string)
+	var results []stats.PerformanceData
+	for _, algo := range selected {
+		if common.Interrupted() {
+			break
+		}
+		dir := filepath.Join(*outputDir, algo.name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Fatalf("Failed to create output directory %s: %v", dir, err)
+		}
+		for _, input := range inputPaths {
+			outputPaths[algo.name] = append(outputPaths[algo.name], filepath.Join(dir, filepath.Base(input)))
+		}
+
+		fmt.Printf("Running %s implementation:\n", algo.name)
+		result, err := algo.run(inputPaths, outputPaths[algo.name], *kernelSize)
+		results = append(results, result)
+		if err != nil {
+			// Record every algorithm run so far, including the failed one's finished images
+			stats.WritePerformanceResultsWithPrefix(results, "abc_")
+			log.Fatalf("%s stopped after %d images (stats written to logs/): %v", algo.name, result.ImagesProcessed, err)
+		}
+	}
+
+	stats.WritePerformanceResultsWithPrefix(results, "abc_")
+	for _, line := range stats.ScalingSummary(results) {
+		fmt.Println(line)
+	}
+	fmt.Println("Results written to logs/")
+	if *htmlReport != "" {
+		if err := stats.WritePerformanceReportHTML(results, *htmlReport); err != nil {
+			log.Printf("Failed to write HTML report: %v", err)
+		} else {
+			fmt.Printf("HTML report written to %s\n", *htmlReport)
+		}
+	}
+
+	if !*compareAll {
+		return
+	}
+	if common.Interrupted() {
+		fmt.Println("Interrupted: skipping -compare-all, the outputs are incomplete")
+		return
+	}
+
+	fmt.Printf("\n=== Comparing outputs (tolerance %d) ===\n", *tolerance)
+	if !compareOutputs(os.Stdout, selected, outputPaths, inputPaths, *tolerance) {
+		fmt.Println("Algorithms diverge beyond tolerance")
+		os.Exit(1)
+	}
+	fmt.Println("All algorithms agree within tolerance")
+}
+
+// compareOutputs diffs every pair of algorithms' outputs for each input and reports
+// each pair's largest difference to w. It returns false if any output is missing or
+// unreadable or any pair differs by more than tolerance levels in a channel.
+func compareOutputs(w io.Writer, selected []algorithm, outputPaths map[string][]string, inputPaths []string, tolerance int) bool {
+	ok := true
+	for i := 0; i < len(selected); i++ {
+		for j := i + 1; j < len(selected); j++ {
+			first, second := selected[i].name, selected[j].name
+			worst := 0
+			for k := range inputPaths {
+				report, err := compareFiles(outputPaths[first][k], outputPaths[second][k])
+				if err != nil {
+					fmt.Fprintf(w, "FAIL %s vs %s %s: %v\n", first, second, filepath.Base(inputPaths[k]), err)
+					ok = false
+					continue
+				}
+				if report.MaxDiff > worst {
+					worst = report.MaxDiff
+				}
+				if report.MaxDiff > tolerance {
+					fmt.Fprintf(w, "FAIL %s vs %s %s: max diff %d, PSNR %.2f dB\n",
+						first, second, filepath.Base(inputPaths[k]), report.MaxDiff, report.PSNR)
+					ok = false
+				}
+			}
+			fmt.Fprintf(w, "%s vs %s: max diff %d\n", first, second, worst)
+		}
+	}
+	return ok
+}
+
+// compareFiles decodes two output images and diffs them
+func compareFiles(firstPath, secondPath string) (verify.DiffReport, error) {
+	first, err := decodeFile(firstPath)
+	if err != nil {
+		return verify.DiffReport{}, err
+	}
+	second, err := decodeFile(secondPath)
+	if err != nil {
+		return verify.DiffReport{}, err
+	}
+	return verify.Diff(first, second)
+}
+
+func decodeFile(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return img, nil
+}
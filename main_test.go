@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writePNG encodes img to path
+func writePNG(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// noiseImage returns an opaque size x size image of random pixels
+func noiseImage(size int, seed int64) *image.RGBA {
+	rng := rand.New(rand.NewSource(seed))
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	rng.Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+	return img
+}
+
+func TestCompareOutputs(t *testing.T) {
+	// Three stand-in algorithms whose one output differs from the reference by the
+	// given number of levels in one channel
+	reference := noiseImage(16, 1)
+	reference.Pix[0] = 100 // room to shift up without wrapping
+	tests := []struct {
+		name      string
+		shift     [3]int
+		tolerance int
+		ok        bool
+		report    []string
+	}{
+		{"identical", [3]int{0, 0, 0}, 1, true, []string{"x vs y: max diff 0", "x vs z: max diff 0", "y vs z: max diff 0"}},
+		{"within tolerance", [3]int{0, 1, 0}, 1, true, []string{"x vs y: max diff 1", "y vs z: max diff 1"}},
+		{"beyond tolerance", [3]int{0, 0, 3}, 1, false, []string{"FAIL x vs z in.png: max diff 3", "x vs z: max diff 3", "y vs z: max diff 3"}},
+		{"looser tolerance", [3]int{0, 0, 3}, 3, true, []string{"x vs z: max diff 3"}},
+	}
+	for _, tc := range tests {
+		dir := t.TempDir()
+		var selected []algorithm
+		outputPaths := make(map[string][]string)
+		for i, name := range []string{"x", "y", "z"} {
+			img := image.NewRGBA(reference.Rect)
+			copy(img.Pix, reference.Pix)
+			img.Pix[0] = uint8(int(img.Pix[0]) + tc.shift[i])
+			path := filepath.Join(dir, name+".png")
+			writePNG(t, path, img)
+			selected = append(selected, algorithm{name: name})
+			outputPaths[name] = []string{path}
+		}
+
+		var out bytes.Buffer
+		if ok := compareOutputs(&out, selected, outputPaths, []string{"input/in.png"}, tc.tolerance); ok != tc.ok {
+			t.Errorf("%s: compareOutputs = %v, want %v\n%s", tc.name, ok, tc.ok, out.String())
+		}
+		for _, line := range tc.report {
+			if !strings.Contains(out.String(), line) {
+				t.Errorf("%s: report does not contain %q:\n%s", tc.name, line, out.String())
+			}
+		}
+	}
+}
+
+func TestCompareOutputsMissingOutput(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "x.png")
+	writePNG(t, path, noiseImage(8, 2))
+	selected := []algorithm{{name: "x"}, {name: "y"}}
+	outputPaths := map[string][]string{"x": {path}, "y": {filepath.Join(dir, "missing.png")}}
+
+	var out bytes.Buffer
+	if compareOutputs(&out, selected, outputPaths, []string{"in.png"}, 255) {
+		t.Error("a missing output compared as agreeing")
+	}
+	if !strings.Contains(out.String(), "FAIL x vs y in.png") {
+		t.Errorf("report %q does not name the failing pair and input", out.String())
+	}
+}
+
+func TestCompareAllAlgorithmsAgree(t *testing.T) {
+	// The real algorithms on inputs spanning several tiles agree within the default
+	// tolerance, as -compare-all checks
+	inDir, outDir := t.TempDir(), t.TempDir()
+	var inputs []string
+	for i, size := range []int{40, 300} {
+		path := filepath.Join(inDir, fmt.Sprintf("img%d.png", i+1))
+		writePNG(t, path, noiseImage(size, int64(i)))
+		inputs = append(inputs, path)
+	}
+	outputPaths := make(map[string][]string)
+	for _, algo := range algorithms {
+		dir := filepath.Join(outDir, algo.name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, input := range inputs {
+			outputPaths[algo.name] = append(outputPaths[algo.name], filepath.Join(dir, filepath.Base(input)))
+		}
+		result, err := algo.run(inputs, outputPaths[algo.name], 7)
+		if err != nil || result.ImagesProcessed != len(inputs) {
+			t.Fatalf("%s processed %d of %d images: %v", algo.name, result.ImagesProcessed, len(inputs), err)
+		}
+	}
+
+	var out bytes.Buffer
+	if !compareOutputs(&out, algorithms, outputPaths, inputs, 1) {
+		t.Errorf("the algorithms diverge:\n%s", out.String())
+	}
+}
+
+func TestSelectAlgorithms(t *testing.T) {
+	tests := []struct {
+		algo    string
+		want    []string // names of the selected algorithms
+		wantErr bool
+	}{
+		{"sequential", []string{"sequential"}, false},
+		{"parallel", []string{"tile_parallel"}, false},
+		{"pipelined", []string{"pipelined"}, false},
+		{"tile_parallel", []string{"tile_parallel"}, false},                  // the output directory name works too
+		{"all", []string{"sequential", "tile_parallel", "pipelined"}, false}, // the -algo default
+		{"fastest", nil, true},
+		{"", nil, true},
+		{"Sequential", nil, true},
+	}
+	for _, tc := range tests {
+		selected, err := selectAlgorithms(tc.algo)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("-algo %q: error %v, want error %v", tc.algo, err, tc.wantErr)
+			continue
+		}
+		var names []string
+		for _, algo := range selected {
+			names = append(names, algo.name)
+		}
+		if !reflect.DeepEqual(names, tc.want) {
+			t.Errorf("-algo %q selected %v, want %v", tc.algo, names, tc.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package blur
 
 import (
+	"fmt"
 	"image"
 	"image/color"
 	"math"
@@ -8,10 +9,17 @@ import (
 
 // GenerateGaussianKernel creates a Gaussian kernel of given size
 func GenerateGaussianKernel(size int) [][]float64 {
+	return GenerateGaussianKernelSigma(size, 0)
+}
+
+// GenerateGaussianKernelSigma creates a Gaussian kernel of given size and spread.
+// A sigma <= 0 selects the default of size / 3.
+func GenerateGaussianKernelSigma(size int, sigma float64) [][]float64 {
 	kernel := make([][]float64, size)
-	// Sigma should be proportional to size, but not too large
-	// Common formula: sigma = radius / 3, where radius = size / 2
-	sigma := float64(size) / 3.0
+	if sigma <= 0 {
+		// Sigma should be proportional to size, but not too large
+		sigma = float64(size) / 3.0
+	}
 	sum := 0.0
 	center := size / 2
 
@@ -36,33 +44,243 @@ func GenerateGaussianKernel(size int) [][]float64 {
 	return kernel
 }
 
+// KernelSumTolerance is how far a kernel's weights may sum from 1 and still pass
+// ValidateKernel; normalization leaves only rounding error, far below this
+const KernelSumTolerance = 1e-6
+
+// ValidateKernel checks that every weight is finite and non-negative and that the
+// weights sum to 1 within KernelSumTolerance. A degenerate sigma (NaN, infinite, or so
+// small that the unnormalized centre weight overflows) yields NaN weights, which would
+// silently turn every blurred pixel to garbage.
+func ValidateKernel(kernel [][]float64) error {
+	if len(kernel) == 0 {
+		return fmt.Errorf("kernel is empty")
+	}
+	sum := 0.0
+	for i, row := range kernel {
+		for j, w := range row {
+			if math.IsNaN(w) || math.IsInf(w, 0) || w < 0 {
+				return fmt.Errorf("kernel weight [%d][%d] is %v", i, j, w)
+			}
+			sum += w
+		}
+	}
+	if math.Abs(sum-1) > KernelSumTolerance {
+		return fmt.Errorf("kernel weights sum to %v, not 1", sum)
+	}
+	return nil
+}
+
+// CheckKernel generates the kernel a run will blur with and validates it, as a
+// startup self-check against a bad size/sigma combination
+func CheckKernel(size int, sigma float64) error {
+	if size < 1 {
+		return fmt.Errorf("kernel size %d is not positive", size)
+	}
+	if err := ValidateKernel(GenerateGaussianKernelSigma(size, sigma)); err != nil {
+		return fmt.Errorf("kernel size %d, sigma %v: %w", size, sigma, err)
+	}
+	return nil
+}
+
+// MinSoftnessSigma is the sigma used for 0% softness. A zero sigma is undefined, and
+// at this spread every neighbour weight underflows, leaving a near-delta kernel.
+const MinSoftnessSigma = 0.05
+
+// SoftnessToSigma maps a softness percentage to a sigma for a kernel of the given size:
+//
+//	sigma = radius * softness / 200, radius = size / 2
+//
+// so 100% gives radius/2, 33% about radius/6 and 0% a near-delta kernel. Softness is
+// clamped to 0..100.
+func SoftnessToSigma(softness float64, size int) float64 {
+	softness = math.Max(0, math.Min(100, softness))
+	return math.Max(MinSoftnessSigma, float64(size/2)*softness/200)
+}
+
+// ClampKernelSize limits a derived kernel size to minSize..maxSize and forces it odd so
+// the kernel has a centre pixel. Even bounds are first rounded inward to odd, so the
+// result never leaves minSize..maxSize; when that leaves no odd size between them (an
+// even minSize equal to maxSize), the cap wins.
+func ClampKernelSize(size, minSize, maxSize int) int {
+	if minSize%2 == 0 {
+		minSize++
+	}
+	if maxSize%2 == 0 {
+		maxSize--
+	}
+	if size%2 == 0 {
+		size++
+	}
+	if size < minSize {
+		size = minSize
+	}
+	if size > maxSize {
+		size = maxSize
+	}
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
+// MaxEnergyKernelSize caps the kernel KernelSizeForEnergy will return, so a large
+// sigma with an energy close to 1 cannot ask for an unbounded window
+const MaxEnergyKernelSize = 255
+
+// KernelEnergy is the fraction of the untruncated (discrete) Gaussian's total weight
+// that falls inside a size x size window. The 2D kernel is separable, so this is the
+// square of the 1D fraction within the window's radius.
+func KernelEnergy(size int, sigma float64) float64 {
+	if sigma <= 0 {
+		sigma = float64(size) / 3.0
+	}
+	radius := size / 2
+
+	// Terms beyond 12 sigma are below float64 resolution relative to the centre
+	limit := radius + int(math.Ceil(12*sigma)) + 1
+	inside, total := 0.0, 0.0
+	for i := 0; i <= limit; i++ {
+		w := math.Exp(-float64(i*i) / (2 * sigma * sigma))
+		if i > 0 {
+			w *= 2 // +i and -i
+		}
+		if i <= radius {
+			inside += w
+		}
+		total += w
+	}
+	fraction := inside / total
+	return fraction * fraction
+}
+
+// KernelSizeForEnergy returns the smallest odd kernel size whose window captures at
+// least the given fraction (0..1) of a Gaussian's total energy for this sigma, capped
+// at MaxEnergyKernelSize. Too tight a window truncates the tails and rings; a larger
+// one only costs time.
+func KernelSizeForEnergy(sigma, energy float64) int {
+	if sigma <= 0 || energy <= 0 {
+		return 1
+	}
+	for size := 1; size < MaxEnergyKernelSize; size += 2 {
+		if KernelEnergy(size, sigma) >= energy {
+			return size
+		}
+	}
+	return MaxEnergyKernelSize
+}
+
+// Options holds optional tweaks to the whole-image blur
+type Options struct {
+	// Dither applies an ordered dither to the final 8-bit quantization instead of
+	// truncating, trading a little noise for less banding in smooth gradients
+	Dither bool
+
+	// SigmaColor, when > 0, turns the blur bilateral: each neighbour's spatial Gaussian
+	// weight is also scaled by how close its colour is to the centre pixel (a Gaussian
+	// of the RGB distance with this sigma, in 8-bit units), so edges are preserved
+	// while flat regions are still smoothed. Smaller values preserve more edges.
+	SigmaColor float64
+
+	// Sigma, when > 0, overrides the default spatial spread of size / 3 (see SoftnessToSigma)
+	Sigma float64
+
+	// Float32 accumulates the plain Gaussian in float32 instead of float64. The 8-bit
+	// output stays within 1 level per channel of the float64 path. Bilateral blurs
+	// always accumulate in float64.
+	Float32 bool
+
+	// LumaOnly blurs just the Rec.601 luma and adds back each pixel's original chroma
+	// (its R, G and B offsets from luma). One convolution instead of four makes it
+	// several times faster, but colour edges stay sharp, so strongly coloured detail
+	// can show halos. Alpha is left unblurred. Ignored for bilateral blurs.
+	LumaOnly bool
+
+	// ResizeFilter is the interpolation for scale steps of a Pipeline (bilinear if empty)
+	ResizeFilter ResizeFilter
+
+	// Approx replaces the convolution with ApproxGaussian at the same sigma: three box
+	// blurs whose cost does not grow with the kernel, within a few levels of the exact
+	// result. Dither, Float32 and LumaOnly do not apply; bilateral blurs are never
+	// approximated.
+	Approx bool
+}
+
+// bayer4 is the 4x4 Bayer threshold matrix used for ordered dithering
+var bayer4 = [4][4]float64{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// quantize converts an accumulated channel value to 8 bits. Without dither the value is
+// truncated as before; with dither the fractional part is compared against the Bayer
+// threshold for pixel (x, y), so the pattern depends only on the pixel position.
+func quantize(v float64, x, y int, dither bool) uint8 {
+	if !dither {
+		return uint8(v)
+	}
+	v += (bayer4[y&3][x&3] + 0.5) / 16.0
+	if v >= 255 {
+		return 255
+	}
+	if v <= 0 {
+		return 0
+	}
+	return uint8(v)
+}
+
 // ApplyBlurToImage applies Gaussian blur directly to an image (optimized for sequential processing)
 func ApplyBlurToImage(img image.Image, kernelSize int) *image.RGBA {
+	return ApplyBlurToImageWithOptions(img, kernelSize, Options{})
+}
+
+// ApplyBilateral applies an edge-preserving bilateral blur, reusing the spatial Gaussian
+// weights of ApplyBlurToImage and weighting neighbours by colour similarity as well
+func ApplyBilateral(img image.Image, kernelSize int, sigmaColor float64) *image.RGBA {
+	return ApplyBlurToImageWithOptions(img, kernelSize, Options{SigmaColor: sigmaColor})
+}
+
+// ApplyBlurToImageWithOptions is ApplyBlurToImage with optional behaviour such as dithering
+func ApplyBlurToImageWithOptions(img image.Image, kernelSize int, opts Options) *image.RGBA {
+	if opts.Approx && opts.SigmaColor <= 0 {
+		sigma := opts.Sigma
+		if sigma <= 0 {
+			sigma = float64(kernelSize) / 3.0
+		}
+		return ApproxGaussian(img, sigma)
+	}
+
 	bounds := img.Bounds()
 	blurred := image.NewRGBA(bounds)
-	kernel := GenerateGaussianKernel(kernelSize)
+	kernel := GenerateGaussianKernelSigma(kernelSize, opts.Sigma)
 	offset := kernelSize / 2
 
 	// Convert input image to RGBA for direct pixel access
-	var srcRGBA *image.RGBA
-	if rgba, ok := img.(*image.RGBA); ok {
-		srcRGBA = rgba
-	} else {
-		srcRGBA = image.NewRGBA(bounds)
-		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-			for x := bounds.Min.X; x < bounds.Max.X; x++ {
-				srcRGBA.Set(x, y, img.At(x, y))
-			}
-		}
-	}
+	srcRGBA := ToRGBA(img)
 
 	width := bounds.Dx()
 	height := bounds.Dy()
 
+	bilateral := opts.SigmaColor > 0
+	colorDenom := 2 * opts.SigmaColor * opts.SigmaColor
+
+	if opts.LumaOnly && !bilateral {
+		blurLuma(srcRGBA, blurred, kernel, opts.Dither)
+		return blurred
+	}
+
+	if opts.Float32 && !bilateral {
+		blurFloat32(srcRGBA, blurred, kernel, opts.Dither)
+		return blurred
+	}
+
 	// Process each pixel with direct pixel access
 	for y := 0; y < height; y++ {
 		for x := 0; x < width; x++ {
-			var rSum, gSum, bSum, aSum float64
+			var rSum, gSum, bSum, aSum, wSum float64
+			center := srcRGBA.RGBAAt(x+bounds.Min.X, y+bounds.Min.Y)
 
 			// Apply kernel
 			for ky := 0; ky < kernelSize; ky++ {
@@ -86,21 +304,35 @@ func ApplyBlurToImage(img image.Image, kernelSize int) *image.RGBA {
 					// Direct pixel access using RGBAAt - much faster than img.At()
 					pixel := srcRGBA.RGBAAt(sx+bounds.Min.X, sy+bounds.Min.Y)
 					weight := kernel[ky][kx]
+					if bilateral {
+						dr := float64(pixel.R) - float64(center.R)
+						dg := float64(pixel.G) - float64(center.G)
+						db := float64(pixel.B) - float64(center.B)
+						weight *= math.Exp(-(dr*dr + dg*dg + db*db) / colorDenom)
+					}
 
 					// Accumulate weighted values
 					rSum += float64(pixel.R) * weight
 					gSum += float64(pixel.G) * weight
 					bSum += float64(pixel.B) * weight
 					aSum += float64(pixel.A) * weight
+					wSum += weight
 				}
 			}
 
+			// The spatial kernel already sums to 1; colour weighting does not, so renormalize.
+			// The epsilon keeps e.g. 255*w/w from truncating to 254 on rounding error.
+			if bilateral && wSum > 0 {
+				const eps = 1e-9
+				rSum, gSum, bSum, aSum = rSum/wSum+eps, gSum/wSum+eps, bSum/wSum+eps, aSum/wSum+eps
+			}
+
 			// Set blurred pixel directly
 			blurred.Set(x+bounds.Min.X, y+bounds.Min.Y, color.RGBA{
-				R: uint8(rSum),
-				G: uint8(gSum),
-				B: uint8(bSum),
-				A: uint8(aSum),
+				R: quantize(rSum, x, y, opts.Dither),
+				G: quantize(gSum, x, y, opts.Dither),
+				B: quantize(bSum, x, y, opts.Dither),
+				A: quantize(aSum, x, y, opts.Dither),
 			})
 		}
 	}
@@ -108,21 +340,152 @@ func ApplyBlurToImage(img image.Image, kernelSize int) *image.RGBA {
 	return blurred
 }
 
-// ApplyBlurToTile applies Gaussian blur to tile data (optimized for parallel processing)
+// blurLuma blurs the luma plane of src into dst and reconstructs RGB from the blurred
+// luma plus the original per-pixel chroma offsets. src and dst share the same bounds.
+func blurLuma(src, dst *image.RGBA, kernel [][]float64, dither bool) {
+	bounds := src.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+	kernelSize := len(kernel)
+	offset := kernelSize / 2
+
+	luma := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := src.RGBAAt(x+bounds.Min.X, y+bounds.Min.Y)
+			luma[y*width+x] = 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+		}
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var sum float64
+			for ky := 0; ky < kernelSize; ky++ {
+				sy := y + ky - offset
+				if sy < 0 {
+					sy = 0
+				} else if sy >= height {
+					sy = height - 1
+				}
+				row := luma[sy*width : (sy+1)*width]
+				for kx := 0; kx < kernelSize; kx++ {
+					sx := x + kx - offset
+					if sx < 0 {
+						sx = 0
+					} else if sx >= width {
+						sx = width - 1
+					}
+					sum += row[sx] * kernel[ky][kx]
+				}
+			}
+
+			c := src.RGBAAt(x+bounds.Min.X, y+bounds.Min.Y)
+			shift := sum - luma[y*width+x]
+			dst.SetRGBA(x+bounds.Min.X, y+bounds.Min.Y, color.RGBA{
+				R: quantize(clamp255(float64(c.R)+shift), x, y, dither),
+				G: quantize(clamp255(float64(c.G)+shift), x, y, dither),
+				B: quantize(clamp255(float64(c.B)+shift), x, y, dither),
+				A: c.A,
+			})
+		}
+	}
+}
+
+// clamp255 limits a reconstructed channel value to the 8-bit range
+func clamp255(v float64) float64 {
+	return math.Max(0, math.Min(255, v))
+}
+
+// blurFloat32 is the plain Gaussian loop of ApplyBlurToImageWithOptions with float32
+// weights and accumulators. src and dst share the same bounds.
+func blurFloat32(src, dst *image.RGBA, kernel [][]float64, dither bool) {
+	bounds := src.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+	kernelSize := len(kernel)
+	offset := kernelSize / 2
+
+	kernel32 := make([][]float32, kernelSize)
+	for i := range kernel {
+		kernel32[i] = make([]float32, kernelSize)
+		for j := range kernel[i] {
+			kernel32[i][j] = float32(kernel[i][j])
+		}
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var rSum, gSum, bSum, aSum float32
+
+			for ky := 0; ky < kernelSize; ky++ {
+				sy := y + ky - offset
+				if sy < 0 {
+					sy = 0
+				} else if sy >= height {
+					sy = height - 1
+				}
+				for kx := 0; kx < kernelSize; kx++ {
+					sx := x + kx - offset
+					if sx < 0 {
+						sx = 0
+					} else if sx >= width {
+						sx = width - 1
+					}
+
+					pixel := src.RGBAAt(sx+bounds.Min.X, sy+bounds.Min.Y)
+					weight := kernel32[ky][kx]
+					rSum += float32(pixel.R) * weight
+					gSum += float32(pixel.G) * weight
+					bSum += float32(pixel.B) * weight
+					aSum += float32(pixel.A) * weight
+				}
+			}
+
+			dst.SetRGBA(x+bounds.Min.X, y+bounds.Min.Y, color.RGBA{
+				R: quantize(float64(rSum), x, y, dither),
+				G: quantize(float64(gSum), x, y, dither),
+				B: quantize(float64(bSum), x, y, dither),
+				A: quantize(float64(aSum), x, y, dither),
+			})
+		}
+	}
+}
+
+// ApplyBlurToTile applies Gaussian blur to tile data (optimized for parallel processing).
+// An empty tile or kernel, as a corrupt queue payload can decode to, is returned unchanged.
 func ApplyBlurToTile(data [][]color.RGBA, kernel [][]float64) [][]color.RGBA {
+	return ApplyBlurToTileWithOptions(data, kernel, 0, 0, Options{})
+}
+
+// ApplyBlurToTileWithOptions is ApplyBlurToTile with the same options as the whole-image
+// blur. originX/originY are the image coordinates of data[0][0]; the dither pattern is a
+// function of image coordinates only, so a tile dithers identically whichever worker
+// processes it and the assembled result matches the sequential path.
+func ApplyBlurToTileWithOptions(data [][]color.RGBA, kernel [][]float64, originX, originY int, opts Options) [][]color.RGBA {
+	if len(data) == 0 || len(data[0]) == 0 || len(kernel) == 0 || len(kernel[0]) == 0 {
+		return data
+	}
 	height := len(data)
 	width := len(data[0])
 	kernelSize := len(kernel)
 	offset := kernelSize / 2
 	
+	bilateral := opts.SigmaColor > 0
+	colorDenom := 2 * opts.SigmaColor * opts.SigmaColor
+	
 	result := make([][]color.RGBA, height)
 	for i := range result {
 		result[i] = make([]color.RGBA, width)
 	}
+	if !bilateral {
+		blurTilePlanar(data, result, kernel, originX, originY, opts.Dither)
+		return result
+	}
 	
 	for y := 0; y < height; y++ {
 		for x := 0; x < width; x++ {
-			var rSum, gSum, bSum, aSum float64
+			var rSum, gSum, bSum, aSum, wSum float64
+			center := data[y][x]
 			
 			for ky := 0; ky < kernelSize; ky++ {
 				for kx := 0; kx < kernelSize; kx++ {
@@ -145,19 +508,32 @@ func ApplyBlurToTile(data [][]color.RGBA, kernel [][]float64) [][]color.RGBA {
 					
 					pixel := data[sy][sx]
 					weight := kernel[ky][kx]
+					if bilateral {
+						dr := float64(pixel.R) - float64(center.R)
+						dg := float64(pixel.G) - float64(center.G)
+						db := float64(pixel.B) - float64(center.B)
+						weight *= math.Exp(-(dr*dr + dg*dg + db*db) / colorDenom)
+					}
 					
 					rSum += float64(pixel.R) * weight
 					gSum += float64(pixel.G) * weight
 					bSum += float64(pixel.B) * weight
 					aSum += float64(pixel.A) * weight
+					wSum += weight
 				}
 			}
 			
+			if bilateral && wSum > 0 {
+				const eps = 1e-9
+				rSum, gSum, bSum, aSum = rSum/wSum+eps, gSum/wSum+eps, bSum/wSum+eps, aSum/wSum+eps
+			}
+			
+			ix, iy := originX+x, originY+y
 			result[y][x] = color.RGBA{
-				R: uint8(rSum),
-				G: uint8(gSum),
-				B: uint8(bSum),
-				A: uint8(aSum),
+				R: quantize(rSum, ix, iy, opts.Dither),
+				G: quantize(gSum, ix, iy, opts.Dither),
+				B: quantize(bSum, ix, iy, opts.Dither),
+				A: quantize(aSum, ix, iy, opts.Dither),
 			}
 		}
 	}
@@ -165,8 +541,72 @@ func ApplyBlurToTile(data [][]color.RGBA, kernel [][]float64) [][]color.RGBA {
 	return result
 }
 
-// ExtractCenter removes padding from processed tile data (FIXED VERSION)
+// blurTilePlanar is the plain Gaussian loop of ApplyBlurToTileWithOptions over planar
+// float64 copies of the four channels, so the inner loop reads contiguous slices
+// instead of color.RGBA fields and converts nothing per tap. Edge clamping goes through
+// a precomputed column index. Taps are summed in the same order as the interleaved
+// loop, so the output is bit-identical to it.
+func blurTilePlanar(data, result [][]color.RGBA, kernel [][]float64, originX, originY int, dither bool) {
+	height, width := len(data), len(data[0])
+	kernelSize := len(kernel)
+	offset := kernelSize / 2
+
+	r := make([]float64, width*height)
+	g := make([]float64, width*height)
+	b := make([]float64, width*height)
+	a := make([]float64, width*height)
+	for y, row := range data {
+		// An empty row after the first, from a corrupt payload, has no pixel to clamp
+		// to and stays transparent black
+		if len(row) == 0 {
+			continue
+		}
+		for x := 0; x < width; x++ {
+			// Rows shorter than the first are clamped like out-of-range columns
+			p := row[min(x, len(row)-1)]
+			i := y*width + x
+			r[i], g[i], b[i], a[i] = float64(p.R), float64(p.G), float64(p.B), float64(p.A)
+		}
+	}
+
+	// cols[x+kx] is the clamped source column for tap kx of output column x
+	cols := make([]int, width+kernelSize-1)
+	for i := range cols {
+		cols[i] = max(0, min(i-offset, width-1))
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var rSum, gSum, bSum, aSum float64
+			for ky := 0; ky < kernelSize; ky++ {
+				base := max(0, min(y+ky-offset, height-1)) * width
+				rr, gr, br, ar := r[base:base+width], g[base:base+width], b[base:base+width], a[base:base+width]
+				for kx, weight := range kernel[ky][:kernelSize] {
+					sx := cols[x+kx]
+					rSum += rr[sx] * weight
+					gSum += gr[sx] * weight
+					bSum += br[sx] * weight
+					aSum += ar[sx] * weight
+				}
+			}
+
+			ix, iy := originX+x, originY+y
+			result[y][x] = color.RGBA{
+				R: quantize(rSum, ix, iy, dither),
+				G: quantize(gSum, ix, iy, dither),
+				B: quantize(bSum, ix, iy, dither),
+				A: quantize(aSum, ix, iy, dither),
+			}
+		}
+	}
+}
+
+// ExtractCenter removes padding from processed tile data (FIXED VERSION). Empty data is
+// returned unchanged.
 func ExtractCenter(data [][]color.RGBA, padding, width, height int) [][]color.RGBA {
+	if len(data) == 0 || len(data[0]) == 0 {
+		return data
+	}
 	result := make([][]color.RGBA, height)
 	
 	for y := 0; y < height; y++ {
@@ -0,0 +1,408 @@
+package blur
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestKernelSizeForEnergy(t *testing.T) {
+	for _, sigma := range []float64{0.5, 1, 2.5, 4, 10} {
+		for _, energy := range []float64{0.5, 0.9, 0.99, 0.999} {
+			size := KernelSizeForEnergy(sigma, energy)
+			if size%2 == 0 {
+				t.Errorf("sigma %v energy %v: size %d is even", sigma, energy, size)
+			}
+			if got := KernelEnergy(size, sigma); got < energy {
+				t.Errorf("sigma %v energy %v: size %d captures only %v", sigma, energy, size, got)
+			}
+			if size > 1 && KernelEnergy(size-2, sigma) >= energy {
+				t.Errorf("sigma %v energy %v: size %d is not the smallest, %d suffices", sigma, energy, size, size-2)
+			}
+		}
+	}
+
+	if got := KernelSizeForEnergy(0, 0.99); got != 1 {
+		t.Errorf("zero sigma: got %d, want 1", got)
+	}
+	if got := KernelSizeForEnergy(1000, 0.999); got != MaxEnergyKernelSize {
+		t.Errorf("huge sigma: got %d, want the cap %d", got, MaxEnergyKernelSize)
+	}
+}
+
+func TestClampKernelSize(t *testing.T) {
+	tests := []struct {
+		size, min, max, want int
+	}{
+		{7, 1, 99, 7},
+		{8, 1, 99, 9},
+		{150, 1, 99, 99},
+		{150, 1, 100, 99},
+		{1, 5, 99, 5},
+		{2, 4, 99, 5},
+		{0, 0, 99, 1},
+		// Even bounds are rounded inward, never past the cap
+		{100, 1, 100, 99},
+		{99, 1, 100, 99},
+		{101, 1, 100, 99},
+		{4, 4, 4, 3},
+		{9, 4, 4, 3},
+		{1, 6, 6, 5},
+		{3, 4, 8, 5},
+		{8, 4, 8, 7},
+		{5, 6, 99, 7},
+	}
+	for _, tc := range tests {
+		if got := ClampKernelSize(tc.size, tc.min, tc.max); got != tc.want {
+			t.Errorf("ClampKernelSize(%d, %d, %d) = %d, want %d", tc.size, tc.min, tc.max, got, tc.want)
+		}
+	}
+}
+
+// rampError is the mean absolute difference between the red channel averaged over
+// each 4x4 block and ramp, the value the block should have, over columns [x0, x1)
+func rampError(img *image.RGBA, x0, x1 int, ramp func(x float64) float64) float64 {
+	var sum float64
+	blocks := 0
+	for by := 0; by+4 <= img.Bounds().Dy(); by += 4 {
+		for bx := x0; bx+4 <= x1; bx += 4 {
+			mean := 0.0
+			for y := by; y < by+4; y++ {
+				for x := bx; x < bx+4; x++ {
+					mean += float64(img.RGBAAt(x, y).R) / 16
+				}
+			}
+			sum += math.Abs(mean - ramp(float64(bx)+1.5))
+			blocks++
+		}
+	}
+	return sum / float64(blocks)
+}
+
+func TestDitherReducesBanding(t *testing.T) {
+	// A shallow ramp stored in 8 bits is a staircase of 16-pixel bands. The blur
+	// smooths it back into a ramp of fractional values, which truncation turns into
+	// flat bands again, always rounding down. Dither mixes the two nearest levels in
+	// proportion, so each 4x4 block averages to the ramp instead.
+	img := image.NewRGBA(image.Rect(0, 0, 256, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 256; x++ {
+			v := uint8(100 + x/16)
+			img.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	ramp := func(x float64) float64 { return 100 + (x-7.5)/16 }
+	plain := ApplyBlurToImageWithOptions(img, 31, Options{})
+	dithered := ApplyBlurToImageWithOptions(img, 31, Options{Dither: true})
+
+	// Columns within the kernel radius of the edges see the clamped border, not the ramp
+	plainErr, ditheredErr := rampError(plain, 32, 224, ramp), rampError(dithered, 32, 224, ramp)
+	if ditheredErr > 0.15 || ditheredErr*3 > plainErr {
+		t.Errorf("4x4 blocks are %.3f levels off the ramp with dither, %.3f without; want dither well under 0.15",
+			ditheredErr, plainErr)
+	}
+}
+
+func TestDitherPatternUsesImageCoordinates(t *testing.T) {
+	// The threshold depends only on (x mod 4, y mod 4) in image coordinates, so a tile
+	// at any offset on the grid quantizes a pixel the same way
+	for _, v := range []float64{10.1, 10.3, 10.55, 10.8, 10.95} {
+		ones := 0
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 4; x++ {
+				q := quantize(v, x, y, true)
+				if q != quantize(v, x+256, y+4*37, true) {
+					t.Fatalf("quantize(%g) differs between (%d,%d) and the same cell of another tile", v, x, y)
+				}
+				if q != 10 && q != 11 {
+					t.Fatalf("quantize(%g) = %d, want 10 or 11", v, q)
+				}
+				ones += int(q - 10)
+			}
+		}
+		// Over a 4x4 cell the pattern rounds up in proportion to the fraction
+		if want := v - 10; math.Abs(float64(ones)/16-want) > 1.0/16 {
+			t.Errorf("quantize(%g) rounded up %d of 16 pixels, want about %.1f", v, ones, want*16)
+		}
+	}
+	if quantize(10.9, 1, 2, false) != 10 {
+		t.Error("without dither quantize no longer truncates")
+	}
+}
+
+func TestBilateralPreservesStepEdge(t *testing.T) {
+	// Black left half, white right half, with a little noise on both
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			v := uint8(20 + (x*7+y*13)%9)
+			if x >= 16 {
+				v += 200
+			}
+			img.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	gaussian := ApplyBlurToImage(img, 9)
+	bilateral := ApplyBilateral(img, 9, 20)
+
+	// Next to the edge the Gaussian mixes in the other side; the bilateral keeps each
+	// side's level
+	for _, x := range []int{15, 16} {
+		orig := float64(img.RGBAAt(x, 8).R)
+		g := math.Abs(float64(gaussian.RGBAAt(x, 8).R) - orig)
+		b := math.Abs(float64(bilateral.RGBAAt(x, 8).R) - orig)
+		if b > 6 || g < 40 {
+			t.Errorf("column %d moved %.0f levels under the bilateral and %.0f under the Gaussian, want under 6 and over 40", x, b, g)
+		}
+	}
+
+	// Away from the edge the noise is still smoothed
+	spread := func(img *image.RGBA) float64 {
+		lo, hi := 255.0, 0.0
+		for y := 4; y < 12; y++ {
+			for x := 2; x < 10; x++ {
+				v := float64(img.RGBAAt(x, y).R)
+				lo, hi = math.Min(lo, v), math.Max(hi, v)
+			}
+		}
+		return hi - lo
+	}
+	if before, after := spread(img), spread(bilateral); after*2 > before {
+		t.Errorf("the flat region spans %.0f levels after the bilateral, %.0f before; want it smoothed", after, before)
+	}
+}
+
+// maxChannelDiff is the largest difference in any channel of any pixel of a and b
+func maxChannelDiff(a, b *image.RGBA) int {
+	most := 0
+	for i := range a.Pix {
+		d := int(a.Pix[i]) - int(b.Pix[i])
+		if d < 0 {
+			d = -d
+		}
+		most = max(most, d)
+	}
+	return most
+}
+
+// noiseImage is a width x height image of seeded random opaque pixels
+func noiseImage(width, height int, seed int64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	rng := rand.New(rand.NewSource(seed))
+	rng.Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+	return img
+}
+
+func TestFloat32WithinOneLevel(t *testing.T) {
+	img := noiseImage(48, 40, 3)
+	for _, kernel := range []int{3, 9, 21} {
+		for _, dither := range []bool{false, true} {
+			f64 := ApplyBlurToImageWithOptions(img, kernel, Options{Dither: dither})
+			f32 := ApplyBlurToImageWithOptions(img, kernel, Options{Dither: dither, Float32: true})
+			if d := maxChannelDiff(f32, f64); d > 1 {
+				t.Errorf("kernel %d, dither %v: float32 is up to %d levels off float64, want at most 1", kernel, dither, d)
+			}
+		}
+	}
+}
+
+func TestLumaOnlyKeepsChroma(t *testing.T) {
+	// Mid-range colours, so adding back the chroma never clips
+	img := noiseImage(40, 32, 4)
+	for i := range img.Pix {
+		if i%4 != 3 {
+			img.Pix[i] = 60 + img.Pix[i]/2
+		} else {
+			img.Pix[i] = uint8(128 + i%100)
+		}
+	}
+	luma := func(c color.RGBA) float64 { return 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B) }
+	fast := ApplyBlurToImageWithOptions(img, 9, Options{LumaOnly: true})
+	full := ApplyBlurToImage(img, 9) // luma is linear, so its luma is the blurred luma
+
+	moved := 0.0
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 40; x++ {
+			in, out := img.RGBAAt(x, y), fast.RGBAAt(x, y)
+			// Each channel shifts by the same amount, so the differences between them
+			// (the chroma) are kept, up to truncation
+			if d := math.Abs(float64(int(out.R)-int(out.G)) - float64(int(in.R)-int(in.G))); d > 1 {
+				t.Fatalf("(%d,%d): R-G changed by %.0f", x, y, d)
+			}
+			if d := math.Abs(float64(int(out.G)-int(out.B)) - float64(int(in.G)-int(in.B))); d > 1 {
+				t.Fatalf("(%d,%d): G-B changed by %.0f", x, y, d)
+			}
+			if out.A != in.A {
+				t.Fatalf("(%d,%d): alpha %d, want it left at %d", x, y, out.A, in.A)
+			}
+			if d := math.Abs(luma(out) - luma(full.RGBAAt(x, y))); d > 1.5 {
+				t.Fatalf("(%d,%d): luma %.1f, want the blurred luma %.1f", x, y, luma(out), luma(full.RGBAAt(x, y)))
+			}
+			moved += math.Abs(luma(out) - luma(in))
+		}
+	}
+	if moved/(40*32) < 5 {
+		t.Errorf("luma moved %.1f levels on average; the noise was not blurred", moved/(40*32))
+	}
+}
+
+// psnr is the peak signal-to-noise ratio of b against a over the colour channels, in dB
+func psnr(a, b *image.RGBA) float64 {
+	var sum float64
+	n := 0
+	for i := range a.Pix {
+		if i%4 == 3 {
+			continue
+		}
+		d := float64(a.Pix[i]) - float64(b.Pix[i])
+		sum += d * d
+		n++
+	}
+	if sum == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/(sum/float64(n)))
+}
+
+func TestApproxGaussianCloseToExact(t *testing.T) {
+	// Hard edges between noisy blocks, the worst case for the box approximation
+	img := noiseImage(96, 80, 6)
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 96; x++ {
+			i := img.PixOffset(x, y)
+			base := uint8(((x/24 + y/20) % 2) * 160)
+			for c := 0; c < 3; c++ {
+				img.Pix[i+c] = base + img.Pix[i+c]/4
+			}
+		}
+	}
+	for _, sigma := range []float64{1.5, 3, 6} {
+		// A window of 8 sigma holds all but a negligible tail of the Gaussian
+		size := int(8*sigma) | 1
+		exact := ApplyBlurToImageWithOptions(img, size, Options{Sigma: sigma})
+		approx := ApproxGaussian(img, sigma)
+		p, d := psnr(exact, approx), maxChannelDiff(exact, approx)
+		if p < 42 || d > 10 {
+			t.Errorf("sigma %v: PSNR %.1f dB and max diff %d against the exact Gaussian, want at least 42 dB and at most 10", sigma, p, d)
+		}
+	}
+}
+
+func TestBoxSizesMatchVariance(t *testing.T) {
+	for _, sigma := range []float64{0.8, 1.5, 3, 6, 12.5} {
+		sizes := BoxSizesForGaussian(sigma, approxPasses)
+		variance := 0.0
+		for _, w := range sizes {
+			if w%2 == 0 {
+				t.Errorf("sigma %v: box width %d is even", sigma, w)
+			}
+			variance += float64(w*w-1) / 12
+		}
+		// Odd widths step the variance in coarse increments, so it lands within one step
+		if step := float64(sizes[len(sizes)-1]+1) / 3; math.Abs(variance-sigma*sigma) > step {
+			t.Errorf("sigma %v: boxes %v have variance %.2f, want about %.2f", sigma, sizes, variance, sigma*sigma)
+		}
+	}
+}
+
+func TestSoftnessToSigma(t *testing.T) {
+	tests := []struct {
+		softness float64
+		size     int
+		want     float64
+	}{
+		{100, 15, 3.5}, // radius 7 / 2
+		{50, 15, 1.75},
+		{100, 13, 3},
+		{150, 13, 3}, // clamped to 100%
+		{0, 15, MinSoftnessSigma},
+		{-20, 15, MinSoftnessSigma},
+		{100, 1, MinSoftnessSigma}, // no radius to spread over
+	}
+	for _, tc := range tests {
+		if got := SoftnessToSigma(tc.softness, tc.size); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("SoftnessToSigma(%v, %d) = %v, want %v", tc.softness, tc.size, got, tc.want)
+		}
+	}
+
+	// 0% leaves all the weight on the centre; 100% spreads it over the window
+	delta := GenerateGaussianKernelSigma(15, SoftnessToSigma(0, 15))
+	if delta[7][7] < 0.999 {
+		t.Errorf("0%% softness puts %.4f of the weight on the centre, want a near-delta kernel", delta[7][7])
+	}
+	broad := GenerateGaussianKernelSigma(15, SoftnessToSigma(100, 15))
+	if broad[7][7] > 0.02 || broad[7][0] < 0.001 {
+		t.Errorf("100%% softness: centre weight %.4f, edge weight %.4f, want a broad kernel", broad[7][7], broad[7][0])
+	}
+}
+
+func TestDerivedKernelSizeClamped(t *testing.T) {
+	// As the a and d processors derive -kernel-energy sizes: the energy picks a size,
+	// then -min-kernel and -max-kernel bound it, odd either way
+	tests := []struct {
+		sigma    float64
+		min, max int
+		want     int
+	}{
+		{0.3, 5, 99, 5},  // derives 1 or 3, raised to the minimum
+		{0.3, 4, 99, 5},  // even minimum, rounded up
+		{40, 1, 99, 99},  // derives far more, capped
+		{40, 1, 100, 99}, // even maximum, rounded down
+		{2, 1, 99, KernelSizeForEnergy(2, 0.99)},
+	}
+	for _, tc := range tests {
+		got := ClampKernelSize(KernelSizeForEnergy(tc.sigma, 0.99), tc.min, tc.max)
+		if got != tc.want || got%2 == 0 {
+			t.Errorf("sigma %v within %d..%d: kernel %d, want %d", tc.sigma, tc.min, tc.max, got, tc.want)
+		}
+	}
+}
+
+func TestValidateKernel(t *testing.T) {
+	tests := []struct {
+		name   string
+		kernel [][]float64
+		ok     bool
+	}{
+		{"generated", GenerateGaussianKernel(9), true},
+		{"delta", [][]float64{{1}}, true},
+		{"sums to 0.9", [][]float64{{0.3, 0.3, 0.3}}, false},
+		{"sums to 1.1", [][]float64{{0.5, 0.6}}, false},
+		{"negative weight", [][]float64{{-0.5, 1, 0.5}}, false},
+		{"NaN weight", [][]float64{{math.NaN(), 1}}, false},
+		{"infinite weight", [][]float64{{math.Inf(1)}}, false},
+		{"empty", nil, false},
+	}
+	for _, tc := range tests {
+		if err := ValidateKernel(tc.kernel); (err == nil) != tc.ok {
+			t.Errorf("%s: ValidateKernel = %v, want ok %v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestCheckKernel(t *testing.T) {
+	tests := []struct {
+		size  int
+		sigma float64
+		ok    bool
+	}{
+		{15, 0, true},
+		{15, 2.5, true},
+		{15, MinSoftnessSigma, true},
+		{15, math.NaN(), false},  // every weight is NaN
+		{15, math.Inf(1), false}, // every weight is 0, so normalizing divides by 0
+		{15, 1e-300, false},      // the centre weight overflows
+		{0, 1, false},
+		{-3, 1, false},
+	}
+	for _, tc := range tests {
+		if err := CheckKernel(tc.size, tc.sigma); (err == nil) != tc.ok {
+			t.Errorf("CheckKernel(%d, %v) = %v, want ok %v", tc.size, tc.sigma, err, tc.ok)
+		}
+	}
+}
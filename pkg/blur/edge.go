@@ -0,0 +1,51 @@
+package blur
+
+import "fmt"
+
+// EdgeMode selects how pixels beyond the image border are synthesized for padding
+type EdgeMode string
+
+const (
+	// EdgeClamp repeats the nearest border pixel: aaa|abcd
+	EdgeClamp EdgeMode = "clamp"
+	// EdgeReflect mirrors the image about its border, repeating the edge pixel: cba|abcd
+	EdgeReflect EdgeMode = "reflect"
+	// EdgeWrap tiles the image periodically: bcd|abcd
+	EdgeWrap EdgeMode = "wrap"
+)
+
+// ParseEdgeMode maps an -edge-mode flag value to an EdgeMode; empty means clamp
+func ParseEdgeMode(name string) (EdgeMode, error) {
+	switch EdgeMode(name) {
+	case "", EdgeClamp:
+		return EdgeClamp, nil
+	case EdgeReflect, EdgeWrap:
+		return EdgeMode(name), nil
+	default:
+		return EdgeClamp, fmt.Errorf("unknown edge mode %q (use clamp, reflect or wrap)", name)
+	}
+}
+
+// Index maps a coordinate i, possibly outside 0..n-1, to the source coordinate
+// the edge mode reads from
+func (m EdgeMode) Index(i, n int) int {
+	if i >= 0 && i < n {
+		return i
+	}
+	switch m {
+	case EdgeReflect:
+		period := 2 * n
+		i = ((i % period) + period) % period
+		if i >= n {
+			i = period - 1 - i
+		}
+		return i
+	case EdgeWrap:
+		return ((i % n) + n) % n
+	default:
+		if i < 0 {
+			return 0
+		}
+		return n - 1
+	}
+}
@@ -0,0 +1,56 @@
+package blur
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEdgeModeIndex(t *testing.T) {
+	// Coordinates -3..6 of a 4-pixel row
+	tests := []struct {
+		mode EdgeMode
+		want []int
+	}{
+		{EdgeClamp, []int{0, 0, 0, 0, 1, 2, 3, 3, 3, 3}},
+		{EdgeReflect, []int{2, 1, 0, 0, 1, 2, 3, 3, 2, 1}},
+		{EdgeWrap, []int{1, 2, 3, 0, 1, 2, 3, 0, 1, 2}},
+	}
+	for _, tc := range tests {
+		var got []int
+		for i := -3; i <= 6; i++ {
+			got = append(got, tc.mode.Index(i, 4))
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: -3..6 map to %v, want %v", tc.mode, got, tc.want)
+		}
+	}
+
+	// Reflect and wrap stay in range however far past the edge the padding reaches
+	for _, mode := range []EdgeMode{EdgeReflect, EdgeWrap} {
+		for i := -20; i < 20; i++ {
+			if got := mode.Index(i, 3); got < 0 || got >= 3 {
+				t.Errorf("%s: Index(%d, 3) = %d, outside the row", mode, i, got)
+			}
+		}
+	}
+}
+
+func TestParseEdgeMode(t *testing.T) {
+	tests := []struct {
+		name string
+		want EdgeMode
+		ok   bool
+	}{
+		{"", EdgeClamp, true},
+		{"clamp", EdgeClamp, true},
+		{"reflect", EdgeReflect, true},
+		{"wrap", EdgeWrap, true},
+		{"mirror", EdgeClamp, false},
+	}
+	for _, tc := range tests {
+		got, err := ParseEdgeMode(tc.name)
+		if got != tc.want || (err == nil) != tc.ok {
+			t.Errorf("ParseEdgeMode(%q) = %q, %v; want %q and ok %v", tc.name, got, err, tc.want, tc.ok)
+		}
+	}
+}
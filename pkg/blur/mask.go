@@ -0,0 +1,108 @@
+package blur
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// ApplyBlurMasked blurs img only where mask is nonzero. The mask value sets the blend:
+// 255 takes the blurred pixel, 0 keeps the original and values between mix the two.
+// The mask must have the same dimensions as the image.
+func ApplyBlurMasked(img *image.RGBA, mask *image.Gray, kernelSize int) (*image.RGBA, error) {
+	if err := checkMask(img.Bounds(), mask); err != nil {
+		return nil, err
+	}
+	return BlendMasked(img, ApplyBlurToImage(img, kernelSize), mask)
+}
+
+// BlendMasked mixes a blurred image back over its original by the mask, as
+// ApplyBlurMasked does, for callers that produced the blur with their own options
+func BlendMasked(orig, blurred *image.RGBA, mask *image.Gray) (*image.RGBA, error) {
+	ob, bb := orig.Bounds(), blurred.Bounds()
+	if ob.Dx() != bb.Dx() || ob.Dy() != bb.Dy() {
+		return nil, fmt.Errorf("blurred image is %dx%d but the original is %dx%d", bb.Dx(), bb.Dy(), ob.Dx(), ob.Dy())
+	}
+	if err := checkMask(ob, mask); err != nil {
+		return nil, err
+	}
+
+	mb := mask.Bounds()
+	dst := image.NewRGBA(ob)
+	for y := 0; y < ob.Dy(); y++ {
+		for x := 0; x < ob.Dx(); x++ {
+			o := orig.RGBAAt(ob.Min.X+x, ob.Min.Y+y)
+			m := uint32(mask.GrayAt(mb.Min.X+x, mb.Min.Y+y).Y)
+			switch m {
+			case 0:
+				dst.SetRGBA(ob.Min.X+x, ob.Min.Y+y, o)
+				continue
+			case 255:
+				dst.SetRGBA(ob.Min.X+x, ob.Min.Y+y, blurred.RGBAAt(bb.Min.X+x, bb.Min.Y+y))
+				continue
+			}
+			b := blurred.RGBAAt(bb.Min.X+x, bb.Min.Y+y)
+			mix := func(o, b uint8) uint8 {
+				return uint8((uint32(o)*(255-m) + uint32(b)*m + 127) / 255)
+			}
+			dst.SetRGBA(ob.Min.X+x, ob.Min.Y+y, color.RGBA{mix(o.R, b.R), mix(o.G, b.G), mix(o.B, b.B), mix(o.A, b.A)})
+		}
+	}
+	return dst, nil
+}
+
+// VignetteMask returns a radial mask for BlendMasked that keeps the centre of bounds
+// sharp and blurs progressively toward the edges, fully at the corners. strength in
+// (0, 1] is the fraction of the centre-to-corner distance the blur fades in over: 1
+// starts fading in at the centre, 0.3 keeps the middle 70% sharp. The fade is a
+// smoothstep, so there is no visible ring where it begins. A strength of 0 or less
+// fades in over no distance at all, so the mask is zero and the whole image stays sharp.
+func VignetteMask(bounds image.Rectangle, strength float64) *image.Gray {
+	mask := image.NewGray(bounds)
+	if strength <= 0 {
+		return mask
+	}
+	// Distances are measured between pixel centres, normalised so a corner pixel is 1
+	cx, cy := float64(bounds.Dx()-1)/2, float64(bounds.Dy()-1)/2
+	corner := math.Hypot(cx, cy)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			d := 1.0
+			if corner > 0 {
+				d = math.Hypot(float64(x)-cx, float64(y)-cy) / corner
+			}
+			t := math.Min(math.Max((d-(1-strength))/strength, 0), 1)
+			mask.Pix[y*mask.Stride+x] = uint8(math.Round(255 * t * t * (3 - 2*t)))
+		}
+	}
+	return mask
+}
+
+// ApplyBlurVignette blurs img through a VignetteMask of the given strength
+func ApplyBlurVignette(img *image.RGBA, kernelSize int, strength float64) (*image.RGBA, error) {
+	return ApplyBlurMasked(img, VignetteMask(img.Bounds(), strength), kernelSize)
+}
+
+// ToGray converts a decoded mask image to 8-bit grayscale
+func ToGray(img image.Image) *image.Gray {
+	if gray, ok := img.(*image.Gray); ok {
+		return gray
+	}
+	bounds := img.Bounds()
+	gray := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gray.Set(x, y, img.At(x, y))
+		}
+	}
+	return gray
+}
+
+func checkMask(bounds image.Rectangle, mask *image.Gray) error {
+	mb := mask.Bounds()
+	if mb.Dx() != bounds.Dx() || mb.Dy() != bounds.Dy() {
+		return fmt.Errorf("mask is %dx%d but the image is %dx%d", mb.Dx(), mb.Dy(), bounds.Dx(), bounds.Dy())
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package blur
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestApplyBlurMaskedHalfMask(t *testing.T) {
+	img := noiseImage(32, 24, 12)
+	mask := image.NewGray(img.Bounds())
+	for y := 0; y < 24; y++ {
+		for x := 16; x < 32; x++ {
+			mask.Pix[y*mask.Stride+x] = 255
+		}
+		mask.Pix[y*mask.Stride+15] = 128 // a half-strength seam column
+	}
+	blurred := ApplyBlurToImage(img, 7)
+	got, err := ApplyBlurMasked(img, mask, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			switch c := got.RGBAAt(x, y); {
+			case x < 15 && c != img.RGBAAt(x, y):
+				t.Fatalf("(%d,%d) is masked out but changed from %v to %v", x, y, img.RGBAAt(x, y), c)
+			case x >= 16 && c != blurred.RGBAAt(x, y):
+				t.Fatalf("(%d,%d) is fully masked but is %v, not the blur %v", x, y, c, blurred.RGBAAt(x, y))
+			case x == 15:
+				o, b := img.RGBAAt(x, y).R, blurred.RGBAAt(x, y).R
+				if c.R < min(o, b) || c.R > max(o, b) {
+					t.Fatalf("(%d,%d) is half masked but its red %d is not between %d and %d", x, y, c.R, o, b)
+				}
+			}
+		}
+	}
+
+	if _, err := ApplyBlurMasked(img, image.NewGray(image.Rect(0, 0, 32, 23)), 7); err == nil {
+		t.Error("a mask one row short was accepted")
+	}
+}
+
+func TestVignetteKeepsCentreBlursCorners(t *testing.T) {
+	img := noiseImage(41, 31, 13) // odd sizes, so one pixel sits at the centre
+	blurred := ApplyBlurToImage(img, 9)
+	got, err := ApplyBlurVignette(img, 9, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RGBAAt(20, 15) != img.RGBAAt(20, 15) {
+		t.Errorf("centre pixel changed from %v to %v", img.RGBAAt(20, 15), got.RGBAAt(20, 15))
+	}
+	for _, p := range []image.Point{{0, 0}, {40, 0}, {0, 30}, {40, 30}} {
+		if got.RGBAAt(p.X, p.Y) != blurred.RGBAAt(p.X, p.Y) {
+			t.Errorf("corner %v is %v, want the full blur %v", p, got.RGBAAt(p.X, p.Y), blurred.RGBAAt(p.X, p.Y))
+		}
+	}
+
+	// With strength 0.5 the inner half stays sharp and the mask rises toward the corner
+	mask := VignetteMask(img.Bounds(), 0.5)
+	prev := uint8(0)
+	for i := 0; i <= 20; i++ {
+		x, y := 20+i, 15+i*15/20
+		m := mask.GrayAt(x, y).Y
+		if m < prev {
+			t.Errorf("the mask falls from %d to %d at (%d,%d) on the way to the corner", prev, m, x, y)
+		}
+		if i < 9 && m != 0 {
+			t.Errorf("(%d,%d), inside the sharp middle, has mask %d", x, y, m)
+		}
+		prev = m
+	}
+}
+
+func TestVignetteMaskZeroStrength(t *testing.T) {
+	// Strength divides the fade, so 0 must not turn the mask into NaN garbage
+	img := noiseImage(41, 31, 14)
+	for _, strength := range []float64{0, -0.5} {
+		mask := VignetteMask(img.Bounds(), strength)
+		for i, m := range mask.Pix {
+			if m != 0 {
+				t.Fatalf("strength %v: mask pixel %d is %d, want 0 everywhere", strength, i, m)
+			}
+		}
+		got, err := ApplyBlurVignette(img, 9, strength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Pix, img.Pix) {
+			t.Errorf("strength %v changed the image, want it left sharp", strength)
+		}
+	}
+}
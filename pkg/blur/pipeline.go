@@ -0,0 +1,132 @@
+package blur
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+)
+
+// Step is one transform of a Pipeline
+type Step struct {
+	Spec    string // the op as written, e.g. "blur:15"
+	apply   func(*image.RGBA) *image.RGBA
+	resizes bool // the output has different bounds from the input
+}
+
+// Pipeline is an ordered list of transforms applied to a decoded image
+type Pipeline []Step
+
+// ParsePipeline parses a comma-separated -pipeline spec such as
+//
+//	scale:0.5,blur:15,unsharp:0.5
+//
+// Supported ops are scale:FACTOR, blur:KERNEL and unsharp:AMOUNT[:KERNEL] (kernel
+// defaults to DefaultUnsharpKernel). Blur steps use opts for everything but the kernel
+// and its default sigma, so -dither and friends still apply.
+//
+// Steps run in the order written, which sets the speed/quality tradeoff of a
+// downscaled blur: scale:0.5,blur:7 blurs a quarter of the pixels and is about four
+// times faster, while blur:15,scale:0.5 blurs at full resolution first and comes
+// closer to the full-size result, at full-resolution cost. Halve the kernel when
+// scaling first to keep a similar blur radius relative to the image.
+func ParsePipeline(spec string, opts Options) (Pipeline, error) {
+	var pipeline Pipeline
+	for _, op := range strings.Split(spec, ",") {
+		op = strings.TrimSpace(op)
+		if op == "" {
+			continue
+		}
+		step, err := parseStep(op, opts)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline op %q: %w", op, err)
+		}
+		pipeline = append(pipeline, step)
+	}
+	if len(pipeline) == 0 {
+		return nil, fmt.Errorf("empty pipeline %q", spec)
+	}
+	return pipeline, nil
+}
+
+func parseStep(op string, opts Options) (Step, error) {
+	name, args, _ := strings.Cut(op, ":")
+	params := strings.Split(args, ":")
+	step := Step{Spec: op}
+
+	switch name {
+	case "scale":
+		factor, err := strconv.ParseFloat(params[0], 64)
+		if err != nil || factor <= 0 || len(params) != 1 {
+			return step, fmt.Errorf("want scale:FACTOR with FACTOR > 0")
+		}
+		step.apply = func(img *image.RGBA) *image.RGBA { return ScaleWithFilter(img, factor, opts.ResizeFilter) }
+		step.resizes = factor != 1
+
+	case "blur":
+		kernel, err := parseKernel(params[0])
+		if err != nil || len(params) != 1 {
+			return step, fmt.Errorf("want blur:KERNEL with an odd KERNEL >= 1")
+		}
+		blurOpts := opts
+		blurOpts.Sigma = 0 // a sigma derived for the run's -kernel does not fit this one
+		step.apply = func(img *image.RGBA) *image.RGBA { return ApplyBlurToImageWithOptions(img, kernel, blurOpts) }
+
+	case "unsharp":
+		amount, err := strconv.ParseFloat(params[0], 64)
+		if err != nil || amount < 0 || len(params) > 2 {
+			return step, fmt.Errorf("want unsharp:AMOUNT[:KERNEL] with AMOUNT >= 0")
+		}
+		kernel := DefaultUnsharpKernel
+		if len(params) == 2 {
+			if kernel, err = parseKernel(params[1]); err != nil {
+				return step, fmt.Errorf("unsharp kernel must be odd and >= 1")
+			}
+		}
+		step.apply = func(img *image.RGBA) *image.RGBA { return Unsharp(img, kernel, amount) }
+
+	default:
+		return step, fmt.Errorf("unknown op %q (use scale, blur or unsharp)", name)
+	}
+	return step, nil
+}
+
+func parseKernel(s string) (int, error) {
+	kernel, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if kernel < 1 || kernel%2 == 0 {
+		return 0, fmt.Errorf("kernel %d is not odd and positive", kernel)
+	}
+	return kernel, nil
+}
+
+// Apply runs every step in order and returns the final image
+func (p Pipeline) Apply(img image.Image) *image.RGBA {
+	out := ToRGBA(img)
+	for _, step := range p {
+		out = step.apply(out)
+	}
+	return out
+}
+
+// Resizes reports whether a step changes the image's size, so the output can't be
+// blended pixel for pixel with the input
+func (p Pipeline) Resizes() bool {
+	for _, step := range p {
+		if step.resizes {
+			return true
+		}
+	}
+	return false
+}
+
+// String joins the steps back into a spec
+func (p Pipeline) String() string {
+	specs := make([]string, len(p))
+	for i, step := range p {
+		specs[i] = step.Spec
+	}
+	return strings.Join(specs, ",")
+}
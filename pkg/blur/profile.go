@@ -0,0 +1,60 @@
+package blur
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Profile is a named blur strength, for users who would rather not pick kernel sizes
+type Profile struct {
+	Name       string
+	KernelSize int
+	EdgeMode   EdgeMode // border padding of tiled runs; whole-image blurs always clamp
+	Passes     int      // times the kernel is applied; each pass widens the blur by about √2
+}
+
+// Profiles are the -profile presets, weakest first. Stronger ones reflect at the
+// border, where a large clamped kernel smears the edge pixels inwards.
+var Profiles = []Profile{
+	{Name: "light", KernelSize: 7, EdgeMode: EdgeClamp, Passes: 1},
+	{Name: "medium", KernelSize: 15, EdgeMode: EdgeClamp, Passes: 1},
+	{Name: "heavy", KernelSize: 31, EdgeMode: EdgeReflect, Passes: 1},
+	{Name: "extreme", KernelSize: 51, EdgeMode: EdgeReflect, Passes: 2},
+}
+
+// ParseProfile looks up a -profile flag value
+func ParseProfile(name string) (Profile, error) {
+	names := make([]string, len(Profiles))
+	for i, p := range Profiles {
+		if p.Name == name {
+			return p, nil
+		}
+		names[i] = p.Name
+	}
+	return Profile{}, fmt.Errorf("unknown profile %q (use %s)", name, strings.Join(names, ", "))
+}
+
+// Pipeline returns the profile's passes of a kernelSize blur, with opts as for
+// ParsePipeline, or nil for a single pass, which the plain blur already does
+func (p Profile) Pipeline(kernelSize int, opts Options) Pipeline {
+	if p.Passes <= 1 {
+		return nil
+	}
+	steps := make([]string, p.Passes)
+	for i := range steps {
+		steps[i] = fmt.Sprintf("blur:%d", kernelSize)
+	}
+	pipeline, err := ParsePipeline(strings.Join(steps, ","), opts)
+	if err != nil {
+		panic(err) // the spec is built from a valid kernel size
+	}
+	return pipeline
+}
+
+func (p Profile) String() string {
+	passes := "1 pass"
+	if p.Passes != 1 {
+		passes = fmt.Sprintf("%d passes", p.Passes)
+	}
+	return fmt.Sprintf("%s (kernel %d, %s edges, %s)", p.Name, p.KernelSize, p.EdgeMode, passes)
+}
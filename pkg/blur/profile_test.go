@@ -0,0 +1,47 @@
+package blur
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseProfile(t *testing.T) {
+	tests := []struct {
+		name       string
+		wantKernel int
+		wantEdge   EdgeMode
+		wantPasses int
+		wantErr    bool
+	}{
+		{"light", 7, EdgeClamp, 1, false},
+		{"medium", 15, EdgeClamp, 1, false},
+		{"heavy", 31, EdgeReflect, 1, false},
+		{"extreme", 51, EdgeReflect, 2, false},
+		{"ultra", 0, "", 0, true},
+		{"", 0, "", 0, true},
+		{"Heavy", 0, "", 0, true},
+		{" light", 0, "", 0, true},
+		{"light,heavy", 0, "", 0, true},
+		{"15", 0, "", 0, true},
+	}
+	for _, tc := range tests {
+		p, err := ParseProfile(tc.name)
+		if tc.wantErr {
+			// The error lists the valid names
+			if err == nil || !strings.Contains(err.Error(), "light, medium, heavy, extreme") {
+				t.Errorf("ParseProfile(%q) = %v, %v; want an error listing the profiles", tc.name, p, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseProfile(%q): %v", tc.name, err)
+			continue
+		}
+		if p.Name != tc.name || p.KernelSize != tc.wantKernel || p.EdgeMode != tc.wantEdge || p.Passes != tc.wantPasses {
+			t.Errorf("ParseProfile(%q) = %v, want kernel %d, %s edges, %d passes", tc.name, p, tc.wantKernel, tc.wantEdge, tc.wantPasses)
+		}
+		if pipeline := p.Pipeline(p.KernelSize, Options{}); (pipeline != nil) != (tc.wantPasses > 1) {
+			t.Errorf("%s: pipeline %v for %d passes", tc.name, pipeline, tc.wantPasses)
+		}
+	}
+}
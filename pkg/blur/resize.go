@@ -0,0 +1,257 @@
+package blur
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// ResizeFilter selects the interpolation Resize uses
+type ResizeFilter string
+
+const (
+	// FilterNearest copies the nearest source pixel: fastest, and exact at integer ratios
+	FilterNearest ResizeFilter = "nearest"
+	// FilterBilinear blends the four nearest source pixels (the default)
+	FilterBilinear ResizeFilter = "bilinear"
+	// FilterCatmullRom is a separable bicubic; when downscaling its support widens with
+	// the ratio so every source pixel contributes, which avoids aliasing
+	FilterCatmullRom ResizeFilter = "catmull-rom"
+	// FilterLanczos is a separable 3-lobe Lanczos, widened for downscaling the same way
+	FilterLanczos ResizeFilter = "lanczos"
+)
+
+// ParseResizeFilter maps a -resize-filter flag value to a ResizeFilter; empty means bilinear
+func ParseResizeFilter(name string) (ResizeFilter, error) {
+	switch ResizeFilter(name) {
+	case "", FilterBilinear:
+		return FilterBilinear, nil
+	case FilterNearest, FilterCatmullRom, FilterLanczos:
+		return ResizeFilter(name), nil
+	default:
+		return FilterBilinear, fmt.Errorf("unknown resize filter %q (use nearest, bilinear, catmull-rom or lanczos)", name)
+	}
+}
+
+// Resize scales img to width x height with bilinear interpolation. Source pixels are
+// sampled at their centres, so a 2:1 downscale averages each 2x2 block.
+func Resize(img image.Image, width, height int) *image.RGBA {
+	return ResizeWithFilter(img, width, height, FilterBilinear)
+}
+
+// ResizeWithFilter is Resize with a choice of interpolation
+func ResizeWithFilter(img image.Image, width, height int, filter ResizeFilter) *image.RGBA {
+	switch filter {
+	case FilterNearest:
+		return resizeNearest(ToRGBA(img), width, height)
+	case FilterCatmullRom:
+		return resizeSeparable(ToRGBA(img), width, height, catmullRom, 2)
+	case FilterLanczos:
+		return resizeSeparable(ToRGBA(img), width, height, lanczos3, 3)
+	default:
+		return resizeBilinear(ToRGBA(img), width, height)
+	}
+}
+
+func resizeBilinear(src *image.RGBA, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if srcW == 0 || srcH == 0 {
+		return dst
+	}
+
+	xRatio := float64(srcW) / float64(width)
+	yRatio := float64(srcH) / float64(height)
+	for y := 0; y < height; y++ {
+		sy := math.Max(0, (float64(y)+0.5)*yRatio-0.5)
+		y0 := int(sy)
+		y1 := min(y0+1, srcH-1)
+		fy := sy - float64(y0)
+		for x := 0; x < width; x++ {
+			sx := math.Max(0, (float64(x)+0.5)*xRatio-0.5)
+			x0 := int(sx)
+			x1 := min(x0+1, srcW-1)
+			fx := sx - float64(x0)
+
+			p00 := src.RGBAAt(bounds.Min.X+x0, bounds.Min.Y+y0)
+			p10 := src.RGBAAt(bounds.Min.X+x1, bounds.Min.Y+y0)
+			p01 := src.RGBAAt(bounds.Min.X+x0, bounds.Min.Y+y1)
+			p11 := src.RGBAAt(bounds.Min.X+x1, bounds.Min.Y+y1)
+			lerp := func(c00, c10, c01, c11 uint8) uint8 {
+				top := float64(c00)*(1-fx) + float64(c10)*fx
+				bottom := float64(c01)*(1-fx) + float64(c11)*fx
+				return uint8(math.Round(top*(1-fy) + bottom*fy))
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: lerp(p00.R, p10.R, p01.R, p11.R),
+				G: lerp(p00.G, p10.G, p01.G, p11.G),
+				B: lerp(p00.B, p10.B, p01.B, p11.B),
+				A: lerp(p00.A, p10.A, p01.A, p11.A),
+			})
+		}
+	}
+	return dst
+}
+
+// Scale resizes img by factor in both dimensions, keeping at least one pixel
+func Scale(img image.Image, factor float64) *image.RGBA {
+	return ScaleWithFilter(img, factor, FilterBilinear)
+}
+
+// ScaleWithFilter is Scale with a choice of interpolation
+func ScaleWithFilter(img image.Image, factor float64, filter ResizeFilter) *image.RGBA {
+	bounds := img.Bounds()
+	width := max(1, int(math.Round(float64(bounds.Dx())*factor)))
+	height := max(1, int(math.Round(float64(bounds.Dy())*factor)))
+	return ResizeWithFilter(img, width, height, filter)
+}
+
+func resizeNearest(src *image.RGBA, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if srcW == 0 || srcH == 0 {
+		return dst
+	}
+	for y := 0; y < height; y++ {
+		sy := min(srcH-1, int((float64(y)+0.5)*float64(srcH)/float64(height)))
+		for x := 0; x < width; x++ {
+			sx := min(srcW-1, int((float64(x)+0.5)*float64(srcW)/float64(width)))
+			dst.SetRGBA(x, y, src.RGBAAt(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// catmullRom is the Catmull-Rom cubic (B=0, C=0.5), support 2
+func catmullRom(t float64) float64 {
+	t = math.Abs(t)
+	switch {
+	case t < 1:
+		return 1.5*t*t*t - 2.5*t*t + 1
+	case t < 2:
+		return -0.5*t*t*t + 2.5*t*t - 4*t + 2
+	default:
+		return 0
+	}
+}
+
+// lanczos3 is the 3-lobe Lanczos window, support 3
+func lanczos3(t float64) float64 {
+	t = math.Abs(t)
+	if t < 1e-9 {
+		return 1
+	}
+	if t >= 3 {
+		return 0
+	}
+	pt := math.Pi * t
+	return 3 * math.Sin(pt) * math.Sin(pt/3) / (pt * pt)
+}
+
+// resampleWeights holds, for each output coordinate, the first source index and the
+// normalised weights of the source pixels that contribute to it
+type resampleWeights struct {
+	start   []int
+	weights [][]float64
+}
+
+// computeWeights samples kernel (with the given support) for a srcN -> dstN resize.
+// When downscaling the kernel is stretched by the ratio so it low-pass filters the
+// source instead of skipping pixels.
+func computeWeights(srcN, dstN int, kernel func(float64) float64, support float64) resampleWeights {
+	ratio := float64(srcN) / float64(dstN)
+	stretch := math.Max(1, ratio)
+	radius := support * stretch
+
+	rw := resampleWeights{start: make([]int, dstN), weights: make([][]float64, dstN)}
+	for i := 0; i < dstN; i++ {
+		center := (float64(i)+0.5)*ratio - 0.5
+		first := int(math.Ceil(center - radius))
+		last := int(math.Floor(center + radius))
+		ws := make([]float64, 0, last-first+1)
+		sum := 0.0
+		for j := first; j <= last; j++ {
+			w := kernel((float64(j) - center) / stretch)
+			ws = append(ws, w)
+			sum += w
+		}
+		if sum != 0 {
+			for k := range ws {
+				ws[k] /= sum
+			}
+		}
+		rw.start[i] = first
+		rw.weights[i] = ws
+	}
+	return rw
+}
+
+// resizeSeparable resamples horizontally then vertically with a kernel filter, in
+// float64 so the intermediate pass keeps the negative lobes' overshoot. Source
+// coordinates outside the image are clamped to the border.
+func resizeSeparable(src *image.RGBA, width, height int, kernel func(float64) float64, support float64) *image.RGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if srcW == 0 || srcH == 0 {
+		return dst
+	}
+
+	clampIndex := func(i, n int) int { return max(0, min(n-1, i)) }
+
+	// Horizontal pass: srcH rows of width pixels, 4 channels each
+	xw := computeWeights(srcW, width, kernel, support)
+	tmp := make([]float64, srcH*width*4)
+	for y := 0; y < srcH; y++ {
+		for x := 0; x < width; x++ {
+			var acc [4]float64
+			for k, w := range xw.weights[x] {
+				p := src.RGBAAt(bounds.Min.X+clampIndex(xw.start[x]+k, srcW), bounds.Min.Y+y)
+				acc[0] += w * float64(p.R)
+				acc[1] += w * float64(p.G)
+				acc[2] += w * float64(p.B)
+				acc[3] += w * float64(p.A)
+			}
+			copy(tmp[(y*width+x)*4:], acc[:])
+		}
+	}
+
+	// Vertical pass into the destination
+	yw := computeWeights(srcH, height, kernel, support)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var acc [4]float64
+			for k, w := range yw.weights[y] {
+				row := clampIndex(yw.start[y]+k, srcH)
+				for c := 0; c < 4; c++ {
+					acc[c] += w * tmp[(row*width+x)*4+c]
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(clamp255(acc[0]) + 0.5),
+				G: uint8(clamp255(acc[1]) + 0.5),
+				B: uint8(clamp255(acc[2]) + 0.5),
+				A: uint8(clamp255(acc[3]) + 0.5),
+			})
+		}
+	}
+	return dst
+}
+
+// ToRGBA returns img as an *image.RGBA, converting only when it is another type.
+// draw.Draw has fast paths for the decoders' NRGBA, YCbCr (baseline JPEG), CMYK (Adobe
+// CMYK JPEG), Gray and Paletted images and produces the same pixels as a per-pixel
+// Set loop, several times faster.
+func ToRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(bounds)
+	draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
+	return rgba
+}
@@ -0,0 +1,176 @@
+package blur
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"math/rand"
+	"testing"
+)
+
+// decoderImages returns a random image of each type the decoders produce, with a
+// non-zero origin so conversions that assume Min is (0, 0) show up
+func decoderImages(width, height int) map[string]image.Image {
+	rng := rand.New(rand.NewSource(7))
+	rect := image.Rect(3, 5, 3+width, 5+height)
+
+	nrgba := image.NewNRGBA(rect)
+	rng.Read(nrgba.Pix)
+
+	ycbcr := image.NewYCbCr(rect, image.YCbCrSubsampleRatio420)
+	rng.Read(ycbcr.Y)
+	rng.Read(ycbcr.Cb)
+	rng.Read(ycbcr.Cr)
+
+	paletted := image.NewPaletted(rect, palette.Plan9)
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(rng.Intn(len(palette.Plan9)))
+	}
+
+	cmyk := image.NewCMYK(rect)
+	rng.Read(cmyk.Pix)
+
+	gray := image.NewGray(rect)
+	rng.Read(gray.Pix)
+
+	return map[string]image.Image{
+		"NRGBA": nrgba, "YCbCr": ycbcr, "Paletted": paletted, "CMYK": cmyk, "Gray": gray,
+	}
+}
+
+// toRGBASlow is the per-pixel Set loop ToRGBA's draw.Draw replaced
+func toRGBASlow(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			rgba.Set(x, y, img.At(x, y))
+		}
+	}
+	return rgba
+}
+
+func TestToRGBAMatchesSet(t *testing.T) {
+	for name, img := range decoderImages(37, 29) {
+		got, want := ToRGBA(img), toRGBASlow(img)
+		if got.Bounds() != want.Bounds() {
+			t.Errorf("%s: bounds %v, want %v", name, got.Bounds(), want.Bounds())
+			continue
+		}
+		if !bytes.Equal(got.Pix, want.Pix) {
+			t.Errorf("%s: ToRGBA differs from the per-pixel Set loop", name)
+		}
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	rgba.SetRGBA(1, 1, color.RGBA{1, 2, 3, 4})
+	if ToRGBA(rgba) != rgba {
+		t.Error("ToRGBA copied an image that is already RGBA")
+	}
+}
+
+func BenchmarkToRGBA(b *testing.B) {
+	for _, name := range []string{"NRGBA", "YCbCr"} {
+		img := decoderImages(2048, 2048)[name]
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ToRGBA(img)
+			}
+		})
+		b.Run(name+"/Set", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				toRGBASlow(img)
+			}
+		})
+	}
+}
+
+func TestParseResizeFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    ResizeFilter
+		wantErr bool
+	}{
+		{"", FilterBilinear, false},
+		{"bilinear", FilterBilinear, false},
+		{"nearest", FilterNearest, false},
+		{"catmull-rom", FilterCatmullRom, false},
+		{"lanczos", FilterLanczos, false},
+		{"bicubic", FilterBilinear, true},
+		{"Nearest", FilterBilinear, true},
+	}
+	for _, tc := range tests {
+		got, err := ParseResizeFilter(tc.name)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("ParseResizeFilter(%q) = %q, %v; want %q, error %v", tc.name, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestResizeNearestIntegerRatios(t *testing.T) {
+	src := decoderImages(6, 4)["NRGBA"]
+	rgba := ToRGBA(src)
+	at := func(x, y int) color.RGBA { return rgba.RGBAAt(rgba.Rect.Min.X+x, rgba.Rect.Min.Y+y) }
+
+	// Upscaling 2x repeats every source pixel as a 2x2 block
+	up := ResizeWithFilter(src, 12, 8, FilterNearest)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 12; x++ {
+			if got, want := up.RGBAAt(x, y), at(x/2, y/2); got != want {
+				t.Fatalf("2x nearest at (%d,%d) = %v, want source pixel %v", x, y, got, want)
+			}
+		}
+	}
+
+	// Downscaling 2:1 keeps one exact source pixel of every 2x2 block
+	down := ResizeWithFilter(src, 3, 2, FilterNearest)
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			if got, want := down.RGBAAt(x, y), at(2*x+1, 2*y+1); got != want {
+				t.Fatalf("2:1 nearest at (%d,%d) = %v, want source pixel %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestResizeBilinearAveragesBlocks(t *testing.T) {
+	src := decoderImages(8, 6)["NRGBA"]
+	rgba := ToRGBA(src)
+	down := ResizeWithFilter(src, 4, 3, FilterBilinear)
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 4; x++ {
+			got := down.RGBAAt(x, y)
+			for c, value := range []uint8{got.R, got.G, got.B, got.A} {
+				sum := 0
+				for _, p := range [][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+					offset := rgba.PixOffset(rgba.Rect.Min.X+2*x+p[0], rgba.Rect.Min.Y+2*y+p[1])
+					sum += int(rgba.Pix[offset+c])
+				}
+				// The mean of the block, rounded once
+				if diff := int(value)*4 - sum; diff < -2 || diff > 2 {
+					t.Fatalf("2:1 bilinear at (%d,%d) channel %d = %d, want the 2x2 mean %.2f", x, y, c, value, float64(sum)/4)
+				}
+			}
+		}
+	}
+}
+
+func TestResizeKeepsFlatColour(t *testing.T) {
+	// Every filter's weights sum to one, so a flat image stays flat at any size
+	flat := image.NewRGBA(image.Rect(0, 0, 17, 13))
+	for i := 0; i < len(flat.Pix); i += 4 {
+		copy(flat.Pix[i:], []uint8{90, 140, 200, 255})
+	}
+	for _, filter := range []ResizeFilter{FilterNearest, FilterBilinear, FilterCatmullRom, FilterLanczos} {
+		for _, size := range [][2]int{{5, 4}, {40, 31}} {
+			got := ResizeWithFilter(flat, size[0], size[1], filter)
+			for i := 0; i < len(got.Pix); i += 4 {
+				if !bytes.Equal(got.Pix[i:i+4], []uint8{90, 140, 200, 255}) {
+					t.Errorf("%s to %dx%d: pixel %v, want the flat colour", filter, size[0], size[1], got.Pix[i:i+4])
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package blur
+
+import (
+	"image"
+	"image/color"
+)
+
+// DefaultUnsharpKernel is the Gaussian size Unsharp uses when none is given
+const DefaultUnsharpKernel = 5
+
+// Unsharp sharpens img by adding back amount times the detail a kernelSize Gaussian
+// removes: out = img + amount * (img - blur(img)). An amount of 0.5 to 1 is a
+// moderate sharpen; alpha is left unchanged.
+func Unsharp(img image.Image, kernelSize int, amount float64) *image.RGBA {
+	src := ToRGBA(img)
+	blurred := ApplyBlurToImage(src, kernelSize)
+	bounds := src.Bounds()
+	dst := image.NewRGBA(bounds)
+
+	sharpen := func(orig, soft uint8) uint8 {
+		return uint8(clamp255(float64(orig) + amount*(float64(orig)-float64(soft)) + 0.5))
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			o := src.RGBAAt(x, y)
+			b := blurred.RGBAAt(x, y)
+			dst.SetRGBA(x, y, color.RGBA{
+				R: sharpen(o.R, b.R),
+				G: sharpen(o.G, b.G),
+				B: sharpen(o.B, b.B),
+				A: o.A,
+			})
+		}
+	}
+	return dst
+}
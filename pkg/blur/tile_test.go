@@ -0,0 +1,211 @@
+package blur
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// blurTiled blurs img the way the tile pipeline does: cut tileSize tiles with
+// kernelSize/2 pixels of clamped padding, blur each on its own and place the centres
+func blurTiled(img *image.RGBA, kernelSize, tileSize int, opts Options) *image.RGBA {
+	bounds := img.Bounds()
+	kernel := GenerateGaussianKernelSigma(kernelSize, opts.Sigma)
+	padding := kernelSize / 2
+	out := image.NewRGBA(bounds)
+	for tileY := 0; tileY < bounds.Dy(); tileY += tileSize {
+		for tileX := 0; tileX < bounds.Dx(); tileX += tileSize {
+			width := min(tileSize, bounds.Dx()-tileX)
+			height := min(tileSize, bounds.Dy()-tileY)
+
+			data := make([][]color.RGBA, height+2*padding)
+			for y := range data {
+				data[y] = make([]color.RGBA, width+2*padding)
+				sy := EdgeClamp.Index(tileY+y-padding, bounds.Dy())
+				for x := range data[y] {
+					sx := EdgeClamp.Index(tileX+x-padding, bounds.Dx())
+					data[y][x] = img.RGBAAt(bounds.Min.X+sx, bounds.Min.Y+sy)
+				}
+			}
+
+			blurred := ApplyBlurToTileWithOptions(data, kernel, tileX-padding, tileY-padding, opts)
+			for y, row := range ExtractCenter(blurred, padding, width, height) {
+				for x, c := range row {
+					out.SetRGBA(bounds.Min.X+tileX+x, bounds.Min.Y+tileY+y, c)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// TestTiledBlurMatchesWhole checks that padding tiles by kernelSize/2 makes the tiled
+// blur seamless: every pixel matches the whole-image blur byte for byte. Clamped
+// padding reproduces the whole-image edge handling, so this holds at the image
+// border too, not just in the interior. With Dither the pattern is keyed to image
+// coordinates, so tiles at offsets that are not multiples of the 4x4 Bayer cell
+// (tile size 7) still dither exactly like the whole image.
+func TestTiledBlurMatchesWhole(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	img := image.NewRGBA(image.Rect(0, 0, 53, 37)) // not a multiple of any tile size
+	rng.Read(img.Pix)
+
+	for _, dither := range []bool{false, true} {
+		opts := Options{Dither: dither}
+		for _, kernelSize := range []int{1, 3, 5, 9, 15} {
+			want := ApplyBlurToImageWithOptions(img, kernelSize, opts)
+			for _, tileSize := range []int{4, 7, 16, 64} {
+				name := fmt.Sprintf("kernel %d tile %d dither %v", kernelSize, tileSize, dither)
+				got := blurTiled(img, kernelSize, tileSize, opts)
+				if bytes.Equal(got.Pix, want.Pix) {
+					continue
+				}
+				for y := 0; y < img.Bounds().Dy(); y++ {
+					for x := 0; x < img.Bounds().Dx(); x++ {
+						if got.RGBAAt(x, y) != want.RGBAAt(x, y) {
+							t.Errorf("%s: first difference at (%d, %d): tiled %v, whole %v",
+								name, x, y, got.RGBAAt(x, y), want.RGBAAt(x, y))
+							y = img.Bounds().Dy()
+							break
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestDitheredTileIsReproducible checks that the same tile blurred by two workers, one
+// of which has dithered other tiles first, comes out byte-identical: the dither has no
+// state carried between tiles
+func TestDitheredTileIsReproducible(t *testing.T) {
+	rng := rand.New(rand.NewSource(9))
+	tile := make([][]color.RGBA, 20)
+	for y := range tile {
+		tile[y] = make([]color.RGBA, 20)
+		for x := range tile[y] {
+			tile[y][x] = color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255}
+		}
+	}
+	kernel := GenerateGaussianKernel(5)
+	opts := Options{Dither: true}
+
+	first := ApplyBlurToTileWithOptions(tile, kernel, 126, 61, opts)
+	for i := 0; i < 3; i++ {
+		ApplyBlurToTileWithOptions(tile, kernel, rng.Intn(500), rng.Intn(500), opts)
+	}
+	second := ApplyBlurToTileWithOptions(tile, kernel, 126, 61, opts)
+	for y := range first {
+		for x := range first[y] {
+			if first[y][x] != second[y][x] {
+				t.Fatalf("pixel (%d, %d) dithered to %v, then %v on a second worker", x, y, first[y][x], second[y][x])
+			}
+		}
+	}
+}
+
+// blurTileInterleaved is the interleaved color.RGBA loop blurTilePlanar replaced
+func blurTileInterleaved(data [][]color.RGBA, kernel [][]float64, originX, originY int, dither bool) [][]color.RGBA {
+	height, width := len(data), len(data[0])
+	offset := len(kernel) / 2
+	result := make([][]color.RGBA, height)
+	for y := range result {
+		result[y] = make([]color.RGBA, width)
+		for x := range result[y] {
+			var rSum, gSum, bSum, aSum float64
+			for ky := range kernel {
+				for kx, weight := range kernel[ky] {
+					sx := max(0, min(x+kx-offset, width-1))
+					sy := max(0, min(y+ky-offset, height-1))
+					pixel := data[sy][sx]
+					rSum += float64(pixel.R) * weight
+					gSum += float64(pixel.G) * weight
+					bSum += float64(pixel.B) * weight
+					aSum += float64(pixel.A) * weight
+				}
+			}
+			ix, iy := originX+x, originY+y
+			result[y][x] = color.RGBA{
+				R: quantize(rSum, ix, iy, dither),
+				G: quantize(gSum, ix, iy, dither),
+				B: quantize(bSum, ix, iy, dither),
+				A: quantize(aSum, ix, iy, dither),
+			}
+		}
+	}
+	return result
+}
+
+func TestPlanarTileMatchesInterleaved(t *testing.T) {
+	rng := rand.New(rand.NewSource(11))
+	for _, size := range [][2]int{{1, 1}, {3, 17}, {20, 20}, {33, 9}} {
+		tile := make([][]color.RGBA, size[1])
+		for y := range tile {
+			tile[y] = make([]color.RGBA, size[0])
+			for x := range tile[y] {
+				tile[y][x] = color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256))}
+			}
+		}
+		for _, kernelSize := range []int{1, 3, 7, 15} {
+			kernel := GenerateGaussianKernel(kernelSize)
+			for _, dither := range []bool{false, true} {
+				got := ApplyBlurToTileWithOptions(tile, kernel, 5, 2, Options{Dither: dither})
+				want := blurTileInterleaved(tile, kernel, 5, 2, dither)
+				for y := range want {
+					for x := range want[y] {
+						if got[y][x] != want[y][x] {
+							t.Fatalf("%dx%d tile, kernel %d, dither %v: pixel (%d, %d) is %v planar, %v interleaved",
+								size[0], size[1], kernelSize, dither, x, y, got[y][x], want[y][x])
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Degenerate tiles from corrupt payloads must not panic the planar copy: a zero-width
+	// tile comes back unchanged, and an empty later row blurs as transparent black
+	kernel := GenerateGaussianKernel(3)
+	zeroWidth := [][]color.RGBA{{}, {}}
+	if got := ApplyBlurToTileWithOptions(zeroWidth, kernel, 0, 0, Options{}); len(got) != 2 || len(got[0]) != 0 {
+		t.Errorf("zero-width tile blurred to %v, want it unchanged", got)
+	}
+	p := color.RGBA{200, 100, 50, 255}
+	got := ApplyBlurToTileWithOptions([][]color.RGBA{{p, p}, {}}, kernel, 0, 0, Options{})
+	if len(got) != 2 || len(got[1]) != 2 {
+		t.Fatalf("tile with an empty second row blurred to %v, want 2x2", got)
+	}
+	if c := got[1][1]; c.A == 0 || c.A >= 255 {
+		t.Errorf("pixel next to the empty row is %v, want it partly faded by the transparent row", c)
+	}
+}
+
+func TestEmptyTileOrKernelUnchanged(t *testing.T) {
+	tile := [][]color.RGBA{{{1, 2, 3, 255}, {4, 5, 6, 255}}}
+	kernel := GenerateGaussianKernel(3)
+	tests := []struct {
+		name   string
+		data   [][]color.RGBA
+		kernel [][]float64
+	}{
+		{"nil data", nil, kernel},
+		{"no rows", [][]color.RGBA{}, kernel},
+		{"empty first row", [][]color.RGBA{{}, {{1, 2, 3, 255}}}, kernel},
+		{"nil kernel", tile, nil},
+		{"empty kernel row", tile, [][]float64{{}}},
+	}
+	for _, tc := range tests {
+		for _, opts := range []Options{{}, {SigmaColor: 10}} {
+			got := ApplyBlurToTileWithOptions(tc.data, tc.kernel, 0, 0, opts)
+			if len(got) != len(tc.data) || (len(got) > 0 && &got[0] != &tc.data[0]) {
+				t.Errorf("%s, bilateral %v: got %v, want the input returned unchanged", tc.name, opts.SigmaColor > 0, got)
+			}
+		}
+	}
+	if got := ExtractCenter(nil, 1, 2, 2); got != nil {
+		t.Errorf("ExtractCenter of no data = %v, want nil", got)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"os"
+
+	"studyguide.parallel/pkg/common"
+	"studyguide.parallel/pkg/verify"
+)
+
+func main() {
+	var (
+		imagePath = flag.String("image", "", "Blurred image to check")
+		tileSize  = flag.Int("tile-size", common.TILE_SIZE, "Tile size the image was processed with")
+		threshold = flag.Float64("seam-threshold", verify.DefaultSeamThreshold, "Excess luma step at a tile boundary that counts as a seam")
+	)
+	flag.Parse()
+
+	if *imagePath == "" {
+		log.Fatalf("Usage: verify -image <path> [-tile-size N] [-seam-threshold T]")
+	}
+
+	file, err := os.Open(*imagePath)
+	if err != nil {
+		log.Fatalf("Failed to open image: %v", err)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		log.Fatalf("Failed to decode image: %v", err)
+	}
+
+	report := verify.DetectTileSeams(img, *tileSize)
+	log.Printf("Tile size: %d", report.TileSize)
+	log.Printf("Worst column seam: x=%d magnitude=%.3f", report.WorstColumn, report.ColumnMagnitude)
+	log.Printf("Worst row seam:    y=%d magnitude=%.3f", report.WorstRow, report.RowMagnitude)
+
+	if err := report.Err(*threshold); err != nil {
+		log.Printf("FAIL: %v", err)
+		os.Exit(1)
+	}
+	log.Printf("OK: no tile-boundary seams above threshold %.2f", *threshold)
+}
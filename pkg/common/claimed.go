@@ -0,0 +1,42 @@
+package common
+
+import "sync"
+
+// ClaimedJobs holds stale jobs a streams queue has taken over with XCLAIM but not yet
+// handed to a worker. XCLAIM only moves entries into the claiming consumer's pending
+// list, where reads of new entries never see them, so the f and g queues keep them
+// here and return them from their next reads. The zero value is empty and ready to use.
+type ClaimedJobs struct {
+    mu   sync.Mutex
+    jobs []claimedJob
+}
+
+type claimedJob struct {
+    id  string
+    job *JobMessage
+}
+
+// Add keeps a claimed job, after any already kept
+func (c *ClaimedJobs) Add(id string, job *JobMessage) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.jobs = append(c.jobs, claimedJob{id: id, job: job})
+}
+
+// Take removes and returns up to count of the kept jobs, oldest first, with their
+// stream IDs. It returns nothing when no jobs are kept.
+func (c *ClaimedJobs) Take(count int) ([]string, []*JobMessage) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    n := min(count, len(c.jobs))
+    if n <= 0 {
+        return nil, nil
+    }
+    ids := make([]string, n)
+    jobs := make([]*JobMessage, n)
+    for i, cj := range c.jobs[:n] {
+        ids[i], jobs[i] = cj.id, cj.job
+    }
+    c.jobs = c.jobs[n:]
+    return ids, jobs
+}
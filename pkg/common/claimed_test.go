@@ -0,0 +1,77 @@
+package common
+
+import (
+    "fmt"
+    "reflect"
+    "sync"
+    "testing"
+)
+
+func TestClaimedJobs(t *testing.T) {
+    var c ClaimedJobs
+    if ids, jobs := c.Take(5); ids != nil || jobs != nil {
+        t.Fatalf("empty Take = %v, %v, want nothing", ids, jobs)
+    }
+
+    want := make([]*JobMessage, 3)
+    for i, id := range []string{"1-0", "2-0", "3-0"} {
+        want[i] = &JobMessage{Type: "tile", ImageTile: &ImageTile{TileID: i}}
+        c.Add(id, want[i])
+    }
+
+    // Oldest first, no more than asked for, and what is left stays for the next Take
+    ids, jobs := c.Take(2)
+    if !reflect.DeepEqual(ids, []string{"1-0", "2-0"}) || len(jobs) != 2 || jobs[0] != want[0] || jobs[1] != want[1] {
+        t.Fatalf("Take(2) = %v, %v; want the first two jobs", ids, jobs)
+    }
+    if ids, _ := c.Take(0); ids != nil {
+        t.Fatalf("Take(0) = %v, want nothing", ids)
+    }
+    ids, jobs = c.Take(10)
+    if !reflect.DeepEqual(ids, []string{"3-0"}) || len(jobs) != 1 || jobs[0] != want[2] {
+        t.Fatalf("Take(10) = %v, %v; want the one job left", ids, jobs)
+    }
+    if ids, _ := c.Take(1); ids != nil {
+        t.Fatalf("Take after draining = %v, want nothing", ids)
+    }
+}
+
+func TestClaimedJobsConcurrent(t *testing.T) {
+    // A queue's monitor adds claimed jobs while its workers take them; under -race this
+    // checks the buffer is locked, and every job must come out exactly once
+    var c ClaimedJobs
+    const n = 200
+    var wg sync.WaitGroup
+    wg.Add(1)
+    go func() {
+        defer wg.Done()
+        for i := 0; i < n; i++ {
+            c.Add(fmt.Sprintf("%d-0", i), &JobMessage{})
+        }
+    }()
+
+    seen := make(map[string]bool)
+    var mu sync.Mutex
+    for w := 0; w < 4; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := 0; i < n; i++ {
+                ids, _ := c.Take(3)
+                mu.Lock()
+                for _, id := range ids {
+                    if seen[id] {
+                        t.Errorf("job %s taken twice", id)
+                    }
+                    seen[id] = true
+                }
+                mu.Unlock()
+            }
+        }()
+    }
+    wg.Wait()
+    ids, _ := c.Take(n)
+    if len(seen)+len(ids) != n {
+        t.Errorf("%d jobs taken and %d left, want %d in all", len(seen), len(ids), n)
+    }
+}
@@ -0,0 +1,90 @@
+package common
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+    "image/draw"
+    "image/png"
+    "math"
+    "os"
+
+    "studyguide.parallel/pkg/blur"
+)
+
+// ContactSheetCell is the side of the square cell each thumbnail is fitted into
+const ContactSheetCell = 256
+
+// contactSheetGap is the border left around every cell
+const contactSheetGap = 8
+
+// ContactSheetGrid picks the columns and rows for n thumbnails: a near-square grid
+// that is never taller than it is wide
+func ContactSheetGrid(n int) (cols, rows int) {
+    if n <= 0 {
+        return 0, 0
+    }
+    cols = int(math.Ceil(math.Sqrt(float64(n))))
+    rows = (n + cols - 1) / cols
+    return cols, rows
+}
+
+// ContactSheet lays images out as thumbnails on a ContactSheetGrid, each scaled with
+// blur.ResizeWithFilter to fit a cell x cell square (keeping its aspect ratio) and
+// centred in it
+func ContactSheet(images []image.Image, cell int, filter blur.ResizeFilter) *image.RGBA {
+    cols, rows := ContactSheetGrid(len(images))
+    pitch := cell + contactSheetGap
+    sheet := image.NewRGBA(image.Rect(0, 0, cols*pitch+contactSheetGap, rows*pitch+contactSheetGap))
+    draw.Draw(sheet, sheet.Bounds(), &image.Uniform{color.RGBA{32, 32, 32, 255}}, image.Point{}, draw.Src)
+
+    for i, img := range images {
+        thumb := thumbnail(img, cell, filter)
+        tb := thumb.Bounds()
+        x := contactSheetGap + (i%cols)*pitch + (cell-tb.Dx())/2
+        y := contactSheetGap + (i/cols)*pitch + (cell-tb.Dy())/2
+        draw.Draw(sheet, image.Rect(x, y, x+tb.Dx(), y+tb.Dy()), thumb, tb.Min, draw.Over)
+    }
+    return sheet
+}
+
+// thumbnail scales img down to fit within cell x cell; smaller images are kept as is
+func thumbnail(img image.Image, cell int, filter blur.ResizeFilter) image.Image {
+    b := img.Bounds()
+    if b.Dx() <= cell && b.Dy() <= cell {
+        return img
+    }
+    factor := math.Min(float64(cell)/float64(b.Dx()), float64(cell)/float64(b.Dy()))
+    return blur.ScaleWithFilter(img, factor, filter)
+}
+
+// WriteContactSheet decodes each output in turn, keeping only its thumbnail, and
+// writes the contact sheet of all of them to sheetPath as a PNG
+func WriteContactSheet(paths []string, sheetPath string, filter blur.ResizeFilter, level png.CompressionLevel) error {
+    thumbs := make([]image.Image, 0, len(paths))
+    for _, p := range paths {
+        file, err := os.Open(p)
+        if err != nil {
+            return err
+        }
+        img, _, err := image.Decode(file)
+        file.Close()
+        if err != nil {
+            return fmt.Errorf("failed to decode %s: %w", p, err)
+        }
+        thumbs = append(thumbs, thumbnail(img, ContactSheetCell, filter))
+    }
+    if len(thumbs) == 0 {
+        return fmt.Errorf("no outputs to put on a contact sheet")
+    }
+
+    file, err := os.Create(sheetPath)
+    if err != nil {
+        return err
+    }
+    if err := EncodePNG(file, ContactSheet(thumbs, ContactSheetCell, filter), level); err != nil {
+        file.Close()
+        return err
+    }
+    return file.Close()
+}
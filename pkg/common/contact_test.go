@@ -0,0 +1,110 @@
+package common
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+    "image/png"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "studyguide.parallel/pkg/blur"
+)
+
+func TestContactSheetGrid(t *testing.T) {
+    tests := []struct {
+        n, cols, rows int
+    }{
+        {0, 0, 0},
+        {1, 1, 1},
+        {2, 2, 1},
+        {3, 2, 2},
+        {4, 2, 2},
+        {5, 3, 2},
+        {9, 3, 3},
+        {10, 4, 3},
+    }
+    for _, tc := range tests {
+        if cols, rows := ContactSheetGrid(tc.n); cols != tc.cols || rows != tc.rows {
+            t.Errorf("ContactSheetGrid(%d) = %dx%d, want %dx%d", tc.n, cols, rows, tc.cols, tc.rows)
+        }
+    }
+}
+
+// solidImage returns a width x height image of one colour
+func solidImage(width, height int, c color.RGBA) *image.RGBA {
+    img := image.NewRGBA(image.Rect(0, 0, width, height))
+    for i := 0; i < len(img.Pix); i += 4 {
+        img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+    }
+    return img
+}
+
+func TestContactSheetLayout(t *testing.T) {
+    // Four images, one wide, fill a 2x2 grid of 32px cells with an 8px border
+    colours := []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {255, 255, 0, 255}}
+    images := []image.Image{
+        solidImage(20, 20, colours[0]),
+        solidImage(64, 16, colours[1]),
+        solidImage(32, 32, colours[2]),
+        solidImage(10, 30, colours[3]),
+    }
+    sheet := ContactSheet(images, 32, blur.FilterNearest)
+    if sheet.Bounds() != image.Rect(0, 0, 88, 88) {
+        t.Fatalf("sheet is %v, want 88x88 for a 2x2 grid", sheet.Bounds())
+    }
+
+    // Each cell's centre shows its image, and the border between cells stays dark
+    for i, c := range colours {
+        cx, cy := 8+(i%2)*40+16, 8+(i/2)*40+16
+        if got := sheet.RGBAAt(cx, cy); got != c {
+            t.Errorf("cell %d centre is %v, want %v", i, got, c)
+        }
+    }
+    if got := sheet.RGBAAt(44, 44); got != (color.RGBA{32, 32, 32, 255}) {
+        t.Errorf("the gap between cells is %v, want the background", got)
+    }
+    // The 64x16 image is scaled to 32x8 and centred vertically in its cell
+    if above, inside := sheet.RGBAAt(48+16, 8+11), sheet.RGBAAt(48+16, 8+12); above == colours[1] || inside != colours[1] {
+        t.Errorf("the wide thumbnail's top edge reads %v above and %v inside, want it to start 12px down", above, inside)
+    }
+}
+
+func TestWriteContactSheet(t *testing.T) {
+    dir := t.TempDir()
+    var paths []string
+    for i, size := range []int{600, 100, 300, 50} {
+        path := filepath.Join(dir, fmt.Sprintf("img%d_blurred.png", i))
+        file, err := os.Create(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := EncodePNG(file, solidImage(size, size/2, color.RGBA{uint8(60 * i), 90, 30, 255}), png.DefaultCompression); err != nil {
+            t.Fatal(err)
+        }
+        file.Close()
+        paths = append(paths, path)
+    }
+
+    sheetPath := filepath.Join(dir, "sheet.png")
+    if err := WriteContactSheet(paths, sheetPath, blur.FilterBilinear, png.DefaultCompression); err != nil {
+        t.Fatal(err)
+    }
+    file, err := os.Open(sheetPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer file.Close()
+    config, _, err := image.DecodeConfig(file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want := 2*(ContactSheetCell+8) + 8; config.Width != want || config.Height != want {
+        t.Errorf("contact sheet of 4 outputs is %dx%d, want %dx%d", config.Width, config.Height, want, want)
+    }
+
+    if err := WriteContactSheet(nil, sheetPath, blur.FilterBilinear, png.DefaultCompression); err == nil {
+        t.Error("a contact sheet of no outputs was written without error")
+    }
+}
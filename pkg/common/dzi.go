@@ -0,0 +1,98 @@
+package common
+
+import (
+    "fmt"
+    "image"
+    "image/png"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "studyguide.parallel/pkg/blur"
+)
+
+// A Deep Zoom Image (DZI) is a .dzi XML descriptor next to a <name>_files directory
+// with one subdirectory of tiles per pyramid level: level 0 is 1x1, each level doubles
+// the one before, and the last is the full image. Web deep-zoom viewers such as
+// OpenSeadragon load only the tiles in view at the current zoom.
+const (
+    DZITileSize = 254 // tile side before overlap; 254 + 2 overlap gives 256px interior tiles
+    DZIOverlap  = 1   // pixels each tile repeats from its neighbours, hiding seams when scaled
+)
+
+const dziDescriptor = `<?xml version="1.0" encoding="UTF-8"?>
+<Image xmlns="http://schemas.microsoft.com/deepzoom/2008" Format="png" Overlap="%d" TileSize="%d">
+  <Size Width="%d" Height="%d"/>
+</Image>
+`
+
+// DZILevels is the number of pyramid levels for a width x height image: one per halving
+// of the longer side, rounding up, until it reaches 1
+func DZILevels(width, height int) int {
+    levels := 1
+    for size := max(width, height); size > 1; size = (size + 1) / 2 {
+        levels++
+    }
+    return levels
+}
+
+// DZITilesDir is the tiles directory that belongs with a .dzi descriptor
+func DZITilesDir(dziPath string) string {
+    return strings.TrimSuffix(dziPath, filepath.Ext(dziPath)) + "_files"
+}
+
+// WriteDZI writes img as a Deep Zoom pyramid of PNG tiles with dziPath as its
+// descriptor. Each level is a 2:1 bilinear downscale of the one above. Tiles of a
+// previous output at the same path are removed first, and the descriptor is written
+// last, so a viewer never finds one without its tiles.
+func WriteDZI(dziPath string, img image.Image, level png.CompressionLevel) error {
+    tilesDir := DZITilesDir(dziPath)
+    if err := os.RemoveAll(tilesDir); err != nil {
+        return err
+    }
+
+    width, height := img.Bounds().Dx(), img.Bounds().Dy()
+    levels := DZILevels(width, height)
+    current := blur.ToRGBA(img)
+    for l := levels - 1; l >= 0; l-- {
+        if l < levels-1 {
+            b := current.Bounds()
+            current = blur.Resize(current, (b.Dx()+1)/2, (b.Dy()+1)/2)
+        }
+        if err := writeDZILevel(filepath.Join(tilesDir, fmt.Sprint(l)), current, level); err != nil {
+            return fmt.Errorf("level %d: %w", l, err)
+        }
+    }
+
+    return os.WriteFile(dziPath, []byte(fmt.Sprintf(dziDescriptor, DZIOverlap, DZITileSize, width, height)), 0644)
+}
+
+// writeDZILevel cuts one pyramid level into <col>_<row>.png tiles, each extended by
+// DZIOverlap pixels on the sides that have a neighbour
+func writeDZILevel(dir string, img *image.RGBA, level png.CompressionLevel) error {
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return err
+    }
+    bounds := img.Bounds()
+    for row := 0; row*DZITileSize < bounds.Dy(); row++ {
+        for col := 0; col*DZITileSize < bounds.Dx(); col++ {
+            rect := image.Rect(
+                max(col*DZITileSize-DZIOverlap, 0), max(row*DZITileSize-DZIOverlap, 0),
+                (col+1)*DZITileSize+DZIOverlap, (row+1)*DZITileSize+DZIOverlap,
+            ).Add(bounds.Min).Intersect(bounds)
+
+            file, err := os.Create(filepath.Join(dir, fmt.Sprintf("%d_%d.png", col, row)))
+            if err != nil {
+                return err
+            }
+            if err := EncodePNG(file, img.SubImage(rect), level); err != nil {
+                file.Close()
+                return err
+            }
+            if err := file.Close(); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+    "fmt"
+    "image"
+    "image/png"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestDZILevels(t *testing.T) {
+    tests := []struct {
+        width, height, want int
+    }{
+        {1, 1, 1},
+        {2, 1, 2},
+        {3, 3, 3},
+        {4, 4, 3},
+        {5, 2, 4},
+        {256, 100, 9},
+        {257, 100, 10},
+        {100, 1000, 11},
+    }
+    for _, tc := range tests {
+        if got := DZILevels(tc.width, tc.height); got != tc.want {
+            t.Errorf("DZILevels(%d, %d) = %d, want %d", tc.width, tc.height, got, tc.want)
+        }
+    }
+}
+
+func TestWriteDZI(t *testing.T) {
+    dir := t.TempDir()
+    dziPath := filepath.Join(dir, "out.dzi")
+    width, height := 600, 300
+    if err := WriteDZI(dziPath, image.NewRGBA(image.Rect(0, 0, width, height)), png.BestSpeed); err != nil {
+        t.Fatal(err)
+    }
+
+    descriptor, err := os.ReadFile(dziPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !strings.Contains(string(descriptor), fmt.Sprintf(`<Size Width="%d" Height="%d"/>`, width, height)) {
+        t.Errorf("descriptor does not give the image size:\n%s", descriptor)
+    }
+
+    levels := DZILevels(width, height)
+    w, h := width, height
+    for l := levels - 1; l >= 0; l-- {
+        if l == 0 && (w != 1 || h != 1) {
+            t.Errorf("level 0 is %dx%d, want 1x1", w, h)
+        }
+        cols := (w + DZITileSize - 1) / DZITileSize
+        rows := (h + DZITileSize - 1) / DZITileSize
+        entries, err := os.ReadDir(filepath.Join(DZITilesDir(dziPath), fmt.Sprint(l)))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(entries) != cols*rows {
+            t.Errorf("level %d (%dx%d): %d tiles, want %d", l, w, h, len(entries), cols*rows)
+        }
+
+        // The bottom-right tile carries overlap only on its top and left sides
+        last := filepath.Join(DZITilesDir(dziPath), fmt.Sprint(l), fmt.Sprintf("%d_%d.png", cols-1, rows-1))
+        file, err := os.Open(last)
+        if err != nil {
+            t.Fatal(err)
+        }
+        config, err := png.DecodeConfig(file)
+        file.Close()
+        if err != nil {
+            t.Fatal(err)
+        }
+        wantW := w - max((cols-1)*DZITileSize-DZIOverlap, 0)
+        wantH := h - max((rows-1)*DZITileSize-DZIOverlap, 0)
+        if config.Width != wantW || config.Height != wantH {
+            t.Errorf("level %d: last tile is %dx%d, want %dx%d", l, config.Width, config.Height, wantW, wantH)
+        }
+
+        w, h = (w+1)/2, (h+1)/2
+    }
+}
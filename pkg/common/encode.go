@@ -0,0 +1,89 @@
+package common
+
+import (
+    "errors"
+    "fmt"
+    "image"
+    "image/jpeg"
+    "image/png"
+    "io"
+    "sync"
+    "syscall"
+)
+
+// ParsePNGCompression maps a -png-compression flag value to a png.CompressionLevel
+func ParsePNGCompression(name string) (png.CompressionLevel, error) {
+    switch name {
+    case "", "default":
+        return png.DefaultCompression, nil
+    case "none":
+        return png.NoCompression, nil
+    case "speed":
+        return png.BestSpeed, nil
+    case "best":
+        return png.BestCompression, nil
+    default:
+        return png.DefaultCompression, fmt.Errorf("unknown PNG compression %q (use default, none, speed or best)", name)
+    }
+}
+
+// pngBuffers lets every EncodePNG reuse the encoder's row and compression buffers, so
+// a process saving image after image does not allocate them afresh each time
+var pngBuffers png.EncoderBufferPool = &pngBufferPool{}
+
+type pngBufferPool struct {
+    pool sync.Pool
+}
+
+func (p *pngBufferPool) Get() *png.EncoderBuffer {
+    b, _ := p.pool.Get().(*png.EncoderBuffer)
+    return b
+}
+
+func (p *pngBufferPool) Put(b *png.EncoderBuffer) {
+    p.pool.Put(b)
+}
+
+// EncodePNG writes img as a PNG at the given compression level, streaming rows to w
+// without copying the image. Blurred images are low-frequency and compress well, so
+// "best" is often worth its small CPU cost.
+func EncodePNG(w io.Writer, img image.Image, level png.CompressionLevel) error {
+    encoder := png.Encoder{CompressionLevel: level, BufferPool: pngBuffers}
+    return encoder.Encode(w, img)
+}
+
+// DefaultJPEGQuality is the quality outputs of JPEG inputs are written at
+const DefaultJPEGQuality = 95
+
+// OutputExt is the extension of an output written in the given source format (as
+// image.Decode names it): .jpeg for JPEG inputs, .png for everything else
+func OutputExt(format string) string {
+    if format == "jpeg" {
+        return ".jpeg"
+    }
+    return ".png"
+}
+
+// EncodeOutput writes img in the format its input had, so JPEG workflows get JPEG
+// outputs back: JPEG at quality (DefaultJPEGQuality when <= 0), and PNG at level for
+// any other format, including "" from producers that do not record one.
+//
+// image/jpeg always subsamples the chroma of colour images 4:2:0 and has no option for
+// 4:4:4, so there is no -jpeg-subsampling flag. Blurred outputs are low-frequency, so
+// the lost chroma detail is rarely visible; for full chroma, use PNG inputs.
+func EncodeOutput(w io.Writer, img image.Image, format string, level png.CompressionLevel, quality int) error {
+    if format != "jpeg" {
+        return EncodePNG(w, img, level)
+    }
+    if quality <= 0 {
+        quality = DefaultJPEGQuality
+    }
+    return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+}
+
+// IsDiskFull reports whether err (possibly wrapped) is an out-of-space write error.
+// Once the output disk is full every later write fails too, so batch processors
+// use this to stop cleanly and flush stats instead of failing image by image.
+func IsDiskFull(err error) bool {
+    return errors.Is(err, syscall.ENOSPC)
+}
@@ -0,0 +1,184 @@
+package common
+
+import (
+    "bytes"
+    "image"
+    "image/jpeg"
+    "image/png"
+    "io"
+    "math/rand"
+    "runtime"
+    "testing"
+
+    "studyguide.parallel/pkg/blur"
+)
+
+// countingPool is a one-slot png.EncoderBufferPool that counts how often a buffer
+// came back for reuse
+type countingPool struct {
+    buf        *png.EncoderBuffer
+    gets, hits int
+}
+
+func (p *countingPool) Get() *png.EncoderBuffer {
+    p.gets++
+    b := p.buf
+    p.buf = nil
+    if b != nil {
+        p.hits++
+    }
+    return b
+}
+
+func (p *countingPool) Put(b *png.EncoderBuffer) { p.buf = b }
+
+// maxWriter records the total size and the largest single write passed through it
+type maxWriter struct {
+    bytes.Buffer
+    largest int
+}
+
+func (w *maxWriter) Write(p []byte) (int, error) {
+    w.largest = max(w.largest, len(p))
+    return w.Buffer.Write(p)
+}
+
+// noiseImage is opaque noise, which barely compresses, so its PNG is about as large
+// as its pixels
+func noiseImage(width, height int) *image.RGBA {
+    img := image.NewRGBA(image.Rect(0, 0, width, height))
+    rand.New(rand.NewSource(1)).Read(img.Pix)
+    for i := 3; i < len(img.Pix); i += 4 {
+        img.Pix[i] = 255
+    }
+    return img
+}
+
+// usePool makes EncodePNG take its buffers from pool for the rest of the test. The
+// race detector drops sync.Pool puts at random, so tests that count on reuse cannot
+// rely on pngBufferPool.
+func usePool(t *testing.T, pool png.EncoderBufferPool) {
+    saved := pngBuffers
+    pngBuffers = pool
+    t.Cleanup(func() { pngBuffers = saved })
+}
+
+func TestEncodePNGStreamsAndReusesBuffers(t *testing.T) {
+    pool := &countingPool{}
+    usePool(t, pool)
+
+    img := noiseImage(512, 512)
+    for i := 0; i < 3; i++ {
+        var w maxWriter
+        if err := EncodePNG(&w, img, png.BestSpeed); err != nil {
+            t.Fatal(err)
+        }
+        // Rows go out in chunks as they are compressed, never as one encoded image
+        if w.Len() < len(img.Pix)/2 || w.largest > w.Len()/8 {
+            t.Errorf("encode %d: largest write %d bytes of %d", i, w.largest, w.Len())
+        }
+        got, err := png.Decode(&w)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.Equal(blur.ToRGBA(got).Pix, img.Pix) {
+            t.Fatalf("encode %d: decoded pixels differ", i)
+        }
+    }
+    if pool.gets != 3 || pool.hits != 2 {
+        t.Errorf("3 encodes took %d buffers, %d of them reused; want 3 and 2", pool.gets, pool.hits)
+    }
+}
+
+func TestEncodePNGAllocatesLessThanItsOutput(t *testing.T) {
+    usePool(t, &countingPool{})
+    img := noiseImage(512, 512)
+    var out bytes.Buffer
+    if err := EncodePNG(&out, img, png.BestSpeed); err != nil {
+        t.Fatal(err)
+    }
+
+    // With the pool warm, an encode allocates far less than the image it writes
+    var before, after runtime.MemStats
+    runtime.ReadMemStats(&before)
+    if err := EncodePNG(io.Discard, img, png.BestSpeed); err != nil {
+        t.Fatal(err)
+    }
+    runtime.ReadMemStats(&after)
+    if allocated := after.TotalAlloc - before.TotalAlloc; allocated > uint64(out.Len()/4) {
+        t.Errorf("encoding a %d-byte PNG allocated %d bytes", out.Len(), allocated)
+    }
+}
+
+func BenchmarkEncodePNG(b *testing.B) {
+    img := noiseImage(1024, 1024)
+    b.SetBytes(int64(len(img.Pix)))
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        if err := EncodePNG(io.Discard, img, png.BestSpeed); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
+
+func TestPNGCompressionLevels(t *testing.T) {
+    // A blurred gradient with some noise, like the outputs: compressible, but not trivially
+    img := noiseImage(256, 256)
+    for y := 0; y < 256; y++ {
+        for x := 0; x < 256; x++ {
+            i := img.PixOffset(x, y)
+            img.Pix[i], img.Pix[i+1], img.Pix[i+2] = uint8(x), uint8(y), img.Pix[i+2]/16+uint8(x+y)/2
+        }
+    }
+    img = blur.ApplyBlurToImage(img, 5)
+
+    sizes := map[string]int{}
+    for _, name := range []string{"none", "speed", "default", "best"} {
+        level, err := ParsePNGCompression(name)
+        if err != nil {
+            t.Fatal(err)
+        }
+        var out bytes.Buffer
+        if err := EncodePNG(&out, img, level); err != nil {
+            t.Fatal(err)
+        }
+        sizes[name] = out.Len()
+        decoded, err := png.Decode(&out)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.Equal(blur.ToRGBA(decoded).Pix, img.Pix) {
+            t.Errorf("-png-compression %s changed the pixels", name)
+        }
+    }
+    if !(sizes["best"] < sizes["speed"] && sizes["speed"] < sizes["none"] && sizes["default"] <= sizes["speed"]) {
+        t.Errorf("encoded sizes %v, want best < speed < none and default no larger than speed", sizes)
+    }
+
+    if _, err := ParsePNGCompression("fastest"); err == nil {
+        t.Error("an unknown -png-compression was accepted")
+    }
+}
+
+func TestEncodeOutputJPEGRoundTrip(t *testing.T) {
+    // Odd dimensions leave partial 16x16 chroma blocks at the right and bottom edges
+    img := blur.ApplyBlurToImage(noiseImage(37, 23), 5)
+    for _, quality := range []int{0, 50, 100} {
+        var out bytes.Buffer
+        if err := EncodeOutput(&out, img, "jpeg", 0, quality); err != nil {
+            t.Fatalf("quality %d: %v", quality, err)
+        }
+        decoded, err := jpeg.Decode(&out)
+        if err != nil {
+            t.Fatalf("quality %d: output does not decode as JPEG: %v", quality, err)
+        }
+        if decoded.Bounds() != img.Bounds() {
+            t.Errorf("quality %d: decoded bounds %v, want %v", quality, decoded.Bounds(), img.Bounds())
+        }
+        // The documented constraint: image/jpeg always subsamples colour 4:2:0
+        if ycc, ok := decoded.(*image.YCbCr); !ok || ycc.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+            t.Errorf("quality %d: decoded %T, want 4:2:0 YCbCr", quality, decoded)
+        }
+    }
+}
@@ -0,0 +1,116 @@
+package common
+
+import (
+    "fmt"
+    "io"
+    "os"
+    "sync"
+)
+
+// EncodePool writes output files on a bounded set of goroutines, so a batch processor
+// can blur the next image while earlier ones are still being encoded. Submit blocks
+// once queueSize writes are pending, which bounds how many blurred images are held
+// in memory at once. Once a write fails because the disk is full, the writes still
+// queued are skipped and DiskFull reports it, so the batch can stop blurring.
+type EncodePool struct {
+    jobs     chan encodeJob
+    wg       sync.WaitGroup
+    mu       sync.Mutex
+    failed   []EncodeFailure
+    diskFull error // the first out-of-space write error, if any
+}
+
+type encodeJob struct {
+    path    string
+    write   func(io.Writer) error
+    verify  func(path string) error
+    retries int
+}
+
+// EncodeFailure is an output the pool could not write. The partial file is removed.
+type EncodeFailure struct {
+    Path string
+    Err  error
+}
+
+// NewEncodePool starts workers encode goroutines (at least one) with room for
+// queueSize pending writes
+func NewEncodePool(workers, queueSize int) *EncodePool {
+    if workers < 1 {
+        workers = 1
+    }
+    if queueSize < 0 {
+        queueSize = 0
+    }
+
+    p := &EncodePool{jobs: make(chan encodeJob, queueSize)}
+    for i := 0; i < workers; i++ {
+        p.wg.Add(1)
+        go p.run()
+    }
+    return p
+}
+
+// Submit queues path to be created and filled by write, blocking while the queue is full
+func (p *EncodePool) Submit(path string, write func(io.Writer) error) {
+    p.jobs <- encodeJob{path: path, write: write}
+}
+
+// SubmitVerified is Submit with the written file checked by verify and rewritten up to
+// retries times while the check fails, as in WriteVerified. An output that never
+// passes is removed and reported by Wait.
+func (p *EncodePool) SubmitVerified(path string, write func(io.Writer) error, verify func(path string) error, retries int) {
+    p.jobs <- encodeJob{path: path, write: write, verify: verify, retries: retries}
+}
+
+// DiskFull returns the first write error that ran out of disk space, or nil. Check it
+// before producing the next output: every later write would fail too.
+func (p *EncodePool) DiskFull() error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    return p.diskFull
+}
+
+// Wait stops accepting work, waits for every pending write to finish and returns the
+// ones that failed, in no particular order. The pool cannot be reused afterwards.
+func (p *EncodePool) Wait() []EncodeFailure {
+    close(p.jobs)
+    p.wg.Wait()
+    return p.failed
+}
+
+func (p *EncodePool) run() {
+    defer p.wg.Done()
+    for job := range p.jobs {
+        if full := p.DiskFull(); full != nil {
+            p.fail(job.path, fmt.Errorf("skipped after an earlier write: %w", full))
+            continue
+        }
+        if err := WriteVerified(job.path, job.write, job.verify, job.retries); err != nil {
+            os.Remove(job.path) // don't leave a truncated image behind
+            p.fail(job.path, err)
+        }
+    }
+}
+
+// fail records a write that did not happen, noting the first one to find the disk full
+func (p *EncodePool) fail(path string, err error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.failed = append(p.failed, EncodeFailure{Path: path, Err: err})
+    if p.diskFull == nil && IsDiskFull(err) {
+        p.diskFull = err
+    }
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+    file, err := os.Create(path)
+    if err != nil {
+        return err
+    }
+    if err := write(file); err != nil {
+        file.Close()
+        return err
+    }
+    return file.Close()
+}
@@ -0,0 +1,60 @@
+package common
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "syscall"
+    "testing"
+)
+
+func TestEncodePoolStopsOnDiskFull(t *testing.T) {
+    dir := t.TempDir()
+    pool := NewEncodePool(1, 4)
+    if pool.DiskFull() != nil {
+        t.Fatal("a new pool reports a full disk")
+    }
+
+    release := make(chan struct{})
+    ok := filepath.Join(dir, "ok.png")
+    pool.Submit(ok, func(w io.Writer) error {
+        <-release // hold the worker so the later jobs queue up behind the full disk
+        _, err := w.Write([]byte("ok"))
+        return err
+    })
+    full := filepath.Join(dir, "full.png")
+    pool.Submit(full, func(io.Writer) error {
+        return fmt.Errorf("write: %w", syscall.ENOSPC)
+    })
+    writes := 0
+    skipped := filepath.Join(dir, "skipped.png")
+    pool.Submit(skipped, func(io.Writer) error {
+        writes++
+        return nil
+    })
+    close(release)
+    failed := pool.Wait()
+
+    if !IsDiskFull(pool.DiskFull()) {
+        t.Errorf("DiskFull() = %v, want the out-of-space error", pool.DiskFull())
+    }
+    if writes != 0 {
+        t.Error("a write queued after the disk filled up still ran")
+    }
+    if len(failed) != 2 {
+        t.Fatalf("%d failures, want the full write and the skipped one: %v", len(failed), failed)
+    }
+    for _, f := range failed {
+        if f.Path == ok || !IsDiskFull(f.Err) {
+            t.Errorf("failure %s: %v, want an out-of-space error for full.png and skipped.png", f.Path, f.Err)
+        }
+        if _, err := os.Stat(f.Path); !errors.Is(err, os.ErrNotExist) {
+            t.Errorf("%s was left behind after its write failed", f.Path)
+        }
+    }
+    if _, err := os.Stat(ok); err != nil {
+        t.Errorf("the write before the disk filled up was lost: %v", err)
+    }
+}
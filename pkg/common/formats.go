@@ -0,0 +1,84 @@
+package common
+
+import (
+    "fmt"
+    "image"
+    "image/png"
+    "io"
+    "strconv"
+    "time"
+)
+
+// CompareFormatsJPEGQualities are the JPEG qualities CompareFormats encodes at
+var CompareFormatsJPEGQualities = []int{50, 75, 90, DefaultJPEGQuality, 100}
+
+// compareFormatsPNGLevels are the -png-compression names CompareFormats encodes at
+var compareFormatsPNGLevels = []string{"none", "speed", "default", "best"}
+
+// FormatSize is the encoded size of one image in one format and setting
+type FormatSize struct {
+    Format  string // "png" or "jpeg"
+    Setting string // the -png-compression level or the -jpeg-quality
+    Bytes   int64
+    Seconds float64
+}
+
+// CompareFormats encodes img as PNG at each -png-compression level and as JPEG at each
+// of CompareFormatsJPEGQualities through EncodeOutput, the path outputs are written
+// with, and reports the size and encode time of each. Nothing is written to disk.
+// There is no WebP row: the Go standard library and x/image only decode WebP.
+func CompareFormats(img image.Image) ([]FormatSize, error) {
+    var rows []FormatSize
+    encode := func(format, setting string, level png.CompressionLevel, quality int) error {
+        var w countingWriter
+        start := time.Now()
+        if err := EncodeOutput(&w, img, format, level, quality); err != nil {
+            return fmt.Errorf("encode %s %s: %w", format, setting, err)
+        }
+        rows = append(rows, FormatSize{Format: format, Setting: setting, Bytes: w.n, Seconds: time.Since(start).Seconds()})
+        return nil
+    }
+
+    for _, name := range compareFormatsPNGLevels {
+        level, err := ParsePNGCompression(name)
+        if err != nil {
+            return nil, err
+        }
+        if err := encode("png", name, level, 0); err != nil {
+            return nil, err
+        }
+    }
+    for _, quality := range CompareFormatsJPEGQualities {
+        if err := encode("jpeg", strconv.Itoa(quality), png.DefaultCompression, quality); err != nil {
+            return nil, err
+        }
+    }
+    return rows, nil
+}
+
+// FormatSizeReport renders CompareFormats rows as an aligned table, with each size
+// also given relative to the uncompressed RGBA pixels (4 bytes per pixel)
+func FormatSizeReport(rows []FormatSize, bounds image.Rectangle) []string {
+    raw := float64(4 * bounds.Dx() * bounds.Dy())
+    lines := []string{fmt.Sprintf("%-6s %-8s %12s %9s %10s", "Format", "Setting", "Bytes", "Of RGBA", "Encode")}
+    for _, r := range rows {
+        ratio := 0.0
+        if raw > 0 {
+            ratio = 100 * float64(r.Bytes) / raw
+        }
+        lines = append(lines, fmt.Sprintf("%-6s %-8s %12d %8.1f%% %9.3fs", r.Format, r.Setting, r.Bytes, ratio, r.Seconds))
+    }
+    return lines
+}
+
+// countingWriter discards what is written to it and counts the bytes
+type countingWriter struct {
+    n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+    w.n += int64(len(p))
+    return len(p), nil
+}
+
+var _ io.Writer = (*countingWriter)(nil)
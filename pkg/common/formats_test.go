@@ -0,0 +1,59 @@
+package common
+
+import (
+    "bytes"
+    "image"
+    "math/rand"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestCompareFormats(t *testing.T) {
+    // A smooth gradient with a little noise, like a blurred photo
+    rng := rand.New(rand.NewSource(1))
+    img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+    for i := range img.Pix {
+        img.Pix[i] = uint8(i/4%64*3 + rng.Intn(8))
+        if i%4 == 3 {
+            img.Pix[i] = 255
+        }
+    }
+    rows, err := CompareFormats(img)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // One row per format and setting, PNG levels first
+    var got []string
+    size := make(map[string]int64)
+    for _, r := range rows {
+        key := r.Format + " " + r.Setting
+        got = append(got, key)
+        size[key] = r.Bytes
+        if r.Bytes <= 0 || r.Seconds < 0 {
+            t.Errorf("%s: %d bytes in %fs", key, r.Bytes, r.Seconds)
+        }
+    }
+    want := []string{"png none", "png speed", "png default", "png best", "jpeg 50", "jpeg 75", "jpeg 90", "jpeg 95", "jpeg 100"}
+    if !reflect.DeepEqual(got, want) {
+        t.Fatalf("rows %v, want %v", got, want)
+    }
+
+    // Sizes are of what EncodeOutput writes at each setting
+    var buf bytes.Buffer
+    if err := EncodeOutput(&buf, img, "jpeg", 0, 75); err != nil {
+        t.Fatal(err)
+    }
+    if size["jpeg 75"] != int64(buf.Len()) {
+        t.Errorf("jpeg 75 reported %d bytes, EncodeOutput writes %d", size["jpeg 75"], buf.Len())
+    }
+    if size["png none"] <= size["png best"] || size["jpeg 50"] >= size["jpeg 100"] {
+        t.Errorf("sizes %v do not grow with quality and shrink with compression", size)
+    }
+
+    lines := FormatSizeReport(rows, img.Bounds())
+    if len(lines) != len(rows)+1 || !strings.HasPrefix(lines[0], "Format") || !strings.HasPrefix(lines[1], "png    none") {
+        t.Errorf("report:\n%s", strings.Join(lines, "\n"))
+    }
+}
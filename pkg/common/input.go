@@ -0,0 +1,209 @@
+package common
+
+import (
+    "bufio"
+    "fmt"
+    "image"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "studyguide.parallel/pkg/blur"
+)
+
+// DefaultDownloadTimeout bounds fetching a URL input when no timeout is configured
+const DefaultDownloadTimeout = 30 * time.Second
+
+// IsURL reports whether an input path is an http:// or https:// URL
+func IsURL(p string) bool {
+    lower := strings.ToLower(p)
+    return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// OpenInput opens a local file, or fetches an http(s) URL with the given timeout
+// covering the whole download. The caller closes the returned reader.
+func OpenInput(p string, timeout time.Duration) (io.ReadCloser, error) {
+    if !IsURL(p) {
+        return os.Open(p)
+    }
+
+    client := &http.Client{Timeout: timeout}
+    resp, err := client.Get(p)
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode != http.StatusOK {
+        resp.Body.Close()
+        return nil, fmt.Errorf("fetching %s: %s", p, resp.Status)
+    }
+    return resp.Body, nil
+}
+
+// InputName is the file name of a local path or URL, used to name outputs.
+// Query strings and fragments are ignored for URLs.
+func InputName(p string) string {
+    if IsURL(p) {
+        if u, err := url.Parse(p); err == nil {
+            return path.Base(u.Path)
+        }
+    }
+    return filepath.Base(p)
+}
+
+// LoadMask decodes a -mask image (a local file or URL) as 8-bit grayscale
+func LoadMask(p string, timeout time.Duration) (*image.Gray, error) {
+    r, err := OpenInput(p, timeout)
+    if err != nil {
+        return nil, err
+    }
+    defer r.Close()
+
+    img, _, err := image.Decode(r)
+    if err != nil {
+        return nil, fmt.Errorf("failed to decode mask %s: %w", p, err)
+    }
+    return blur.ToGray(img), nil
+}
+
+// ResumeFile is the default name, in the output directory, of the list of inputs a
+// deadline-limited run left unprocessed
+const ResumeFile = "unprocessed.txt"
+
+// WriteInputList writes inputs one per line, for a later run to pick up with ReadInputList
+func WriteInputList(p string, inputs []string) error {
+    return os.WriteFile(p, []byte(strings.Join(inputs, "\n")+"\n"), 0644)
+}
+
+// ReadInputList reads a list of inputs, one per line. Blank lines and lines starting
+// with # are skipped.
+func ReadInputList(p string) ([]string, error) {
+    file, err := os.Open(p)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    var inputs []string
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        inputs = append(inputs, line)
+    }
+    return inputs, scanner.Err()
+}
+
+// ImageKernels maps input file names to the kernel size a distributed run blurs each
+// one with, in place of the coordinator's -kernel
+type ImageKernels map[string]int
+
+// ReadImageKernels reads a -kernel-manifest: one "name kernel" pair per line, where
+// name is an input's file name, e.g. "img1.png 31". Blank lines and lines starting with
+// # are skipped, as in ReadInputList. Kernel sizes must be odd and positive.
+func ReadImageKernels(p string) (ImageKernels, error) {
+    lines, err := ReadInputList(p)
+    if err != nil {
+        return nil, err
+    }
+    kernels := make(ImageKernels, len(lines))
+    for _, line := range lines {
+        fields := strings.Fields(line)
+        if len(fields) != 2 {
+            return nil, fmt.Errorf("%s: want \"name kernel\", got %q", p, line)
+        }
+        size, err := strconv.Atoi(fields[1])
+        if err != nil || size < 1 || size%2 == 0 {
+            return nil, fmt.Errorf("%s: kernel for %s must be odd and positive, got %q", p, fields[0], fields[1])
+        }
+        kernels[fields[0]] = size
+    }
+    return kernels, nil
+}
+
+// For returns the kernel size for inputPath, or fallback when it is not listed
+func (k ImageKernels) For(inputPath string, fallback int) int {
+    if size, ok := k[InputName(inputPath)]; ok {
+        return size
+    }
+    return fallback
+}
+
+// FindInputs returns the PNG files in dir, ordered by an -input-sort spec (see
+// SortInputs). An empty directory gives no inputs and no error; the processors report
+// that themselves, see ExitNoInputs.
+func FindInputs(dir, sortSpec string) ([]string, error) {
+    files, err := filepath.Glob(filepath.Join(dir, "*.png"))
+    if err != nil {
+        return nil, err
+    }
+    if err := SortInputs(files, sortSpec); err != nil {
+        return nil, err
+    }
+    return files, nil
+}
+
+// SortInputs orders discovered inputs for an -input-sort spec: "name", "size" or
+// "mtime", ascending unless suffixed ":desc". Directory listings and globs come back in
+// lexical or filesystem order, so this makes batch order explicit. Equal keys keep their
+// discovered order, and an empty spec leaves paths alone.
+func SortInputs(paths []string, spec string) error {
+    if spec == "" {
+        return nil
+    }
+    key, order, _ := strings.Cut(spec, ":")
+    if order != "" && order != "asc" && order != "desc" {
+        return fmt.Errorf("unknown sort order %q in %q (use asc or desc)", order, spec)
+    }
+    if key != "name" && key != "size" && key != "mtime" {
+        return fmt.Errorf("unknown input sort %q (use name, size or mtime, optionally with :desc)", key)
+    }
+
+    // Stat each input once up front rather than on every comparison
+    type input struct {
+        path string
+        size int64
+        mod  time.Time
+    }
+    inputs := make([]input, len(paths))
+    for i, p := range paths {
+        inputs[i].path = p
+        if key == "name" {
+            continue
+        }
+        info, err := os.Stat(p)
+        if err != nil {
+            return err
+        }
+        inputs[i].size, inputs[i].mod = info.Size(), info.ModTime()
+    }
+
+    less := func(a, b input) bool {
+        switch key {
+        case "size":
+            return a.size < b.size
+        case "mtime":
+            return a.mod.Before(b.mod)
+        default:
+            return a.path < b.path
+        }
+    }
+    sort.SliceStable(inputs, func(i, j int) bool {
+        if order == "desc" {
+            return less(inputs[j], inputs[i])
+        }
+        return less(inputs[i], inputs[j])
+    })
+    for i := range inputs {
+        paths[i] = inputs[i].path
+    }
+    return nil
+}
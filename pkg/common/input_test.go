@@ -0,0 +1,226 @@
+package common
+
+import (
+    "bytes"
+    "image"
+    "image/png"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestReadImageKernels(t *testing.T) {
+    tests := []struct {
+        name     string
+        manifest string
+        want     ImageKernels
+    }{
+        {"pairs", "a.png 31\nb.jpg 3\n", ImageKernels{"a.png": 31, "b.jpg": 3}},
+        {"comments and blank lines", "# per-image kernels\n\n  a.png   9  \n", ImageKernels{"a.png": 9}},
+        {"empty", "", ImageKernels{}},
+        {"even kernel", "a.png 8\n", nil},
+        {"zero kernel", "a.png 0\n", nil},
+        {"not a number", "a.png large\n", nil},
+        {"missing kernel", "a.png\n", nil},
+        {"extra field", "a.png 9 11\n", nil},
+    }
+    for _, tc := range tests {
+        path := filepath.Join(t.TempDir(), "kernels.txt")
+        if err := os.WriteFile(path, []byte(tc.manifest), 0644); err != nil {
+            t.Fatal(err)
+        }
+        got, err := ReadImageKernels(path)
+        if (err == nil) != (tc.want != nil) || !reflect.DeepEqual(got, tc.want) {
+            t.Errorf("%s: ReadImageKernels = %v, %v; want %v", tc.name, got, err, tc.want)
+        }
+    }
+    if _, err := ReadImageKernels(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+        t.Error("a missing manifest read without error")
+    }
+}
+
+func TestImageKernelsFor(t *testing.T) {
+    kernels := ImageKernels{"a.png": 31}
+    tests := []struct {
+        input string
+        want  int
+    }{
+        {"a.png", 31},
+        {"/data/in/a.png", 31},
+        {"https://example.com/images/a.png?size=large", 31},
+        {"b.png", 15},
+        {"/data/a.png/b.png", 15},
+    }
+    for _, tc := range tests {
+        if got := kernels.For(tc.input, 15); got != tc.want {
+            t.Errorf("For(%q) = %d, want %d", tc.input, got, tc.want)
+        }
+    }
+    if got := ImageKernels(nil).For("a.png", 15); got != 15 {
+        t.Errorf("without a manifest For = %d, want the fallback 15", got)
+    }
+}
+
+func TestFindInputs(t *testing.T) {
+    dir := t.TempDir()
+    for name, size := range map[string]int{"b.png": 10, "a.png": 30, "c.png": 20, "photo.jpg": 5, "notes.txt": 1} {
+        if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    tests := []struct {
+        dir, sort string
+        want      []string // file names; nil for none
+        ok        bool
+    }{
+        {dir, "", []string{"a.png", "b.png", "c.png"}, true},
+        {dir, "name:desc", []string{"c.png", "b.png", "a.png"}, true},
+        {dir, "size", []string{"b.png", "c.png", "a.png"}, true},
+        {dir, "colour", nil, false},
+        {t.TempDir(), "", nil, true},
+        {filepath.Join(dir, "missing"), "", nil, true},
+    }
+    for _, tc := range tests {
+        files, err := FindInputs(tc.dir, tc.sort)
+        var names []string
+        for _, f := range files {
+            names = append(names, filepath.Base(f))
+        }
+        if (err == nil) != tc.ok || !reflect.DeepEqual(names, tc.want) {
+            t.Errorf("FindInputs(%s, %q) = %v, %v; want %v", filepath.Base(tc.dir), tc.sort, names, err, tc.want)
+        }
+    }
+}
+
+func TestSortInputs(t *testing.T) {
+    // Sizes and modification times disagree with the names, and b and d tie on size
+    dir := t.TempDir()
+    base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    var discovered []string
+    for _, f := range []struct {
+        name string
+        size int
+        age  time.Duration
+    }{{"d.png", 20, 1}, {"a.png", 30, 3}, {"c.png", 10, 2}, {"b.png", 20, 4}} {
+        path := filepath.Join(dir, f.name)
+        if err := os.WriteFile(path, make([]byte, f.size), 0644); err != nil {
+            t.Fatal(err)
+        }
+        mtime := base.Add(-f.age * time.Hour)
+        if err := os.Chtimes(path, mtime, mtime); err != nil {
+            t.Fatal(err)
+        }
+        discovered = append(discovered, path)
+    }
+
+    tests := []struct {
+        spec string
+        want string // file name initials in order; "" for an error
+    }{
+        {"", "dacb"},
+        {"name", "abcd"},
+        {"name:asc", "abcd"},
+        {"name:desc", "dcba"},
+        {"size", "cdba"}, // d before b: ties keep their discovered order
+        {"size:desc", "adbc"},
+        {"mtime", "bacd"},
+        {"mtime:desc", "dcab"},
+        {"date", ""},
+        {"size:up", ""},
+    }
+    for _, tc := range tests {
+        paths := append([]string(nil), discovered...)
+        err := SortInputs(paths, tc.spec)
+        if tc.want == "" {
+            if err == nil {
+                t.Errorf("SortInputs(%q) accepted an invalid spec", tc.spec)
+            }
+            continue
+        }
+        var got string
+        for _, p := range paths {
+            got += filepath.Base(p)[:1]
+        }
+        if err != nil || got != tc.want {
+            t.Errorf("SortInputs(%q) = %s, %v; want %s", tc.spec, got, err, tc.want)
+        }
+    }
+
+    missing := append(append([]string(nil), discovered...), filepath.Join(dir, "gone.png"))
+    if err := SortInputs(missing, "size"); err == nil {
+        t.Error("sorting by size with a missing input did not fail")
+    }
+}
+
+func TestIsURLAndInputName(t *testing.T) {
+    tests := []struct {
+        input string
+        url   bool
+        name  string
+    }{
+        {"in/cat.png", false, "cat.png"},
+        {"http://example.com/a/cat.png", true, "cat.png"},
+        {"HTTPS://example.com/cat.png?w=100#top", true, "cat.png"},
+        {"ftp://example.com/cat.png", false, "cat.png"},
+        {"/data/http:/cat.png", false, "cat.png"},
+    }
+    for _, tc := range tests {
+        if got := IsURL(tc.input); got != tc.url {
+            t.Errorf("IsURL(%q) = %v, want %v", tc.input, got, tc.url)
+        }
+        if got := InputName(tc.input); got != tc.name {
+            t.Errorf("InputName(%q) = %q, want %q", tc.input, got, tc.name)
+        }
+    }
+}
+
+func TestOpenInputURL(t *testing.T) {
+    var pngData bytes.Buffer
+    if err := png.Encode(&pngData, image.NewGray(image.Rect(0, 0, 6, 4))); err != nil {
+        t.Fatal(err)
+    }
+    mux := http.NewServeMux()
+    mux.HandleFunc("/img.png", func(w http.ResponseWriter, r *http.Request) { w.Write(pngData.Bytes()) })
+    mux.HandleFunc("/slow.png", func(w http.ResponseWriter, r *http.Request) {
+        select {
+        case <-time.After(2 * time.Second):
+        case <-r.Context().Done():
+        }
+    })
+    server := httptest.NewServer(mux)
+    defer server.Close()
+
+    r, err := OpenInput(server.URL+"/img.png", time.Second)
+    if err != nil {
+        t.Fatal(err)
+    }
+    data, err := io.ReadAll(r)
+    r.Close()
+    if err != nil || !bytes.Equal(data, pngData.Bytes()) {
+        t.Errorf("fetched %d bytes (%v), want the served %d", len(data), err, pngData.Len())
+    }
+    if mask, err := LoadMask(server.URL+"/img.png", time.Second); err != nil || mask.Bounds().Dx() != 6 {
+        t.Errorf("LoadMask from a URL = %v, %v; want the 6x4 image", mask, err)
+    }
+    if checks := ValidateInputs([]string{server.URL + "/img.png"}); checks[0].Err != nil || checks[0].Width != 6 {
+        t.Errorf("validating a URL input gave %+v", checks[0])
+    }
+
+    if _, err := OpenInput(server.URL+"/missing.png", time.Second); err == nil || !strings.Contains(err.Error(), "404") {
+        t.Errorf("a 404 gave %v, want an error naming the status", err)
+    }
+    start := time.Now()
+    if _, err := OpenInput(server.URL+"/slow.png", 50*time.Millisecond); err == nil {
+        t.Error("a download past its timeout succeeded")
+    }
+    if waited := time.Since(start); waited > time.Second {
+        t.Errorf("waited %s for a 50ms download timeout", waited)
+    }
+}
@@ -0,0 +1,80 @@
+package common
+
+import (
+    "log"
+    "os"
+    "os/signal"
+    "sync"
+    "syscall"
+)
+
+// ExitInterrupted is the status a second interrupt aborts with (128 + SIGINT)
+const ExitInterrupted = 130
+
+// interrupt is the state shared by HandleInterrupts and the batch runners
+var interrupt struct {
+    sync.Mutex
+    once      sync.Once
+    requested bool
+    writing   string // output currently being written, removed on abort
+}
+
+// HandleInterrupts makes the first SIGINT or SIGTERM a request to stop: the in-process
+// runners finish the image in hand, so no output is left half-written, and return
+// results for the completed images, leaving main to write their stats. A second
+// signal aborts at once, removing the output being written. Call it once from main.
+func HandleInterrupts() {
+    interrupt.once.Do(func() {
+        signals := make(chan os.Signal, 2)
+        signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+        go func() {
+            <-signals
+            RequestInterrupt()
+            log.Printf("Interrupted: finishing the current image, then writing stats for the completed ones (interrupt again to abort now)")
+
+            <-signals
+            interrupt.Lock() // held until the exit, so no runner records a new output
+            removePartialOutput()
+            os.Exit(ExitInterrupted)
+        }()
+    })
+}
+
+// RequestInterrupt asks the runners to stop after the image in hand, as the first
+// signal HandleInterrupts catches does
+func RequestInterrupt() {
+    interrupt.Lock()
+    interrupt.requested = true
+    interrupt.Unlock()
+}
+
+// ClearInterrupt withdraws a requested stop, so a later batch runs in full
+func ClearInterrupt() {
+    interrupt.Lock()
+    interrupt.requested = false
+    interrupt.Unlock()
+}
+
+// removePartialOutput removes the output recorded by WritingOutput, for an abort.
+// Call it with interrupt locked.
+func removePartialOutput() {
+    if interrupt.writing != "" {
+        os.Remove(interrupt.writing)
+        log.Printf("Aborted: removed partial output %s", interrupt.writing)
+    }
+}
+
+// Interrupted reports whether a stop was requested; runners check it between images
+func Interrupted() bool {
+    interrupt.Lock()
+    defer interrupt.Unlock()
+    return interrupt.requested
+}
+
+// WritingOutput records path as the output being written, for an abort to remove.
+// Call it with "" once the file is complete.
+func WritingOutput(path string) {
+    interrupt.Lock()
+    interrupt.writing = path
+    interrupt.Unlock()
+}
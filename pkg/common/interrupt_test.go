@@ -0,0 +1,53 @@
+package common
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestRequestInterrupt(t *testing.T) {
+    t.Cleanup(ClearInterrupt)
+    if Interrupted() {
+        t.Fatal("interrupted before any request")
+    }
+    RequestInterrupt()
+    if !Interrupted() {
+        t.Fatal("not interrupted after RequestInterrupt")
+    }
+    ClearInterrupt()
+    if Interrupted() {
+        t.Fatal("still interrupted after ClearInterrupt")
+    }
+}
+
+func TestAbortRemovesPartialOutput(t *testing.T) {
+    dir := t.TempDir()
+    abort := func() {
+        interrupt.Lock()
+        defer interrupt.Unlock()
+        removePartialOutput()
+    }
+
+    partial := filepath.Join(dir, "partial.png")
+    if err := os.WriteFile(partial, []byte("half"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    WritingOutput(partial)
+    abort()
+    if _, err := os.Stat(partial); !os.IsNotExist(err) {
+        t.Errorf("partial output still there after the abort: %v", err)
+    }
+
+    // A finished output is no longer recorded, so an abort leaves it alone
+    done := filepath.Join(dir, "done.png")
+    if err := os.WriteFile(done, []byte("whole"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    WritingOutput(done)
+    WritingOutput("")
+    abort()
+    if _, err := os.Stat(done); err != nil {
+        t.Errorf("finished output removed by the abort: %v", err)
+    }
+}
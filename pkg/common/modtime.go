@@ -0,0 +1,54 @@
+package common
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "time"
+)
+
+// SetModTime sets path's modification (and access) time to mtime, so an output can
+// carry its source image's timestamp
+func SetModTime(path string, mtime time.Time) error {
+    return os.Chtimes(path, mtime, mtime)
+}
+
+// InputModTime returns the modification time of a local input; URL inputs have none
+func InputModTime(inputPath string) (time.Time, error) {
+    if IsURL(inputPath) {
+        return time.Time{}, fmt.Errorf("%s: URL inputs have no modification time", inputPath)
+    }
+    info, err := os.Stat(inputPath)
+    if err != nil {
+        return time.Time{}, err
+    }
+    return info.ModTime(), nil
+}
+
+// CopyModTimes gives each output the modification time of the input at the same index,
+// for -preserve-mtime. Every pair is attempted; the failures are returned together.
+func CopyModTimes(outputs, inputs []string) error {
+    if len(outputs) != len(inputs) {
+        return fmt.Errorf("%d outputs for %d inputs", len(outputs), len(inputs))
+    }
+    var errs []error
+    for i, output := range outputs {
+        mtime, err := InputModTime(inputs[i])
+        if err == nil {
+            err = SetModTime(output, mtime)
+        }
+        if err != nil {
+            errs = append(errs, err)
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// ApplySourceModTime sets the output at path to info.SourceModTime, when the
+// coordinator recorded one (-preserve-mtime); otherwise it leaves the file alone
+func ApplySourceModTime(info *ImageInfo, path string) error {
+    if info.SourceModTime == nil {
+        return nil
+    }
+    return SetModTime(path, *info.SourceModTime)
+}
@@ -0,0 +1,96 @@
+package common
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+// touch creates path with mtime as its modification time
+func touch(t *testing.T, path string, mtime time.Time) {
+    t.Helper()
+    if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if err := SetModTime(path, mtime); err != nil {
+        t.Fatal(err)
+    }
+}
+
+// modTime returns path's modification time
+func modTime(t *testing.T, path string) time.Time {
+    t.Helper()
+    info, err := os.Stat(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return info.ModTime()
+}
+
+func TestCopyModTimes(t *testing.T) {
+    dir := t.TempDir()
+    old := time.Date(2019, 6, 1, 8, 30, 0, 0, time.UTC)
+    var inputs, outputs []string
+    for i, name := range []string{"a", "b", "c"} {
+        input, output := filepath.Join(dir, name+".png"), filepath.Join(dir, name+"_blurred.png")
+        touch(t, input, old.Add(time.Duration(i)*time.Hour))
+        touch(t, output, time.Now())
+        inputs, outputs = append(inputs, input), append(outputs, output)
+    }
+    if got, err := InputModTime(inputs[1]); err != nil || !got.Equal(old.Add(time.Hour)) {
+        t.Fatalf("InputModTime = %v, %v; want %v", got, err, old.Add(time.Hour))
+    }
+
+    // A missing source fails its own pair without stopping the others
+    missing := filepath.Join(dir, "missing.png")
+    inputs[1] = missing
+    err := CopyModTimes(outputs, inputs)
+    if err == nil || !strings.Contains(err.Error(), missing) {
+        t.Errorf("CopyModTimes = %v, want the missing source reported", err)
+    }
+    for _, i := range []int{0, 2} {
+        if got, want := modTime(t, outputs[i]), old.Add(time.Duration(i)*time.Hour); !got.Equal(want) {
+            t.Errorf("%s has mtime %v, want its source's %v", outputs[i], got, want)
+        }
+    }
+    if got := modTime(t, outputs[1]); got.Year() == 2019 {
+        t.Errorf("the output of a missing source was given mtime %v", got)
+    }
+
+    if err := CopyModTimes(outputs, inputs[:2]); err == nil {
+        t.Error("mismatched outputs and inputs copied without error")
+    }
+    if _, err := InputModTime("https://example.com/cat.png"); err == nil {
+        t.Error("a URL input has a modification time")
+    }
+}
+
+func TestApplySourceModTime(t *testing.T) {
+    dir := t.TempDir()
+    now := time.Now().Truncate(time.Second)
+    source := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+    output := filepath.Join(dir, "out.png")
+    touch(t, output, now)
+    if err := ApplySourceModTime(&ImageInfo{SourceModTime: &source}, output); err != nil {
+        t.Fatal(err)
+    }
+    if got := modTime(t, output); !got.Equal(source) {
+        t.Errorf("output mtime %v, want the source's %v", got, source)
+    }
+
+    // Without a recorded source time the output keeps its own
+    touch(t, output, now)
+    if err := ApplySourceModTime(&ImageInfo{}, output); err != nil {
+        t.Fatal(err)
+    }
+    if got := modTime(t, output); !got.Equal(now) {
+        t.Errorf("output mtime %v after an apply with no source time, want it left at %v", got, now)
+    }
+
+    if err := ApplySourceModTime(&ImageInfo{SourceModTime: &source}, filepath.Join(dir, "missing.png")); err == nil {
+        t.Error("setting the mtime of a missing output succeeded")
+    }
+}
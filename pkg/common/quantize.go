@@ -0,0 +1,145 @@
+package common
+
+import (
+    "image"
+    "image/color"
+    "sort"
+
+    "studyguide.parallel/pkg/blur"
+)
+
+// PaletteSize is the largest palette a paletted PNG can carry
+const PaletteSize = 256
+
+// colorCount is one distinct colour of the source and how many pixels use it
+type colorCount struct {
+    c     [4]uint8 // R, G, B, A
+    count int
+}
+
+// colorBox is a median-cut box: a run of colours and the pixels they cover
+type colorBox struct {
+    colors []colorCount
+    pixels int
+}
+
+// widest returns the channel with the largest value range in the box and that range
+func (b colorBox) widest() (channel int, span int) {
+    for ch := 0; ch < 4; ch++ {
+        lo, hi := uint8(255), uint8(0)
+        for _, cc := range b.colors {
+            lo = min(lo, cc.c[ch])
+            hi = max(hi, cc.c[ch])
+        }
+        if int(hi)-int(lo) > span {
+            channel, span = ch, int(hi)-int(lo)
+        }
+    }
+    return channel, span
+}
+
+// mean is the pixel-weighted average colour of the box
+func (b colorBox) mean() color.RGBA {
+    var sum [4]int
+    for _, cc := range b.colors {
+        for ch := 0; ch < 4; ch++ {
+            sum[ch] += int(cc.c[ch]) * cc.count
+        }
+    }
+    half := b.pixels / 2
+    return color.RGBA{
+        R: uint8((sum[0] + half) / b.pixels),
+        G: uint8((sum[1] + half) / b.pixels),
+        B: uint8((sum[2] + half) / b.pixels),
+        A: uint8((sum[3] + half) / b.pixels),
+    }
+}
+
+// Quantize reduces img to at most colors colours with median cut: the box covering the
+// most pixels is split at the pixel median of its widest channel until there are colors
+// boxes, and each box's average becomes a palette entry. Every pixel then maps to its
+// nearest entry. An image that already has few enough colours keeps them exactly.
+func Quantize(img image.Image, colors int) *image.Paletted {
+    colors = max(1, min(colors, PaletteSize))
+    src := blur.ToRGBA(img)
+    bounds := src.Bounds()
+
+    counts := make(map[[4]uint8]int)
+    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+        for x := bounds.Min.X; x < bounds.Max.X; x++ {
+            p := src.RGBAAt(x, y)
+            counts[[4]uint8{p.R, p.G, p.B, p.A}]++
+        }
+    }
+    all := make([]colorCount, 0, len(counts))
+    for c, n := range counts {
+        all = append(all, colorCount{c: c, count: n})
+    }
+
+    boxes := []colorBox{{colors: all, pixels: bounds.Dx() * bounds.Dy()}}
+    for len(boxes) < colors {
+        // Split the most populous box that still has more than one colour
+        pick := -1
+        for i, b := range boxes {
+            if len(b.colors) > 1 && (pick < 0 || b.pixels > boxes[pick].pixels) {
+                pick = i
+            }
+        }
+        if pick < 0 {
+            break
+        }
+        lo, hi := splitBox(boxes[pick])
+        boxes[pick] = lo
+        boxes = append(boxes, hi)
+    }
+
+    palette := make(color.Palette, 0, len(boxes))
+    for _, b := range boxes {
+        if b.pixels > 0 {
+            palette = append(palette, b.mean())
+        }
+    }
+    if len(palette) == 0 {
+        palette = append(palette, color.RGBA{})
+    }
+
+    dst := image.NewPaletted(bounds, palette)
+    index := make(map[[4]uint8]uint8, len(counts))
+    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+        for x := bounds.Min.X; x < bounds.Max.X; x++ {
+            p := src.RGBAAt(x, y)
+            key := [4]uint8{p.R, p.G, p.B, p.A}
+            i, ok := index[key]
+            if !ok {
+                i = uint8(palette.Index(p))
+                index[key] = i
+            }
+            dst.SetColorIndex(x, y, i)
+        }
+    }
+    return dst
+}
+
+// splitBox divides a box at the pixel median of its widest channel
+func splitBox(b colorBox) (colorBox, colorBox) {
+    ch, _ := b.widest()
+    sort.Slice(b.colors, func(i, j int) bool { return b.colors[i].c[ch] < b.colors[j].c[ch] })
+
+    // Cut after the colour that takes the running pixel count past half, keeping at
+    // least one colour on each side
+    cut, seen := 1, 0
+    for i, cc := range b.colors[:len(b.colors)-1] {
+        seen += cc.count
+        cut = i + 1
+        if seen*2 >= b.pixels {
+            break
+        }
+    }
+    lo := colorBox{colors: b.colors[:cut]}
+    hi := colorBox{colors: b.colors[cut:]}
+    for _, cc := range lo.colors {
+        lo.pixels += cc.count
+    }
+    hi.pixels = b.pixels - lo.pixels
+    return lo, hi
+}
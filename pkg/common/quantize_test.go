@@ -0,0 +1,96 @@
+package common
+
+import (
+    "bytes"
+    "image"
+    "image/color"
+    "image/png"
+    "math/rand"
+    "testing"
+)
+
+// gradient is a smooth blurred-looking image with far more than 256 colours
+func gradient(width, height int) *image.RGBA {
+    img := image.NewRGBA(image.Rect(0, 0, width, height))
+    for y := 0; y < height; y++ {
+        for x := 0; x < width; x++ {
+            img.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), uint8((x + y) / 2), 255})
+        }
+    }
+    return img
+}
+
+func TestQuantizePaletteSize(t *testing.T) {
+    src := gradient(256, 256)
+    for _, colors := range []int{2, 16, 256, 1000} {
+        out := Quantize(src, colors)
+        if len(out.Palette) > min(colors, PaletteSize) {
+            t.Errorf("Quantize(%d): palette has %d entries", colors, len(out.Palette))
+        }
+        if out.Bounds() != src.Bounds() {
+            t.Errorf("Quantize(%d): bounds %v, want %v", colors, out.Bounds(), src.Bounds())
+        }
+        for _, index := range out.Pix {
+            if int(index) >= len(out.Palette) {
+                t.Fatalf("Quantize(%d): index %d past a %d-entry palette", colors, index, len(out.Palette))
+            }
+        }
+    }
+
+    // Every pixel should land near its source colour, not just somewhere in the palette
+    out := Quantize(src, PaletteSize)
+    var worst int
+    for y := 0; y < 256; y++ {
+        for x := 0; x < 256; x++ {
+            a, b := src.RGBAAt(x, y), color.RGBAModel.Convert(out.At(x, y)).(color.RGBA)
+            worst = max(worst, absDiff(a.R, b.R), absDiff(a.G, b.G), absDiff(a.B, b.B))
+        }
+    }
+    if worst > 32 {
+        t.Errorf("worst channel error %d after quantizing to %d colours", worst, PaletteSize)
+    }
+}
+
+func TestQuantizeKeepsFewColours(t *testing.T) {
+    src := image.NewRGBA(image.Rect(0, 0, 8, 8))
+    colors := []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 128}}
+    for i := 0; i < 64; i++ {
+        src.SetRGBA(i%8, i/8, colors[i%len(colors)])
+    }
+    out := Quantize(src, PaletteSize)
+    for y := 0; y < 8; y++ {
+        for x := 0; x < 8; x++ {
+            if got := color.RGBAModel.Convert(out.At(x, y)); got != src.RGBAAt(x, y) {
+                t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, src.RGBAAt(x, y))
+            }
+        }
+    }
+}
+
+func TestQuantizeShrinksPNG(t *testing.T) {
+    // Grain defeats PNG's row filters on the truecolor image, as in a photograph
+    src := gradient(256, 256)
+    rng := rand.New(rand.NewSource(1))
+    for i := range src.Pix {
+        if i%4 != 3 {
+            src.Pix[i] = uint8(min(255, int(src.Pix[i])+rng.Intn(8)))
+        }
+    }
+    var truecolor, paletted bytes.Buffer
+    if err := EncodePNG(&truecolor, src, png.DefaultCompression); err != nil {
+        t.Fatal(err)
+    }
+    if err := EncodePNG(&paletted, Quantize(src, PaletteSize), png.DefaultCompression); err != nil {
+        t.Fatal(err)
+    }
+    if paletted.Len() >= truecolor.Len() {
+        t.Errorf("paletted PNG is %d bytes, truecolor %d", paletted.Len(), truecolor.Len())
+    }
+}
+
+func absDiff(a, b uint8) int {
+    if a > b {
+        return int(a - b)
+    }
+    return int(b - a)
+}
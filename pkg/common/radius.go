@@ -0,0 +1,62 @@
+package common
+
+import (
+    "flag"
+    "fmt"
+
+    "studyguide.parallel/pkg/blur"
+)
+
+// ApplyRadiusFlag resolves a -radius flag against -kernel after flag.Parse: a radius
+// R > 0 sets *kernelSize to 2R+1, the same blur as -kernel 2R+1. Setting both flags
+// on the command line is an error, as is a negative radius; 0 leaves -kernel in force.
+func ApplyRadiusFlag(kernelSize *int, radius int) error {
+    return applyRadius(flag.CommandLine, kernelSize, radius)
+}
+
+// applyRadius is ApplyRadiusFlag against the flags set on fs
+func applyRadius(fs *flag.FlagSet, kernelSize *int, radius int) error {
+    if radius < 0 {
+        return fmt.Errorf("-radius must be >= 0, got %d", radius)
+    }
+    if radius == 0 {
+        return nil
+    }
+    kernelSet := false
+    fs.Visit(func(f *flag.Flag) {
+        if f.Name == "kernel" {
+            kernelSet = true
+        }
+    })
+    if kernelSet {
+        return fmt.Errorf("-radius and -kernel are mutually exclusive")
+    }
+    *kernelSize = 2*radius + 1
+    return nil
+}
+
+// ApplyProfileFlag resolves a -profile flag after flag.Parse: a preset name sets
+// *kernelSize from blur.Profiles and returns the profile for its edge mode and passes.
+// Like -radius it stands in for -kernel, so setting it with -kernel, -radius or
+// -edge-mode on the command line is an error. An empty name returns a zero Profile.
+func ApplyProfileFlag(kernelSize *int, name string) (blur.Profile, error) {
+    if name == "" {
+        return blur.Profile{}, nil
+    }
+    profile, err := blur.ParseProfile(name)
+    if err != nil {
+        return profile, err
+    }
+    var conflict error
+    flag.Visit(func(f *flag.Flag) {
+        switch f.Name {
+        case "kernel", "radius", "edge-mode":
+            conflict = fmt.Errorf("-profile and -%s are mutually exclusive", f.Name)
+        }
+    })
+    if conflict != nil {
+        return profile, conflict
+    }
+    *kernelSize = profile.KernelSize
+    return profile, nil
+}
@@ -0,0 +1,38 @@
+package common
+
+import (
+    "flag"
+    "testing"
+)
+
+func TestApplyRadiusFlag(t *testing.T) {
+    tests := []struct {
+        args    []string
+        want    int
+        wantErr bool
+    }{
+        {[]string{"-radius", "7"}, 15, false},
+        {[]string{"-radius", "1"}, 3, false},
+        {[]string{"-radius", "0"}, 9, false}, // leaves the -kernel default
+        {[]string{"-kernel", "5"}, 5, false},
+        {[]string{"-kernel", "5", "-radius", "0"}, 5, false},
+        {[]string{"-kernel", "15", "-radius", "7"}, 0, true},
+        {[]string{"-radius", "-2"}, 0, true},
+    }
+    for _, tc := range tests {
+        fs := flag.NewFlagSet("test", flag.ContinueOnError)
+        kernelSize := fs.Int("kernel", 9, "")
+        radius := fs.Int("radius", 0, "")
+        if err := fs.Parse(tc.args); err != nil {
+            t.Fatal(err)
+        }
+        err := applyRadius(fs, kernelSize, *radius)
+        if (err != nil) != tc.wantErr {
+            t.Errorf("%v: error %v, want error %v", tc.args, err, tc.wantErr)
+            continue
+        }
+        if !tc.wantErr && *kernelSize != tc.want {
+            t.Errorf("%v: kernel %d, want %d", tc.args, *kernelSize, tc.want)
+        }
+    }
+}
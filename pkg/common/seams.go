@@ -0,0 +1,20 @@
+package common
+
+import (
+    "image"
+
+    "studyguide.parallel/pkg/verify"
+)
+
+// CheckSeamless runs the tile-seam detector over an assembled image on the grid it was
+// split into and returns an error naming the worst boundary when a seam's magnitude
+// exceeds threshold (see verify.DefaultSeamThreshold). It is verify.DetectTileSeams
+// with the tile size taken from info; assemblers use it as a self-check that catches
+// padding and extraction regressions.
+func CheckSeamless(img image.Image, info *ImageInfo, threshold float64) error {
+    tileSize := info.TileSize
+    if tileSize <= 0 {
+        tileSize = TILE_SIZE
+    }
+    return verify.DetectTileSeams(img, tileSize).Err(threshold)
+}
@@ -0,0 +1,42 @@
+package common
+
+import (
+    "fmt"
+    "image"
+    "image/png"
+    "os"
+    "path/filepath"
+)
+
+// OutputSink is where an assembler delivers a finished image. The file sink writes to
+// ImageInfo.OutputPath; queues can provide sinks for deployments without shared disk.
+type OutputSink interface {
+    // Save stores img for info and returns where it went, for logging
+    Save(info *ImageInfo, img image.Image) (string, error)
+}
+
+// FileSink writes each image to its OutputPath in the input's format (see
+// EncodeOutput), creating the directory, and gives it the input's timestamp when the
+// coordinator recorded one
+type FileSink struct {
+    Level       png.CompressionLevel
+    JPEGQuality int // for JPEG inputs; 0 uses DefaultJPEGQuality
+}
+
+func (s FileSink) Save(info *ImageInfo, img image.Image) (string, error) {
+    if err := os.MkdirAll(filepath.Dir(info.OutputPath), 0755); err != nil {
+        return "", fmt.Errorf("failed to create output directory: %w", err)
+    }
+    file, err := os.Create(info.OutputPath)
+    if err != nil {
+        return "", err
+    }
+    if err := EncodeOutput(file, img, info.Format, s.Level, s.JPEGQuality); err != nil {
+        file.Close()
+        return "", err
+    }
+    if err := file.Close(); err != nil {
+        return "", err
+    }
+    return info.OutputPath, ApplySourceModTime(info, info.OutputPath)
+}
@@ -0,0 +1,233 @@
+package common
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+    "math"
+    "sort"
+
+    "studyguide.parallel/pkg/blur"
+)
+
+// Bounds for AutoTileSize: below the minimum the per-tile padding and queue overhead
+// dominate, above the maximum a single tile message gets unwieldy
+const (
+    MinAutoTileSize = 64
+    MaxAutoTileSize = 1024
+)
+
+// AutoTilesPerWorker is how many tiles -auto-tile aims to cut each image into per worker
+const AutoTilesPerWorker = 4
+
+// AutoTileSize picks a square tile size that splits a width x height image into roughly
+// targetTiles tiles. The size is rounded to a multiple of 32 and clamped to
+// MinAutoTileSize..MaxAutoTileSize; a targetTiles <= 0 returns TILE_SIZE.
+func AutoTileSize(width, height, targetTiles int) int {
+    if targetTiles <= 0 || width <= 0 || height <= 0 {
+        return TILE_SIZE
+    }
+
+    side := math.Sqrt(float64(width) * float64(height) / float64(targetTiles))
+    size := int(math.Round(side/32)) * 32
+    if size < MinAutoTileSize {
+        size = MinAutoTileSize
+    }
+    if size > MaxAutoTileSize {
+        size = MaxAutoTileSize
+    }
+    return size
+}
+
+// TileCount returns how many tileSize x tileSize tiles cover a width x height image,
+// counting the partial tiles along the right and bottom edges
+func TileCount(width, height, tileSize int) int {
+    tilesX := (width + tileSize - 1) / tileSize
+    tilesY := (height + tileSize - 1) / tileSize
+    return tilesX * tilesY
+}
+
+// ExtractPaddedTile copies the tile at (tileX, tileY) with padding pixels of context on
+// every side. Padding that falls outside the image is synthesized with the edge mode,
+// so border tiles have the same full padding as interior ones.
+func ExtractPaddedTile(img *image.RGBA, tileX, tileY, width, height, padding int, mode blur.EdgeMode) [][]color.RGBA {
+    bounds := img.Bounds()
+    paddedWidth := width + 2*padding
+    paddedHeight := height + 2*padding
+
+    data := make([][]color.RGBA, paddedHeight)
+    for y := 0; y < paddedHeight; y++ {
+        data[y] = make([]color.RGBA, paddedWidth)
+        srcY := bounds.Min.Y + mode.Index(tileY+y-padding-bounds.Min.Y, bounds.Dy())
+        for x := 0; x < paddedWidth; x++ {
+            srcX := bounds.Min.X + mode.Index(tileX+x-padding-bounds.Min.X, bounds.Dx())
+            data[y][x] = img.RGBAAt(srcX, srcY)
+        }
+    }
+    return data
+}
+
+// PlaceTile copies a processed tile into the output image at its position, writing
+// its rows straight into img.Pix instead of going through SetRGBA per pixel. Pixels
+// outside img are dropped, as SetRGBA would. Each call writes only the tile's own
+// rectangle, so tiles that do not overlap can be placed from several goroutines at once.
+func PlaceTile(img *image.RGBA, tile *ProcessedImageTile) {
+    bounds := img.Bounds()
+    x0 := max(tile.X, bounds.Min.X)
+    for y := 0; y < tile.Height && y < len(tile.Data); y++ {
+        dy := tile.Y + y
+        if dy < bounds.Min.Y || dy >= bounds.Max.Y {
+            continue
+        }
+        row := tile.Data[y]
+        x1 := min(tile.X+min(tile.Width, len(row)), bounds.Max.X)
+        if x1 <= x0 {
+            continue
+        }
+        pix := img.Pix[img.PixOffset(x0, dy):img.PixOffset(x1, dy)]
+        for i, c := range row[x0-tile.X : x1-tile.X] {
+            pix[4*i], pix[4*i+1], pix[4*i+2], pix[4*i+3] = c.R, c.G, c.B, c.A
+        }
+    }
+}
+
+// ValidateTileData checks that a processed tile's Data holds exactly Height rows of
+// Width pixels. PlaceTile copies whatever is there, so a tile from a buggy worker or a
+// truncated payload would otherwise leave unwritten (black) pixels in the output.
+func ValidateTileData(tile *ProcessedImageTile) error {
+    if tile == nil {
+        return fmt.Errorf("result carries no tile")
+    }
+    if tile.Width <= 0 || tile.Height <= 0 {
+        return fmt.Errorf("tile %d of image %d declares %dx%d", tile.TileID, tile.ImageID, tile.Width, tile.Height)
+    }
+    if len(tile.Data) != tile.Height {
+        return fmt.Errorf("tile %d of image %d has %d rows, declares %d", tile.TileID, tile.ImageID, len(tile.Data), tile.Height)
+    }
+    for y, row := range tile.Data {
+        if len(row) != tile.Width {
+            return fmt.Errorf("tile %d of image %d has %d pixels in row %d, declares %d", tile.TileID, tile.ImageID, len(row), y, tile.Width)
+        }
+    }
+    return nil
+}
+
+// AssembleOrdered builds a width x height image from a complete set of tiles, placing
+// them in tile-ID order. The result does not depend on the order the tiles arrived in,
+// which makes distributed runs reproducible for golden-image comparisons.
+func AssembleOrdered(width, height int, tiles []*ProcessedImageTile) *image.RGBA {
+    img := image.NewRGBA(image.Rect(0, 0, width, height))
+    PlaceOrdered(img, tiles)
+    return img
+}
+
+// PlaceOrdered places tiles into an existing image in tile-ID order, as AssembleOrdered
+// does, for assemblers that already hold the output buffer and should not allocate a
+// second one
+func PlaceOrdered(img *image.RGBA, tiles []*ProcessedImageTile) {
+    ordered := make([]*ProcessedImageTile, len(tiles))
+    copy(ordered, tiles)
+    sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].TileID < ordered[j].TileID })
+
+    for _, tile := range ordered {
+        PlaceTile(img, tile)
+    }
+}
+
+// TileOverlap describes whether the padding sent with each tile covers a blur kernel
+type TileOverlap struct {
+    TileSize   int
+    KernelSize int
+    Padding    int
+    Required   int     // padding the kernel reaches into, kernelSize / 2
+    Seamless   bool    // Padding >= Required, so interior tile edges match a whole-image blur
+    Overhead   float64 // extra pixels a padded interior tile carries, relative to the tile itself
+}
+
+// CheckTileOverlap compares the padding the coordinators attach to each tileSize tile
+// with the radius a kernelSize Gaussian needs. A kernel wider than the padding reads
+// past the padded data and the tile edges show as seams in the assembled image.
+func CheckTileOverlap(tileSize, kernelSize, padding int) TileOverlap {
+    padded := float64(tileSize + 2*padding)
+    return TileOverlap{
+        TileSize:   tileSize,
+        KernelSize: kernelSize,
+        Padding:    padding,
+        Required:   kernelSize / 2,
+        Seamless:   padding >= kernelSize/2,
+        Overhead:   padded*padded/float64(tileSize*tileSize) - 1,
+    }
+}
+
+// Report formats the check as log lines: a summary, then a warning if tiles will seam
+func (o TileOverlap) Report() []string {
+    lines := []string{fmt.Sprintf("Tile overlap: %dpx tiles, %dpx padding, kernel %d needs %dpx; padded tiles carry %.1f%% extra pixels",
+        o.TileSize, o.Padding, o.KernelSize, o.Required, 100*o.Overhead)}
+    if o.Seamless {
+        lines = append(lines, "Tile overlap: padding covers the kernel, interior tiles are seamless")
+    } else {
+        lines = append(lines, fmt.Sprintf("WARNING: kernel %d reaches %dpx past each tile but only %dpx of padding is sent; tile edges will show seams",
+            o.KernelSize, o.Required, o.Padding))
+    }
+    return lines
+}
+
+// TileSource returns a function that yields img's padded tiles in row-major tile-ID
+// order and then nil, so a coordinator can extract tiles lazily as it enqueues them.
+// Each tile carries kernelSize/2 pixels of padding and asks workers for kernelSize.
+func TileSource(img *image.RGBA, imageID, tileSize, kernelSize int, mode blur.EdgeMode, dither bool) func() *ImageTile {
+    padding := kernelSize / 2
+    b := img.Bounds()
+    x, y, tileID := b.Min.X, b.Min.Y, 0
+    return func() *ImageTile {
+        if y >= b.Max.Y {
+            return nil
+        }
+        w := min(tileSize, b.Max.X-x)
+        h := min(tileSize, b.Max.Y-y)
+        tile := &ImageTile{
+            ImageID:    imageID,
+            TileID:     tileID,
+            X:          x,
+            Y:          y,
+            Width:      w,
+            Height:     h,
+            Data:       ExtractPaddedTile(img, x, y, w, h, padding, mode),
+            Padding:    padding,
+            KernelSize: kernelSize,
+            Dither:     dither,
+        }
+        tileID++
+        if x += tileSize; x >= b.Max.X {
+            x, y = b.Min.X, y+tileSize
+        }
+        return tile
+    }
+}
+
+// InterleaveTiles drains the sources round-robin, taking at most perTurn consecutive
+// tiles from one image before moving on to the next, and passes each tile to emit.
+// Enqueued in this order, workers pulling from a shared queue make progress on every
+// image instead of draining one large image before the next gets a tile. perTurn <= 0
+// drains each source in turn.
+func InterleaveTiles(sources []func() *ImageTile, perTurn int, emit func(*ImageTile)) {
+    active := append([]func() *ImageTile(nil), sources...)
+    for len(active) > 0 {
+        remaining := active[:0]
+        for _, next := range active {
+            exhausted := false
+            for n := 0; perTurn <= 0 || n < perTurn; n++ {
+                tile := next()
+                if tile == nil {
+                    exhausted = true
+                    break
+                }
+                emit(tile)
+            }
+            if !exhausted {
+                remaining = append(remaining, next)
+            }
+        }
+        active = remaining
+    }
+}
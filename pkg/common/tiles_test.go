@@ -0,0 +1,466 @@
+package common
+
+import (
+    "bytes"
+    "image"
+    "image/color"
+    "math"
+    "math/rand"
+    "reflect"
+    "strings"
+    "sync"
+    "testing"
+
+    "studyguide.parallel/pkg/blur"
+)
+
+// placeTileSlow is the per-pixel loop PlaceTile replaced
+func placeTileSlow(img *image.RGBA, tile *ProcessedImageTile) {
+    for y := 0; y < tile.Height && y < len(tile.Data); y++ {
+        for x := 0; x < tile.Width && x < len(tile.Data[y]); x++ {
+            img.SetRGBA(tile.X+x, tile.Y+y, tile.Data[y][x])
+        }
+    }
+}
+
+func TestPlaceTileMatchesPerPixel(t *testing.T) {
+    tiles := []struct {
+        name       string
+        x, y, w, h int
+    }{
+        {"inside", 3, 2, 5, 4},
+        {"right edge", 14, 1, 6, 3},
+        {"bottom edge", 1, 9, 4, 5},
+        {"top left overhang", -2, -3, 5, 5},
+        {"outside", 20, 20, 3, 3},
+        {"whole image", 0, 0, 16, 12},
+    }
+    for _, tc := range tiles {
+        tile := &ProcessedImageTile{X: tc.x, Y: tc.y, Width: tc.w, Height: tc.h}
+        for y := 0; y < tc.h; y++ {
+            row := make([]color.RGBA, tc.w)
+            for x := range row {
+                row[x] = color.RGBA{uint8(x * 13), uint8(y * 29), uint8(x + y), 255}
+            }
+            tile.Data = append(tile.Data, row)
+        }
+
+        // A non-zero origin catches offsets taken from 0 instead of bounds.Min
+        for _, bounds := range []image.Rectangle{image.Rect(0, 0, 16, 12), image.Rect(-4, 1, 12, 13)} {
+            want := image.NewRGBA(bounds)
+            got := image.NewRGBA(bounds)
+            placeTileSlow(want, tile)
+            PlaceTile(got, tile)
+            if !bytes.Equal(got.Pix, want.Pix) {
+                t.Errorf("%s in %v: PlaceTile differs from the per-pixel loop", tc.name, bounds)
+            }
+        }
+    }
+}
+
+func TestPlaceTileShortRows(t *testing.T) {
+    // Missing rows and short rows are skipped rather than read past
+    tile := &ProcessedImageTile{X: 1, Y: 1, Width: 4, Height: 3, Data: [][]color.RGBA{
+        {{1, 2, 3, 255}, {4, 5, 6, 255}},
+    }}
+    want := image.NewRGBA(image.Rect(0, 0, 6, 6))
+    got := image.NewRGBA(image.Rect(0, 0, 6, 6))
+    placeTileSlow(want, tile)
+    PlaceTile(got, tile)
+    if !bytes.Equal(got.Pix, want.Pix) {
+        t.Fatal("PlaceTile differs from the per-pixel loop for a short tile")
+    }
+}
+
+func TestPlaceTileConcurrentDisjoint(t *testing.T) {
+    // Tiles of one image placed from separate goroutines; run with -race
+    const tileSize, cols, rows = 8, 4, 3
+    var tiles []*ProcessedImageTile
+    for ty := 0; ty < rows; ty++ {
+        for tx := 0; tx < cols; tx++ {
+            tile := &ProcessedImageTile{X: tx * tileSize, Y: ty * tileSize, Width: tileSize, Height: tileSize}
+            for y := 0; y < tileSize; y++ {
+                row := make([]color.RGBA, tileSize)
+                for x := range row {
+                    row[x] = color.RGBA{uint8(tile.X + x), uint8(tile.Y + y), uint8(len(tiles)), 255}
+                }
+                tile.Data = append(tile.Data, row)
+            }
+            tiles = append(tiles, tile)
+        }
+    }
+
+    bounds := image.Rect(0, 0, cols*tileSize, rows*tileSize)
+    want := image.NewRGBA(bounds)
+    for _, tile := range tiles {
+        placeTileSlow(want, tile)
+    }
+    got := image.NewRGBA(bounds)
+    var wg sync.WaitGroup
+    for _, tile := range tiles {
+        wg.Add(1)
+        go func(tile *ProcessedImageTile) {
+            defer wg.Done()
+            PlaceTile(got, tile)
+        }(tile)
+    }
+    wg.Wait()
+    if !bytes.Equal(got.Pix, want.Pix) {
+        t.Fatal("tiles placed concurrently differ from placing them one by one")
+    }
+}
+
+func TestValidateTileData(t *testing.T) {
+    rows := func(n, width int) [][]color.RGBA {
+        data := make([][]color.RGBA, n)
+        for y := range data {
+            data[y] = make([]color.RGBA, width)
+        }
+        return data
+    }
+    ragged := rows(3, 4)
+    ragged[1] = ragged[1][:3]
+    tests := []struct {
+        name string
+        tile *ProcessedImageTile
+        ok   bool
+    }{
+        {"exact", &ProcessedImageTile{Width: 4, Height: 3, Data: rows(3, 4)}, true},
+        {"fewer rows than height", &ProcessedImageTile{Width: 4, Height: 3, Data: rows(2, 4)}, false},
+        {"more rows than height", &ProcessedImageTile{Width: 4, Height: 3, Data: rows(4, 4)}, false},
+        {"short row", &ProcessedImageTile{Width: 4, Height: 3, Data: ragged}, false},
+        {"long rows", &ProcessedImageTile{Width: 4, Height: 3, Data: rows(3, 5)}, false},
+        {"no data", &ProcessedImageTile{Width: 4, Height: 3}, false},
+        {"zero size", &ProcessedImageTile{}, false},
+        {"nil tile", nil, false},
+    }
+    for _, tc := range tests {
+        if err := ValidateTileData(tc.tile); (err == nil) != tc.ok {
+            t.Errorf("%s: ValidateTileData = %v, want ok %v", tc.name, err, tc.ok)
+        }
+    }
+}
+
+func TestAutoTileSize(t *testing.T) {
+    tests := []struct {
+        name                 string
+        width, height, target int
+        want                 int
+    }{
+        {"no target keeps the default", 4000, 3000, 0, TILE_SIZE},
+        {"empty image keeps the default", 0, 3000, 16, TILE_SIZE},
+        {"exact split", 1024, 1024, 16, 256},
+        {"rounded to a multiple of 32", 1000, 1000, 16, 256},
+        {"small image clamped up", 100, 100, 16, MinAutoTileSize},
+        {"huge image clamped down", 20000, 20000, 4, MaxAutoTileSize},
+        {"more workers, smaller tiles", 4096, 4096, 64, 512},
+    }
+    for _, tc := range tests {
+        if got := AutoTileSize(tc.width, tc.height, tc.target); got != tc.want {
+            t.Errorf("%s: AutoTileSize(%d, %d, %d) = %d, want %d", tc.name, tc.width, tc.height, tc.target, got, tc.want)
+        }
+    }
+
+    // Within the clamps a larger image gets more tiles of a similar size, close to the target
+    for _, side := range []int{2048, 8192} {
+        size := AutoTileSize(side, side, 32)
+        if n := TileCount(side, side, size); n < 16 || n > 64 {
+            t.Errorf("a %dpx image got %d tiles of %dpx, want about 32", side, n, size)
+        }
+    }
+    if small, large := TileCount(512, 512, AutoTileSize(512, 512, 32)), TileCount(8192, 8192, AutoTileSize(8192, 8192, 32)); small >= large {
+        t.Errorf("a 512px image got %d tiles and an 8192px one %d; the small one should get fewer", small, large)
+    }
+}
+
+func TestTileCount(t *testing.T) {
+    tests := []struct {
+        width, height, tileSize, want int
+    }{
+        {256, 256, 256, 1},
+        {257, 256, 256, 2},
+        {512, 512, 256, 4},
+        {600, 300, 256, 6},
+        {1, 1, 256, 1},
+        {0, 100, 256, 0},
+    }
+    for _, tc := range tests {
+        if got := TileCount(tc.width, tc.height, tc.tileSize); got != tc.want {
+            t.Errorf("TileCount(%d, %d, %d) = %d, want %d", tc.width, tc.height, tc.tileSize, got, tc.want)
+        }
+    }
+}
+
+// coordinateImage returns a width x height image whose pixels record their own
+// position, R = x and G = y
+func coordinateImage(width, height int) *image.RGBA {
+    img := image.NewRGBA(image.Rect(0, 0, width, height))
+    for y := 0; y < height; y++ {
+        for x := 0; x < width; x++ {
+            img.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+        }
+    }
+    return img
+}
+
+func TestExtractPaddedTileEdgeModes(t *testing.T) {
+    // The top-left 2x2 tile of a 4x3 image with 2px of padding: the padding left of
+    // and above the image comes from the edge mode, the rest from the image itself
+    tests := []struct {
+        mode   blur.EdgeMode
+        xs, ys []uint8
+    }{
+        {blur.EdgeClamp, []uint8{0, 0, 0, 1, 2, 3}, []uint8{0, 0, 0, 1, 2, 2}},
+        {blur.EdgeReflect, []uint8{1, 0, 0, 1, 2, 3}, []uint8{1, 0, 0, 1, 2, 2}},
+        {blur.EdgeWrap, []uint8{2, 3, 0, 1, 2, 3}, []uint8{1, 2, 0, 1, 2, 0}},
+    }
+    img := coordinateImage(4, 3)
+    for _, tc := range tests {
+        data := ExtractPaddedTile(img, 0, 0, 2, 2, 2, tc.mode)
+        var xs, ys []uint8
+        for _, c := range data[2] {
+            xs = append(xs, c.R)
+        }
+        for _, row := range data {
+            ys = append(ys, row[2].G)
+        }
+        if !reflect.DeepEqual(xs, tc.xs) || !reflect.DeepEqual(ys, tc.ys) {
+            t.Errorf("%s: padded columns read x %v and rows y %v, want %v and %v", tc.mode, xs, ys, tc.xs, tc.ys)
+        }
+    }
+
+    // A sub-image pads from its own bounds, not the parent image's
+    sub := coordinateImage(8, 8).SubImage(image.Rect(2, 2, 6, 6)).(*image.RGBA)
+    data := ExtractPaddedTile(sub, 2, 2, 2, 2, 1, blur.EdgeClamp)
+    if corner := data[0][0]; corner.R != 2 || corner.G != 2 {
+        t.Errorf("the padding corner of a sub-image read (%d, %d), want its own corner (2, 2)", corner.R, corner.G)
+    }
+}
+
+func TestTileSource(t *testing.T) {
+    type tileRect struct{ id, x, y, w, h int }
+    tests := []struct {
+        name                string
+        width, height, size int
+        want                []tileRect
+    }{
+        {"one partial tile", 100, 50, 256, []tileRect{{0, 0, 0, 100, 50}}},
+        {"exact grid", 8, 8, 4, []tileRect{{0, 0, 0, 4, 4}, {1, 4, 0, 4, 4}, {2, 0, 4, 4, 4}, {3, 4, 4, 4, 4}}},
+        {"ragged right and bottom edges", 10, 6, 4, []tileRect{
+            {0, 0, 0, 4, 4}, {1, 4, 0, 4, 4}, {2, 8, 0, 2, 4},
+            {3, 0, 4, 4, 2}, {4, 4, 4, 4, 2}, {5, 8, 4, 2, 2},
+        }},
+    }
+    for _, tc := range tests {
+        img := coordinateImage(tc.width, tc.height)
+        next := TileSource(img, 7, tc.size, 5, blur.EdgeReflect, true)
+        var got []tileRect
+        for tile := next(); tile != nil; tile = next() {
+            got = append(got, tileRect{tile.TileID, tile.X, tile.Y, tile.Width, tile.Height})
+            if tile.ImageID != 7 || tile.Padding != 2 || tile.KernelSize != 5 || !tile.Dither {
+                t.Errorf("%s: tile %d has image %d, padding %d, kernel %d, dither %v; want 7, 2, 5, true",
+                    tc.name, tile.TileID, tile.ImageID, tile.Padding, tile.KernelSize, tile.Dither)
+            }
+            want := ExtractPaddedTile(img, tile.X, tile.Y, tile.Width, tile.Height, 2, blur.EdgeReflect)
+            if !reflect.DeepEqual(tile.Data, want) {
+                t.Errorf("%s: tile %d data is not its reflect-padded extraction", tc.name, tile.TileID)
+            }
+        }
+        if !reflect.DeepEqual(got, tc.want) {
+            t.Errorf("%s: tiles %v, want %v", tc.name, got, tc.want)
+        }
+        if len(got) != TileCount(tc.width, tc.height, tc.size) {
+            t.Errorf("%s: %d tiles, TileCount says %d", tc.name, len(got), TileCount(tc.width, tc.height, tc.size))
+        }
+        if next() != nil {
+            t.Errorf("%s: the source yielded a tile after reporting it was done", tc.name)
+        }
+    }
+}
+
+func TestCheckTileOverlap(t *testing.T) {
+    tests := []struct {
+        name                          string
+        tileSize, kernelSize, padding int
+        required                      int
+        seamless                      bool
+    }{
+        {"padding cut for the kernel", 256, 15, 7, 7, true},
+        {"more padding than needed", 256, 15, 10, 7, true},
+        {"kernel grew past the padding", 256, 31, 7, 15, false},
+        {"no padding", 64, 3, 0, 1, false},
+        {"1x1 kernel needs none", 64, 1, 0, 0, true},
+    }
+    for _, tc := range tests {
+        o := CheckTileOverlap(tc.tileSize, tc.kernelSize, tc.padding)
+        if o.Required != tc.required || o.Seamless != tc.seamless {
+            t.Errorf("%s: requires %dpx, seamless %v; want %dpx and %v", tc.name, o.Required, o.Seamless, tc.required, tc.seamless)
+        }
+        padded := float64(tc.tileSize + 2*tc.padding)
+        if want := padded*padded/float64(tc.tileSize*tc.tileSize) - 1; math.Abs(o.Overhead-want) > 1e-9 {
+            t.Errorf("%s: overhead %.4f, want %.4f", tc.name, o.Overhead, want)
+        }
+        lines := o.Report()
+        warned := len(lines) == 2 && strings.HasPrefix(lines[1], "WARNING")
+        if len(lines) != 2 || warned == tc.seamless {
+            t.Errorf("%s: report %q, want a summary and a warning only when tiles seam", tc.name, lines)
+        }
+    }
+}
+
+// countingSource yields n tiles of image imageID, as TileSource would, then nil
+func countingSource(imageID, n int) func() *ImageTile {
+    tileID := 0
+    return func() *ImageTile {
+        if tileID == n {
+            return nil
+        }
+        tileID++
+        return &ImageTile{ImageID: imageID, TileID: tileID - 1}
+    }
+}
+
+func TestInterleaveTiles(t *testing.T) {
+    tests := []struct {
+        name    string
+        counts  []int // tiles per image
+        perTurn int
+        want    string
+    }{
+        {"image by image", []int{3, 2}, 0, "000 11"},
+        {"one tile each", []int{3, 2, 1}, 1, "012 01 0"},
+        {"two at a time", []int{5, 2, 3}, 2, "001122 002 0"},
+        {"turn larger than any image", []int{2, 1}, 4, "001"},
+        {"empty image skipped", []int{2, 0, 2}, 1, "02 02"},
+        {"no images", nil, 2, ""},
+    }
+    for _, tc := range tests {
+        var sources []func() *ImageTile
+        for id, n := range tc.counts {
+            sources = append(sources, countingSource(id, n))
+        }
+        var order []byte
+        next := make(map[int]int)
+        InterleaveTiles(sources, tc.perTurn, func(tile *ImageTile) {
+            order = append(order, byte('0'+tile.ImageID))
+            if tile.TileID != next[tile.ImageID] {
+                t.Errorf("%s: image %d emitted tile %d, want %d", tc.name, tile.ImageID, tile.TileID, next[tile.ImageID])
+            }
+            next[tile.ImageID]++
+        })
+        if want := strings.ReplaceAll(tc.want, " ", ""); string(order) != want {
+            t.Errorf("%s: emitted images %s, want %s", tc.name, order, want)
+        }
+    }
+}
+
+func TestInterleaveTilesBoundsRuns(t *testing.T) {
+    // However the images are sized, no image emits more than perTurn tiles in a row
+    // while another image still has tiles queued
+    counts := []int{40, 3, 17, 1, 25}
+    for perTurn := 1; perTurn <= 6; perTurn++ {
+        var sources []func() *ImageTile
+        left := make(map[int]int)
+        for id, n := range counts {
+            sources = append(sources, countingSource(id, n))
+            left[id] = n
+        }
+        last, run, total := -1, 0, 0
+        InterleaveTiles(sources, perTurn, func(tile *ImageTile) {
+            total++
+            if tile.ImageID == last {
+                run++
+            } else {
+                last, run = tile.ImageID, 1
+            }
+            left[tile.ImageID]--
+            others := false
+            for id, n := range left {
+                others = others || (id != tile.ImageID && n > 0)
+            }
+            if run > perTurn && others {
+                t.Fatalf("perTurn %d: image %d emitted %d tiles in a row while others waited", perTurn, tile.ImageID, run)
+            }
+        })
+        if total != 86 {
+            t.Errorf("perTurn %d: emitted %d tiles, want all 86", perTurn, total)
+        }
+    }
+}
+
+// gridTiles cuts a width x height image into size x size tiles in tile-ID order. Each
+// pixel encodes its image position, so a tile placed in the wrong spot shows.
+func gridTiles(width, height, size int) []*ProcessedImageTile {
+    var tiles []*ProcessedImageTile
+    for y0 := 0; y0 < height; y0 += size {
+        for x0 := 0; x0 < width; x0 += size {
+            tile := &ProcessedImageTile{TileID: len(tiles), X: x0, Y: y0, Width: min(size, width-x0), Height: min(size, height-y0)}
+            for y := 0; y < tile.Height; y++ {
+                row := make([]color.RGBA, tile.Width)
+                for x := range row {
+                    row[x] = color.RGBA{uint8((x0 + x) * 20), uint8((y0 + y) * 30), uint8(tile.TileID), 255}
+                }
+                tile.Data = append(tile.Data, row)
+            }
+            tiles = append(tiles, tile)
+        }
+    }
+    return tiles
+}
+
+func TestAssembleOrderedIgnoresArrivalOrder(t *testing.T) {
+    const width, height = 10, 7
+    tiles := gridTiles(width, height, 4)
+    want := AssembleOrdered(width, height, tiles)
+    for y := 0; y < height; y++ {
+        for x := 0; x < width; x++ {
+            if c := want.RGBAAt(x, y); c.R != uint8(x*20) || c.G != uint8(y*30) || c.A != 255 {
+                t.Fatalf("in-order assembly has %v at (%d,%d)", c, x, y)
+            }
+        }
+    }
+
+    rng := rand.New(rand.NewSource(1))
+    for i := 0; i < 5; i++ {
+        shuffled := append([]*ProcessedImageTile(nil), tiles...)
+        rng.Shuffle(len(shuffled), func(a, b int) { shuffled[a], shuffled[b] = shuffled[b], shuffled[a] })
+        if got := AssembleOrdered(width, height, shuffled); !bytes.Equal(got.Pix, want.Pix) {
+            t.Fatalf("tiles in order %v assemble differently from tile-ID order", tileIDs(shuffled))
+        }
+
+        // A redelivered tile carries the same pixels, so placing it twice changes nothing
+        dup := append(shuffled, tiles[2])
+        if got := AssembleOrdered(width, height, dup); !bytes.Equal(got.Pix, want.Pix) {
+            t.Fatalf("tiles %v with tile 2 twice assemble differently", tileIDs(dup))
+        }
+
+        // A missing tile leaves its own area unwritten and nothing else
+        var partial []*ProcessedImageTile
+        for _, tile := range shuffled {
+            if tile.TileID != 4 {
+                partial = append(partial, tile)
+            }
+        }
+        got := AssembleOrdered(width, height, partial)
+        missing := image.Rect(tiles[4].X, tiles[4].Y, tiles[4].X+tiles[4].Width, tiles[4].Y+tiles[4].Height)
+        for y := 0; y < height; y++ {
+            for x := 0; x < width; x++ {
+                c, w := got.RGBAAt(x, y), want.RGBAAt(x, y)
+                if image.Pt(x, y).In(missing) {
+                    w = color.RGBA{}
+                }
+                if c != w {
+                    t.Fatalf("without tile 4, (%d,%d) is %v, want %v", x, y, c, w)
+                }
+            }
+        }
+    }
+}
+
+func tileIDs(tiles []*ProcessedImageTile) []int {
+    ids := make([]int, len(tiles))
+    for i, tile := range tiles {
+        ids[i] = tile.TileID
+    }
+    return ids
+}
@@ -0,0 +1,66 @@
+package common
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "flag"
+    "fmt"
+    "os"
+)
+
+// RedisTLS holds the flags for connecting to a TLS-only Redis, such as a managed
+// cloud instance. Register them with RedisTLSFlags before flag.Parse.
+type RedisTLS struct {
+    Enabled    *bool
+    CAFile     *string
+    CertFile   *string
+    KeyFile    *string
+    ServerName *string
+}
+
+// RedisTLSFlags registers -redis-tls, -redis-ca, -redis-cert, -redis-key and
+// -redis-server-name on the default flag set
+func RedisTLSFlags() RedisTLS {
+    return RedisTLS{
+        Enabled:    flag.Bool("redis-tls", false, "Connect to Redis over TLS"),
+        CAFile:     flag.String("redis-ca", "", "With -redis-tls, PEM CA bundle to verify the server with (default: system roots)"),
+        CertFile:   flag.String("redis-cert", "", "With -redis-tls, PEM client certificate for mutual TLS (needs -redis-key)"),
+        KeyFile:    flag.String("redis-key", "", "With -redis-tls, PEM private key for -redis-cert"),
+        ServerName: flag.String("redis-server-name", "", "With -redis-tls, name to verify the server certificate against (default: the -redis host)"),
+    }
+}
+
+// Config returns the tls.Config for the parsed flags, or nil when -redis-tls is off.
+// Certificate paths given without -redis-tls are an error rather than a silent
+// plaintext connection.
+func (t RedisTLS) Config() (*tls.Config, error) {
+    if !*t.Enabled {
+        if *t.CAFile != "" || *t.CertFile != "" || *t.KeyFile != "" || *t.ServerName != "" {
+            return nil, fmt.Errorf("-redis-ca, -redis-cert, -redis-key and -redis-server-name need -redis-tls")
+        }
+        return nil, nil
+    }
+
+    config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: *t.ServerName}
+    if *t.CAFile != "" {
+        pem, err := os.ReadFile(*t.CAFile)
+        if err != nil {
+            return nil, fmt.Errorf("read CA bundle: %w", err)
+        }
+        config.RootCAs = x509.NewCertPool()
+        if !config.RootCAs.AppendCertsFromPEM(pem) {
+            return nil, fmt.Errorf("no certificates found in %s", *t.CAFile)
+        }
+    }
+    if (*t.CertFile == "") != (*t.KeyFile == "") {
+        return nil, fmt.Errorf("-redis-cert and -redis-key must be given together")
+    }
+    if *t.CertFile != "" {
+        cert, err := tls.LoadX509KeyPair(*t.CertFile, *t.KeyFile)
+        if err != nil {
+            return nil, fmt.Errorf("load client certificate: %w", err)
+        }
+        config.Certificates = []tls.Certificate{cert}
+    }
+    return config, nil
+}
@@ -3,6 +3,8 @@ package common
 import (
     "image/color"
     "time"
+
+    "studyguide.parallel/pkg/blur"
 )
 
 const (
@@ -10,14 +12,16 @@ const (
 )
 
 type ImageTile struct {
-    ImageID int              `json:"image_id"`
-    TileID  int              `json:"tile_id"`
-    X       int              `json:"x"`
-    Y       int              `json:"y"`
-    Width   int              `json:"width"`
-    Height  int              `json:"height"`
-    Data    [][]color.RGBA   `json:"data"`
-    Padding int              `json:"padding"`
+    ImageID    int            `json:"image_id"`
+    TileID     int            `json:"tile_id"`
+    X          int            `json:"x"`
+    Y          int            `json:"y"`
+    Width      int            `json:"width"`
+    Height     int            `json:"height"`
+    Data       [][]color.RGBA `json:"data"`
+    Padding    int            `json:"padding"`
+    Dither     bool           `json:"dither,omitempty"`      // ordered dither keyed to image coordinates
+    KernelSize int            `json:"kernel_size,omitempty"` // kernel the padding was sized for (0 = the worker's -kernel)
 }
 
 type ProcessedImageTile struct {
@@ -31,19 +35,25 @@ type ProcessedImageTile struct {
 }
 
 type ImageInfo struct {
-    ID            int       `json:"id"`
-    InputPath     string    `json:"input_path"`
-    OutputPath    string    `json:"output_path"`
-    Width         int       `json:"width"`
-    Height        int       `json:"height"`
-    ExpectedTiles int       `json:"expected_tiles"`
-    LoadTime      time.Time `json:"load_time"`
-    StartTime     time.Time `json:"start_time"`
+    ID            int           `json:"id"`
+    InputPath     string        `json:"input_path"`
+    OutputPath    string        `json:"output_path"`
+    Width         int           `json:"width"`
+    Height        int           `json:"height"`
+    ExpectedTiles int           `json:"expected_tiles"`
+    TileSize      int           `json:"tile_size,omitempty"`       // tile side used for this image (0 = TILE_SIZE)
+    EdgeMode      blur.EdgeMode `json:"edge_mode,omitempty"`       // how border tiles were padded ("" = clamp)
+    Format        string        `json:"format,omitempty"`          // input format, which the output is written in ("" = png)
+    SourceModTime *time.Time    `json:"source_mod_time,omitempty"` // input's mtime for the output to carry (nil = leave it)
+    KernelSize    int           `json:"kernel_size,omitempty"`     // kernel the coordinator tiled for (0 = unrecorded)
+    LoadTime      time.Time     `json:"load_time"`
+    StartTime     time.Time     `json:"start_time"`
 }
 
 type JobMessage struct {
     Type      string     `json:"type"`
     ImageTile *ImageTile `json:"image_tile,omitempty"`
+    ImageInfo *ImageInfo `json:"image_info,omitempty"`
 }
 
 type ResultMessage struct {
@@ -61,4 +71,6 @@ type TimingData struct {
     OutputPaths     []string            `json:"output_paths"`
     ImageStartTimes map[int]time.Time  `json:"image_start_times"`
     ImageEndTimes   map[int]*time.Time `json:"image_end_times"`
+    Workers         int                 `json:"workers,omitempty"`   // workers the coordinator was configured for
+    TileSize        int                 `json:"tile_size,omitempty"` // tile side the images were split into
 }
\ No newline at end of file
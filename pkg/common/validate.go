@@ -0,0 +1,62 @@
+package common
+
+import (
+    "fmt"
+    "image"
+    _ "image/gif"
+    _ "image/jpeg"
+    _ "image/png"
+    "io"
+)
+
+// ExitNoInputs is the exit status for -fail-on-empty when there are no input images.
+// It is distinct from the 1 of log.Fatal, so orchestration can tell "nothing to do"
+// from a crash.
+const ExitNoInputs = 3
+
+// InputCheck is the outcome of a cheap header decode of one input file
+type InputCheck struct {
+    Path   string
+    Format string
+    Width  int
+    Height int
+    Err    error
+}
+
+// ValidateInputs runs image.DecodeConfig on every path. Only the header is read,
+// so this is a fast preflight for broken or unreadable files before a long batch.
+func ValidateInputs(paths []string) []InputCheck {
+    checks := make([]InputCheck, 0, len(paths))
+    for _, path := range paths {
+        check := InputCheck{Path: path}
+        file, err := OpenInput(path, DefaultDownloadTimeout)
+        if err != nil {
+            check.Err = err
+            checks = append(checks, check)
+            continue
+        }
+        cfg, format, err := image.DecodeConfig(file)
+        file.Close()
+        if err == nil && (cfg.Width <= 0 || cfg.Height <= 0) {
+            err = fmt.Errorf("invalid dimensions %dx%d", cfg.Width, cfg.Height)
+        }
+        check.Format, check.Width, check.Height, check.Err = format, cfg.Width, cfg.Height, err
+        checks = append(checks, check)
+    }
+    return checks
+}
+
+// WriteValidationReport prints one line per input and a summary, returning the number of invalid inputs
+func WriteValidationReport(w io.Writer, checks []InputCheck) int {
+    invalid := 0
+    for _, check := range checks {
+        if check.Err != nil {
+            invalid++
+            fmt.Fprintf(w, "INVALID %s: %v\n", check.Path, check.Err)
+            continue
+        }
+        fmt.Fprintf(w, "OK      %s (%s %dx%d)\n", check.Path, check.Format, check.Width, check.Height)
+    }
+    fmt.Fprintf(w, "%d of %d inputs decode cleanly, %d invalid\n", len(checks)-invalid, len(checks), invalid)
+    return invalid
+}
@@ -0,0 +1,78 @@
+package common
+
+import (
+    "bytes"
+    "image"
+    "image/jpeg"
+    "image/png"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// writeInputFiles writes files of the given contents to a temporary directory and
+// returns their paths in the order given
+func writeInputFiles(t *testing.T, files map[string][]byte, order ...string) []string {
+    t.Helper()
+    dir := t.TempDir()
+    var paths []string
+    for _, name := range order {
+        path := filepath.Join(dir, name)
+        if err := os.WriteFile(path, files[name], 0644); err != nil {
+            t.Fatal(err)
+        }
+        paths = append(paths, path)
+    }
+    return paths
+}
+
+func TestValidateInputs(t *testing.T) {
+    var pngData, jpegData bytes.Buffer
+    if err := png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 7, 5))); err != nil {
+        t.Fatal(err)
+    }
+    if err := jpeg.Encode(&jpegData, image.NewRGBA(image.Rect(0, 0, 9, 3)), nil); err != nil {
+        t.Fatal(err)
+    }
+    files := map[string][]byte{
+        "ok.png":        pngData.Bytes(),
+        "photo.jpg":     jpegData.Bytes(),
+        "truncated.png": pngData.Bytes()[:12],
+        "notes.png":     []byte("not an image"),
+    }
+    paths := writeInputFiles(t, files, "ok.png", "photo.jpg", "truncated.png", "notes.png")
+    paths = append(paths, filepath.Join(t.TempDir(), "missing.png"))
+
+    tests := []struct {
+        format        string
+        width, height int
+        ok            bool
+    }{
+        {"png", 7, 5, true},
+        {"jpeg", 9, 3, true},
+        {"png", 0, 0, false}, // the signature matches but the header is cut short
+        {"", 0, 0, false},
+        {"", 0, 0, false},
+    }
+    checks := ValidateInputs(paths)
+    if len(checks) != len(tests) {
+        t.Fatalf("%d checks for %d inputs", len(checks), len(tests))
+    }
+    for i, tc := range tests {
+        c := checks[i]
+        if c.Path != paths[i] || c.Format != tc.format || c.Width != tc.width || c.Height != tc.height || (c.Err == nil) != tc.ok {
+            t.Errorf("%s: %+v, want %s %dx%d ok %v", filepath.Base(paths[i]), c, tc.format, tc.width, tc.height, tc.ok)
+        }
+    }
+
+    var report bytes.Buffer
+    if invalid := WriteValidationReport(&report, checks); invalid != 3 {
+        t.Errorf("report counted %d invalid inputs, want 3", invalid)
+    }
+    lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+    if len(lines) != 6 || !strings.HasPrefix(lines[0], "OK      ") || !strings.HasPrefix(lines[2], "INVALID ") ||
+        lines[5] != "2 of 5 inputs decode cleanly, 3 invalid" {
+        t.Errorf("report:\n%s", report.String())
+    }
+}
@@ -0,0 +1,58 @@
+package common
+
+import (
+    "errors"
+    "fmt"
+    "image"
+    _ "image/jpeg"
+    _ "image/png"
+    "io"
+    "os"
+)
+
+// ErrInvalidOutput marks an output that was written without error but does not decode
+// back to the image that was encoded
+var ErrInvalidOutput = errors.New("output failed verification")
+
+// VerifyOutput re-opens a written output and decodes it in full, checking it is a
+// width x height image. A full decode catches truncated writes and corrupt data that
+// a header check would miss. Failures wrap ErrInvalidOutput.
+func VerifyOutput(path string, width, height int) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidOutput, err)
+    }
+    defer file.Close()
+
+    img, _, err := image.Decode(file)
+    if err != nil {
+        return fmt.Errorf("%w: %s does not decode: %v", ErrInvalidOutput, path, err)
+    }
+    if b := img.Bounds(); b.Dx() != width || b.Dy() != height {
+        return fmt.Errorf("%w: %s is %dx%d, want %dx%d", ErrInvalidOutput, path, b.Dx(), b.Dy(), width, height)
+    }
+    return nil
+}
+
+// WriteVerified creates path and fills it with write. When verify is non-nil the
+// closed file is then checked with it and written again, up to retries more times,
+// while the check fails. An output that never passes is removed and the last check's
+// error returned; write errors are returned at once, without a retry.
+func WriteVerified(path string, write func(io.Writer) error, verify func(path string) error, retries int) error {
+    for attempt := 0; ; attempt++ {
+        if err := writeFile(path, write); err != nil {
+            return err
+        }
+        if verify == nil {
+            return nil
+        }
+        err := verify(path)
+        if err == nil {
+            return nil
+        }
+        if attempt >= retries {
+            os.Remove(path)
+            return err
+        }
+    }
+}
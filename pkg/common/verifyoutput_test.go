@@ -0,0 +1,86 @@
+package common
+
+import (
+    "bytes"
+    "errors"
+    "image"
+    "image/png"
+    "io"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// encodedPNG returns a width x height PNG
+func encodedPNG(t *testing.T, width, height int) []byte {
+    t.Helper()
+    img := image.NewRGBA(image.Rect(0, 0, width, height))
+    for i := range img.Pix {
+        img.Pix[i] = uint8(i * 7)
+    }
+    var buf bytes.Buffer
+    if err := png.Encode(&buf, img); err != nil {
+        t.Fatal(err)
+    }
+    return buf.Bytes()
+}
+
+func TestVerifyOutput(t *testing.T) {
+    dir := t.TempDir()
+    data := encodedPNG(t, 20, 10)
+    tests := []struct {
+        name string
+        data []byte
+        ok   bool
+    }{
+        {"whole", data, true},
+        {"truncated", data[:len(data)/2], false},
+        {"header only", data[:33], false},
+        {"empty", nil, false},
+        {"wrong size", encodedPNG(t, 10, 20), false},
+    }
+    for _, tc := range tests {
+        path := filepath.Join(dir, tc.name+".png")
+        if err := os.WriteFile(path, tc.data, 0644); err != nil {
+            t.Fatal(err)
+        }
+        err := VerifyOutput(path, 20, 10)
+        if (err == nil) != tc.ok || (err != nil && !errors.Is(err, ErrInvalidOutput)) {
+            t.Errorf("%s: VerifyOutput = %v, want ok %v or an ErrInvalidOutput", tc.name, err, tc.ok)
+        }
+    }
+    if err := VerifyOutput(filepath.Join(dir, "missing.png"), 20, 10); !errors.Is(err, ErrInvalidOutput) {
+        t.Errorf("a missing output gave %v, want ErrInvalidOutput", err)
+    }
+}
+
+func TestWriteVerifiedRetriesTruncatedWrite(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "out.png")
+    data := encodedPNG(t, 20, 10)
+    verify := func(path string) error { return VerifyOutput(path, 20, 10) }
+
+    // The first write is cut short, as on flaky storage; the retry writes it whole
+    writes := 0
+    write := func(w io.Writer) error {
+        writes++
+        if writes == 1 {
+            _, err := w.Write(data[:len(data)-20])
+            return err
+        }
+        _, err := w.Write(data)
+        return err
+    }
+    if err := WriteVerified(path, write, verify, 1); err != nil || writes != 2 {
+        t.Errorf("WriteVerified = %v after %d writes, want success on the retry", err, writes)
+    }
+
+    // Without retries the truncated output fails its image and is removed
+    writes = 0
+    err := WriteVerified(path, write, verify, 0)
+    if !errors.Is(err, ErrInvalidOutput) || writes != 1 {
+        t.Errorf("WriteVerified without retries = %v after %d writes, want ErrInvalidOutput after 1", err, writes)
+    }
+    if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+        t.Error("the output that failed verification was left behind")
+    }
+}
@@ -0,0 +1,255 @@
+package common
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "fmt"
+    "log"
+    "os"
+    "sync"
+    "time"
+
+    "studyguide.parallel/pkg/blur"
+)
+
+// Queue is the part of a job queue a tile worker needs. The list-based queue in e,
+// the streams queue in f and the streams client in g all satisfy it, so they can share
+// one worker loop. Queues without acknowledgements return an empty ID and a no-op AckJob.
+type Queue interface {
+    ReadJob(consumer string, block time.Duration) (string, *JobMessage, error)
+    AckJob(id string) error
+    AddResult(res *ResultMessage) (string, error)
+}
+
+// BatchQueue is a Queue that can also move several jobs per round-trip, for
+// RunBatchWorker. ReadJobs returns the jobs it could decode along with an error for
+// any it could not; those stay pending for redelivery. AddResultsAndAck must ack the
+// jobs only if every result was added.
+type BatchQueue interface {
+    Queue
+    ReadJobs(consumer string, count int, block time.Duration) ([]string, []*JobMessage, error)
+    AddResultsAndAck(results []*ResultMessage, ids []string) error
+}
+
+// UniqueConsumerName builds a stream consumer name from the prefix, hostname, PID and
+// a random suffix. Containers can share a hostname (one pod, or "localhost"), and two
+// workers with the same consumer name share pending entries and reclaim each other's
+// in-flight jobs, so the hostname alone is not enough.
+func UniqueConsumerName(prefix string) string {
+    hostname, _ := os.Hostname()
+    suffix := make([]byte, 4)
+    if _, err := rand.Read(suffix); err != nil {
+        // The PID still separates processes on one host
+        return fmt.Sprintf("%s-%s-%d", prefix, hostname, os.Getpid())
+    }
+    return fmt.Sprintf("%s-%s-%d-%s", prefix, hostname, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// ProcessTile blurs a padded tile and strips the padding. A malformed tile from a
+// corrupt payload (a negative size, say) can panic the blur; that panic is
+// returned as an error instead, so one bad job cannot take the worker down.
+func ProcessTile(tile *ImageTile, kernel [][]float64) (processed *ProcessedImageTile, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            processed, err = nil, fmt.Errorf("tile %d of image %d: blur panicked: %v", tile.TileID, tile.ImageID, r)
+        }
+    }()
+
+    // data[0][0] sits Padding pixels up and left of the tile origin in image coordinates
+    opts := blur.Options{Dither: tile.Dither}
+    blurred := blur.ApplyBlurToTileWithOptions(tile.Data, kernel, tile.X-tile.Padding, tile.Y-tile.Padding, opts)
+    center := blur.ExtractCenter(blurred, tile.Padding, tile.Width, tile.Height)
+
+    return &ProcessedImageTile{
+        ImageID: tile.ImageID,
+        TileID:  tile.TileID,
+        X:       tile.X,
+        Y:       tile.Y,
+        Width:   tile.Width,
+        Height:  tile.Height,
+        Data:    center,
+    }, nil
+}
+
+// RunWorker pulls tile jobs from q, blurs them and pushes the results until ctx is
+// cancelled or a "complete" job arrives. A job is acked only once its result has been
+// added, so a failed push is left for redelivery. onResult, if non-nil, runs after each
+// tile is acked. Tiles that carry the coordinator's kernel size are blurred with that
+// kernel, generated once per size, so a worker started with a different -kernel still
+// matches the tiles' padding; kernelSize covers tiles without one. Returns the number
+// of tiles processed.
+func RunWorker(ctx context.Context, q Queue, kernelSize int, consumer string, block time.Duration, onResult func(*ProcessedImageTile)) int {
+    kernels := map[int][][]float64{kernelSize: blur.GenerateGaussianKernel(kernelSize)}
+    tilesProcessed := 0
+
+    for {
+        select {
+        case <-ctx.Done():
+            return tilesProcessed
+        default:
+        }
+
+        id, job, err := q.ReadJob(consumer, block)
+        if err != nil {
+            log.Printf("%s: read job: %v", consumer, err)
+            continue
+        }
+        if job == nil {
+            continue
+        }
+
+        if job.Type == "complete" {
+            log.Printf("%s: received completion signal", consumer)
+            return tilesProcessed
+        }
+
+        if job.Type != "tile" || job.ImageTile == nil {
+            log.Printf("%s: invalid job type or missing tile data", consumer)
+            _ = q.AckJob(id)
+            continue
+        }
+
+        kernel := tileKernel(kernels, job.ImageTile, kernelSize, consumer)
+
+        start := time.Now()
+        processed, err := ProcessTile(job.ImageTile, kernel)
+        if err != nil {
+            // Left unacked: the f and g streams queues redeliver it and dead-letter it
+            // after queue.MaxDeliveries, while e's list queue has already popped it,
+            // so there the tile is lost and its image never completes
+            log.Printf("%s: %v", consumer, err)
+            continue
+        }
+        result := &ResultMessage{
+            ProcessedTile: processed,
+            WorkerID:      consumer,
+            ProcessTime:   time.Since(start).Seconds(),
+        }
+
+        if _, err := q.AddResult(result); err != nil {
+            log.Printf("%s: push result: %v", consumer, err)
+            continue
+        }
+        if err := q.AckJob(id); err != nil {
+            log.Printf("%s: ack job: %v", consumer, err)
+        }
+
+        tilesProcessed++
+        if onResult != nil {
+            onResult(processed)
+        }
+    }
+}
+
+// tileKernel returns the kernel for tile's kernel size, or kernelSize when the tile
+// has none, generating and caching it on first use
+func tileKernel(kernels map[int][][]float64, tile *ImageTile, kernelSize int, consumer string) [][]float64 {
+    size := tile.KernelSize
+    if size <= 0 {
+        size = kernelSize
+    }
+    kernel, ok := kernels[size]
+    if !ok {
+        log.Printf("%s: tiles were cut for kernel %d, not -kernel %d; blurring them with %d", consumer, size, kernelSize, size)
+        kernel = blur.GenerateGaussianKernel(size)
+        kernels[size] = kernel
+    }
+    return kernel
+}
+
+// RunBatchWorker is RunWorker reading up to batchSize jobs at a time. The batch's
+// tiles are blurred on up to concurrency goroutines, then every result is added and
+// every job acked in one round-trip, so a worker makes two Redis round-trips per batch
+// instead of three per tile. Tiles that fail to blur are left out of the batch and
+// stay unacked for redelivery; if the results cannot be added, none of the batch is
+// acked. A "complete" job ends the worker after the rest of its batch.
+func RunBatchWorker(ctx context.Context, q BatchQueue, kernelSize int, consumer string, block time.Duration, batchSize, concurrency int, onResult func(*ProcessedImageTile)) int {
+    kernels := map[int][][]float64{kernelSize: blur.GenerateGaussianKernel(kernelSize)}
+    concurrency = max(concurrency, 1)
+    tilesProcessed := 0
+
+    for {
+        select {
+        case <-ctx.Done():
+            return tilesProcessed
+        default:
+        }
+
+        ids, jobs, err := q.ReadJobs(consumer, batchSize, block)
+        if err != nil {
+            log.Printf("%s: read jobs: %v", consumer, err)
+        }
+        if len(jobs) == 0 {
+            continue
+        }
+
+        complete := false
+        var tileIDs []string
+        var tiles []*ImageTile
+        var tileKernels [][][]float64
+        for i, job := range jobs {
+            switch {
+            case job.Type == "complete":
+                complete = true
+            case job.Type != "tile" || job.ImageTile == nil:
+                log.Printf("%s: invalid job type or missing tile data", consumer)
+                _ = q.AckJob(ids[i])
+            default:
+                tileIDs = append(tileIDs, ids[i])
+                tiles = append(tiles, job.ImageTile)
+                tileKernels = append(tileKernels, tileKernel(kernels, job.ImageTile, kernelSize, consumer))
+            }
+        }
+
+        results := make([]*ResultMessage, len(tiles))
+        sem := make(chan struct{}, concurrency)
+        var wg sync.WaitGroup
+        for i, tile := range tiles {
+            wg.Add(1)
+            sem <- struct{}{}
+            go func(i int, tile *ImageTile) {
+                defer func() { <-sem; wg.Done() }()
+                start := time.Now()
+                processed, err := ProcessTile(tile, tileKernels[i])
+                if err != nil {
+                    log.Printf("%s: %v", consumer, err)
+                    return
+                }
+                results[i] = &ResultMessage{
+                    ProcessedTile: processed,
+                    WorkerID:      consumer,
+                    ProcessTime:   time.Since(start).Seconds(),
+                }
+            }(i, tile)
+        }
+        wg.Wait()
+
+        // Only the tiles that blurred are published and acked
+        var done []*ResultMessage
+        var doneIDs []string
+        for i, result := range results {
+            if result != nil {
+                done = append(done, result)
+                doneIDs = append(doneIDs, tileIDs[i])
+            }
+        }
+        if len(done) > 0 {
+            if err := q.AddResultsAndAck(done, doneIDs); err != nil {
+                log.Printf("%s: push %d results: %v", consumer, len(done), err)
+            } else {
+                tilesProcessed += len(done)
+                if onResult != nil {
+                    for _, result := range done {
+                        onResult(result.ProcessedTile)
+                    }
+                }
+            }
+        }
+
+        if complete {
+            log.Printf("%s: received completion signal", consumer)
+            return tilesProcessed
+        }
+    }
+}
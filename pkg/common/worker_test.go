@@ -0,0 +1,276 @@
+package common
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "image/color"
+    "os"
+    "reflect"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "studyguide.parallel/pkg/blur"
+)
+
+// fakeQueue hands out scripted jobs in order and records every result and ack as an
+// event, so tests can check what a worker published and acked, and in which order
+type fakeQueue struct {
+    mu      sync.Mutex
+    ids     []string
+    jobs    []*JobMessage
+    failAdd map[int]bool // tile IDs whose results fail to publish
+    events  []string
+    results []*ResultMessage
+}
+
+var _ Queue = (*fakeQueue)(nil)
+
+func (q *fakeQueue) push(id string, job *JobMessage) {
+    q.ids = append(q.ids, id)
+    q.jobs = append(q.jobs, job)
+}
+
+func (q *fakeQueue) ReadJob(consumer string, block time.Duration) (string, *JobMessage, error) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if len(q.jobs) == 0 {
+        return "", nil, nil
+    }
+    id, job := q.ids[0], q.jobs[0]
+    q.ids, q.jobs = q.ids[1:], q.jobs[1:]
+    q.events = append(q.events, "read "+id)
+    return id, job, nil
+}
+
+func (q *fakeQueue) AckJob(id string) error {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    q.events = append(q.events, "ack "+id)
+    return nil
+}
+
+func (q *fakeQueue) AddResult(res *ResultMessage) (string, error) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if q.failAdd[res.ProcessedTile.TileID] {
+        q.events = append(q.events, fmt.Sprintf("add tile %d failed", res.ProcessedTile.TileID))
+        return "", errors.New("results stream unavailable")
+    }
+    q.events = append(q.events, fmt.Sprintf("add tile %d", res.ProcessedTile.TileID))
+    q.results = append(q.results, res)
+    return fmt.Sprintf("r-%d", len(q.results)), nil
+}
+
+// paddedTile returns a width x height tile job at (x, y) with padding on every side,
+// filled with a colour derived from the tile ID
+func paddedTile(tileID, x, y, width, height, padding int) *JobMessage {
+    data := make([][]color.RGBA, height+2*padding)
+    for row := range data {
+        data[row] = make([]color.RGBA, width+2*padding)
+        for col := range data[row] {
+            data[row][col] = color.RGBA{uint8(40 * tileID), uint8(row), uint8(col), 255}
+        }
+    }
+    return &JobMessage{Type: "tile", ImageTile: &ImageTile{
+        TileID: tileID, X: x, Y: y, Width: width, Height: height, Data: data, Padding: padding,
+    }}
+}
+
+// corruptTile returns a tile job whose header claims a negative width, which panics
+// cutting the blurred centre out
+func corruptTile(tileID int) *JobMessage {
+    job := paddedTile(tileID, 0, 0, 4, 4, 1)
+    job.ImageTile.Width = -1
+    return job
+}
+
+func TestRunWorkerAcksAfterPublish(t *testing.T) {
+    q := &fakeQueue{failAdd: map[int]bool{3: true}}
+    q.push("1-0", paddedTile(1, 0, 0, 4, 4, 1))
+    q.push("2-0", corruptTile(2))
+    q.push("3-0", paddedTile(3, 4, 0, 4, 4, 1))
+    q.push("4-0", paddedTile(4, 0, 4, 4, 4, 1))
+    q.push("5-0", &JobMessage{Type: "complete"})
+    q.push("6-0", paddedTile(6, 4, 4, 4, 4, 1))
+
+    var seen []int
+    processed := RunWorker(context.Background(), q, 3, "test", time.Millisecond, func(tile *ProcessedImageTile) {
+        seen = append(seen, tile.TileID)
+    })
+
+    // A tile whose blur fails or whose result can't be added stays unacked for
+    // redelivery, and nothing past "complete" is read
+    want := []string{
+        "read 1-0", "add tile 1", "ack 1-0",
+        "read 2-0",
+        "read 3-0", "add tile 3 failed",
+        "read 4-0", "add tile 4", "ack 4-0",
+        "read 5-0",
+    }
+    if !reflect.DeepEqual(q.events, want) {
+        t.Errorf("events:\n%v\nwant:\n%v", q.events, want)
+    }
+    if processed != 2 || !reflect.DeepEqual(seen, []int{1, 4}) {
+        t.Errorf("processed %d tiles, onResult saw %v; want 2 and [1 4]", processed, seen)
+    }
+    for _, res := range q.results {
+        tile := res.ProcessedTile
+        if res.WorkerID != "test" || len(tile.Data) != 4 || len(tile.Data[0]) != 4 {
+            t.Errorf("tile %d: worker %q, %dx%d data; want worker test and the 4x4 centre",
+                tile.TileID, res.WorkerID, len(tile.Data[0]), len(tile.Data))
+        }
+    }
+}
+
+func TestRunWorkerStopsOnCancel(t *testing.T) {
+    q := &fakeQueue{}
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan int)
+    go func() { done <- RunWorker(ctx, q, 3, "test", time.Millisecond, nil) }()
+    cancel()
+    select {
+    case processed := <-done:
+        if processed != 0 {
+            t.Errorf("processed %d tiles from an empty queue", processed)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("RunWorker kept polling after its context was cancelled")
+    }
+}
+
+// fakeBatchQueue is a fakeQueue that also reads and publishes in batches
+type fakeBatchQueue struct {
+    fakeQueue
+}
+
+var _ BatchQueue = (*fakeBatchQueue)(nil)
+
+func (q *fakeBatchQueue) ReadJobs(consumer string, count int, block time.Duration) ([]string, []*JobMessage, error) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    n := min(count, len(q.jobs))
+    ids, jobs := q.ids[:n], q.jobs[:n]
+    q.ids, q.jobs = q.ids[n:], q.jobs[n:]
+    if n > 0 {
+        q.events = append(q.events, fmt.Sprintf("read %v", ids))
+    }
+    return ids, jobs, nil
+}
+
+func (q *fakeBatchQueue) AddResultsAndAck(results []*ResultMessage, ids []string) error {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    var tiles []int
+    for _, res := range results {
+        tiles = append(tiles, res.ProcessedTile.TileID)
+    }
+    q.events = append(q.events, fmt.Sprintf("add tiles %v, ack %v", tiles, ids))
+    q.results = append(q.results, results...)
+    return nil
+}
+
+func TestProcessTileRecoversPanic(t *testing.T) {
+    job := corruptTile(2)
+    job.ImageTile.ImageID = 7
+    processed, err := ProcessTile(job.ImageTile, [][]float64{{0, 0, 0}, {0, 1, 0}, {0, 0, 0}})
+    if err == nil || processed != nil {
+        t.Fatalf("ProcessTile on a corrupt tile = %v, %v; want the panic as an error", processed, err)
+    }
+    if want := "tile 2 of image 7: blur panicked"; !strings.Contains(err.Error(), want) {
+        t.Errorf("error %q does not name the tile (%q)", err, want)
+    }
+}
+
+func TestRunBatchWorkerSurvivesPanickingTile(t *testing.T) {
+    // The corrupt tile panics the blur mid-batch; the worker leaves it unacked,
+    // publishes the rest of the batch and goes on to the next one
+    q := &fakeBatchQueue{}
+    q.push("1-0", paddedTile(1, 0, 0, 4, 4, 1))
+    q.push("2-0", corruptTile(2))
+    q.push("3-0", paddedTile(3, 4, 0, 4, 4, 1))
+    q.push("4-0", paddedTile(4, 0, 4, 4, 4, 1))
+    q.push("5-0", &JobMessage{Type: "complete"})
+
+    processed := RunBatchWorker(context.Background(), q, 3, "test", time.Millisecond, 3, 2, nil)
+    want := []string{
+        "read [1-0 2-0 3-0]", "add tiles [1 3], ack [1-0 3-0]",
+        "read [4-0 5-0]", "add tiles [4], ack [4-0]",
+    }
+    if !reflect.DeepEqual(q.events, want) {
+        t.Errorf("events:\n%v\nwant:\n%v", q.events, want)
+    }
+    if processed != 3 {
+        t.Errorf("processed %d tiles, want 3", processed)
+    }
+}
+
+func TestRunWorkerUsesTileKernel(t *testing.T) {
+    // Tile 1 was cut for kernel 9 and tile 2 carries no size, so a worker started
+    // with -kernel 3 blurs tile 1 with 9 and tile 2 with its own 3
+    cut := paddedTile(1, 0, 0, 4, 4, 4)
+    cut.ImageTile.KernelSize = 9
+    plain := paddedTile(2, 0, 0, 4, 4, 1)
+    for _, job := range []*JobMessage{cut, plain} {
+        for y, row := range job.ImageTile.Data {
+            for x := range row {
+                row[x] = color.RGBA{uint8(x * 37 % 251), uint8(y * 53 % 241), uint8((x + y) * 29), 255}
+            }
+        }
+    }
+    q := &fakeQueue{}
+    q.push("1-0", cut)
+    q.push("2-0", plain)
+    q.push("3-0", &JobMessage{Type: "complete"})
+    RunWorker(context.Background(), q, 3, "test", time.Millisecond, nil)
+    if len(q.results) != 2 {
+        t.Fatalf("%d results, want 2", len(q.results))
+    }
+
+    for i, tc := range []struct {
+        tile   *ImageTile
+        kernel int
+    }{{cut.ImageTile, 9}, {plain.ImageTile, 3}} {
+        want, err := ProcessTile(tc.tile, blur.GenerateGaussianKernel(tc.kernel))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !reflect.DeepEqual(q.results[i].ProcessedTile.Data, want.Data) {
+            t.Errorf("tile %d was not blurred with kernel %d", tc.tile.TileID, tc.kernel)
+        }
+    }
+    if other, _ := ProcessTile(cut.ImageTile, blur.GenerateGaussianKernel(3)); reflect.DeepEqual(other.Data, q.results[0].ProcessedTile.Data) {
+        t.Error("kernels 3 and 9 give the same tile; the check above proves nothing")
+    }
+}
+
+func TestTileKernelCachesEachSize(t *testing.T) {
+    kernels := map[int][][]float64{3: blur.GenerateGaussianKernel(3)}
+    first := tileKernel(kernels, &ImageTile{KernelSize: 7}, 3, "test")
+    if len(first) != 7 || len(kernels) != 2 {
+        t.Fatalf("got a %d-wide kernel with %d cached, want 7 wide and 2 cached", len(first), len(kernels))
+    }
+    if again := tileKernel(kernels, &ImageTile{KernelSize: 7}, 3, "test"); &again[0] != &first[0] {
+        t.Error("the kernel for size 7 was generated again instead of reused")
+    }
+    if fallback := tileKernel(kernels, &ImageTile{}, 3, "test"); len(fallback) != 3 {
+        t.Errorf("a tile without a kernel size got a %d-wide kernel, want the worker's 3", len(fallback))
+    }
+}
+
+func TestUniqueConsumerName(t *testing.T) {
+    // Two workers in one process, as on one host, still get names of their own
+    first, second := UniqueConsumerName("worker"), UniqueConsumerName("worker")
+    if first == second {
+        t.Errorf("two calls both returned %q", first)
+    }
+    hostname, _ := os.Hostname()
+    prefix := fmt.Sprintf("worker-%s-%d-", hostname, os.Getpid())
+    for _, name := range []string{first, second} {
+        if !strings.HasPrefix(name, prefix) {
+            t.Errorf("%q does not start with the prefix, hostname and PID (%q)", name, prefix)
+        }
+    }
+}
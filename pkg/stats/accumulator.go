@@ -0,0 +1,71 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// ImageFailure records an input that could not be processed and why
+type ImageFailure struct {
+	Path string
+	Err  error
+}
+
+// StatsAccumulator gathers per-image results from many goroutines into one
+// PerformanceData. All methods are safe for concurrent use.
+type StatsAccumulator struct {
+	mu            sync.Mutex
+	start         time.Time
+	images        int
+	totalBlurTime float64
+	failures      []ImageFailure
+}
+
+// NewStatsAccumulator starts an accumulator; the run's total time is measured from now
+func NewStatsAccumulator() *StatsAccumulator {
+	return &StatsAccumulator{start: time.Now()}
+}
+
+// AddImage counts one successfully processed image and its blur time in seconds
+func (a *StatsAccumulator) AddImage(blurTime float64) {
+	a.mu.Lock()
+	a.images++
+	a.totalBlurTime += blurTime
+	a.mu.Unlock()
+}
+
+// AddFailure records an input that failed
+func (a *StatsAccumulator) AddFailure(path string, err error) {
+	a.mu.Lock()
+	a.failures = append(a.failures, ImageFailure{Path: path, Err: err})
+	a.mu.Unlock()
+}
+
+// Failures returns a copy of the failures recorded so far, in the order they were added
+func (a *StatsAccumulator) Failures() []ImageFailure {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]ImageFailure(nil), a.failures...)
+}
+
+// Build snapshots the totals as PerformanceData. TotalTime runs from
+// NewStatsAccumulator to this call; AverageTime is zero when no image succeeded.
+func (a *StatsAccumulator) Build(algorithm string, kernel int) PerformanceData {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	totalTime := time.Since(a.start).Seconds()
+	totalBlurTime := a.totalBlurTime
+	data := PerformanceData{
+		AlgorithmName:   algorithm,
+		ImagesProcessed: a.images,
+		KernelSize:      kernel,
+		TotalTime:       totalTime,
+		Timestamp:       a.start,
+		TotalBlurTime:   &totalBlurTime,
+	}
+	if a.images > 0 {
+		data.AverageTime = totalTime / float64(a.images)
+	}
+	return data
+}
@@ -0,0 +1,51 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestStatsAccumulatorConcurrent(t *testing.T) {
+	// Run with -race: every goroutine adds images and failures at once
+	const goroutines, perGoroutine = 32, 250
+	acc := NewStatsAccumulator()
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				acc.AddImage(0.25)
+			}
+			acc.AddFailure(fmt.Sprintf("img%d.png", g), errors.New("decode failed"))
+		}(g)
+	}
+	wg.Wait()
+
+	data := acc.Build("Concurrent", 15)
+	if want := goroutines * perGoroutine; data.ImagesProcessed != want {
+		t.Errorf("ImagesProcessed = %d, want %d", data.ImagesProcessed, want)
+	}
+	// Quarter seconds add up exactly in floating point
+	if want := 0.25 * goroutines * perGoroutine; data.TotalBlurTime == nil || *data.TotalBlurTime != want {
+		t.Errorf("TotalBlurTime = %v, want %g", data.TotalBlurTime, want)
+	}
+	if data.AlgorithmName != "Concurrent" || data.KernelSize != 15 {
+		t.Errorf("Build labelled the run %q with kernel %d", data.AlgorithmName, data.KernelSize)
+	}
+	if data.AverageTime != data.TotalTime/float64(data.ImagesProcessed) {
+		t.Errorf("AverageTime = %g, want TotalTime / images", data.AverageTime)
+	}
+	if failures := acc.Failures(); len(failures) != goroutines {
+		t.Errorf("%d failures recorded, want %d", len(failures), goroutines)
+	}
+}
+
+func TestStatsAccumulatorEmpty(t *testing.T) {
+	data := NewStatsAccumulator().Build("Empty", 3)
+	if data.ImagesProcessed != 0 || data.AverageTime != 0 {
+		t.Errorf("empty accumulator built %d images at %g s each, want 0 and 0", data.ImagesProcessed, data.AverageTime)
+	}
+}
@@ -0,0 +1,64 @@
+package stats
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// csvHeader names the columns AppendCSV writes
+var csvHeader = []string{
+	"timestamp", "algorithm", "images", "kernel", "total_seconds", "average_seconds",
+	"blur_seconds", "peak_heap_bytes", "inputs", "outputs",
+}
+
+// AppendCSV appends one row for result to a cumulative CSV file, writing the header
+// first when the file is new. Tools invoked once per frame or per file use it to
+// build a single record across many runs.
+func AppendCSV(path string, result PerformanceData) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(file)
+	if info.Size() == 0 {
+		if err := w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+
+	blurSeconds := ""
+	if result.TotalBlurTime != nil {
+		blurSeconds = fmt.Sprintf("%.4f", *result.TotalBlurTime)
+	}
+	timestamp := result.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	row := []string{
+		timestamp.Format(time.RFC3339),
+		result.AlgorithmName,
+		fmt.Sprint(result.ImagesProcessed),
+		fmt.Sprint(result.KernelSize),
+		fmt.Sprintf("%.4f", result.TotalTime),
+		fmt.Sprintf("%.4f", result.AverageTime),
+		blurSeconds,
+		fmt.Sprint(result.PeakHeapBytes),
+		strings.Join(result.InputPaths, ";"),
+		strings.Join(result.OutputPaths, ";"),
+	}
+	if err := w.Write(row); err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
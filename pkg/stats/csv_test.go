@@ -0,0 +1,43 @@
+package stats
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAppendCSVWritesHeaderOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "runs.csv")
+	first := sampleResult("Sequential", 2, 1)
+	second := sampleResult("Distributed", 1.5, 4)
+	second.ImagesProcessed, second.InputPaths, second.OutputPaths = 1, []string{"frame1.png"}, []string{"frame1_blurred.png"}
+	second.TotalBlurTime = nil
+	for _, result := range []PerformanceData{first, second} {
+		if err := AppendCSV(path, result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || !reflect.DeepEqual(rows[0], csvHeader) {
+		t.Fatalf("%d rows starting %v, want the header then one row per run", len(rows), rows[0])
+	}
+	want := []string{"2024-03-01T12:00:00Z", "Sequential", "2", "15", "2.0000", "1.0000", "1.0000", "3145728",
+		"in/cat.png;in/dog.png", "out/cat_blurred.png;out/dog_blurred.png"}
+	if !reflect.DeepEqual(rows[1], want) {
+		t.Errorf("first run's row = %v, want %v", rows[1], want)
+	}
+	if rows[2][1] != "Distributed" || rows[2][2] != "1" || rows[2][6] != "" || rows[2][8] != "frame1.png" {
+		t.Errorf("single-image run's row = %v", rows[2])
+	}
+}
@@ -0,0 +1,90 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ManifestFile is the name of the output index written next to a batch's outputs
+const ManifestFile = "outputs.json"
+
+// ImageTiming holds the dimensions and processing time of one image in a batch.
+// DecodeSeconds and BlurSeconds split out the read-and-decode and blur stages of
+// Seconds where the processor measures them separately (0 otherwise).
+type ImageTiming struct {
+	Width   int
+	Height  int
+	Seconds float64
+
+	DecodeSeconds float64
+	BlurSeconds   float64
+}
+
+// ManifestEntry maps one input to the output produced from it
+type ManifestEntry struct {
+	Input   string  `json:"input"`
+	Output  string  `json:"output"`
+	Width   int     `json:"width"`
+	Height  int     `json:"height"`
+	Kernel  int     `json:"kernel"`
+	Seconds float64 `json:"seconds"`
+
+	DecodeSeconds float64 `json:"decode_seconds,omitempty"`
+	BlurSeconds   float64 `json:"blur_seconds,omitempty"`
+}
+
+// Manifest is the machine-readable index of a batch, so downstream systems can
+// ingest results without scanning the output directory
+type Manifest struct {
+	Algorithm string          `json:"algorithm"`
+	Outputs   []ManifestEntry `json:"outputs"`
+}
+
+// BuildManifest pairs each input path with its output path and per-image timing.
+// The three slices in result must line up one entry per processed image.
+func BuildManifest(result PerformanceData) (Manifest, error) {
+	if len(result.OutputPaths) != len(result.InputPaths) {
+		return Manifest{}, fmt.Errorf("%d inputs but %d outputs", len(result.InputPaths), len(result.OutputPaths))
+	}
+	if len(result.ImageTimings) != len(result.InputPaths) {
+		return Manifest{}, fmt.Errorf("%d inputs but %d image timings", len(result.InputPaths), len(result.ImageTimings))
+	}
+
+	manifest := Manifest{Algorithm: result.AlgorithmName, Outputs: []ManifestEntry{}}
+	for i, input := range result.InputPaths {
+		timing := result.ImageTimings[i]
+		manifest.Outputs = append(manifest.Outputs, ManifestEntry{
+			Input:   input,
+			Output:  result.OutputPaths[i],
+			Width:   timing.Width,
+			Height:  timing.Height,
+			Kernel:  result.KernelSize,
+			Seconds: timing.Seconds,
+
+			DecodeSeconds: timing.DecodeSeconds,
+			BlurSeconds:   timing.BlurSeconds,
+		})
+	}
+	return manifest, nil
+}
+
+// WriteManifest writes the batch manifest as outputs.json in outputDir and returns its path
+func WriteManifest(outputDir string, result PerformanceData) (string, error) {
+	manifest, err := BuildManifest(result)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(outputDir, ManifestFile)
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,78 @@
+package stats
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteManifest(t *testing.T) {
+	result := sampleResult("Sequential", 3, 1)
+	result.ImageTimings = []ImageTiming{
+		{Width: 640, Height: 480, Seconds: 1.25, DecodeSeconds: 0.25, BlurSeconds: 1},
+		{Width: 32, Height: 16, Seconds: 0.5},
+	}
+	dir := t.TempDir()
+	path, err := WriteManifest(dir, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, "outputs.json") {
+		t.Errorf("manifest written to %s, want outputs.json in the output directory", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Downstream readers depend on the field names, so decode generically
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"algorithm": "Sequential",
+		"outputs": []any{
+			map[string]any{
+				"input": "in/cat.png", "output": "out/cat_blurred.png", "width": 640.0, "height": 480.0,
+				"kernel": 15.0, "seconds": 1.25, "decode_seconds": 0.25, "blur_seconds": 1.0,
+			},
+			// Stage times the processor did not measure are left out
+			map[string]any{
+				"input": "in/dog.png", "output": "out/dog_blurred.png", "width": 32.0, "height": 16.0,
+				"kernel": 15.0, "seconds": 0.5,
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("manifest:\n%s\nwant:\n%v", data, want)
+	}
+}
+
+func TestBuildManifestMismatch(t *testing.T) {
+	result := sampleResult("Sequential", 3, 1)
+	result.ImageTimings = []ImageTiming{{Seconds: 1}}
+	if _, err := BuildManifest(result); err == nil {
+		t.Error("2 inputs with 1 image timing built a manifest")
+	}
+	result.ImageTimings = []ImageTiming{{Seconds: 1}, {Seconds: 2}}
+	result.OutputPaths = result.OutputPaths[:1]
+	if _, err := BuildManifest(result); err == nil {
+		t.Error("2 inputs with 1 output built a manifest")
+	}
+
+	// An empty batch lists no outputs rather than null
+	manifest, err := BuildManifest(PerformanceData{AlgorithmName: "Sequential"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"algorithm":"Sequential","outputs":[]}` {
+		t.Errorf("empty manifest is %s", data)
+	}
+}
@@ -0,0 +1,60 @@
+package stats
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// DefaultHeapSampleInterval is how often a HeapSampler reads runtime.MemStats
+const DefaultHeapSampleInterval = 100 * time.Millisecond
+
+// HeapSampler polls runtime.MemStats.HeapAlloc in the background and keeps the peak,
+// giving a batch's memory high-water mark for capacity planning
+type HeapSampler struct {
+	mu   sync.Mutex
+	peak uint64
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartHeapSampler begins sampling every interval until Stop is called
+func StartHeapSampler(interval time.Duration) *HeapSampler {
+	s := &HeapSampler{stop: make(chan struct{}), done: make(chan struct{})}
+	s.sample()
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.sample()
+			}
+		}
+	}()
+	return s
+}
+
+func (s *HeapSampler) sample() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	s.mu.Lock()
+	if m.HeapAlloc > s.peak {
+		s.peak = m.HeapAlloc
+	}
+	s.mu.Unlock()
+}
+
+// Stop takes a final sample, ends sampling and returns the peak HeapAlloc in bytes
+func (s *HeapSampler) Stop() uint64 {
+	close(s.stop)
+	<-s.done
+	s.sample()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.peak
+}
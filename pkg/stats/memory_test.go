@@ -0,0 +1,23 @@
+package stats
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestHeapSamplerRecordsPeak(t *testing.T) {
+	sampler := StartHeapSampler(time.Millisecond)
+	buf := make([]byte, 32<<20)
+	for i := range buf {
+		buf[i] = byte(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	peak := sampler.Stop()
+	runtime.KeepAlive(buf)
+
+	// The buffer was live for several samples, so the peak includes it
+	if peak < uint64(len(buf)) {
+		t.Errorf("peak heap %d bytes, want at least the %d-byte buffer held during the run", peak, len(buf))
+	}
+}
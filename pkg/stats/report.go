@@ -0,0 +1,122 @@
+package stats
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+)
+
+// Layout of the report's bar chart, in SVG user units
+const (
+	reportLabelWidth = 160
+	reportBarWidth   = 480
+	reportBarHeight  = 28
+	reportBarGap     = 12
+)
+
+// reportBar is one algorithm's row of the chart and table
+type reportBar struct {
+	Name    string
+	Images  int
+	Partial bool // the run was interrupted before all its inputs
+	Total   float64
+	Average float64
+	Speedup float64 // baseline total time / this total time
+	Y       int
+	Width   float64
+	ValueX  float64 // where the value label after the bar starts
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Gaussian blur benchmark</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-top: 1.5em; }
+th, td { padding: 4px 12px; text-align: right; border-bottom: 1px solid #ddd; }
+th:first-child, td:first-child { text-align: left; }
+</style>
+</head>
+<body>
+<h1>Gaussian blur benchmark</h1>
+<p>{{.Timestamp}} &middot; kernel {{.Kernel}} &middot; speedup relative to {{.Baseline}}</p>
+<svg id="chart" xmlns="http://www.w3.org/2000/svg" width="{{.ChartWidth}}" height="{{.ChartHeight}}" font-size="13">
+{{- range .Bars}}
+<g class="bar">
+<text x="0" y="{{.Y}}" dy="18">{{.Name}}{{if .Partial}}*{{end}}</text>
+<rect x="{{$.LabelWidth}}" y="{{.Y}}" width="{{printf "%.1f" .Width}}" height="{{$.BarHeight}}" fill="#4a7fb5"><title>{{.Name}}: {{printf "%.2f" .Total}}s</title></rect>
+<text x="{{printf "%.1f" .ValueX}}" y="{{.Y}}" dy="18">{{printf "%.2f" .Total}}s ({{printf "%.2f" .Speedup}}x)</text>
+</g>
+{{- end}}
+</svg>
+<table>
+<tr><th>Algorithm</th><th>Images</th><th>Total (s)</th><th>Average (s)</th><th>Speedup</th></tr>
+{{- range .Bars}}
+<tr><td>{{.Name}}{{if .Partial}} (interrupted){{end}}</td><td>{{.Images}}</td><td>{{printf "%.2f" .Total}}</td><td>{{printf "%.2f" .Average}}</td><td>{{printf "%.2f" .Speedup}}x</td></tr>
+{{- end}}
+</table>
+{{- if .AnyPartial}}
+<p>* Interrupted: the run stopped early and covers only the images shown.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+// WritePerformanceReportHTML writes a self-contained HTML page comparing results: an
+// inline SVG bar chart of each algorithm's total time and a table of the numbers.
+// Speedups are relative to the first result, which is usually the sequential run.
+func WritePerformanceReportHTML(results []PerformanceData, path string) error {
+	if len(results) == 0 {
+		return fmt.Errorf("no results to report")
+	}
+
+	longest := 0.0
+	anyPartial := false
+	for _, r := range results {
+		longest = max(longest, r.TotalTime)
+		anyPartial = anyPartial || r.Interrupted
+	}
+
+	baseline := results[0].TotalTime
+	bars := make([]reportBar, len(results))
+	for i, r := range results {
+		bars[i] = reportBar{
+			Name:    r.AlgorithmName,
+			Images:  r.ImagesProcessed,
+			Partial: r.Interrupted,
+			Total:   r.TotalTime,
+			Average: r.AverageTime,
+			Y:       i * (reportBarHeight + reportBarGap),
+		}
+		if r.TotalTime > 0 {
+			bars[i].Speedup = baseline / r.TotalTime
+		}
+		if longest > 0 {
+			bars[i].Width = reportBarWidth * r.TotalTime / longest
+		}
+		bars[i].ValueX = reportLabelWidth + bars[i].Width + 6
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = reportTemplate.Execute(file, map[string]any{
+		"Timestamp":   results[0].Timestamp.Format("2006-01-02 15:04:05"),
+		"Kernel":      results[0].KernelSize,
+		"Baseline":    results[0].AlgorithmName,
+		"Bars":        bars,
+		"AnyPartial":  anyPartial,
+		"LabelWidth":  reportLabelWidth,
+		"BarHeight":   reportBarHeight,
+		"ChartWidth":  reportLabelWidth + reportBarWidth + 140,
+		"ChartHeight": len(bars)*(reportBarHeight+reportBarGap) - reportBarGap,
+	})
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
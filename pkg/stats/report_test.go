@@ -0,0 +1,62 @@
+package stats
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestWritePerformanceReportHTML(t *testing.T) {
+	results := []PerformanceData{
+		sampleResult("Sequential", 8, 1),
+		sampleResult("Parallel <tiles>", 2, 8),
+		sampleResult("Pipelined", 4, 8),
+	}
+	path := filepath.Join(t.TempDir(), "report.html")
+	if err := WritePerformanceReportHTML(results, path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(data)
+
+	if !strings.Contains(page, `<svg id="chart"`) {
+		t.Fatal("the report has no chart")
+	}
+	// One bar per result, the slowest spanning the full chart width
+	widths := regexp.MustCompile(`<rect [^>]*width="([0-9.]+)"`).FindAllStringSubmatch(page, -1)
+	if len(widths) != 3 || widths[0][1] != "480.0" || widths[1][1] != "120.0" || widths[2][1] != "240.0" {
+		t.Errorf("bar widths %v, want 480, 120 and 240", widths)
+	}
+	// Speedups are relative to the first result
+	for _, want := range []string{"8.00s (1.00x)", "2.00s (4.00x)", "4.00s (2.00x)", "speedup relative to Sequential"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("the report does not contain %q", want)
+		}
+	}
+	if strings.Contains(page, "<tiles>") || !strings.Contains(page, "Parallel &lt;tiles&gt;") {
+		t.Error("an algorithm name was not escaped")
+	}
+
+	if strings.Contains(page, "interrupted") {
+		t.Error("a report of complete runs flags one as interrupted")
+	}
+	results[2].Interrupted = true
+	if err := WritePerformanceReportHTML(results, path); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if page = string(data); !strings.Contains(page, "Pipelined (interrupted)") || !strings.Contains(page, "Pipelined*") {
+		t.Error("the interrupted run is not flagged in the table and chart")
+	}
+
+	if err := WritePerformanceReportHTML(nil, path); err == nil {
+		t.Error("a report of no results was written without error")
+	}
+}
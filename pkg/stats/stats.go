@@ -1,7 +1,9 @@
 package stats
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"time"
@@ -18,6 +20,20 @@ type PerformanceData struct {
 	OutputPaths     []string
 	Timestamp       time.Time
 
+	// OutputsSuppressed is set when the run skipped encoding/writing (-no-output),
+	// so timings measure decode and blur only
+	OutputsSuppressed bool
+
+	// Interrupted is set when a stop request (Ctrl-C) ended the run before all of its
+	// inputs, so its numbers cover only ImagesProcessed of them
+	Interrupted bool
+
+	// ImageTimings lines up with InputPaths, for processors that record per-image results
+	ImageTimings []ImageTiming
+
+	// PeakHeapBytes is the highest sampled runtime HeapAlloc during the run (0 = not tracked)
+	PeakHeapBytes uint64
+
 	// Algorithm-specific data
 	TotalBlurTime *float64 // For sequential and parallel
 	Workers       *int     // For parallel algorithms
@@ -25,13 +41,73 @@ type PerformanceData struct {
 	QueueSize     *int     // For pipelined
 }
 
+// MinParallelEfficiency is the parallel efficiency below which ScalingSummary flags an
+// algorithm's scaling as sub-linear
+const MinParallelEfficiency = 0.5
+
+// ParallelEfficiency compares result against a baseline run of the same images: the
+// speedup is baseline time / result time, and the efficiency that speedup divided by
+// result's worker count, so 1 is linear scaling. ok is false when result has no worker
+// count or the runs processed different numbers of images.
+func ParallelEfficiency(baseline, result PerformanceData) (speedup, efficiency float64, ok bool) {
+	if result.Workers == nil || *result.Workers <= 0 || result.TotalTime <= 0 ||
+		baseline.TotalTime <= 0 || baseline.ImagesProcessed != result.ImagesProcessed {
+		return 0, 0, false
+	}
+	speedup = baseline.TotalTime / result.TotalTime
+	return speedup, speedup / float64(*result.Workers), true
+}
+
+// ScalingSummary describes, one line per parallel algorithm, its speedup over the
+// sequential result and its parallel efficiency, flagging efficiencies below
+// MinParallelEfficiency. It is empty without a sequential result to compare against.
+func ScalingSummary(results []PerformanceData) []string {
+	var baseline *PerformanceData
+	for i := range results {
+		if results[i].AlgorithmName == "Sequential" {
+			baseline = &results[i]
+			break
+		}
+	}
+	if baseline == nil {
+		return nil
+	}
+
+	var lines []string
+	for _, result := range results {
+		speedup, efficiency, ok := ParallelEfficiency(*baseline, result)
+		if !ok {
+			continue
+		}
+		line := fmt.Sprintf("%s: %.2fx speedup on %d workers, %.0f%% parallel efficiency",
+			result.AlgorithmName, speedup, *result.Workers, 100*efficiency)
+		if efficiency < MinParallelEfficiency {
+			line += fmt.Sprintf(" (sub-linear: below %.0f%%)", 100*MinParallelEfficiency)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
 // WritePerformanceResults writes a single combined results file
 func WritePerformanceResults(results []PerformanceData) {
 	WritePerformanceResultsWithPrefix(results, "abc_")
 }
 
+// WriteOptions controls how much detail goes into a results file
+type WriteOptions struct {
+	SummaryOnly bool // Omit the per-file input/output listings, keeping only aggregate numbers
+}
+
 // WritePerformanceResultsWithPrefix writes results file with custom prefix
 func WritePerformanceResultsWithPrefix(results []PerformanceData, prefix string) {
+	WritePerformanceResultsWithOptions(results, prefix, WriteOptions{})
+}
+
+// WritePerformanceResultsWithOptions writes results file with custom prefix and options.
+// The file is written under a temporary name in logs/ and renamed into place, so a
+// crash mid-write leaves any earlier file of the same name intact.
+func WritePerformanceResultsWithOptions(results []PerformanceData, prefix string, opts WriteOptions) {
 	if len(results) == 0 {
 		return
 	}
@@ -46,13 +122,33 @@ func WritePerformanceResultsWithPrefix(results []PerformanceData, prefix string)
 	timestamp := results[0].Timestamp.Format("2006-01-02_15-04-05")
 	resultsFile := fmt.Sprintf("logs/%s%s.txt", prefix, timestamp)
 
-	file, err := os.Create(resultsFile)
+	file, err := os.CreateTemp("logs", ".results-*.tmp")
 	if err != nil {
 		log.Printf("Failed to create results file: %v", err)
 		return
 	}
-	defer file.Close()
+	w := bufio.NewWriter(file)
+	writeResults(w, results, opts)
+	err = w.Flush()
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		log.Printf("Failed to write results file: %v", err)
+		return
+	}
+	if err := os.Rename(file.Name(), resultsFile); err != nil {
+		os.Remove(file.Name())
+		log.Printf("Failed to write results file: %v", err)
+	}
+}
 
+// writeResults writes the body of a results file
+func writeResults(file io.Writer, results []PerformanceData, opts WriteOptions) {
 	fmt.Fprintf(file, "=== Combined Multi-Algorithm Gaussian Blur Results ===\n")
 	fmt.Fprintf(file, "Timestamp: %s\n\n", results[0].Timestamp.Format("2006-01-02 15:04:05"))
 
@@ -88,6 +184,22 @@ func WritePerformanceResultsWithPrefix(results []PerformanceData, prefix string)
 			fmt.Fprintf(file, "Queue size: %d\n", *result.QueueSize)
 		}
 
+		if result.PeakHeapBytes > 0 {
+			fmt.Fprintf(file, "Peak heap: %.1f MB\n", float64(result.PeakHeapBytes)/(1024*1024))
+		}
+
+		if result.OutputsSuppressed {
+			fmt.Fprintf(file, "Outputs: suppressed by -no-output (timings exclude encode and write)\n")
+		}
+		if result.Interrupted {
+			fmt.Fprintf(file, "Interrupted: stopped early, the images above are the ones completed\n")
+		}
+
+		if opts.SummaryOnly {
+			fmt.Fprintf(file, "\n")
+			continue
+		}
+
 		fmt.Fprintf(file, "\nInput files:\n")
 		for i, path := range result.InputPaths {
 			fmt.Fprintf(file, "  %d. %s\n", i+1, path)
@@ -100,4 +212,11 @@ func WritePerformanceResultsWithPrefix(results []PerformanceData, prefix string)
 
 		fmt.Fprintf(file, "\n")
 	}
+
+	if lines := ScalingSummary(results); len(lines) > 0 {
+		fmt.Fprintf(file, "=== Scaling vs Sequential ===\n")
+		for _, line := range lines {
+			fmt.Fprintf(file, "%s\n", line)
+		}
+	}
 }
\ No newline at end of file
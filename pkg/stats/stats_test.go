@@ -0,0 +1,110 @@
+package stats
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sampleResult is a two-image run with every optional field set
+func sampleResult(name string, totalTime float64, workers int) PerformanceData {
+	blur := totalTime / 2
+	tile := 64
+	return PerformanceData{
+		AlgorithmName:   name,
+		ImagesProcessed: 2,
+		KernelSize:      15,
+		TotalTime:       totalTime,
+		AverageTime:     totalTime / 2,
+		InputPaths:      []string{"in/cat.png", "in/dog.png"},
+		OutputPaths:     []string{"out/cat_blurred.png", "out/dog_blurred.png"},
+		Timestamp:       time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		PeakHeapBytes:   3 << 20,
+		TotalBlurTime:   &blur,
+		Workers:         &workers,
+		TileSize:        &tile,
+	}
+}
+
+func TestWriteResultsSummaryOnly(t *testing.T) {
+	results := []PerformanceData{sampleResult("Parallel", 4, 8)}
+	var full, summary bytes.Buffer
+	writeResults(&full, results, WriteOptions{})
+	writeResults(&summary, results, WriteOptions{SummaryOnly: true})
+
+	for _, line := range []string{
+		"Images processed: 2", "Total blur time: 2.00s", "Total execution time: 4.00s",
+		"Average time per image: 2.00s", "Workers: 8", "Tile size: 64", "Peak heap: 3.0 MB",
+	} {
+		if !strings.Contains(summary.String(), line) {
+			t.Errorf("summary-only output lacks %q:\n%s", line, summary.String())
+		}
+	}
+	for _, listing := range []string{"Input files:", "Output files:", "in/cat.png", "out/dog_blurred.png"} {
+		if !strings.Contains(full.String(), listing) {
+			t.Errorf("full output lacks %q", listing)
+		}
+		if strings.Contains(summary.String(), listing) {
+			t.Errorf("summary-only output still lists %q", listing)
+		}
+	}
+
+	if strings.Contains(full.String(), "Interrupted") || strings.Contains(full.String(), "Outputs: suppressed") {
+		t.Error("a complete run with outputs is flagged as interrupted or suppressed")
+	}
+	results[0].Interrupted = true
+	results[0].OutputsSuppressed = true
+	summary.Reset()
+	writeResults(&summary, results, WriteOptions{SummaryOnly: true})
+	for _, flag := range []string{"Interrupted: stopped early", "Outputs: suppressed by -no-output"} {
+		if !strings.Contains(summary.String(), flag) {
+			t.Errorf("output lacks %q:\n%s", flag, summary.String())
+		}
+	}
+}
+
+func TestParallelEfficiency(t *testing.T) {
+	sequential := sampleResult("Sequential", 12, 1)
+	sequential.Workers = nil
+	moreImages := sampleResult("Parallel", 3, 4)
+	moreImages.ImagesProcessed = 3
+	tests := []struct {
+		name                string
+		result              PerformanceData
+		speedup, efficiency float64
+		ok                  bool
+	}{
+		{"linear", sampleResult("Parallel", 3, 4), 4, 1, true},
+		{"half", sampleResult("Parallel", 3, 8), 4, 0.5, true},
+		{"slower", sampleResult("Parallel", 24, 2), 0.5, 0.25, true},
+		{"no worker count", sequential, 0, 0, false},
+		{"zero time", sampleResult("Parallel", 0, 4), 0, 0, false},
+		{"different images", moreImages, 0, 0, false},
+	}
+
+	for _, tc := range tests {
+		speedup, efficiency, ok := ParallelEfficiency(sequential, tc.result)
+		if ok != tc.ok || speedup != tc.speedup || efficiency != tc.efficiency {
+			t.Errorf("%s: ParallelEfficiency = %v, %v, %v; want %v, %v, %v",
+				tc.name, speedup, efficiency, ok, tc.speedup, tc.efficiency, tc.ok)
+		}
+	}
+}
+
+func TestScalingSummary(t *testing.T) {
+	sequential := sampleResult("Sequential", 12, 1)
+	sequential.Workers = nil
+	results := []PerformanceData{sequential, sampleResult("Parallel", 4, 4), sampleResult("Pipelined", 6, 8)}
+	want := []string{
+		"Parallel: 3.00x speedup on 4 workers, 75% parallel efficiency",
+		"Pipelined: 2.00x speedup on 8 workers, 25% parallel efficiency (sub-linear: below 50%)",
+	}
+	got := ScalingSummary(results)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("ScalingSummary =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if lines := ScalingSummary(results[1:]); lines != nil {
+		t.Errorf("without a sequential run ScalingSummary = %v, want nothing", lines)
+	}
+}
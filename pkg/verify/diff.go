@@ -0,0 +1,51 @@
+package verify
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// DiffReport summarises the per-channel difference between two images of the same size
+type DiffReport struct {
+	MaxDiff int     // largest absolute difference in any R, G, B or A channel (8-bit levels)
+	PSNR    float64 // peak signal-to-noise ratio in dB over RGB; +Inf for identical images
+}
+
+// Diff compares two images pixel by pixel in 8-bit RGBA. Images of different
+// dimensions cannot be compared and return an error.
+func Diff(a, b image.Image) (DiffReport, error) {
+	ab, bb := a.Bounds(), b.Bounds()
+	if ab.Dx() != bb.Dx() || ab.Dy() != bb.Dy() {
+		return DiffReport{}, fmt.Errorf("size mismatch: %dx%d vs %dx%d", ab.Dx(), ab.Dy(), bb.Dx(), bb.Dy())
+	}
+
+	var report DiffReport
+	var sumSquares float64
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			r1, g1, b1, a1 := a.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			r2, g2, b2, a2 := b.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			for i, pair := range [4][2]uint32{{r1, r2}, {g1, g2}, {b1, b2}, {a1, a2}} {
+				d := int(pair[0]>>8) - int(pair[1]>>8)
+				if d < 0 {
+					d = -d
+				}
+				if d > report.MaxDiff {
+					report.MaxDiff = d
+				}
+				if i < 3 {
+					sumSquares += float64(d * d)
+				}
+			}
+		}
+	}
+
+	samples := float64(ab.Dx() * ab.Dy() * 3)
+	if sumSquares == 0 || samples == 0 {
+		report.PSNR = math.Inf(1)
+	} else {
+		report.PSNR = 10 * math.Log10(255*255/(sumSquares/samples))
+	}
+	return report, nil
+}
@@ -0,0 +1,53 @@
+package verify
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	grey := func(w, h int) *image.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, w, h))
+		for i := range img.Pix {
+			img.Pix[i] = 100
+			if i%4 == 3 {
+				img.Pix[i] = 255
+			}
+		}
+		return img
+	}
+	withPixel := func(c color.RGBA) *image.RGBA {
+		img := grey(2, 2)
+		img.SetRGBA(1, 1, c)
+		return img
+	}
+	tests := []struct {
+		name    string
+		a, b    image.Image
+		maxDiff int
+		psnr    float64
+	}{
+		{"identical", grey(2, 2), grey(2, 2), 0, math.Inf(1)},
+		// One of 12 RGB samples off by 4: MSE 16/12
+		{"one channel", grey(2, 2), withPixel(color.RGBA{104, 100, 100, 255}), 4, 10 * math.Log10(255*255/(16.0/12))},
+		// Alpha counts towards the max diff but not the PSNR, which is over RGB
+		{"alpha only", grey(2, 2), withPixel(color.RGBA{100, 100, 100, 120}), 135, math.Inf(1)},
+		{"different origins", grey(2, 2), grey(4, 4).SubImage(image.Rect(2, 2, 4, 4)), 0, math.Inf(1)},
+	}
+	for _, tc := range tests {
+		report, err := Diff(tc.a, tc.b)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		samePSNR := report.PSNR == tc.psnr || math.Abs(report.PSNR-tc.psnr) < 1e-9
+		if report.MaxDiff != tc.maxDiff || !samePSNR {
+			t.Errorf("%s: max diff %d, PSNR %.4f; want %d and %.4f", tc.name, report.MaxDiff, report.PSNR, tc.maxDiff, tc.psnr)
+		}
+	}
+
+	if _, err := Diff(grey(2, 2), grey(2, 3)); err == nil {
+		t.Error("images of different sizes compared without error")
+	}
+}
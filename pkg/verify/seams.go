@@ -0,0 +1,124 @@
+package verify
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// DefaultSeamThreshold is the excess step (in 8-bit luma levels) above which a grid-aligned
+// discontinuity is reported as a seam. A correctly padded blur leaves gradients at tile
+// boundaries indistinguishable from their neighbours, so anything well above 1 is suspicious.
+const DefaultSeamThreshold = 2.0
+
+// SeamReport describes the worst tile-grid-aligned discontinuities found in an image
+type SeamReport struct {
+	TileSize        int
+	WorstColumn     int     // x of the worst vertical seam (-1 if the image has no interior column boundary)
+	ColumnMagnitude float64 // excess luma step irregularity at that column over its neighbours
+	WorstRow        int     // y of the worst horizontal seam (-1 if the image has no interior row boundary)
+	RowMagnitude    float64
+}
+
+// HasSeam reports whether either worst seam exceeds the threshold
+func (r SeamReport) HasSeam(threshold float64) bool {
+	return r.ColumnMagnitude > threshold || r.RowMagnitude > threshold
+}
+
+// Err returns nil when neither worst seam exceeds threshold, and otherwise an error
+// naming the worse of the two boundaries
+func (r SeamReport) Err(threshold float64) error {
+	if !r.HasSeam(threshold) {
+		return nil
+	}
+	if r.ColumnMagnitude >= r.RowMagnitude {
+		return fmt.Errorf("seam at tile column x=%d (magnitude %.2f > %.2f)", r.WorstColumn, r.ColumnMagnitude, threshold)
+	}
+	return fmt.Errorf("seam at tile row y=%d (magnitude %.2f > %.2f)", r.WorstRow, r.RowMagnitude, threshold)
+}
+
+// DetectTileSeams scans img for discontinuities aligned to multiples of tileSize.
+// At every interior boundary it measures how far the luma step across the boundary
+// departs from the steps just before and after it (a discrete second difference), and
+// subtracts the same measure taken two pixels away as a baseline for the local texture.
+// Padding or assembly bugs show up as a boundary that stands out from its surroundings.
+func DetectTileSeams(img image.Image, tileSize int) SeamReport {
+	report := SeamReport{TileSize: tileSize, WorstColumn: -1, WorstRow: -1}
+	if tileSize <= 0 {
+		return report
+	}
+
+	luma := lumaPlane(img)
+	height := len(luma)
+	if height == 0 {
+		return report
+	}
+	width := len(luma[0])
+
+	at := func(x, y int) float64 { return luma[y][x] }
+	transposed := func(y, x int) float64 { return luma[y][x] }
+
+	for x := tileSize; x < width; x += tileSize {
+		magnitude := boundaryExcess(at, x, width, height)
+		if report.WorstColumn < 0 || magnitude > report.ColumnMagnitude {
+			report.WorstColumn, report.ColumnMagnitude = x, magnitude
+		}
+	}
+	for y := tileSize; y < height; y += tileSize {
+		magnitude := boundaryExcess(transposed, y, height, width)
+		if report.WorstRow < 0 || magnitude > report.RowMagnitude {
+			report.WorstRow, report.RowMagnitude = y, magnitude
+		}
+	}
+
+	return report
+}
+
+// boundaryExcess returns the mean step irregularity at pos minus the mean irregularity
+// two pixels either side of it. at(pos, i) reads luma along the scan axis; span is
+// the number of lines crossing the boundary.
+func boundaryExcess(at func(pos, i int) float64, pos, limit, span int) float64 {
+	irregularity := func(p int) (float64, bool) {
+		if p-2 < 0 || p+1 >= limit {
+			return 0, false
+		}
+		sum := 0.0
+		for i := 0; i < span; i++ {
+			step := at(p, i) - at(p-1, i)
+			around := (at(p-1, i) - at(p-2, i) + at(p+1, i) - at(p, i)) / 2
+			sum += math.Abs(step - around)
+		}
+		return sum / float64(span), true
+	}
+
+	boundary, ok := irregularity(pos)
+	if !ok {
+		return 0
+	}
+
+	baseline, n := 0.0, 0
+	for _, p := range []int{pos - 2, pos + 2} {
+		if v, ok := irregularity(p); ok {
+			baseline += v
+			n++
+		}
+	}
+	if n > 0 {
+		baseline /= float64(n)
+	}
+	return boundary - baseline
+}
+
+// lumaPlane converts img to a [y][x] grid of Rec. 601 luma values in 0-255
+func lumaPlane(img image.Image) [][]float64 {
+	bounds := img.Bounds()
+	plane := make([][]float64, bounds.Dy())
+	for y := 0; y < bounds.Dy(); y++ {
+		plane[y] = make([]float64, bounds.Dx())
+		for x := 0; x < bounds.Dx(); x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			plane[y][x] = (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257.0
+		}
+	}
+	return plane
+}
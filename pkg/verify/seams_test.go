@@ -0,0 +1,67 @@
+package verify
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+// smoothImage returns a width x height image of slowly varying colour, like a blurred
+// photo, with luma raised by offset from column seamX on (seamX < 0 for none)
+func smoothImage(width, height, seamX int, offset float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := 120 + 60*math.Sin(float64(x)/37) + 40*math.Cos(float64(y)/23)
+			if seamX >= 0 && x >= seamX {
+				v += offset
+			}
+			c := uint8(math.Round(v))
+			img.SetRGBA(x, y, color.RGBA{c, c, c, 255})
+		}
+	}
+	return img
+}
+
+func TestDetectTileSeams(t *testing.T) {
+	clean := DetectTileSeams(smoothImage(600, 300, -1, 0), 256)
+	if err := clean.Err(DefaultSeamThreshold); err != nil {
+		t.Errorf("seamless image: %v (%+v)", err, clean)
+	}
+
+	seamed := DetectTileSeams(smoothImage(600, 300, 256, 8), 256)
+	if seamed.WorstColumn != 256 || !seamed.HasSeam(DefaultSeamThreshold) {
+		t.Fatalf("seam injected at x=256 not found: %+v", seamed)
+	}
+	if seamed.ColumnMagnitude < 6 {
+		t.Errorf("an 8-level step scored only %.2f", seamed.ColumnMagnitude)
+	}
+	if err := seamed.Err(DefaultSeamThreshold); err == nil || !strings.Contains(err.Error(), "column x=256") {
+		t.Errorf("Err = %v, want it to name column x=256", err)
+	}
+
+	// The same step off the tile grid is texture, not a seam
+	if off := DetectTileSeams(smoothImage(600, 300, 300, 8), 256); off.HasSeam(DefaultSeamThreshold) {
+		t.Errorf("a step at x=300 was reported on the 256 grid: %+v", off)
+	}
+}
+
+func TestDetectTileSeamsRows(t *testing.T) {
+	// A seam across a row is reported as a row, by transposing a column seam
+	src := smoothImage(300, 600, 256, 8)
+	img := image.NewRGBA(image.Rect(0, 0, 600, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 600; x++ {
+			img.Set(x, y, src.At(y, x))
+		}
+	}
+	report := DetectTileSeams(img, 256)
+	if report.WorstRow != 256 || report.RowMagnitude <= DefaultSeamThreshold {
+		t.Fatalf("row seam at y=256 not found: %+v", report)
+	}
+	if report.ColumnMagnitude > DefaultSeamThreshold {
+		t.Errorf("the row seam was also reported as a column seam: %+v", report)
+	}
+}